@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	fields   []string
+	paginate bool
+	jqPath   string
+)
+
+// Response is the response of the host
+type Response struct {
+	Host     string          `json:"host"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "api <method> <path>",
+		Short: "Raw API request",
+		Long: `Send the request to the rest api of every selected host and print the json responses tagged with the host name.
+The path is relative to the api url, the /api/v4/ prefix is optional.`,
+		Example: `  glaball api GET /api/v4/projects --field visibility=private --paginate --jq '.[].path_with_namespace'
+  glaball api PUT /api/v4/application/settings --field signup_enabled=false`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Request(strings.ToUpper(args[0]), args[1])
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&fields, "field", "F", []string{},
+		"Add the key=value parameter to the query of GET requests or to the json body of other requests. "+
+			"true, false, null and numbers are sent as json values in the body. Can be repeated.")
+
+	cmd.Flags().BoolVar(&paginate, "paginate", false,
+		"Fetch all pages of the list and merge them into one array per host. Only for GET requests.")
+
+	cmd.Flags().StringVar(&jqPath, "jq", "",
+		"Print the values at the path of every response, one per line with the host name: .field, .[index] and .[] for every element.")
+
+	return cmd
+}
+
+func Request(method, path string) error {
+	if paginate && method != http.MethodGet {
+		return fmt.Errorf("--paginate is only supported for GET requests")
+	}
+
+	var extract Path
+	if jqPath != "" {
+		var err error
+		if extract, err = ParsePath(jqPath); err != nil {
+			return err
+		}
+	}
+
+	path, params, err := parseRequest(method, path, fields)
+	if err != nil {
+		return err
+	}
+
+	var options []gitlab.RequestOptionFunc
+	if method == http.MethodGet {
+		options = append(options, common.Client.WithCache())
+	}
+
+	if method != http.MethodGet {
+		hosts := make(client.Hosts, 0, len(common.Client.Hosts))
+		for _, h := range common.Client.Hosts {
+			if h.Client != nil {
+				hosts = append(hosts, h)
+			}
+		}
+		util.AskUser(fmt.Sprintf("Do you really want to send %s %s to %d gitlab(s) %v ?",
+			method, path, len(hosts), hosts.Projects(common.Config.ShowAll)))
+	}
+
+	pipe := pipeline.New(common.Limiter)
+	responses := pipeline.Collect(pipeline.FromHosts(pipe, common.Client.Hosts,
+		func(ctx context.Context, h *client.Host, emit pipeline.Emit[*Response]) error {
+			if h.Client == nil {
+				hclog.L().Debug("The api command is not supported for the github host", "host", h.URL)
+				return nil
+			}
+			hclog.L().Debug("Sending api request", "host", h.URL, "method", method, "path", path)
+
+			resp, err := send(pipe.Slot(), h, method, path, params, paginate, pipeline.WithContext(ctx, options)...)
+			if err != nil {
+				return err
+			}
+			emit(resp, false)
+			return nil
+		}))
+
+	// The responses are printed in the order of the hosts
+	slices.SortFunc(responses, func(a, b pipeline.Element[*Response]) int {
+		return slices.Index(common.Client.Hosts, a.Host) - slices.Index(common.Client.Hosts, b.Host)
+	})
+
+	if extract != nil {
+		for _, e := range responses {
+			values, err := extract.Values(e.Value.Response)
+			if err != nil {
+				hclog.L().Error(err.Error(), "host", e.Value.Host)
+				continue
+			}
+			for _, v := range values {
+				fmt.Fprintf(os.Stdout, "%s\t%s\n", e.Value.Host, v)
+			}
+		}
+	} else {
+		list := make([]*Response, 0, len(responses))
+		for _, e := range responses {
+			list = append(list, e.Value)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(list); err != nil {
+			return err
+		}
+	}
+
+	for _, err := range pipe.Errors() {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	return nil
+}
+
+// Splits the query of the path and adds the fields to the parameters of the request
+func parseRequest(method, path string, fields []string) (string, map[string]interface{}, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	path = strings.TrimPrefix(u.Path, "/")
+	path = strings.TrimPrefix(path, "api/v4/")
+
+	params := make(map[string]interface{})
+	for k, v := range u.Query() {
+		params[k] = v[len(v)-1]
+	}
+
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return "", nil, fmt.Errorf("invalid field %q, expected key=value", f)
+		}
+		if method == http.MethodGet {
+			params[k] = v
+		} else {
+			params[k] = fieldValue(v)
+		}
+	}
+
+	return path, params, nil
+}
+
+// Converts the value of the field to the json value of the body
+func fieldValue(v string) interface{} {
+	switch v {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return i
+	}
+	return v
+}
+
+// Sets the parameters of the GET request in the query
+func withQuery(params map[string]interface{}) gitlab.RequestOptionFunc {
+	return func(req *retryablehttp.Request) error {
+		q := req.URL.Query()
+		for k, v := range params {
+			q.Set(k, fmt.Sprint(v))
+		}
+		req.URL.RawQuery = q.Encode()
+		return nil
+	}
+}
+
+// The caller holds the worker slot, it is released between the pages, see pipeline.PaginateHost
+func send(slot sync.Locker, h *client.Host, method, path string, params map[string]interface{}, paginate bool,
+	options ...gitlab.RequestOptionFunc) (*Response, error) {
+
+	var opt interface{}
+	if method == http.MethodGet {
+		options = append([]gitlab.RequestOptionFunc{withQuery(params)}, options...)
+	} else if len(params) > 0 {
+		opt = params
+	}
+
+	do := func(v interface{}, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		req, err := h.Client.NewRequest(method, path, opt, options)
+		if err != nil {
+			return nil, err
+		}
+		return h.Client.Do(req, v)
+	}
+
+	if !paginate {
+		var v json.RawMessage
+		resp, err := do(&v, options...)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = json.RawMessage("null")
+		}
+		return &Response{Host: h.FullName(), Status: resp.StatusCode, Response: v}, nil
+	}
+
+	// The parallel pages arrive in any order, they are merged by their numbers
+	type apiPage struct {
+		number int
+		list   []json.RawMessage
+	}
+
+	var (
+		mu     sync.Mutex
+		pages  = make([]apiPage, 0)
+		status int
+	)
+
+	err := pipeline.PaginateHost(slot, h, nil,
+		func(page ...gitlab.RequestOptionFunc) ([]apiPage, *gitlab.Response, error) {
+			var v []json.RawMessage
+			resp, err := do(&v, slices.Concat(options, page)...)
+			if err != nil {
+				return nil, resp, err
+			}
+			mu.Lock()
+			status = resp.StatusCode
+			mu.Unlock()
+			// The first page is requested without the number
+			number, _ := strconv.Atoi(resp.Request.URL.Query().Get("page"))
+			return []apiPage{{number: number, list: v}}, resp, nil
+		},
+		func(v apiPage, _ bool) {
+			mu.Lock()
+			pages = append(pages, v)
+			mu.Unlock()
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(pages, func(a, b apiPage) int { return a.number - b.number })
+
+	list := make([]json.RawMessage, 0)
+	for _, page := range pages {
+		list = append(list, page.list...)
+	}
+
+	b, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{Host: h.FullName(), Status: status, Response: b}, nil
+}
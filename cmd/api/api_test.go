@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/cmd/common"
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+	mux, server, cli := common.Setup(t)
+	defer common.Teardown(server)
+
+	mux.HandleFunc("GET /api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "private", r.URL.Query().Get("visibility"))
+		assert.Equal(t, "x", r.URL.Query().Get("search"))
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `[{"id": 3}]`)
+			return
+		}
+		w.Header().Set("X-Next-Page", "2")
+		fmt.Fprint(w, `[{"id": 1}, {"id": 2}]`)
+	})
+	mux.HandleFunc("GET /api/v4/groups", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "2":
+			// The last page arrives first
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, `[{"id": 2}]`)
+		case "3":
+			fmt.Fprint(w, `[{"id": 3}]`)
+		default:
+			w.Header().Set("X-Next-Page", "2")
+			w.Header().Set("X-Total-Pages", "3")
+			fmt.Fprint(w, `[{"id": 1}]`)
+		}
+	})
+	mux.HandleFunc("PUT /api/v4/application/settings", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, map[string]interface{}{"signup_enabled": false, "max_attachment_size": float64(10)}, body)
+		fmt.Fprint(w, `{"signup_enabled": false}`)
+	})
+
+	h := cli.Hosts[0]
+
+	// The slot of the worker is held by the task
+	slot := limiter.NewLimiter(1)
+	slot.Lock()
+	defer slot.Unlock()
+
+	path, params, err := parseRequest(http.MethodGet, "/api/v4/projects?search=x", []string{"visibility=private"})
+	assert.NoError(t, err)
+	assert.Equal(t, "projects", path)
+
+	resp, err := send(slot, h, http.MethodGet, path, params, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &Response{Host: "alfa.test.local", Status: http.StatusOK, Response: json.RawMessage(`[{"id": 1}, {"id": 2}]`)}, resp)
+
+	resp, err = send(slot, h, http.MethodGet, path, params, true)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id": 1}, {"id": 2}, {"id": 3}]`, string(resp.Response))
+
+	// The parallel pages are merged in order
+	h.Pagination = client.PaginationParallel
+	parallel := limiter.NewLimiter(limiter.DefaultLimit)
+	parallel.Lock()
+	resp, err = send(parallel, h, http.MethodGet, "groups", nil, true)
+	parallel.Unlock()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id": 1}, {"id": 2}, {"id": 3}]`, string(resp.Response))
+
+	path, params, err = parseRequest(http.MethodPut, "application/settings", []string{"signup_enabled=false", "max_attachment_size=10"})
+	assert.NoError(t, err)
+
+	resp, err = send(slot, h, http.MethodPut, path, params, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"signup_enabled": false}`, string(resp.Response))
+
+	_, err = send(slot, h, http.MethodGet, "missing", nil, false)
+	assert.Error(t, err)
+
+	_, _, err = parseRequest(http.MethodGet, "projects", []string{"visibility"})
+	assert.EqualError(t, err, `invalid field "visibility", expected key=value`)
+}
+
+func TestPath(t *testing.T) {
+	doc := []byte(`[
+		{"id": 1, "path": "a", "namespace": {"full_path": "group/a"}, "tags": ["x", "y"]},
+		{"id": 2, "path": "b", "namespace": {"full_path": "group/b"}, "tags": []}
+	]`)
+
+	tests := []struct {
+		path   string
+		values []string
+	}{
+		{".", []string{`[{"id":1,"namespace":{"full_path":"group/a"},"path":"a","tags":["x","y"]},{"id":2,"namespace":{"full_path":"group/b"},"path":"b","tags":[]}]`}},
+		{".[].path", []string{"a", "b"}},
+		{".[].id", []string{"1", "2"}},
+		{".[1].namespace.full_path", []string{"group/b"}},
+		{".[-1].id", []string{"2"}},
+		{".[].tags[]", []string{"x", "y"}},
+		{".[0].missing", []string{"null"}},
+		{".[5]", []string{"null"}},
+		{".[0].namespace[]", []string{"group/a"}},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePath(tt.path)
+		assert.NoError(t, err, tt.path)
+		values, err := p.Values(doc)
+		assert.NoError(t, err, tt.path)
+		assert.Equal(t, tt.values, values, tt.path)
+	}
+
+	for _, path := range []string{"path", "..a", ".[a]", ".[0"} {
+		_, err := ParsePath(path)
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path is a jq-style path of the values in the json document
+type Path []step
+
+type step struct {
+	key   string
+	index *int
+	all   bool
+}
+
+// ParsePath parses the path made of .field, .[index] and .[] for every element of the array or the object,
+// a single . is the whole document
+func ParsePath(s string) (Path, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("invalid path %q, expected it to start with .", s)
+	}
+
+	path := make(Path, 0)
+	for rest := s; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q, missing ]", s)
+			}
+			if idx := rest[1:end]; idx == "" {
+				path = append(path, step{all: true})
+			} else {
+				i, err := strconv.Atoi(idx)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q, bad index %q", s, idx)
+				}
+				path = append(path, step{index: &i})
+			}
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if key := rest[:end]; key != "" {
+				path = append(path, step{key: key})
+			} else if end == 0 && rest != "" && rest[0] == '.' {
+				return nil, fmt.Errorf("invalid path %q, empty field", s)
+			}
+			rest = rest[end:]
+		default:
+			return nil, fmt.Errorf("invalid path %q", s)
+		}
+	}
+
+	return path, nil
+}
+
+// Values returns the values at the path of the json document, strings are unquoted and the rest is compact json.
+// Missing fields are null, the elements of the values that are not arrays or objects are skipped.
+func (p Path) Values(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{doc}
+	for _, s := range p {
+		next := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			next = append(next, s.apply(v)...)
+		}
+		values = next
+	}
+
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			list = append(list, s)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, string(b))
+	}
+
+	return list, nil
+}
+
+func (s step) apply(v interface{}) []interface{} {
+	switch {
+	case s.all:
+		switch v := v.(type) {
+		case []interface{}:
+			return v
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			list := make([]interface{}, 0, len(keys))
+			for _, k := range keys {
+				list = append(list, v[k])
+			}
+			return list
+		}
+		return nil
+	case s.index != nil:
+		list, ok := v.([]interface{})
+		if !ok {
+			return []interface{}{nil}
+		}
+		i := *s.index
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 || i >= len(list) {
+			return []interface{}{nil}
+		}
+		return []interface{}{list[i]}
+	default:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return []interface{}{nil}
+		}
+		return []interface{}{m[s.key]}
+	}
+}
@@ -1,9 +1,17 @@
 package common
 
 import (
+	"context"
+	"fmt"
+	"os"
+
 	"github.com/flant/glaball/pkg/client"
 	"github.com/flant/glaball/pkg/config"
 	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/progress"
+	"github.com/flant/glaball/pkg/stats"
+	"github.com/flant/glaball/pkg/tracing"
 
 	"github.com/spf13/viper"
 )
@@ -12,6 +20,13 @@ var (
 	Config  *config.Config
 	Client  *client.Client
 	Limiter *limiter.Limiter
+
+	Progress *progress.Tracker
+	Stats    *stats.Recorder
+	Tracer   *tracing.Tracer
+
+	// The stats were included in the structured output of the results
+	statsIncluded bool
 )
 
 func Init() (err error) {
@@ -28,5 +43,79 @@ func Init() (err error) {
 
 	Limiter = limiter.NewLimiter(Config.Threads)
 
+	Progress = progress.New(Client.Hosts)
+	Limiter.Notify(Progress)
+	Client.Observe(Progress)
+
 	return nil
 }
+
+// StartProgress renders the progress of the requests on stderr, stdout is left to the results
+func StartProgress(mode string) error {
+	if Progress == nil {
+		return nil
+	}
+	return Progress.Start(mode, os.Stderr)
+}
+
+// StopProgress stops rendering the progress if it was started
+func StopProgress() {
+	if Progress != nil {
+		Progress.Stop()
+	}
+}
+
+// StartStats records the statistics of the requests to print them after the results
+func StartStats() {
+	if Client == nil {
+		return
+	}
+	Stats = stats.New()
+	Client.Observe(Stats)
+}
+
+// PrintStats prints the statistics table after the results unless they were already included in the structured output
+func PrintStats() error {
+	if Stats == nil || statsIncluded {
+		return nil
+	}
+	fmt.Fprintln(os.Stdout)
+	return Stats.Report().Write(os.Stdout, output.FormatTable)
+}
+
+// StartTracing starts the root span of the command and traces the tasks of the limiter and the requests of the client
+func StartTracing(name, destination string) (err error) {
+	if Client == nil {
+		return nil
+	}
+	if Tracer, err = tracing.Start(context.Background(), name, destination); err != nil {
+		return err
+	}
+	Limiter.Notify(Tracer)
+	Client.Observe(Tracer)
+	return nil
+}
+
+// StopTracing ends the spans and flushes them to the exporter if tracing was started
+func StopTracing() error {
+	if Tracer == nil {
+		return nil
+	}
+	return Tracer.Stop(context.Background())
+}
+
+// StartRecording saves the requests and the responses of the hosts to the fixtures in the directory
+func StartRecording(dir string) error {
+	if Client == nil {
+		return nil
+	}
+	return Client.Record(dir)
+}
+
+// StartReplay serves the requests of the hosts from the fixtures in the directory instead of sending them
+func StartReplay(dir string) error {
+	if Client == nil {
+		return nil
+	}
+	return Client.Replay(dir)
+}
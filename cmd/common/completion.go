@@ -0,0 +1,237 @@
+package common
+
+import (
+	go_sort "sort"
+	"strings"
+
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// CompletionFunc completes the flag values and the arguments of the commands
+type CompletionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// Completed flags of the result fields and the pseudo fields they accept besides the json fields
+var fieldFlags = map[string][]string{
+	"order_by":     {"count", "host"},
+	"group_by":     {"host"},
+	"columns":      {"host", "count", "cached"},
+	"aggregate":    nil,
+	"matrix_key":   nil,
+	"matrix_value": nil,
+}
+
+// IsCompletion reports whether the command is the hidden command of cobra requesting the shell completion
+func IsCompletion(cmd *cobra.Command) bool {
+	return cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd
+}
+
+// completionConfig reads the config without creating the client, the root command is not run on completion
+func completionConfig(cmd *cobra.Command) (*config.Config, error) {
+	// The config file has been read before the flags of the completed command were parsed
+	if f := cmd.Flag("config"); f != nil && f.Changed {
+		viper.SetConfigFile(f.Value.String())
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfg config.Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// CompleteHosts completes --filter with the teams, the projects and the full names of the configured hosts
+func CompleteHosts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, err := completionConfig(cmd)
+	if err != nil {
+		cobra.CompErrorln(err.Error())
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return withPrefix(HostNames(cfg.Hosts), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// HostNames returns the sorted teams, team.project and team.project.name of the hosts
+func HostNames(hosts config.Hosts) []string {
+	names := make([]string, 0)
+	for team, projects := range hosts {
+		names = append(names, team)
+		for project, hosts := range projects {
+			names = append(names, team+"."+project)
+			for name := range hosts {
+				names = append(names, team+"."+project+"."+name)
+			}
+		}
+	}
+	go_sort.Strings(names)
+
+	return names
+}
+
+// CompleteFields completes the comma separated json field paths of the struct type one level at a time,
+// the nested fields are offered after the parent field and a dot, e.g. namespace.
+func CompleteFields(structType interface{}, pseudo ...string) CompletionFunc {
+	fields := append(append([]string{}, pseudo...), sort.FieldNames(structType)...)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeList(toComplete, func(item string) []string {
+			return fieldsAt(fields, item)
+		}), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// CompleteOrderBy completes the fields of --order_by with an optional :asc or :desc direction
+func CompleteOrderBy(structType interface{}, pseudo ...string) CompletionFunc {
+	fields := append(append([]string{}, pseudo...), sort.FieldNames(structType)...)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeList(toComplete, func(item string) []string {
+			if field, _, ok := strings.Cut(item, ":"); ok {
+				return withPrefix([]string{field + ":asc", field + ":desc"}, item)
+			}
+			return fieldsAt(fields, item)
+		}), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// CompleteAggregate completes the func:field specs of --aggregate
+func CompleteAggregate(structType interface{}) CompletionFunc {
+	fields := sort.FieldNames(structType)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeList(toComplete, func(item string) []string {
+			fn, field, ok := strings.Cut(item, ":")
+			if !ok {
+				funcs := make([]string, 0, len(sort.AggregateFuncs))
+				for _, f := range sort.AggregateFuncs {
+					funcs = append(funcs, f+":")
+				}
+				return withPrefix(funcs, item)
+			}
+			values := fieldsAt(fields, field)
+			for i := range values {
+				values[i] = fn + ":" + values[i]
+			}
+			return values
+		}), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// RegisterFieldCompletions completes the result flags of the command that are defined with the fields of the struct type
+func RegisterFieldCompletions(cmd *cobra.Command, structType interface{}) {
+	for name, pseudo := range fieldFlags {
+		f := cmd.Flags().Lookup(name)
+		// Enum flags are completed with their options
+		if f == nil || isEnum(f) {
+			continue
+		}
+
+		fn := CompleteFields(structType, pseudo...)
+		switch name {
+		case "order_by":
+			fn = CompleteOrderBy(structType, pseudo...)
+		case "aggregate":
+			fn = CompleteAggregate(structType)
+		}
+		cmd.RegisterFlagCompletionFunc(name, fn)
+	}
+}
+
+// RegisterEnumCompletions completes the flags of the command and its subcommands that accept a fixed set of values
+func RegisterEnumCompletions(cmd *cobra.Command) {
+	register := func(f *pflag.Flag) {
+		if !isEnum(f) {
+			return
+		}
+		options := f.Value.(interface{ Options() []string }).Options()
+		// Already registered flags return an error
+		cmd.RegisterFlagCompletionFunc(f.Name, cobra.FixedCompletions(options, cobra.ShellCompDirectiveNoFileComp))
+	}
+	cmd.Flags().VisitAll(register)
+	cmd.PersistentFlags().VisitAll(register)
+
+	for _, c := range cmd.Commands() {
+		RegisterEnumCompletions(c)
+	}
+}
+
+func isEnum(f *pflag.Flag) bool {
+	_, ok := f.Value.(interface{ Options() []string })
+	return ok
+}
+
+// CompleteUsernames completes the usernames found in the cached responses of the hosts
+func CompleteUsernames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeCached(cmd, "username", toComplete)
+}
+
+// CompleteProjects completes the project paths found in the cached responses of the hosts
+func CompleteProjects(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeCached(cmd, "path", toComplete)
+}
+
+func completeCached(cmd *cobra.Command, field, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, err := completionConfig(cmd)
+	if err != nil {
+		cobra.CompErrorln(err.Error())
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	values, err := CachedValues(&cfg.Cache, field)
+	if err != nil {
+		cobra.CompErrorln(err.Error())
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return withPrefix(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// CachedValues returns the sorted unique values of the username of the cached user lists
+// or the path of the cached project lists, nothing is requested from the hosts
+func CachedValues(opt *config.CacheOptions, field string) ([]string, error) {
+	return opt.CachedValues(field)
+}
+
+// completeList completes the last item of the comma separated list keeping the previous ones
+func completeList(toComplete string, complete func(item string) []string) []string {
+	prefix, item := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix, item = toComplete[:i+1], toComplete[i+1:]
+	}
+
+	values := complete(item)
+	for i := range values {
+		values[i] = prefix + values[i]
+	}
+
+	return values
+}
+
+// fieldsAt returns the fields with the prefix that are not nested deeper than the prefix
+func fieldsAt(fields []string, prefix string) []string {
+	depth := strings.Count(prefix, ".")
+	values := make([]string, 0)
+	for _, f := range fields {
+		if strings.HasPrefix(f, prefix) && strings.Count(f, ".") == depth {
+			values = append(values, f)
+		}
+	}
+
+	return values
+}
+
+func withPrefix(values []string, prefix string) []string {
+	filtered := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			filtered = append(filtered, v)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/gitlabfake"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestHostNames(t *testing.T) {
+	hosts := config.Hosts{
+		"main": {
+			"alpha": {"prod": {}, "dev": {}},
+			"beta":  {"prod": {}},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"main", "main.alpha", "main.alpha.dev", "main.alpha.prod", "main.beta", "main.beta.prod",
+	}, HostNames(hosts))
+}
+
+func TestCompleteFields(t *testing.T) {
+	complete := func(fn CompletionFunc, toComplete string) []string {
+		values, directive := fn(&cobra.Command{}, nil, toComplete)
+		assert.Equal(t, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace, directive)
+		return values
+	}
+
+	columns := CompleteFields(gitlab.Project{}, "host", "count", "cached")
+	assert.Equal(t, []string{"host", "http_url_to_repo"}, complete(columns, "h"))
+	assert.Equal(t, []string{"id,namespace"}, complete(columns, "id,names"))
+	assert.Contains(t, complete(columns, "id,namespace."), "id,namespace.full_path")
+	assert.NotContains(t, complete(columns, "n"), "namespace.full_path")
+
+	orderBy := CompleteOrderBy(gitlab.User{}, "count", "host")
+	assert.Equal(t, []string{"count,username"}, complete(orderBy, "count,usern"))
+	assert.Equal(t, []string{"username:asc", "username:desc"}, complete(orderBy, "username:"))
+
+	aggregate := CompleteAggregate(gitlab.Project{})
+	assert.Equal(t, []string{"sum:", "avg:", "min:", "max:"}, complete(aggregate, ""))
+	assert.Equal(t, []string{"max:star_count,sum:statistics"}, complete(aggregate, "max:star_count,sum:stat"))
+}
+
+func TestRegisterEnumCompletions(t *testing.T) {
+	var by string
+	root := &cobra.Command{Use: "root"}
+	cmd := &cobra.Command{Use: "block", Run: func(*cobra.Command, []string) {}}
+	cmd.Flags().Var(util.NewEnumValue(&by, "email", "username", "name"), "by", "")
+	root.AddCommand(cmd)
+
+	RegisterEnumCompletions(root)
+
+	fn, ok := cmd.GetFlagCompletionFunc("by")
+	require.True(t, ok)
+	values, _ := fn(cmd, nil, "")
+	assert.Equal(t, []string{"email", "username", "name"}, values)
+}
+
+func TestCachedValues(t *testing.T) {
+	fake := gitlabfake.New("main.alpha.prod", "main.beta.prod")
+	defer fake.Close()
+
+	fake.Instance("main.alpha.prod").AddUser(gitlab.User{Username: "alice"})
+	fake.Instance("main.beta.prod").AddUser(gitlab.User{Username: "bob"})
+	fake.Instance("main.beta.prod").AddProject(gitlab.Project{Path: "api", PathWithNamespace: "backend/api"})
+
+	cfg := fake.Config()
+	cfg.Cache = config.CacheOptions{Enabled: true, BasePath: t.TempDir(), CacheSizeMax: config.DefaultCacheSize, Compression: true}
+
+	cli, err := client.NewClient(cfg)
+	require.NoError(t, err)
+
+	for _, h := range cli.Hosts {
+		_, _, err := h.Client.Users.ListUsers(&gitlab.ListUsersOptions{}, cli.WithCache())
+		require.NoError(t, err)
+		_, _, err = h.Client.Projects.ListProjects(&gitlab.ListProjectsOptions{}, cli.WithCache())
+		require.NoError(t, err)
+	}
+
+	usernames, err := CachedValues(&cfg.Cache, "username")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, usernames)
+
+	projects, err := CachedValues(&cfg.Cache, "path")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api"}, projects)
+}
@@ -0,0 +1,412 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/stats"
+	"github.com/flant/glaball/pkg/tui"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+)
+
+// ResultOptions are applied to the results of the list commands on the client side
+type ResultOptions struct {
+	Where       string
+	Columns     []string
+	Aggregate   []string
+	Matrix      bool
+	MatrixKey   string
+	MatrixValue string
+	Stream      bool
+
+	Format       string
+	TemplateFile string
+
+	Interactive bool
+	// Actions are offered on the selected rows in the interactive mode, set by the commands
+	Actions []tui.Action
+}
+
+func ResultOptionsFlags(cmd *cobra.Command, opt *ResultOptions) {
+	cmd.Flags().StringVar(&opt.Where, "where", "",
+		`Filter results by expression over any json field, e.g. "last_activity_at < now-180d && !archived && star_count == 0".
+Operators: == != < <= > >= =~ !~ && || ! (). Values: field paths, "strings", numbers, true, false, null, now[+-]N[s|m|h|d|w].`)
+
+	cmd.Flags().StringSliceVar(&opt.Columns, "columns", []string{},
+		`Print the results with custom columns of any json field, including nested paths, e.g. "username,email,last_sign_in_at,host".
+Pseudo columns: host, count, cached.`)
+
+	cmd.Flags().StringSliceVar(&opt.Aggregate, "aggregate", []string{},
+		`Print the count and aggregations of every group instead of the elements, e.g. "sum:statistics.repository_size,avg:star_count,max:last_activity_at".
+Functions: sum, avg (numeric fields), min, max (any scalar field). Use with --group_by, e.g. --group_by host for the values per host.`)
+
+	cmd.Flags().BoolVar(&opt.Matrix, "matrix", false,
+		"Print one row per object and one column per host with ✓ if the object exists on the host, ✗ if not and ? if the host failed.")
+
+	cmd.Flags().StringVar(&opt.MatrixKey, "matrix_key", "",
+		`Identify the objects of the matrix rows by any json field instead of the result key, e.g. "path_with_namespace". Implies --matrix.`)
+
+	cmd.Flags().StringVar(&opt.MatrixValue, "matrix_value", "",
+		`Print the value of any json field in the cells of the matrix instead of ✓, e.g. "state". Implies --matrix.`)
+
+	cmd.Flags().StringVar(&opt.Format, "format", "",
+		`Print every element with the go template, e.g. '{{.Host.FullName}} {{.Struct.Username}} {{date "2006-01-02" .Struct.LastActivityOn}}'.
+Grouped results are printed once per group: {{.Key}} {{.Count}} {{.Elements}}. Functions: date, bytes, join, value, json, upper, lower.`)
+
+	cmd.Flags().StringVar(&opt.TemplateFile, "template_file", "",
+		"Print the results with the go template from the file, see --format.")
+
+	cmd.Flags().BoolVarP(&opt.Interactive, "interactive", "i", false,
+		`Browse the results in the terminal: enter expands a result to the elements of every host, / filters the rows, 1-9 sort by the column,
+space marks the rows for the actions of the command, e.g. b blocks the users of "users list".`)
+}
+
+// StreamFlags adds --stream to the commands that support ResultOptions.PrintStream
+func StreamFlags(cmd *cobra.Command, opt *ResultOptions) {
+	cmd.Flags().BoolVar(&opt.Stream, "stream", false,
+		`Write the results as they arrive instead of collecting them first. Output formats: table, csv, ndjson.
+The elements are written in the order they arrive unless --order_by or --group_by is set, then they are sorted with temporary files.`)
+}
+
+// Validate checks the options against the struct type of the results before fetching them
+func (o *ResultOptions) Validate(structType interface{}) error {
+	if o.Where != "" {
+		if _, err := sort.ParseWhere(o.Where, structType); err != nil {
+			return err
+		}
+	}
+
+	if len(o.Columns) > 0 {
+		if _, err := sort.ParseColumns(o.Columns, structType); err != nil {
+			return err
+		}
+	}
+
+	if _, err := sort.ParseAggregations(o.Aggregate, structType); err != nil {
+		return err
+	}
+
+	if o.Stream && o.matrix() {
+		return fmt.Errorf("--matrix is not supported with --stream")
+	}
+
+	if o.Format != "" && o.TemplateFile != "" {
+		return fmt.Errorf("--format and --template_file can't be used together")
+	}
+
+	if _, err := o.template(); err != nil {
+		return err
+	}
+
+	if o.Interactive && (o.Stream || o.matrix() || o.Format != "" || o.TemplateFile != "") {
+		return fmt.Errorf("--interactive can't be used with --stream, --matrix, --format or --template_file")
+	}
+
+	for _, v := range []string{o.MatrixKey, o.MatrixValue} {
+		if v == "" {
+			continue
+		}
+		if _, err := sort.ParseColumns([]string{v}, structType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fields returns the field paths used by --where, --columns, --aggregate and the matrix options,
+// the options must be validated with the struct type first
+func (o *ResultOptions) Fields(structType interface{}) []string {
+	fields := make([]string, 0, len(o.Columns)+len(o.Aggregate)+2)
+	if o.Where != "" {
+		if w, err := sort.ParseWhere(o.Where, structType); err == nil {
+			fields = append(fields, w.Fields()...)
+		}
+	}
+
+	fields = append(fields, o.Columns...)
+	for _, spec := range o.Aggregate {
+		if _, field, ok := strings.Cut(spec, ":"); ok {
+			fields = append(fields, field)
+		}
+	}
+
+	for _, v := range []string{o.MatrixKey, o.MatrixValue} {
+		if v != "" {
+			fields = append(fields, v)
+		}
+	}
+
+	return fields
+}
+
+// Custom checks if the results should be printed with the custom output options instead of the default table
+func (o *ResultOptions) Custom() bool {
+	return len(o.Columns) > 0 || len(o.Aggregate) > 0 || o.matrix() || o.Format != "" || o.TemplateFile != "" || o.Interactive
+}
+
+// Returns the output template of --format or --template_file, nil if none is set
+func (o *ResultOptions) template() (*template.Template, error) {
+	text := o.Format
+	if o.TemplateFile != "" {
+		b, err := os.ReadFile(o.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := output.NewTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// Print prints the results in every format and logs the errors.
+// The results are printed once with the output template if it is set, the output formats are ignored then.
+// The matrix of objects and hosts is printed if requested, multi-level groups are printed as a tree,
+// otherwise aggregations are printed per result if any, and the columns of every element if not.
+func (o *ResultOptions) Print(formats []string, structType interface{}, results []sort.Result, errs []limiter.Error) error {
+	tmpl, err := o.template()
+	if err != nil {
+		return err
+	}
+	if tmpl != nil {
+		if err := output.Template(os.Stdout, tmpl, results); err != nil {
+			return err
+		}
+		for _, err := range errs {
+			hclog.L().Error(err.Err.Error())
+		}
+		return nil
+	}
+
+	if o.Interactive {
+		return o.browse(structType, results, errs)
+	}
+
+	if o.matrix() {
+		return o.printMatrix(formats, structType, results, errs)
+	}
+
+	var columns []sort.Column
+	if len(o.Columns) > 0 {
+		var err error
+		if columns, err = sort.ParseColumns(o.Columns, structType); err != nil {
+			return err
+		}
+	}
+
+	aggregations, err := sort.ParseAggregations(o.Aggregate, structType)
+	if err != nil {
+		return err
+	}
+
+	for _, format := range formats {
+		err := printStructured(format, func(w io.Writer) error {
+			switch {
+			case sort.Nested(results):
+				return output.Tree(w, format, columns, aggregations, results)
+			case len(aggregations) > 0:
+				return output.Aggregates(w, format, aggregations, results)
+			default:
+				return output.Columns(w, format, columns, results)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	includeStats(formats)
+
+	for _, err := range errs {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	return nil
+}
+
+// PrintStream prints the results as they arrive from the channel and logs the errors at the end
+func (o *ResultOptions) PrintStream(formats []string, data chan interface{}, opt *sort.Options, wg *limiter.Limiter) error {
+	if len(formats) != 1 {
+		return fmt.Errorf("only one output format is supported with --stream")
+	}
+
+	var columns []sort.Column
+	if len(o.Columns) > 0 {
+		var err error
+		if columns, err = sort.ParseColumns(o.Columns, opt.StructType); err != nil {
+			return err
+		}
+	}
+
+	aggregations, err := sort.ParseAggregations(o.Aggregate, opt.StructType)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := o.template()
+	if err != nil {
+		return err
+	}
+
+	if tmpl != nil {
+		if err := sort.Stream(data, opt, func(r sort.Result) error {
+			return output.TemplateResult(os.Stdout, tmpl, r)
+		}); err != nil {
+			return err
+		}
+	} else {
+		w, err := output.NewStreamWriter(os.Stdout, formats[0], columns, aggregations)
+		if err != nil {
+			return err
+		}
+
+		if err := sort.Stream(data, opt, w.Write); err != nil {
+			return err
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if tmpl == nil && Stats != nil && formats[0] == output.FormatNDJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(map[string]stats.Report{"stats": Stats.Report()}); err != nil {
+			return err
+		}
+	}
+
+	includeStats(formats)
+
+	for _, err := range wg.Errors() {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	return nil
+}
+
+// Shows the results in the terminal instead of printing them, the errors are logged after the user quits
+func (o *ResultOptions) browse(structType interface{}, results []sort.Result, errs []limiter.Error) error {
+	var columns []sort.Column
+	if len(o.Columns) > 0 {
+		var err error
+		if columns, err = sort.ParseColumns(o.Columns, structType); err != nil {
+			return err
+		}
+	}
+
+	aggregations, err := sort.ParseAggregations(o.Aggregate, structType)
+	if err != nil {
+		return err
+	}
+
+	// The progress bar would draw over the table
+	StopProgress()
+
+	if err := tui.Run(tui.NewModel(columns, aggregations, results), o.Actions...); err != nil {
+		return err
+	}
+
+	for _, err := range errs {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	return nil
+}
+
+func (o *ResultOptions) matrix() bool {
+	return o.Matrix || o.MatrixKey != "" || o.MatrixValue != ""
+}
+
+func (o *ResultOptions) printMatrix(formats []string, structType interface{}, results []sort.Result, errs []limiter.Error) error {
+	key, err := matrixColumn(o.MatrixKey, structType)
+	if err != nil {
+		return err
+	}
+
+	value, err := matrixColumn(o.MatrixValue, structType)
+	if err != nil {
+		return err
+	}
+
+	failed := make(client.Hosts, 0, len(errs))
+	for _, err := range errs {
+		failed = append(failed, err.Host)
+	}
+
+	for _, format := range formats {
+		err := printStructured(format, func(w io.Writer) error {
+			return output.Matrix(w, format, Client.Hosts, key, value, results, failed)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	includeStats(formats)
+
+	for _, err := range errs {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	return nil
+}
+
+// Returns nil if the column is not set
+func matrixColumn(name string, structType interface{}) (*sort.Column, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	columns, err := sort.ParseColumns([]string{name}, structType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &columns[0], nil
+}
+
+// Prints the results to stdout, json results are wrapped in an object with the stats if they are recorded
+func printStructured(format string, fn func(w io.Writer) error) error {
+	if Stats == nil || format != output.FormatJSON {
+		return fn(os.Stdout)
+	}
+
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Results json.RawMessage `json:"results"`
+		Stats   stats.Report    `json:"stats"`
+	}{buf.Bytes(), Stats.Report()})
+}
+
+// The stats table is not printed after the results if every format included the stats
+func includeStats(formats []string) {
+	for _, format := range formats {
+		if format != output.FormatJSON && format != output.FormatNDJSON {
+			return
+		}
+	}
+	statsIncluded = true
+}
@@ -1,12 +1,15 @@
 package common
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/flant/glaball/pkg/client"
 	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/gitlabfake"
 	"github.com/flant/glaball/pkg/limiter"
 )
 
@@ -43,3 +46,54 @@ func Setup(t *testing.T) (*http.ServeMux, *httptest.Server, *client.Client) {
 func Teardown(server *httptest.Server) {
 	server.Close()
 }
+
+// SetupFake starts the fake gitlab instances with the full names of the hosts
+// and sets up the config, the client and the limiter of the commands to use them.
+// The instances are stopped when the test is done.
+func SetupFake(t *testing.T, names ...string) *gitlabfake.Fake {
+	fake := gitlabfake.New(names...)
+	t.Cleanup(fake.Close)
+
+	Config = fake.Config()
+
+	var err error
+	if Client, err = client.NewClient(Config); err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	Limiter = limiter.NewLimiter(Config.Threads)
+
+	return fake
+}
+
+// RunWithInput runs the command with the input on stdin, e.g. the answer to util.AskUser, and returns its output
+func RunWithInput(t *testing.T, input string, fn func() error) string {
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(inW, input)
+	inW.Close()
+	os.Stdin, os.Stdout = inR, outW
+
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(outR)
+		done <- string(b)
+	}()
+
+	if err := fn(); err != nil {
+		t.Error(err)
+	}
+	outW.Close()
+
+	return <-done
+}
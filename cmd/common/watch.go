@@ -0,0 +1,93 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/watch"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+// WatchFlag adds --watch to the commands that support Watch
+func WatchFlag(cmd *cobra.Command, interval *time.Duration) {
+	cmd.Flags().DurationVar(interval, "watch", 0,
+		`Re-run the command every interval, e.g. 5m, with the cached responses revalidated and print only the changes
+of the results by key and host: + added, - removed, ~ changed with the old and the new values of the fields. Stop with Ctrl+C.`)
+}
+
+// ValidateWatch rejects the options that don't apply to the changes printed by Watch
+func (o *ResultOptions) ValidateWatch() error {
+	if o.Stream || o.Interactive || o.matrix() || o.Format != "" || o.TemplateFile != "" {
+		return fmt.Errorf("--watch can't be used with --stream, --interactive, --matrix, --format or --template_file")
+	}
+	return nil
+}
+
+// Watch collects the results every interval and prints the changes since the previous iteration until interrupted,
+// all results are printed as added on the first one. The id field tells apart the elements with the same key on a host.
+// The request options passed to collect revalidate the cached responses and must follow the cache options of the requests.
+func Watch(interval time.Duration, structType interface{}, id string,
+	collect func(options ...gitlab.RequestOptionFunc) ([]sort.Result, error)) error {
+	var idColumn *sort.Column
+	if id != "" {
+		columns, err := sort.ParseColumns([]string{id}, structType)
+		if err != nil {
+			return err
+		}
+		idColumn = &columns[0]
+	}
+
+	// The changes are printed as a log, the progress bar would be drawn between them
+	StopProgress()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var prev watch.Snapshot
+	for {
+		started := time.Now()
+
+		// Every iteration has its own errors
+		Limiter.Reset()
+
+		// The cached responses are revalidated on every iteration
+		results, err := collect(Client.WithNoCache())
+		if err != nil {
+			hclog.L().Error(err.Error())
+		}
+
+		failed := make(client.Hosts, 0)
+		for _, err := range Limiter.Errors() {
+			failed = append(failed, err.Host)
+			hclog.L().Error(err.Err.Error())
+		}
+
+		if err == nil {
+			next, err := watch.NewSnapshot(results, idColumn)
+			if err != nil {
+				return err
+			}
+			if prev == nil {
+				prev = make(watch.Snapshot)
+			}
+			if err := watch.Print(os.Stdout, started, watch.Diff(prev, next, failed)); err != nil {
+				return err
+			}
+			prev = next
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(started.Add(interval))):
+		}
+	}
+}
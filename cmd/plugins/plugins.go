@@ -0,0 +1,159 @@
+package plugins
+
+import (
+	"os"
+	"strings"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/plugin"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const groupID = "plugins"
+
+// NewCmds returns the commands running the glaball-<name> executables found in PATH,
+// the plugins with the names of the builtin commands are skipped.
+// initConfig is called again after the flags of glaball are parsed by the plugin command.
+func NewCmds(root *cobra.Command, initConfig func()) []*cobra.Command {
+	builtin := make(map[string]bool)
+	for _, cmd := range root.Commands() {
+		builtin[cmd.Name()] = true
+		for _, alias := range cmd.Aliases {
+			builtin[alias] = true
+		}
+	}
+	// Added by cobra on execute
+	builtin["help"], builtin["completion"] = true, true
+
+	cmds := make([]*cobra.Command, 0)
+	for _, p := range plugin.Find(os.Getenv("PATH")) {
+		if builtin[p.Name] {
+			continue
+		}
+		cmds = append(cmds, NewCmd(p, initConfig))
+	}
+
+	if len(cmds) > 0 {
+		root.AddGroup(&cobra.Group{ID: groupID, Title: "Plugin Commands:"})
+	}
+
+	return cmds
+}
+
+func NewCmd(p plugin.Plugin, initConfig func()) *cobra.Command {
+	var (
+		outputFormat string
+		pluginArgs   []string
+	)
+
+	cmd := &cobra.Command{
+		Use:     p.Name,
+		Short:   "Plugin " + p.Path,
+		GroupID: groupID,
+		// The flags of glaball are parsed before the root pre-run, the rest is passed to the plugin as is
+		DisableFlagParsing: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.InheritedFlags()
+			flags.StringVar(&outputFormat, "output", "table", "Output format passed to the plugin")
+
+			own, rest := splitArgs(flags, args)
+			if err := flags.Parse(own); err != nil {
+				return err
+			}
+			pluginArgs = rest
+
+			if initConfig != nil {
+				initConfig()
+			}
+
+			return cmd.Root().PersistentPreRunE(cmd, rest)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cachePath := common.Config.Cache.BasePath
+			if cachePath == "" {
+				var err error
+				if cachePath, err = config.DefaultCacheDir(); err != nil {
+					return err
+				}
+			}
+
+			return p.Run(pluginArgs, Hosts(common.Config, common.Client.Hosts), plugin.Options{
+				CachePath: cachePath,
+				Output:    outputFormat,
+				Threads:   common.Config.Threads,
+			})
+		},
+	}
+
+	return cmd
+}
+
+// Hosts returns the selected hosts with their tokens
+func Hosts(cfg *config.Config, hosts client.Hosts) []plugin.Host {
+	list := make([]plugin.Host, 0, len(hosts))
+	for _, h := range hosts {
+		c := cfg.Hosts[h.Team][h.Project][h.Name]
+		typ := c.Type
+		if typ == "" {
+			typ = client.Gitlab
+		}
+		list = append(list, plugin.Host{
+			Name:       h.FullName(),
+			Team:       h.Team,
+			Project:    h.Project,
+			Host:       h.Name,
+			Type:       typ,
+			URL:        h.URL,
+			IP:         c.IP,
+			Org:        c.Org,
+			Token:      c.Token,
+			Pagination: h.Pagination,
+		})
+	}
+	return list
+}
+
+// Splits the arguments into the flags of glaball and the arguments of the plugin, everything after -- is passed to the plugin
+func splitArgs(flags *pflag.FlagSet, args []string) (own, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return own, append(rest, args[i+1:]...)
+		}
+
+		var f *pflag.Flag
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name, _, _ := strings.Cut(arg[2:], "=")
+			f = flags.Lookup(name)
+			if f != nil && strings.Contains(arg, "=") {
+				own = append(own, arg)
+				continue
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			f = flags.ShorthandLookup(arg[1:2])
+			if f != nil && len(arg) > 2 {
+				own = append(own, arg)
+				continue
+			}
+		}
+
+		if f == nil {
+			rest = append(rest, arg)
+			continue
+		}
+
+		own = append(own, arg)
+		if f.NoOptDefVal == "" && i+1 < len(args) {
+			i++
+			own = append(own, args[i])
+		}
+	}
+
+	return own, rest
+}
@@ -0,0 +1,26 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringP("filter", "f", ".*", "")
+	flags.BoolP("update", "u", false, "")
+	flags.String("output", "table", "")
+
+	own, rest := splitArgs(flags, []string{
+		"report", "-f", "main.*", "--name", "x", "-u", "--output=json", "--verbose", "-fother", "--", "--filter", "y",
+	})
+
+	assert.Equal(t, []string{"-f", "main.*", "-u", "--output=json", "-fother"}, own)
+	assert.Equal(t, []string{"report", "--name", "x", "--verbose", "--filter", "y"}, rest)
+
+	assert.NoError(t, flags.Parse(own))
+	filter, _ := flags.GetString("filter")
+	assert.Equal(t, "other", filter)
+}
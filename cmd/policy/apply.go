@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flant/glaball/cmd/common"
+	"github.com/flant/glaball/cmd/projects"
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	applyFormat = util.Dict{
+		{
+			Key:   "HOST",
+			Value: "[%s]",
+		},
+		{
+			Key:   "REPOSITORY",
+			Value: "%s",
+		},
+		{
+			Key:   "ACTION",
+			Value: "%s",
+		},
+	}
+
+	applyTotalFormat = util.Dict{
+		{
+			Value: "Edited: %d",
+		},
+		{
+			Value: "Protected: %d",
+		},
+		{
+			Value: "Errors: %d",
+		},
+	}
+)
+
+func NewApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Remediate projects drifting from the policy",
+		Long:  "Edit project settings and protect default branches of the projects drifting from the policy.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Apply()
+		},
+	}
+
+	return cmd
+}
+
+func Apply() error {
+	cfg, err := LoadConfig(policyFile)
+	if err != nil {
+		return err
+	}
+
+	wg := common.Limiter
+	defer func() {
+		for _, err := range wg.Errors() {
+			hclog.L().Error(err.Err.Error())
+		}
+	}()
+
+	checked, results, err := listDrifts(cfg, wg)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Printf("All %d repositories in %v comply with the policy\n", checked, common.Client.Hosts.Projects(common.Config.ShowAll))
+		return nil
+	}
+
+	util.AskUser(fmt.Sprintf("Do you really want to remediate %d repositories in %v ?",
+		len(results), common.Client.Hosts.Projects(common.Config.ShowAll)))
+
+	drifting := make([]pipeline.Element[*ProjectDrift], 0)
+	for _, r := range results {
+		for _, v := range r.Elements.Typed() {
+			drifting = append(drifting, pipeline.Element[*ProjectDrift]{Host: v.Host, Value: v.Struct.(*ProjectDrift), Cached: bool(v.Cached)})
+		}
+	}
+
+	pipe := pipeline.New(wg)
+	applied := pipeline.Then(pipe, pipeline.From(drifting), remediate(common.Client.WithNoCache()))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	if _, err := fmt.Fprintln(w, strings.Join(applyFormat.Keys(), "\t")); err != nil {
+		return err
+	}
+
+	edited := 0
+	protected := 0
+	for v := range applied {
+		switch s := v.Value.(type) {
+		case *gitlab.Project:
+			edited++
+			if err := applyFormat.Print(w, "\t", v.Host.ProjectName(), s.WebURL, "edited"); err != nil {
+				return err
+			}
+		case *projects.ProjectProtectedBranch:
+			protected++
+			if err := applyFormat.Print(w, "\t", v.Host.ProjectName(), s.Project.WebURL,
+				fmt.Sprintf("protected %q", s.Project.DefaultBranch)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := applyTotalFormat.Print(w, "\n", edited, protected, len(wg.Errors())); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// Returns the stage editing the settings of the project and protecting its default branch as the policy requires
+func remediate(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*ProjectDrift], emit pipeline.Emit[interface{}]) error {
+	return func(ctx context.Context, e pipeline.Element[*ProjectDrift], emit pipeline.Emit[interface{}]) error {
+		pd := e.Value
+
+		opt, err := pd.EditProjectOptions()
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+
+		if opt != nil {
+			project := pipeline.Element[*gitlab.Project]{Host: e.Host, Value: pd.Project}
+			if err := projects.EditProject(*opt, options...)(ctx, project, func(v *gitlab.Project, cached bool) { emit(v, cached) }); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if opt := pd.ProtectRepositoryBranchesOptions(); opt != nil {
+			pb := &projects.ProjectProtectedBranch{Project: pd.Project, ProtectedBranches: pd.ProtectedBranches}
+			// Reprotect the branch if it is already protected with other access levels
+			_, forceProtect := pb.Search(pd.Project.DefaultBranch)
+			protected := pipeline.Element[*projects.ProjectProtectedBranch]{Host: e.Host, Value: pb}
+			if err := projects.ProtectRepositoryBranches(forceProtect, *opt, options...)(ctx, protected,
+				func(v *projects.ProjectProtectedBranch, cached bool) { emit(v, cached) }); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestApply(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/api", Visibility: gitlab.InternalVisibility})
+	alfa.AddProject(gitlab.Project{PathWithNamespace: "frontend/web", Visibility: gitlab.PrivateVisibility})
+	// The api of bravo already complies with the policy
+	bravo.AddProject(gitlab.Project{PathWithNamespace: "backend/api", Visibility: gitlab.PrivateVisibility}).
+		Protect("main", gitlab.MaintainerPermissions, gitlab.MaintainerPermissions)
+	bravo.AddProject(gitlab.Project{PathWithNamespace: "frontend/web", Visibility: gitlab.PublicVisibility})
+
+	policyFile = filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(policyFile, []byte(`
+policies:
+  - name: all
+    settings:
+      visibility: private
+  - name: backend
+    selector:
+      namespaces: ["^backend(/|$)"]
+    protect_default_branch:
+      push_access_level: 40
+`), 0o600))
+	defer func() { policyFile = "" }()
+
+	out := common.RunWithInput(t, "y\n", Apply)
+	assert.Contains(t, out, "Do you really want to remediate 2 repositories in [alfa.local bravo.local] ?")
+	assert.Contains(t, out, "Edited: 2\nProtected: 1\nErrors: 0\n")
+
+	api := alfa.Project("backend/api")
+	assert.Equal(t, gitlab.PrivateVisibility, api.Visibility)
+	if assert.Len(t, api.ProtectedBranches, 1) {
+		assert.Equal(t, "main", api.ProtectedBranches[0].Name)
+		assert.Equal(t, gitlab.MaintainerPermissions, api.ProtectedBranches[0].PushAccessLevels[0].AccessLevel)
+	}
+	assert.Empty(t, alfa.Project("frontend/web").ProtectedBranches)
+	assert.Equal(t, gitlab.PrivateVisibility, bravo.Project("frontend/web").Visibility)
+	assert.Len(t, bravo.Project("backend/api").ProtectedBranches, 1)
+
+	// The second run finds nothing to remediate
+	out = common.RunWithInput(t, "", Apply)
+	assert.Contains(t, out, "All 4 repositories in [alfa.local bravo.local] comply with the policy")
+}
@@ -0,0 +1,196 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	go_sort "sort"
+
+	"github.com/flant/glaball/cmd/common"
+	"github.com/flant/glaball/cmd/projects"
+	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	listProjectsOptions = gitlab.ListProjectsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Archived:    gitlab.Bool(false),
+	}
+	listProtectedBranchesOptions = gitlab.ListProtectedBranchesOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+
+	driftFormat = util.Dict{
+		{
+			Key:   "HOST",
+			Value: "[%s]",
+		},
+		{
+			Key:   "REPOSITORY",
+			Value: "%s",
+		},
+		{
+			Key:   "POLICY",
+			Value: "%s",
+		},
+		{
+			Key:   "SETTING",
+			Value: "%s",
+		},
+		{
+			Key:   "CURRENT",
+			Value: "%s",
+		},
+		{
+			Key:   "DESIRED",
+			Value: "%s",
+		},
+	}
+
+	checkTotalFormat = util.Dict{
+		{
+			Value: "Checked: %d",
+		},
+		{
+			Value: "Drifted: %d",
+		},
+		{
+			Value: "Errors: %d",
+		},
+	}
+)
+
+func NewCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check projects against the policy",
+		Long:  "List every project setting that drifts from the policy, per host.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Check()
+		},
+	}
+
+	return cmd
+}
+
+func Check() error {
+	cfg, err := LoadConfig(policyFile)
+	if err != nil {
+		return err
+	}
+
+	wg := common.Limiter
+	defer func() {
+		for _, err := range wg.Errors() {
+			hclog.L().Error(err.Err.Error())
+		}
+	}()
+
+	checked, results, err := listDrifts(cfg, wg)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	if _, err := fmt.Fprintln(w, strings.Join(driftFormat.Keys(), "\t")); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		for _, v := range r.Elements.Typed() {
+			pd := v.Struct.(*ProjectDrift)
+			for _, d := range pd.Drifts {
+				if err := driftFormat.Print(w, "\t",
+					v.Host.ProjectName(),
+					r.Key,
+					d.Policy,
+					d.Setting,
+					formatValue(d.Current),
+					formatValue(d.Desired),
+				); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := checkTotalFormat.Print(w, "\n", checked, len(results), len(wg.Errors())); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// listDrifts returns the number of projects matching the policy
+// and the projects drifting from it ordered by host
+func listDrifts(cfg *Config, wg *limiter.Limiter) (int, []sort.Result, error) {
+	pipe := pipeline.New(wg)
+
+	matched := pipeline.Collect(pipeline.Filter(
+		pipeline.FromHosts(pipe, common.Client.Hosts, projects.GitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())),
+		func(e pipeline.Element[*gitlab.Project]) bool { return len(cfg.Match(e.Host, e.Value)) > 0 }))
+
+	if len(matched) == 0 {
+		return 0, nil, fmt.Errorf("no projects matching the policy found")
+	}
+
+	// Protected branches are required only to check the default branch
+	protectedBranches := pipeline.Collect(pipeline.Then(pipe,
+		pipeline.Filter(pipeline.From(matched), func(e pipeline.Element[*gitlab.Project]) bool {
+			return cfg.ProtectsDefaultBranch(e.Host, e.Value)
+		}),
+		projects.ListProjectProtectedBranches(pipe.Slot(), listProtectedBranchesOptions, common.Client.WithCache())))
+
+	branches := make(map[string][]*gitlab.ProtectedBranch)
+	for _, e := range protectedBranches {
+		branches[fmt.Sprintf("%s/%d", e.Host.FullName(), e.Value.Project.ID)] = e.Value.ProtectedBranches
+	}
+
+	drifts := pipeline.Then(pipe, pipeline.From(matched),
+		func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectDrift]) error {
+			pd, err := cfg.Drift(e.Host, e.Value, branches[fmt.Sprintf("%s/%d", e.Host.FullName(), e.Value.ID)])
+			if err != nil {
+				return err
+			}
+			if len(pd.Drifts) > 0 {
+				emit(pd, e.Cached)
+			}
+			return nil
+		})
+
+	results, err := sort.FromChannel(pipeline.Sort(drifts), &sort.Options{
+		OrderBy:    []string{policyDefaultField},
+		SortBy:     "asc",
+		GroupBy:    policyDefaultField,
+		StructType: ProjectDrift{},
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	go_sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Elements.Typed()[0].Host.FullName() < results[j].Elements.Typed()[0].Host.FullName()
+	})
+
+	return len(matched), results, nil
+}
+
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,502 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	policyDefaultField = "project.web_url"
+
+	// Pseudo setting name used to report default branch protection drift
+	protectDefaultBranchSetting = "protect_default_branch"
+)
+
+var (
+	policyFile string
+)
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Project settings policy",
+		Long: `Describe desired project settings in a policy file and check or remediate projects that drift from it.
+
+Example policy file:
+
+policies:
+  - name: production
+    selector:
+      # the host must have all of the tags from the config
+      tags: ["production"]
+      # regexp of the host full name "<team>.<project>.<name>"
+      hosts: "main.*"
+      # regexps of the project namespace full path, any of them must match
+      namespaces: ["^backend(/|$)"]
+      # project must have all of the topics
+      topics: ["production"]
+    # any field of the Projects API "Edit project" endpoint,
+    # the ones the project doesn't return can't be checked and are skipped
+    settings:
+      only_allow_merge_if_pipeline_succeeds: true
+      visibility: private
+    # true or protected branch access levels
+    protect_default_branch:
+      push_access_level: 40
+      merge_access_level: 40`,
+	}
+
+	cmd.PersistentFlags().StringVar(&policyFile, "file", "", "Path to the policy file")
+	cmd.MarkPersistentFlagRequired("file")
+
+	cmd.AddCommand(
+		NewCheckCmd(),
+		NewApplyCmd(),
+	)
+
+	return cmd
+}
+
+type Config struct {
+	Policies []*Policy `yaml:"policies"`
+}
+
+type Policy struct {
+	Name                 string            `yaml:"name"`
+	Selector             Selector          `yaml:"selector"`
+	Settings             util.Patch        `yaml:"settings"`
+	ProtectDefaultBranch *BranchProtection `yaml:"protect_default_branch"`
+
+	// Settings that are not returned with the project
+	unverifiable map[string]bool
+}
+
+type Selector struct {
+	Tags       []string `yaml:"tags"`
+	Hosts      string   `yaml:"hosts"`
+	Namespaces []string `yaml:"namespaces"`
+	Topics     []string `yaml:"topics"`
+
+	hosts      *regexp.Regexp
+	namespaces []*regexp.Regexp
+}
+
+type BranchProtection struct {
+	Enabled          bool                     `yaml:"-"`
+	PushAccessLevel  *gitlab.AccessLevelValue `yaml:"push_access_level"`
+	MergeAccessLevel *gitlab.AccessLevelValue `yaml:"merge_access_level"`
+	AllowForcePush   *bool                    `yaml:"allow_force_push"`
+}
+
+// UnmarshalYAML allows to set branch protection either as bool or as access levels
+func (b *BranchProtection) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&b.Enabled)
+	}
+
+	type plain BranchProtection
+	if err := value.Decode((*plain)(b)); err != nil {
+		return err
+	}
+	b.Enabled = true
+
+	return nil
+}
+
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg Config
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %q error: %v", path, err)
+	}
+
+	if len(cfg.Policies) == 0 {
+		return nil, fmt.Errorf("no policies found in %q", path)
+	}
+
+	for i, p := range cfg.Policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy-%d", i)
+		}
+		if err := p.compile(); err != nil {
+			return nil, fmt.Errorf("invalid policy %q: %v", p.Name, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+func (p *Policy) compile() (err error) {
+	if p.Selector.Hosts != "" {
+		if p.Selector.hosts, err = regexp.Compile(p.Selector.Hosts); err != nil {
+			return err
+		}
+	}
+
+	for _, ns := range p.Selector.Namespaces {
+		re, err := regexp.Compile(ns)
+		if err != nil {
+			return err
+		}
+		p.Selector.namespaces = append(p.Selector.namespaces, re)
+	}
+
+	if err := p.Settings.Validate(gitlab.EditProjectOptions{}); err != nil {
+		return err
+	}
+
+	// Avatar is uploaded as a file and can't be set by value
+	if _, ok := p.Settings["avatar"]; ok {
+		return fmt.Errorf("setting %q is not supported", "avatar")
+	}
+
+	p.unverifiable = make(map[string]bool)
+	for _, k := range p.Settings.Keys() {
+		if !projectField(k) {
+			hclog.L().Warn("Setting is skipped, the project doesn't return it and its drift can't be checked",
+				"policy", p.Name, "setting", k)
+			p.unverifiable[k] = true
+		}
+	}
+
+	if len(p.Settings) == 0 && !p.ProtectsDefaultBranch() {
+		return fmt.Errorf("policy has no settings")
+	}
+
+	return nil
+}
+
+// Some of the edit options have different names in the project struct,
+// e.g. container_expiration_policy_attributes
+func projectField(k string) bool {
+	for _, name := range []string{k, strings.TrimSuffix(k, "_attributes")} {
+		if util.Patch(map[string]interface{}{name: nil}).Validate(gitlab.Project{}) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) ProtectsDefaultBranch() bool {
+	return p.ProtectDefaultBranch != nil && p.ProtectDefaultBranch.Enabled
+}
+
+// Match checks if the project on the host is selected by the policy
+func (s *Selector) Match(h *client.Host, project *gitlab.Project) bool {
+	if !h.HasTags(s.Tags...) {
+		return false
+	}
+
+	if s.hosts != nil && !s.hosts.MatchString(h.FullName()) {
+		return false
+	}
+
+	if len(s.namespaces) > 0 {
+		if project.Namespace == nil {
+			return false
+		}
+		matched := false
+		for _, re := range s.namespaces {
+			if re.MatchString(project.Namespace.FullPath) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, topic := range s.Topics {
+		found := false
+		for _, v := range project.Topics {
+			if v == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match returns all policies matching the project
+func (c *Config) Match(h *client.Host, project *gitlab.Project) []*Policy {
+	policies := make([]*Policy, 0, len(c.Policies))
+	for _, p := range c.Policies {
+		if p.Selector.Match(h, project) {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// ProtectsDefaultBranch checks if any of policies requires to protect the default branch of the project
+func (c *Config) ProtectsDefaultBranch(h *client.Host, project *gitlab.Project) bool {
+	for _, p := range c.Match(h, project) {
+		if p.ProtectsDefaultBranch() {
+			return true
+		}
+	}
+	return false
+}
+
+type Drift struct {
+	Policy  string      `json:"policy"`
+	Setting string      `json:"setting"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+type ProjectDrift struct {
+	Project           *gitlab.Project           `json:"project,omitempty"`
+	ProtectedBranches []*gitlab.ProtectedBranch `json:"protected_branches,omitempty"`
+	Drifts            []Drift                   `json:"drifts,omitempty"`
+
+	// Settings to edit and branch protection to apply
+	settings   util.Patch
+	protection *BranchProtection
+}
+
+// Drift compares the project with all matching policies.
+// Later policies override settings of the previous ones.
+func (c *Config) Drift(h *client.Host, project *gitlab.Project, protectedBranches []*gitlab.ProtectedBranch) (*ProjectDrift, error) {
+	current, err := toJSONMap(project)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(util.Patch)
+	policyOf := make(map[string]string)
+	var protection *BranchProtection
+	var protectionPolicy string
+
+	for _, p := range c.Match(h, project) {
+		// Merge a copy to keep nested settings of the policy untouched
+		s, err := normalize(p.Settings)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := s.(map[string]interface{}); ok {
+			settings.Merge(s)
+		}
+		for k := range p.Settings {
+			policyOf[k] = p.Name
+		}
+		for k := range p.unverifiable {
+			delete(settings, k)
+		}
+		if p.ProtectsDefaultBranch() {
+			protection, protectionPolicy = p.ProtectDefaultBranch, p.Name
+		}
+	}
+
+	pd := ProjectDrift{
+		Project:           project,
+		ProtectedBranches: protectedBranches,
+		settings:          make(util.Patch),
+	}
+
+	for _, k := range settings.Keys() {
+		desired, err := normalize(settings[k])
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := current[k]
+		if !ok {
+			// The empty fields of the project are omitted
+			value = current[strings.TrimSuffix(k, "_attributes")]
+		}
+
+		if contains(value, desired) {
+			continue
+		}
+
+		pd.Drifts = append(pd.Drifts, Drift{Policy: policyOf[k], Setting: k, Current: value, Desired: desired})
+		pd.settings[k] = settings[k]
+	}
+
+	if protection != nil && project.DefaultBranch != "" {
+		if current, ok := protection.Check(project.DefaultBranch, protectedBranches); !ok {
+			pd.Drifts = append(pd.Drifts, Drift{
+				Policy:  protectionPolicy,
+				Setting: protectDefaultBranchSetting,
+				Current: current,
+				Desired: protection.String(project.DefaultBranch),
+			})
+			pd.protection = protection
+		}
+	}
+
+	return &pd, nil
+}
+
+// EditProjectOptions returns options to remediate settings drift
+func (pd *ProjectDrift) EditProjectOptions() (*gitlab.EditProjectOptions, error) {
+	if len(pd.settings) == 0 {
+		return nil, nil
+	}
+
+	var opt gitlab.EditProjectOptions
+	if err := pd.settings.Apply(&opt); err != nil {
+		return nil, err
+	}
+
+	return &opt, nil
+}
+
+// ProtectRepositoryBranchesOptions returns options to remediate default branch protection drift
+func (pd *ProjectDrift) ProtectRepositoryBranchesOptions() *gitlab.ProtectRepositoryBranchesOptions {
+	if pd.protection == nil {
+		return nil
+	}
+
+	return &gitlab.ProtectRepositoryBranchesOptions{
+		Name:             gitlab.String(pd.Project.DefaultBranch),
+		PushAccessLevel:  pd.protection.PushAccessLevel,
+		MergeAccessLevel: pd.protection.MergeAccessLevel,
+		AllowForcePush:   pd.protection.AllowForcePush,
+	}
+}
+
+// Check if the branch is protected with desired access levels
+func (b *BranchProtection) Check(branch string, protectedBranches []*gitlab.ProtectedBranch) (string, bool) {
+	var found *gitlab.ProtectedBranch
+	for _, pb := range protectedBranches {
+		if pb.Name == branch {
+			found = pb
+			break
+		}
+		if found == nil && wildcardMatch(pb.Name, branch) {
+			found = pb
+		}
+	}
+
+	if found == nil {
+		return "not protected", false
+	}
+
+	current := fmt.Sprintf("protected by %q", found.Name)
+
+	if b.PushAccessLevel != nil && !hasAccessLevel(found.PushAccessLevels, *b.PushAccessLevel) {
+		return current, false
+	}
+
+	if b.MergeAccessLevel != nil && !hasAccessLevel(found.MergeAccessLevels, *b.MergeAccessLevel) {
+		return current, false
+	}
+
+	if b.AllowForcePush != nil && found.AllowForcePush != *b.AllowForcePush {
+		return current, false
+	}
+
+	return current, true
+}
+
+func (b *BranchProtection) String(branch string) string {
+	s := make([]string, 0, 3)
+	if b.PushAccessLevel != nil {
+		s = append(s, fmt.Sprintf("push_access_level=%d", *b.PushAccessLevel))
+	}
+	if b.MergeAccessLevel != nil {
+		s = append(s, fmt.Sprintf("merge_access_level=%d", *b.MergeAccessLevel))
+	}
+	if b.AllowForcePush != nil {
+		s = append(s, fmt.Sprintf("allow_force_push=%t", *b.AllowForcePush))
+	}
+	if len(s) == 0 {
+		return fmt.Sprintf("protected %q", branch)
+	}
+	return fmt.Sprintf("protected %q (%s)", branch, strings.Join(s, ", "))
+}
+
+func hasAccessLevel(levels []*gitlab.BranchAccessDescription, level gitlab.AccessLevelValue) bool {
+	for _, l := range levels {
+		if l.AccessLevel == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Protected branch names support "*" wildcard
+func wildcardMatch(pattern, name string) bool {
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	re, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
+
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Convert value to its json representation to compare with the project fields
+func normalize(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n interface{}
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Check if the current value contains the desired one.
+// Nested objects are compared only by the desired keys.
+func contains(current, desired interface{}) bool {
+	d, ok := desired.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(current, desired)
+	}
+
+	c, ok := current.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	for k, v := range d {
+		if !contains(c[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestDrift(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+policies:
+  - name: all
+    settings:
+      visibility: private
+      mirror_branch_regex: "^main$"
+      container_expiration_policy_attributes:
+        enabled: true
+  - name: backend
+    selector:
+      tags: ["production"]
+      hosts: "^main\\."
+      namespaces: ["^backend(/|$)"]
+      topics: ["production"]
+    settings:
+      only_allow_merge_if_pipeline_succeeds: true
+      visibility: internal
+    protect_default_branch:
+      push_access_level: 40
+`), 0o600))
+
+	cfg, err := LoadConfig(path)
+	assert.NoError(t, err)
+
+	h := &client.Host{Team: "main", Project: "group", Name: "alfa", Tags: []string{"production", "eu"}}
+	project := &gitlab.Project{
+		ID:            1,
+		DefaultBranch: "main",
+		Visibility:    gitlab.InternalVisibility,
+		Namespace:     &gitlab.ProjectNamespace{FullPath: "backend/api"},
+		Topics:        []string{"production", "go"},
+		ContainerExpirationPolicy: &gitlab.ContainerExpirationPolicy{
+			Enabled: true,
+		},
+	}
+
+	pd, err := cfg.Drift(h, project, []*gitlab.ProtectedBranch{{Name: "ma*"}})
+	assert.NoError(t, err)
+	if assert.Len(t, pd.Drifts, 2) {
+		assert.Equal(t, "only_allow_merge_if_pipeline_succeeds", pd.Drifts[0].Setting)
+		assert.Equal(t, protectDefaultBranchSetting, pd.Drifts[1].Setting)
+		assert.Equal(t, `protected by "ma*"`, pd.Drifts[1].Current)
+	}
+
+	opt, err := pd.EditProjectOptions()
+	assert.NoError(t, err)
+	assert.True(t, *opt.OnlyAllowMergeIfPipelineSucceeds)
+	assert.Nil(t, opt.Visibility)
+	assert.Nil(t, opt.MirrorBranchRegex)
+	assert.Equal(t, "main", *pd.ProtectRepositoryBranchesOptions().Name)
+
+	// Project outside of the backend namespace is checked only by the first policy
+	project.Namespace.FullPath = "frontend"
+	pd, err = cfg.Drift(h, project, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, pd.Drifts, 1) {
+		assert.Equal(t, "visibility", pd.Drifts[0].Setting)
+		assert.Equal(t, "all", pd.Drifts[0].Policy)
+	}
+	assert.Nil(t, pd.ProtectRepositoryBranchesOptions())
+
+	// Host without the tag is checked only by the first policy too
+	project.Namespace.FullPath = "backend/api"
+	pd, err = cfg.Drift(&client.Host{Team: "main", Project: "group", Name: "beta"}, project, nil)
+	assert.NoError(t, err)
+	assert.Len(t, pd.Drifts, 1)
+
+	assert.NoError(t, os.WriteFile(path, []byte("policies:\n  - settings:\n      unknown_field: 1\n"), 0o600))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
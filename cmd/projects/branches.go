@@ -1,14 +1,16 @@
 package projects
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 
 	"github.com/flant/glaball/cmd/common"
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/google/go-github/v66/github"
@@ -72,9 +74,14 @@ func NewBranchesListCmd() *cobra.Command {
 		"Return branches sorted in asc or desc order. Default is desc")
 
 	cmd.Flags().StringSliceVar(&branchOrderBy, "order_by", []string{"count", branchDefaultField},
-		`Return branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.`)
+		`Return branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	graphQLFlag(cmd)
+
+	common.RegisterFieldCompletions(cmd, ProjectBranch{})
 
 	return cmd
 }
@@ -90,63 +97,62 @@ type RepositoryBranch struct {
 }
 
 func BranchesListCmd() error {
+	if err := resultOptions.Validate(ProjectBranch{}); err != nil {
+		return err
+	}
+
 	if !sort.ValidOrderBy(branchOrderBy, ProjectBranch{}) {
 		branchOrderBy = append(branchOrderBy, branchDefaultField)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
-	defer func() {
-		for _, err := range wg.Errors() {
-			hclog.L().Error(err.Err.Error())
+	// The commit of the branches is not fetched with graphql, the results would depend on the hosts supporting it
+	if useGraphQL {
+		for _, field := range append(sort.Fields(branchOrderBy), resultOptions.Fields(ProjectBranch{})...) {
+			if field = strings.TrimSpace(field); field == "branches.commit" || strings.HasPrefix(field, "branches.commit.") {
+				return fmt.Errorf("field %q is not supported with --graphql", field)
+			}
 		}
-	}()
-
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting branches from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
+	pipe := pipeline.New(common.Limiter)
+	defer func() {
+		for _, err := range pipe.Errors() {
+			hclog.L().Error(err.Err.Error())
+		}
 	}()
 
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
-	}
-
-	if len(toList) == 0 {
-		return fmt.Errorf("no projects found")
-	}
-
-	branches := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listBranches(v.Host, v.Struct.(*gitlab.Project), listBranchesOptions, wg, branches, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(branches)
-	}()
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	batches, projects := graphQLProjects(projects)
+	branches := pipeline.Merge(
+		pipeline.Then(pipe, batches, graphQLListBranches(pipe.Slot(), listBranchesOptions, common.Client.WithCache())),
+		pipeline.Then(pipe, projects, listBranches(pipe.Slot(), listBranchesOptions, common.Client.WithCache())),
+	)
 
-	results, err := sort.FromChannel(branches, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(branches), &sort.Options{
 		OrderBy:    branchOrderBy,
 		SortBy:     sortBy,
 		GroupBy:    branchDefaultField,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: ProjectBranch{},
 	})
 	if err != nil {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
 	if len(results) == 0 {
 		return fmt.Errorf("no branches found")
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, ProjectBranch{}, results, nil)
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	if _, err := fmt.Fprintln(w, strings.Join(branchFormat.Keys(), "\t")); err != nil {
 		return err
@@ -161,10 +167,15 @@ func BranchesListCmd() error {
 		for _, v := range r.Elements.Typed() {
 			pb := v.Struct.(*ProjectBranch)
 			for _, b := range pb.Branches {
+				// The commit is not fetched with graphql
+				updated := "-"
+				if b.Commit != nil && b.Commit.CommittedDate != nil {
+					updated = b.Commit.CommittedDate.Format("2006-01-02 15:04:05")
+				}
 				if err := branchFormat.Print(w, "\t",
 					v.Host.ProjectName(),
 					b.WebURL,
-					b.Commit.CommittedDate.Format("2006-01-02 15:04:05"),
+					updated,
 					v.Cached,
 				); err != nil {
 					return err
@@ -175,7 +186,7 @@ func BranchesListCmd() error {
 
 	}
 
-	if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+	if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 		return err
 	}
 
@@ -186,29 +197,33 @@ func BranchesListCmd() error {
 	return nil
 }
 
-func listBranches(h *client.Host, project *gitlab.Project, opt gitlab.ListBranchesOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.Branches.ListBranches(project.ID, &opt, options...)
-	wg.Unlock()
-	if err != nil {
-		wg.Error(h, err)
-		return err
-	}
+// Returns the stage listing all branches of the project
+func listBranches(slot sync.Locker, opt gitlab.ListBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectBranch]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectBranch]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		var (
+			mu     sync.Mutex
+			pb     = ProjectBranch{Project: e.Value, Branches: make([]*gitlab.Branch, 0)}
+			cached = true
+		)
+
+		err := pipeline.PaginateHost(slot, e.Host, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Branch, *gitlab.Response, error) {
+			return e.Host.Client.Branches.ListBranches(e.Value.ID, &opt, slices.Concat(options, page)...)
+		}, func(b *gitlab.Branch, c bool) {
+			mu.Lock()
+			pb.Branches = append(pb.Branches, b)
+			cached = cached && c
+			mu.Unlock()
+		})
+		if err != nil {
+			return err
+		}
 
-	data <- sort.Element{
-		Host:   h,
-		Struct: &ProjectBranch{Project: project, Branches: list},
-		Cached: resp.Header.Get("X-From-Cache") == "1"}
+		emit(&pb, cached)
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listBranches(h, project, opt, wg, data, options...)
+		return nil
 	}
-
-	return nil
 }
@@ -1,12 +1,13 @@
 package projects
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"reflect"
 	"text/tabwriter"
 
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 
@@ -19,13 +20,22 @@ import (
 
 var (
 	editProjectsOptions = gitlab.EditProjectOptions{}
+	editProjectsSet     []string
+	editProjectsPatch   string
 )
 
 func NewEditCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "edit",
 		Short: "Edit projects.",
+		Long: `Edit projects settings.
+Any field of the Projects API "Edit project" endpoint can be set with --set key=value
+(nested fields are addressed with dots, e.g. container_expiration_policy_attributes.keep_n=5)
+or with a yaml patch file provided by --patch flag.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := parseEditProjectsPatch(&editProjectsOptions); err != nil {
+				return err
+			}
 			return Edit()
 		},
 	}
@@ -39,15 +49,25 @@ func NewEditCmd() *cobra.Command {
 	cmd.Flags().StringSliceVar(&orderBy, "order_by", []string{"count", projectDefaultField},
 		`Return projects ordered by id, name, path, created_at, updated_at, last_activity_at, or similarity fields.
 repository_size, storage_size, packages_size or wiki_size fields are only allowed for administrators.
-similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.`)
+similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
 	editProjectsOptionsFlags(cmd, &editProjectsOptions)
 
+	common.RegisterFieldCompletions(cmd, gitlab.Project{})
+
 	return cmd
 }
 
 func editProjectsOptionsFlags(cmd *cobra.Command, opt *gitlab.EditProjectOptions) {
+	cmd.Flags().StringArrayVar(&editProjectsSet, "set", []string{},
+		`Set project field value by its json name, e.g. --set merge_method=ff --set visibility=private.
+Can be specified multiple times. Overrides values from --patch file.`)
+
+	cmd.Flags().StringVar(&editProjectsPatch, "patch", "",
+		"Path to the yaml file with project fields to set, e.g. only_allow_merge_if_pipeline_succeeds: true")
+
 	cmd.Flags().Var(util.NewStringPtrValue(&opt.AutoCancelPendingPipelines), "auto_cancel_pending_pipelines",
 		"Auto-cancel pending pipelines. This isn’t a boolean, but enabled/disabled.")
 
@@ -64,47 +84,52 @@ func editProjectsOptionsFlags(cmd *cobra.Command, opt *gitlab.EditProjectOptions
 		"Enable shared runners for this project.")
 }
 
-func Edit() error {
-	if !sort.ValidOrderBy(orderBy, gitlab.Project{}) {
-		orderBy = append(orderBy, projectDefaultField)
-	}
+// Apply --patch file and --set values on top of the options set by flags
+func parseEditProjectsPatch(opt *gitlab.EditProjectOptions) error {
+	patch := make(util.Patch)
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	if editProjectsPatch != "" {
+		v, err := util.PatchFromFile(opt, editProjectsPatch)
+		if err != nil {
+			return err
+		}
+		patch.Merge(v)
+	}
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Fetching projects from %s ...\n", h.URL)
-		// TODO: context with cancel
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
+	v, err := util.ParsePatch(opt, editProjectsSet)
+	if err != nil {
+		return err
 	}
+	patch.Merge(v)
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	// Avatar is uploaded as a file and can't be set by value
+	if _, ok := patch["avatar"]; ok {
+		return fmt.Errorf("field %q is not supported", "avatar")
+	}
 
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
+	if err := patch.Apply(opt); err != nil {
+		return fmt.Errorf("invalid project settings: %v", err)
 	}
 
-	if len(toList) == 0 {
-		return fmt.Errorf("no projects found")
+	if reflect.ValueOf(*opt).IsZero() {
+		return fmt.Errorf("no project settings to edit, use --set, --patch or other flags")
 	}
 
-	projects := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go editProject(v.Host, v.Struct.(*gitlab.Project), editProjectsOptions, wg, projects, common.Client.WithCache())
+	return nil
+}
+
+func Edit() error {
+	if !sort.ValidOrderBy(orderBy, gitlab.Project{}) {
+		orderBy = append(orderBy, projectDefaultField)
 	}
 
-	go func() {
-		wg.Wait()
-		close(projects)
-	}()
+	pipe := pipeline.New(common.Limiter)
+
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	edited := pipeline.Then(pipe, projects, editProject(editProjectsOptions, common.Client.WithCache()))
 
-	results, err := sort.FromChannel(projects, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(edited), &sort.Options{
 		OrderBy:    orderBy,
 		SortBy:     sortBy,
 		GroupBy:    groupBy,
@@ -114,6 +139,10 @@ func Edit() error {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "COUNT\tREPOSITORY\tHOSTS\tCACHED\n")
 	unique := 0
@@ -125,34 +154,37 @@ func Edit() error {
 		fmt.Fprintf(w, "[%d]\t%s\t%s\t[%s]\n", v.Count, v.Key, v.Elements.Hosts().Projects(common.Config.ShowAll), v.Cached)
 	}
 
-	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(wg.Errors()))
+	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func editProject(h *client.Host, project *gitlab.Project, opt gitlab.EditProjectOptions, wg *limiter.Limiter,
-	data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
+// Returns the stage editing the project
+func editProject(opt gitlab.EditProjectOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.Project]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.Project]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
+		v, resp, err := e.Host.Client.Projects.EditProject(e.Value.ID, &opt, options...)
+		if err != nil {
+			return err
+		}
 
-	v, resp, err := h.Client.Projects.EditProject(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-
-	wg.Unlock()
+		emit(v, resp.Header.Get("X-From-Cache") == "1")
 
-	data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		return nil
+	}
+}
 
-	return nil
+// EditProject returns the stage editing the project
+func EditProject(opt gitlab.EditProjectOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.Project]) error {
+	return editProject(opt, options...)
 }
@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/google/go-github/v66/github"
 	"gopkg.in/yaml.v3"
@@ -80,24 +82,19 @@ func Search() error {
 		re = append(re, r)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Searching for files in %s ...\n", h.URL)
-		// TODO: context with cancel
+	projects := pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsFilesOptions, common.Client.WithCache()))
+	files := pipeline.Then(pipe, projects, func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectFile]) error {
 		for _, fp := range filepaths {
-			wg.Add(1)
-			go listProjectsFiles(h, fp, gitRef, re, listProjectsFilesOptions, wg, data, common.Client.WithCache())
+			if err := getRawFile(fp, gitRef, re, common.Client.WithCache())(ctx, e, emit); err != nil {
+				return err
+			}
 		}
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+		return nil
+	})
 
-	results, err := sort.FromChannel(data, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(files), &sort.Options{
 		OrderBy:    []string{"project.web_url"},
 		SortBy:     "desc",
 		GroupBy:    "",
@@ -134,9 +131,9 @@ func Search() error {
 		}
 	}
 
-	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(wg.Errors()))
+	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(pipe.Errors()))
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -160,21 +157,12 @@ func SearchRegexp() error {
 		re = append(re, r)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Searching for files in %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjectsFilesRegexp(h, gitRef, re, listProjectsFilesOptions, wg, data, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	projects := pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsFilesOptions, common.Client.WithCache()))
+	found := pipeline.Then(pipe, projects, listTree(pipe.Slot(), gitRef, re, listProjectsFilesOptions.ListOptions, common.Client.WithCache()))
 
-	results, err := sort.FromChannel(data, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(found), &sort.Options{
 		OrderBy:    []string{"web_url"},
 		SortBy:     "desc",
 		GroupBy:    "",
@@ -195,181 +183,134 @@ func SearchRegexp() error {
 		fmt.Fprintf(w, "[%d]\t%s\t%s\t[%s]\n", v.Count, v.Key, v.Elements.Hosts().Projects(common.Config.ShowAll), v.Cached)
 	}
 
-	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(wg.Errors()))
+	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func listProjectsFiles(h *client.Host, filepath, ref string, re []*regexp.Regexp, opt gitlab.ListProjectsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage searching the file of the project for the patterns, the project is passed if any of them is found
+func getRawFile(filepath, ref string, re []*regexp.Regexp, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectFile]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectFile]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	list, resp, err := h.Client.Projects.ListProjects(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		wg.Add(1)
-		// TODO: handle deadlock when no files found
-		go getRawFile(h, v, filepath, ref, re, wg, data, options...)
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjectsFiles(h, filepath, ref, re, opt, wg, data, options...)
-	}
-}
+		h, project := e.Host, e.Value
 
-func listProjectsFilesFromGithub(h *client.Host, filepath, ref string, re []*regexp.Regexp, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-
-	defer wg.Done()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.Repositories.ListByOrg(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, &opt)
-	if err != nil {
+		targetRef := ref
+		if ref == "" {
+			targetRef = project.DefaultBranch
+		}
+		raw, resp, err := h.Client.RepositoryFiles.GetRawFile(project.ID, filepath, &gitlab.GetRawFileOptions{Ref: &targetRef}, options...)
 		if err != nil {
-			wg.Error(h, err)
-			wg.Unlock()
-			return
+			hclog.L().Named("files").Trace("get raw file error", "project", project.WebURL, "error", err)
+			return nil
 		}
-	}
-	wg.Unlock()
 
-	for _, v := range list {
-		wg.Add(1)
-		// TODO: handle deadlock when no files found
-		go getRawFileFromGithub(h, v, filepath, ref, re, wg, data)
-	}
+		for _, r := range re {
+			if r.Match(raw) {
+				emit(&ProjectFile{Project: project, Raw: raw}, resp.Header.Get("X-From-Cache") == "1")
+				hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "project", h.Project, "host", h.URL,
+					"repo", project.WebURL, "file", filepath, "pattern", r.String(), "content", hclog.Fmt("%s", raw))
+				return nil
+			}
+		}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjectsFilesFromGithub(h, filepath, ref, re, opt, wg, data, options...)
+		return nil
 	}
-
-	return
 }
 
-func getRawFile(h *client.Host, project *gitlab.Project, filepath, ref string, re []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage searching the file of the github repository for the patterns, the repository is passed if any of them is found
+func getRawFileFromGithub(filepath, ref string, re []*regexp.Regexp) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*RepositoryFile]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*RepositoryFile]) error {
+		h, repository := e.Host, e.Value
 
-	targetRef := ref
-	if ref == "" {
-		targetRef = project.DefaultBranch
-	}
-	wg.Lock()
-	raw, resp, err := h.Client.RepositoryFiles.GetRawFile(project.ID, filepath, &gitlab.GetRawFileOptions{Ref: &targetRef}, options...)
-	wg.Unlock()
-	if err != nil {
-		hclog.L().Named("files").Trace("get raw file error", "project", project.WebURL, "error", err)
-		return
-	}
+		targetRef := ref
+		if ref == "" {
+			targetRef = repository.GetDefaultBranch()
+		}
+		// TODO:
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+		defer cancel()
+		fileContent, _, resp, err := h.GithubClient.Repositories.GetContents(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true),
+			repository.Owner.GetLogin(),
+			repository.GetName(),
+			filepath,
+			&github.RepositoryContentGetOptions{Ref: targetRef})
+		if err != nil {
+			hclog.L().Named("files").Trace("get raw file error", "repository", repository.GetHTMLURL(), "error", err)
+			return nil
+		}
 
-	for _, r := range re {
-		if r.Match(raw) {
-			data <- sort.Element{Host: h, Struct: &ProjectFile{Project: project, Raw: raw}, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "project", h.Project, "host", h.URL,
-				"repo", project.WebURL, "file", filepath, "pattern", r.String(), "content", hclog.Fmt("%s", raw))
-			return
+		raw, err := fileContent.GetContent()
+		if err != nil {
+			hclog.L().Named("files").Trace("get raw file error", "repository", repository.GetHTMLURL(), "error", err)
+			return nil
+		}
+
+		for _, r := range re {
+			if r.MatchString(raw) {
+				emit(&RepositoryFile{Repository: repository, Raw: raw}, resp.Header.Get("X-From-Cache") == "1")
+				hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "repository", h.Project, "host", h.URL,
+					"repo", repository.GetHTMLURL(), "file", filepath, "pattern", r.String(), "content", hclog.Fmt("%s", raw))
+				return nil
+			}
 		}
+
+		return nil
 	}
 }
 
-// TODO:
-func getRawFileFromGithub(h *client.Host, repository *github.Repository, filepath, ref string, re []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}) {
+// Returns the stage linting the .gitlab-ci.yml file of the project, with check the project is passed
+// only if the merged file matches any of the patterns
+func getGitlabCIFile(check bool, re []*regexp.Regexp, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectLintResult]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectLintResult]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	targetRef := ref
-	if ref == "" {
-		targetRef = repository.GetDefaultBranch()
-	}
-	// TODO:
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	fileContent, _, resp, err := h.GithubClient.Repositories.GetContents(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true),
-		repository.Owner.GetLogin(),
-		repository.GetName(),
-		filepath,
-		&github.RepositoryContentGetOptions{Ref: targetRef})
-	wg.Unlock()
-	if err != nil {
-		hclog.L().Named("files").Trace("get raw file error", "repository", repository.GetHTMLURL(), "error", err)
-		return
-	}
-
-	raw, err := fileContent.GetContent()
-	if err != nil {
-		hclog.L().Named("files").Trace("get raw file error", "repository", repository.GetHTMLURL(), "error", err)
-		return
-	}
+		h, project := e.Host, e.Value
 
-	for _, r := range re {
-		if r.MatchString(raw) {
-			data <- sort.Element{Host: h, Struct: &RepositoryFile{Repository: repository, Raw: raw}, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "repository", h.Project, "host", h.URL,
-				"repo", repository.GetHTMLURL(), "file", filepath, "pattern", r.String(), "content", hclog.Fmt("%s", raw))
-			return
+		lint, resp, err := h.Client.Validate.ProjectLint(project.ID, &gitlab.ProjectLintOptions{}, options...)
+		if err != nil {
+			hclog.L().Named("files").Trace("project lint error", "project", project.WebURL, "error", err)
+			return nil
 		}
-	}
-}
-
-func getGitlabCIFile(h *client.Host, check bool, project *gitlab.Project, re []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
 
-	defer wg.Done()
+		var v map[string]interface{}
+		if err := yaml.NewDecoder(strings.NewReader(lint.MergedYaml)).Decode(&v); err != nil {
+			hclog.L().Named("files").Debug("error decoding .gitlab-ci.yml file, skipping", "team", h.Team, "project", h.Project, "host", h.URL,
+				"repo", project.WebURL, "content", lint.MergedYaml, "error", err)
+			return nil
+		}
 
-	wg.Lock()
-	lint, resp, err := h.Client.Validate.ProjectLint(project.ID, &gitlab.ProjectLintOptions{}, options...)
-	wg.Unlock()
-	if err != nil {
-		hclog.L().Named("files").Trace("project lint error", "project", project.WebURL, "error", err)
-		return
-	}
+		if !check {
+			emit(&ProjectLintResult{Project: project, MergedYaml: v}, resp.Header.Get("X-From-Cache") == "1")
+			return nil
+		}
 
-	var v map[string]interface{}
-	if err := yaml.NewDecoder(strings.NewReader(lint.MergedYaml)).Decode(&v); err != nil {
-		hclog.L().Named("files").Debug("error decoding .gitlab-ci.yml file, skipping", "team", h.Team, "project", h.Project, "host", h.URL,
-			"repo", project.WebURL, "content", lint.MergedYaml, "error", err)
-		return
-	}
+		for _, r := range re {
+			if r.MatchString(lint.MergedYaml) {
+				emit(&ProjectLintResult{Project: project, MergedYaml: v}, resp.Header.Get("X-From-Cache") == "1")
+				hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "project", h.Project, "host", h.URL,
+					"repo", project.WebURL, "pattern", r.String(), "content", lint.MergedYaml)
+				return nil
+			}
+		}
 
-	if !check {
-		data <- sort.Element{Host: h, Struct: &ProjectLintResult{Project: project, MergedYaml: v}, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		return
-	}
+		hclog.L().Named("files").Debug("search pattern was not found in file", "team", h.Team, "project", h.Project, "host", h.URL,
+			"repo", project.WebURL, "patterns", hclog.Fmt("%v", re))
 
-	for _, r := range re {
-		if r.MatchString(lint.MergedYaml) {
-			data <- sort.Element{Host: h, Struct: &ProjectLintResult{Project: project, MergedYaml: v}, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			hclog.L().Named("files").Trace("search pattern was found in file", "team", h.Team, "project", h.Project, "host", h.URL,
-				"repo", project.WebURL, "pattern", r.String(), "content", lint.MergedYaml)
-			return
-		}
+		return nil
 	}
-
-	hclog.L().Named("files").Debug("search pattern was not found in file", "team", h.Team, "project", h.Project, "host", h.URL,
-		"repo", project.WebURL, "patterns", hclog.Fmt("%v", re))
 }
 
 type ProjectFile struct {
@@ -387,110 +328,128 @@ type ProjectLintResult struct {
 	MergedYaml map[string]interface{} `json:"merged_yaml,omitempty"`
 }
 
-func listProjectsFilesRegexp(h *client.Host, ref string, re []*regexp.Regexp, opt gitlab.ListProjectsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage searching the repository tree of the project for the first blob with the path matching the patterns,
+// the project is passed if the contents of the blob match them too
+func listTree(slot sync.Locker, ref string, re []*regexp.Regexp, opt gitlab.ListOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.Project]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.Project]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	list, resp, err := h.Client.Projects.ListProjects(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		h, project := e.Host, e.Value
 
-	for _, v := range list {
-		// context
-		wg.Add(1)
 		targetRef := ref
 		if ref == "" {
-			targetRef = v.DefaultBranch
+			targetRef = project.DefaultBranch
 		}
-		go listTree(h, v, re, gitlab.ListTreeOptions{ListOptions: opt.ListOptions, Ref: &targetRef, Recursive: gitlab.Bool(true)},
-			wg, data, options...)
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjectsFilesRegexp(h, ref, re, opt, wg, data, options...)
-	}
-}
-
-func listTree(h *client.Host, project *gitlab.Project, re []*regexp.Regexp, opt gitlab.ListTreeOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.Repositories.ListTree(project.ID, &opt, options...)
-	if err != nil {
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		if v.Type == "blob" {
+		treeOptions := gitlab.ListTreeOptions{ListOptions: opt, Ref: &targetRef, Recursive: gitlab.Bool(true)}
+
+		var (
+			mu    sync.Mutex
+			blobs []*gitlab.TreeNode
+		)
+
+		err := pipeline.PaginateHost(slot, h, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.TreeNode, *gitlab.Response, error) {
+			return h.Client.Repositories.ListTree(project.ID, &treeOptions, slices.Concat(options, page)...)
+		}, func(v *gitlab.TreeNode, _ bool) {
+			if v.Type != "blob" {
+				return
+			}
 			for _, r := range re {
 				if r.MatchString(v.Path) {
-					wg.Add(1)
-					go rawBlobContent(h, project, re, v.ID, wg, data, options...)
+					mu.Lock()
+					blobs = append(blobs, v)
+					mu.Unlock()
 					return
 				}
 			}
+		})
+		if err != nil || len(blobs) == 0 {
+			return nil
 		}
-	}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listTree(h, project, re, opt, wg, data, options...)
-	}
+		raw, resp, err := h.Client.Repositories.RawBlobContent(project.ID, blobs[0].ID, options...)
+		if err != nil {
+			return err
+		}
 
+		for _, r := range re {
+			if r.Match(raw) {
+				emit(project, resp.Header.Get("X-From-Cache") == "1")
+				return nil
+			}
+		}
+
+		return nil
+	}
 }
 
-func rawBlobContent(h *client.Host, project *gitlab.Project, re []*regexp.Regexp, sha string,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// ListProjectsFiles returns the first stage of the pipelines searching the file of the projects of every gitlab host for the patterns
+func ListProjectsFiles(slot sync.Locker, filepath, ref string, re []*regexp.Regexp, opt gitlab.ListProjectsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*ProjectFile]) error {
+
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*ProjectFile]) error {
+		projects, err := collectHost(ctx, h, gitlabProjects(slot, opt, options...))
+		if err != nil {
+			return err
+		}
 
-	defer wg.Done()
+		for _, e := range projects {
+			if err := getRawFile(filepath, ref, re, options...)(ctx, e, emit); err != nil {
+				return err
+			}
+		}
 
-	wg.Lock()
-	raw, resp, err := h.Client.Repositories.RawBlobContent(project.ID, sha, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
+		return nil
 	}
-	wg.Unlock()
+}
 
-	for _, r := range re {
-		if r.Match(raw) {
-			data <- sort.Element{Host: h, Struct: project, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			return
+// ListProjectsFilesFromGithub returns the first stage of the pipelines searching the file of the repositories of every github host for the patterns
+func ListProjectsFilesFromGithub(slot sync.Locker, filepath, ref string, re []*regexp.Regexp, opt github.RepositoryListByOrgOptions) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*RepositoryFile]) error {
+
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*RepositoryFile]) error {
+		repositories, err := collectHost(ctx, h, githubRepositories(slot, opt))
+		if err != nil {
+			return err
 		}
-	}
 
-}
+		for _, e := range repositories {
+			if err := getRawFileFromGithub(filepath, ref, re)(ctx, e, emit); err != nil {
+				return err
+			}
+		}
 
-func ListProjectsFiles(h *client.Host, filepath, ref string, re []*regexp.Regexp, opt gitlab.ListProjectsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	listProjectsFiles(h, filepath, ref, re, opt, wg, data, options...)
+		return nil
+	}
 }
 
-func ListProjectsFilesFromGithub(h *client.Host, filepath, ref string, re []*regexp.Regexp, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	listProjectsFilesFromGithub(h, filepath, ref, re, opt, wg, data, options...)
+// GetRawFile returns the stage searching the file of the project for the patterns
+func GetRawFile(filepath, ref string, re []*regexp.Regexp, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectFile]) error {
+	return getRawFile(filepath, ref, re, options...)
 }
 
-func GetRawFile(h *client.Host, project *gitlab.Project, filepath, ref string, re []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	getRawFile(h, project, filepath, ref, re, wg, data, options...)
+// GetRawFileFromGithub returns the stage searching the file of the github repository for the patterns
+func GetRawFileFromGithub(filepath, ref string, re []*regexp.Regexp) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*RepositoryFile]) error {
+	return getRawFileFromGithub(filepath, ref, re)
 }
 
-func GetRawFileFromGithub(h *client.Host, repository *github.Repository, filepath, ref string, re []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}) {
-	getRawFileFromGithub(h, repository, filepath, ref, re, wg, data)
+// Collects the values of the first stage for the host, so the task of the host can make
+// the next requests holding its worker slot
+func collectHost[T any](ctx context.Context, h *client.Host,
+	fn func(ctx context.Context, h *client.Host, emit pipeline.Emit[T]) error) ([]pipeline.Element[T], error) {
+	var (
+		mu   sync.Mutex
+		list []pipeline.Element[T]
+	)
+
+	err := fn(ctx, h, func(v T, cached bool) {
+		mu.Lock()
+		list = append(list, pipeline.Element[T]{Host: h, Value: v, Cached: cached})
+		mu.Unlock()
+	})
+
+	return list, err
 }
@@ -0,0 +1,126 @@
+package projects
+
+import (
+	"context"
+	"sync"
+
+	"github.com/flant/glaball/cmd/common"
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/pipeline"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+var useGraphQL bool
+
+func graphQLFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&useGraphQL, "graphql", false,
+		`Fetch the nested fields of the projects with batched graphql queries instead of a request per project on the hosts supporting it.`)
+}
+
+// Splits the projects into the batches for the graphql api and the projects of the hosts without it
+func graphQLProjects(projects <-chan pipeline.Element[*gitlab.Project]) (<-chan pipeline.Element[[]*gitlab.Project], <-chan pipeline.Element[*gitlab.Project]) {
+	supported, other := pipeline.Split(projects, func(e pipeline.Element[*gitlab.Project]) bool {
+		if !useGraphQL {
+			return false
+		}
+		if !common.Client.GraphQL.Supported(e.Host) {
+			hclog.L().Debug("GraphQL is not supported, falling back to the rest api", "host", e.Host.URL)
+			return false
+		}
+		return true
+	})
+	return pipeline.Batch(supported, client.GraphQLBatchSize), other
+}
+
+// Returns the stage getting the languages of the batch of projects with a single query,
+// the projects missing from the response are queried with the rest api
+func graphQLProjectLanguages(options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectWithLanguages]) error {
+
+	rest := getProjectLanguages(options...)
+
+	return func(ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectWithLanguages]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		languages, err := common.Client.GraphQL.Languages(e.Host, e.Value, options...)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range e.Value {
+			l, ok := languages[p.ID]
+			if !ok {
+				if err := rest(ctx, pipeline.Element[*gitlab.Project]{Host: e.Host, Value: p, Cached: e.Cached}, emit); err != nil {
+					return err
+				}
+				continue
+			}
+			emit(&ProjectWithLanguages{Project: p, Languages: l}, false)
+		}
+
+		return nil
+	}
+}
+
+// Returns the stage listing the branch names of the batch of projects with a single query,
+// the projects with too many branches are listed with the rest api
+func graphQLListBranches(slot sync.Locker, opt gitlab.ListBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectBranch]) error {
+
+	rest := listBranches(slot, opt, options...)
+
+	return func(ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectBranch]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		branches, err := common.Client.GraphQL.Branches(e.Host, e.Value, options...)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range e.Value {
+			list, ok := branches[p.ID]
+			if !ok {
+				if err := rest(ctx, pipeline.Element[*gitlab.Project]{Host: e.Host, Value: p, Cached: e.Cached}, emit); err != nil {
+					return err
+				}
+				continue
+			}
+			emit(&ProjectBranch{Project: p, Branches: list}, false)
+		}
+
+		return nil
+	}
+}
+
+// Returns the stage listing the protected branches of the batch of projects with a single query,
+// the projects with too many branch rules or missing from the response are listed with the rest api
+func graphQLListProtectedBranches(slot sync.Locker, opt gitlab.ListProtectedBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+
+	rest := listProjectProtectedBranches(slot, opt, options...)
+
+	return func(ctx context.Context, e pipeline.Element[[]*gitlab.Project], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		protected, err := common.Client.GraphQL.ProtectedBranches(e.Host, e.Value, options...)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range e.Value {
+			list, ok := protected[p.ID]
+			if !ok {
+				if err := rest(ctx, pipeline.Element[*gitlab.Project]{Host: e.Host, Value: p, Cached: e.Cached}, emit); err != nil {
+					return err
+				}
+				continue
+			}
+			emit(&ProjectProtectedBranch{Project: p, ProtectedBranches: list}, false)
+		}
+
+		return nil
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
+	"slices"
 	go_sort "sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/tui"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/google/go-github/v66/github"
 
@@ -34,15 +37,17 @@ func NewListCmd() *cobra.Command {
 		Use:   "list",
 		Short: "List projects.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(orderBy) == 0 {
+			// Streamed projects are written in the order they arrive by default
+			if len(orderBy) == 0 && !resultOptions.Stream {
 				orderBy = []string{"count", projectDefaultField}
 			}
+			resultOptions.Actions = []tui.Action{protectAction()}
 			return List()
 		},
 	}
 
-	cmd.Flags().Var(util.NewEnumValue(&groupBy, "name", "path"), "group_by",
-		"Return projects grouped by id, name, path, fields.")
+	cmd.Flags().StringVar(&groupBy, "group_by", "",
+		"Return projects grouped by name, path, host or any nested field. Multiple comma separated fields produce nested groups, e.g. host,namespace.full_path.")
 
 	cmd.Flags().Var(util.NewEnumValue(&sortBy, "asc", "desc"), "sort",
 		"Return projects sorted in asc or desc order. Default is desc")
@@ -50,9 +55,14 @@ func NewListCmd() *cobra.Command {
 	cmd.Flags().StringSliceVar(&orderBy, "order_by", []string{},
 		`Return projects ordered by id, name, path, created_at, updated_at, last_activity_at, or similarity fields.
 repository_size, storage_size, packages_size or wiki_size fields are only allowed for administrators.
-similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.`)
+similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	common.StreamFlags(cmd, &resultOptions)
+
+	common.RegisterFieldCompletions(cmd, gitlab.Project{})
 
 	return cmd
 }
@@ -69,8 +79,8 @@ func NewLanguagesCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().Var(util.NewEnumValue(&groupBy, "name", "path"), "group_by",
-		"Return projects grouped by id, name, path, fields.")
+	cmd.Flags().StringVar(&groupBy, "group_by", "",
+		"Return projects grouped by host or any nested field. Multiple comma separated fields produce nested groups, e.g. host,project.namespace.full_path.")
 
 	cmd.Flags().Var(util.NewEnumValue(&sortBy, "asc", "desc"), "sort",
 		"Return projects sorted in asc or desc order. Default is desc")
@@ -78,9 +88,14 @@ func NewLanguagesCmd() *cobra.Command {
 	cmd.Flags().StringSliceVar(&orderBy, "order_by", []string{},
 		`Return projects ordered by id, name, path, created_at, updated_at, last_activity_at, or similarity fields.
 repository_size, storage_size, packages_size or wiki_size fields are only allowed for administrators.
-similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.`)
+similarity (introduced in GitLab 14.1) is only available when searching and is limited to projects that the current user is a member of.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	graphQLFlag(cmd)
+
+	common.RegisterFieldCompletions(cmd, ProjectWithLanguages{})
 
 	return cmd
 }
@@ -113,6 +128,7 @@ Available in GitLab Premium self-managed, GitLab Premium SaaS, and higher tiers.
 
 	cmd.Flags().Var(util.NewStringPtrValue(&opt.Search), "search",
 		"Return list of projects matching the search criteria.")
+	cmd.RegisterFlagCompletionFunc("search", common.CompleteProjects)
 
 	cmd.Flags().Var(util.NewBoolPtrValue(&opt.SearchNamespaces), "search_namespaces",
 		"Include ancestor namespaces when matching search criteria. Default is false.")
@@ -151,35 +167,52 @@ Available in GitLab Premium self-managed, GitLab Premium SaaS, and higher tiers.
 }
 
 func List() error {
-	if !sort.ValidOrderBy(orderBy, gitlab.Project{}) {
-		orderBy = append(orderBy, projectDefaultField)
+	if err := resultOptions.Validate(gitlab.Project{}); err != nil {
+		return err
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Fetching projects from %s ...\n", h.URL)
-		// TODO: context with cancel
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
+	if len(orderBy) > 0 && !sort.ValidOrderBy(orderBy, gitlab.Project{}) {
+		orderBy = append(orderBy, projectDefaultField)
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	results, err := sort.FromChannel(data, &sort.Options{
+	var found int
+	projects := pipeline.Sort(pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts,
+		hostProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found))
+
+	opt := &sort.Options{
 		OrderBy:    orderBy,
 		SortBy:     sortBy,
 		GroupBy:    groupBy,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: gitlab.Project{},
-	})
+	}
+
+	if resultOptions.Stream {
+		if err := resultOptions.PrintStream(outputFormat, projects, opt, common.Limiter); err != nil {
+			return err
+		}
+		if found == 0 {
+			return fmt.Errorf("no projects found")
+		}
+		return nil
+	}
+
+	results, err := sort.FromChannel(projects, opt)
 	if err != nil {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
+	if resultOptions.Custom() || sort.Nested(results) {
+		return resultOptions.Print(outputFormat, gitlab.Project{}, results, pipe.Errors())
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "COUNT\tREPOSITORY\tHOSTS\tCACHED\n")
 	unique := 0
@@ -191,11 +224,11 @@ func List() error {
 		fmt.Fprintf(w, "[%d]\t%s\t%s\t[%s]\n", v.Count, v.Key, v.Elements.Hosts().Projects(common.Config.ShowAll), v.Cached)
 	}
 
-	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(wg.Errors()))
+	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -204,54 +237,43 @@ func List() error {
 
 func ListWithLanguages() error {
 	structT := new(ProjectWithLanguages)
+	if err := resultOptions.Validate(structT); err != nil {
+		return err
+	}
 	if !sort.ValidOrderBy(orderBy, structT) {
 		orderBy = append(orderBy, projectWithLanguagesDefaultField)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Fetching projects from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
-	}
+	pipe := pipeline.New(common.Limiter)
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	batches, projects := graphQLProjects(projects)
+	projectsWithLanguages := pipeline.Merge(
+		pipeline.Then(pipe, batches, graphQLProjectLanguages(common.Client.WithCache())),
+		pipeline.Then(pipe, projects, getProjectLanguages(common.Client.WithCache())),
+	)
 
-	projectList := make(sort.Elements, 0)
-	for e := range data {
-		projectList = append(projectList, e)
-	}
-
-	if len(projectList) == 0 {
-		return fmt.Errorf("no projects found")
-	}
-
-	projectsWithLanguages := make(chan interface{})
-	for _, v := range projectList.Typed() {
-		wg.Add(1)
-		go getProjectLanguages(v.Host, v.Struct.(*gitlab.Project), wg, projectsWithLanguages, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(projectsWithLanguages)
-	}()
-
-	results, err := sort.FromChannel(projectsWithLanguages, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(projectsWithLanguages), &sort.Options{
 		OrderBy:    orderBy,
 		SortBy:     sortBy,
 		GroupBy:    groupBy,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: structT,
 	})
 	if err != nil {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
+	if resultOptions.Custom() || sort.Nested(results) {
+		return resultOptions.Print(outputFormat, structT, results, pipe.Errors())
+	}
+
 	projectsWithLanguagesFormat := util.Dict{
 		{
 			Key:   "COUNT",
@@ -327,7 +349,7 @@ func ListWithLanguages() error {
 			}
 		}
 
-		if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+		if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 			return err
 		}
 
@@ -336,89 +358,90 @@ func ListWithLanguages() error {
 		}
 	}
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func listProjects(h *client.Host, opt gitlab.ListProjectsOptions, wg *limiter.Limiter, data chan<- interface{},
-	options ...gitlab.RequestOptionFunc) error {
-
-	defer wg.Done()
+// Returns the first stage of the pipelines over the projects of the gitlab hosts and the repositories of the github hosts
+func hostProjects(slot sync.Locker, opt gitlab.ListProjectsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[interface{}]) error {
 
-	// TODO:
-	if h.GithubClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-		defer cancel()
-		list, resp, err := h.GithubClient.Repositories.ListByOrg(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org,
-			&github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}},
-		)
-		if err != nil {
-			wg.Error(h, err)
-			return err
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[interface{}]) error {
+		if h.GithubClient == nil {
+			return gitlabProjects(slot, opt, options...)(ctx, h, func(v *gitlab.Project, cached bool) { emit(v, cached) })
 		}
 
-		for _, v := range list {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
+		hclog.L().Debug("Fetching repositories", "host", h.URL)
 
-		if resp.NextPage > 0 {
-			wg.Add(1)
-			opt.Page = resp.NextPage
-			go listProjects(h, opt, wg, data, options...)
+		return githubRepositories(slot, github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}})(ctx, h,
+			func(v *github.Repository, cached bool) { emit(v, cached) })
+	}
+}
+
+// Returns the first stage of the pipelines over the repositories of every github host, gitlab hosts are skipped
+func githubRepositories(slot sync.Locker, opt github.RepositoryListByOrgOptions) func(ctx context.Context, h *client.Host, emit pipeline.Emit[*github.Repository]) error {
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*github.Repository]) error {
+		if h.GithubClient == nil {
+			return nil
 		}
 
-		return nil
+		// TODO:
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+		defer cancel()
+		ctx = context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
 
+		// The options are changed for the pagination of the host
+		opt := opt
+
+		return pipeline.PaginateGithub(slot, func(page int) ([]*github.Repository, *github.Response, error) {
+			opt.Page = page
+			return h.GithubClient.Repositories.ListByOrg(ctx, h.Org, &opt)
+		}, emit)
 	}
+}
 
-	wg.Lock()
+// Returns the first stage of the pipelines over the projects of every gitlab host, github hosts are skipped
+func gitlabProjects(slot sync.Locker, opt gitlab.ListProjectsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
 
-	list, resp, err := h.Client.Projects.ListProjects(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Unlock() // TODO: ratelimiter
+		if h.GithubClient != nil {
+			hclog.L().Debug("Skipping github host", "host", h.URL)
+			return nil
+		}
 
-	for _, v := range list {
-		data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-	}
+		hclog.L().Debug("Fetching projects", "host", h.URL)
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjects(h, opt, wg, data, options...)
-	}
+		// The options are changed for the pagination of the host
+		opt := opt
 
-	return nil
+		return pipeline.PaginateHost(slot, h, pipeline.ProjectsKeyset(&opt), func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Project, *gitlab.Response, error) {
+			return h.Client.Projects.ListProjects(&opt, slices.Concat(options, page)...)
+		}, emit)
+	}
 }
 
-func getProjectLanguages(h *client.Host, project *gitlab.Project, wg *limiter.Limiter,
-	data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
+// Returns the stage getting the languages of the project
+func getProjectLanguages(options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectWithLanguages]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectWithLanguages]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	list, resp, err := h.Client.Projects.GetProjectLanguages(project.ID, options...)
-	wg.Unlock()
-	if err != nil {
-		wg.Error(h, err)
-		return err
-	}
+		list, resp, err := e.Host.Client.Projects.GetProjectLanguages(e.Value.ID, options...)
+		if err != nil {
+			return err
+		}
 
-	data <- sort.Element{
-		Host: h,
-		Struct: &ProjectWithLanguages{
-			Project:   project,
-			Languages: list},
-		Cached: resp.Header.Get("X-From-Cache") == "1"}
+		emit(&ProjectWithLanguages{Project: e.Value, Languages: list}, resp.Header.Get("X-From-Cache") == "1")
 
-	return nil
+		return nil
+	}
 }
 
 type ProjectWithLanguages struct {
@@ -459,3 +482,9 @@ func (p ProjectWithLanguages) LanguagesToString() string {
 
 	return strings.Join(names, ", ")
 }
+
+// GitlabProjects returns the first stage of the pipelines over the projects of every gitlab host
+func GitlabProjects(slot sync.Locker, opt gitlab.ListProjectsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
+	return gitlabProjects(slot, opt, options...)
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
+	"sync"
 	"text/tabwriter"
 	"time"
 
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/google/go-github/v66/github"
@@ -29,6 +31,9 @@ var (
 	listProjectMergeRequestsOptions = gitlab.ListProjectMergeRequestsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
 
 	byNamespaces []string
+
+	mergeRequestsOrderBy       []string
+	mergeRequestsWatchInterval time.Duration
 )
 
 func NewMergeRequestsCmd() *cobra.Command {
@@ -50,6 +55,16 @@ func NewMergeRequestListCmd() *cobra.Command {
 		Short: "List merge requests",
 		Long:  "Get all merge requests the authenticated user has access to.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// Streamed merge requests are written in the order they arrive by default
+			if resultOptions.Stream && !cmd.Flags().Changed("order_by") {
+				mergeRequestsOrderBy = nil
+			}
+			if len(mergeRequestsOrderBy) == 0 && !resultOptions.Stream {
+				mergeRequestsOrderBy = []string{"count", projectDefaultField}
+			}
+			if mergeRequestsWatchInterval > 0 {
+				return WatchMergeRequestsCmd()
+			}
 			return MergeRequestsListCmd()
 		},
 	}
@@ -58,23 +73,34 @@ func NewMergeRequestListCmd() *cobra.Command {
 		"Limit projects by multiple groups. Default: all projects.")
 
 	cmd.Flags().StringSliceVar(&outputFormat, "output", []string{"table"},
-		"Output format: [table csv]. Default: table.")
+		"Output format: [table csv]. Default: table. json is also supported with --columns, --aggregate and --matrix, ndjson with --stream.")
 
 	cmd.Flags().Var(util.NewEnumValue(&sortBy, "asc", "desc"), "sort",
 		"Return merge requests sorted in asc or desc order. Default is desc")
 
-	cmd.Flags().StringSliceVar(&orderBy, "order_by", []string{"count", projectDefaultField},
-		`Return requests ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url. https://pkg.go.dev/github.com/xanzy/go-gitlab#MergeRequest`)
+	cmd.Flags().StringSliceVar(&mergeRequestsOrderBy, "order_by", []string{"count", projectDefaultField},
+		`Return requests ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url. https://pkg.go.dev/github.com/xanzy/go-gitlab#MergeRequest
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
 	listProjectMergeRequestsOptionsFlags(cmd, &listProjectMergeRequestsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	common.StreamFlags(cmd, &resultOptions)
+	common.WatchFlag(cmd, &mergeRequestsWatchInterval)
+
+	common.RegisterFieldCompletions(cmd, gitlab.MergeRequest{})
 
 	return cmd
 }
 
-func MergeRequestsListCmd() error {
-	if !sort.ValidOrderBy(orderBy, gitlab.Project{}) {
-		orderBy = append(orderBy, projectDefaultField)
+// Checks the options and sets the defaults of the merge requests listing
+func prepareMergeRequestsList() error {
+	if err := resultOptions.Validate(gitlab.MergeRequest{}); err != nil {
+		return err
+	}
+
+	if len(mergeRequestsOrderBy) > 0 && !sort.ValidOrderBy(mergeRequestsOrderBy, gitlab.Project{}) {
+		mergeRequestsOrderBy = append(mergeRequestsOrderBy, projectDefaultField)
 	}
 
 	// sort namespaces in ascending order for fast search
@@ -95,53 +121,94 @@ func MergeRequestsListCmd() error {
 		listProjectMergeRequestsOptions.Scope = gitlab.String("all")
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	return nil
+}
+
+// Returns the merge requests of the projects of all hosts in the order of the hosts and the projects
+// The projects of the merge requests are counted in found, the options follow the cache option of the requests
+// (see common.Watch)
+func mergeRequestsPipeline(found *int, options ...gitlab.RequestOptionFunc) chan interface{} {
+	pipe := pipeline.New(common.Limiter)
+	options = append([]gitlab.RequestOptionFunc{common.Client.WithCache()}, options...)
+
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, options...)), found)
+	if len(byNamespaces) > 0 {
+		projects = pipeline.Filter(projects, func(e pipeline.Element[*gitlab.Project]) bool {
+			return util.ContainsString(byNamespaces, e.Value.Namespace.Name)
+		})
+	}
+	return pipeline.Sort(pipeline.Then(pipe, projects,
+		listProjectMergeRequests(pipe.Slot(), listProjectMergeRequestsOptions, options...)))
+}
+
+func mergeRequestsSortOptions() *sort.Options {
+	return &sort.Options{
+		OrderBy:    mergeRequestsOrderBy,
+		SortBy:     sortBy,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
+		StructType: gitlab.MergeRequest{},
+	}
+}
+
+func collectMergeRequests(options ...gitlab.RequestOptionFunc) ([]sort.Result, error) {
+	var found int
+	results, err := sort.FromChannel(mergeRequestsPipeline(&found, options...), mergeRequestsSortOptions())
+	if err != nil {
+		return nil, err
+	}
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting merge requests from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjectsByNamespace(h, byNamespaces, listProjectsOptions, wg, data, common.Client.WithCache())
+	if found == 0 {
+		return nil, fmt.Errorf("no projects found")
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	return results, nil
+}
 
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
+// WatchMergeRequestsCmd prints the changes of the merge requests every --watch interval
+func WatchMergeRequestsCmd() error {
+	if err := prepareMergeRequestsList(); err != nil {
+		return err
 	}
 
-	if len(toList) == 0 {
-		return fmt.Errorf("no projects found")
+	if err := resultOptions.ValidateWatch(); err != nil {
+		return err
 	}
 
-	mergeRequests := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listMergeRequests(v.Host, v.Struct.(*gitlab.Project), listProjectMergeRequestsOptions, wg, mergeRequests, common.Client.WithCache())
+	return common.Watch(mergeRequestsWatchInterval, gitlab.MergeRequest{}, "id", collectMergeRequests)
+}
+
+func MergeRequestsListCmd() error {
+	if err := prepareMergeRequestsList(); err != nil {
+		return err
 	}
 
-	go func() {
-		wg.Wait()
-		close(mergeRequests)
-	}()
+	if resultOptions.Stream {
+		var found int
+		if err := resultOptions.PrintStream(outputFormat, mergeRequestsPipeline(&found), mergeRequestsSortOptions(), common.Limiter); err != nil {
+			return err
+		}
+		if found == 0 {
+			return fmt.Errorf("no projects found")
+		}
+		return nil
+	}
 
-	results, err := sort.FromChannel(mergeRequests, &sort.Options{
-		OrderBy:    orderBy,
-		SortBy:     sortBy,
-		StructType: gitlab.MergeRequest{},
-	})
+	results, err := collectMergeRequests()
 	if err != nil {
 		return err
 	}
 
+	wg := common.Limiter
+
 	if len(results) == 0 {
 		return fmt.Errorf("no merge requests found")
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, gitlab.MergeRequest{}, results, wg.Errors())
+	}
+
 	if util.ContainsString(outputFormat, "csv") {
 		w := csv.NewWriter(os.Stdout)
 		w.Write([]string{"HOST", "URL", "Title", "Username", "Last"})
@@ -189,376 +256,177 @@ func MergeRequestsListCmd() error {
 	return nil
 }
 
-func listProjectsByNamespace(h *client.Host, namespaces []string, opt gitlab.ListProjectsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.Projects.ListProjects(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		if len(namespaces) == 0 || util.ContainsString(namespaces, v.Namespace.Name) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
+// ListProjectsByNamespace returns the first stage of the pipelines over the projects of the namespaces of every gitlab host,
+// all projects are passed if namespaces is empty
+func ListProjectsByNamespace(slot sync.Locker, namespaces []string, opt gitlab.ListProjectsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjectsByNamespace(h, namespaces, opt, wg, data, options...)
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
+		return gitlabProjects(slot, opt, options...)(ctx, h, func(v *gitlab.Project, cached bool) {
+			if len(namespaces) == 0 || util.ContainsString(namespaces, v.Namespace.Name) {
+				emit(v, cached)
+			}
+		})
 	}
-
-	return nil
 }
 
-func ListProjectsByNamespace(h *client.Host, namespaces []string, opt gitlab.ListProjectsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listProjectsByNamespace(h, namespaces, opt, wg, data, options...)
+// ListRepositories returns the first stage of the pipelines over the repositories of every github host
+func ListRepositories(slot sync.Locker, archived bool, opt github.RepositoryListByOrgOptions) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*github.Repository]) error {
+	return ListRepositoriesByNamespace(slot, nil, archived, opt)
 }
 
-func listRepositories(h *client.Host, archived bool, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}) error {
-	defer wg.Done()
+// ListRepositoriesByNamespace returns the first stage of the pipelines over the repositories with the names
+// of every github host, all repositories are passed if namespaces is empty
+func ListRepositoriesByNamespace(slot sync.Locker, namespaces []string, archived bool, opt github.RepositoryListByOrgOptions) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*github.Repository]) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.Repositories.ListByOrg(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, &opt)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		if v.GetArchived() == archived {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listRepositories(h, archived, opt, wg, data)
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*github.Repository]) error {
+		return githubRepositories(slot, opt)(ctx, h, func(v *github.Repository, cached bool) {
+			if v.GetArchived() == archived && (len(namespaces) == 0 || util.ContainsString(namespaces, v.GetName())) {
+				emit(v, cached)
+			}
+		})
 	}
-
-	return nil
 }
 
-func ListRepositories(h *client.Host, archived bool, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}) error {
-	return listRepositories(h, archived, opt, wg, data)
-}
-
-func listRepositoriesByNamespace(h *client.Host, namespaces []string, archived bool, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}) error {
-	defer wg.Done()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.Repositories.ListByOrg(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, &opt)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
+// Returns the stage listing the merge requests of the project
+func listProjectMergeRequests(slot sync.Locker, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
 
-	for _, v := range list {
-		if v.GetArchived() == archived && (len(namespaces) == 0 || util.ContainsString(namespaces, v.GetName())) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listRepositoriesByNamespace(h, namespaces, archived, opt, wg, data)
+		return pipeline.PaginateHost(slot, e.Host, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.MergeRequest, *gitlab.Response, error) {
+			return e.Host.Client.MergeRequests.ListProjectMergeRequests(e.Value.ID, &opt, slices.Concat(options, page)...)
+		}, emit)
 	}
-
-	return nil
-}
-
-func ListRepositoriesByNamespace(h *client.Host, namespaces []string, archived bool, opt github.RepositoryListByOrgOptions,
-	wg *limiter.Limiter, data chan<- interface{}) error {
-	return listRepositoriesByNamespace(h, namespaces, archived, opt, wg, data)
 }
 
-func listMergeRequests(h *client.Host, project *gitlab.Project, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
+// Returns the stage listing the merge requests of the project matching fn
+func listProjectMergeRequestsFunc(slot sync.Locker, opt gitlab.ListProjectMergeRequestsOptions, fn func(v *gitlab.MergeRequest) bool,
+	options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
 
-	wg.Lock()
-	list, resp, err := h.Client.MergeRequests.ListProjectMergeRequests(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listMergeRequests(h, project, opt, wg, data, options...)
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
+		return listProjectMergeRequests(slot, opt, options...)(ctx, e, func(v *gitlab.MergeRequest, cached bool) {
+			if fn(v) {
+				emit(v, cached)
+			}
+		})
 	}
-
-	return nil
 }
 
-func ListMergeRequests(h *client.Host, project *gitlab.Project, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listMergeRequests(h, project, opt, wg, data, options...)
-}
-
-func listMergeRequestsByAuthorID(h *client.Host, project *gitlab.Project, authorIDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.MergeRequests.ListProjectMergeRequests(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		if len(authorIDs) == 0 || (v.Author != nil && util.ContainsInt(authorIDs, v.Author.ID)) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listMergeRequestsByAuthorID(h, project, authorIDs, opt, wg, data, options...)
-	}
-
-	return nil
+// ListMergeRequests returns the stage listing the merge requests of the project
+func ListMergeRequests(slot sync.Locker, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
+	return listProjectMergeRequests(slot, opt, options...)
 }
 
 // authorIDs slice must be sorted in ascending order
-func ListMergeRequestsByAuthorID(h *client.Host, project *gitlab.Project, authorIDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listMergeRequestsByAuthorID(h, project, authorIDs, opt, wg, data, options...)
-}
-
-func listMergeRequestsByAssigneeID(h *client.Host, project *gitlab.Project, assigneeIDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.MergeRequests.ListProjectMergeRequests(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		if len(assigneeIDs) == 0 || (v.Assignee != nil && util.ContainsInt(assigneeIDs, v.Assignee.ID)) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listMergeRequestsByAssigneeID(h, project, assigneeIDs, opt, wg, data, options...)
-	}
+func ListMergeRequestsByAuthorID(slot sync.Locker, authorIDs []int, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
 
-	return nil
+	return listProjectMergeRequestsFunc(slot, opt, func(v *gitlab.MergeRequest) bool {
+		return len(authorIDs) == 0 || (v.Author != nil && util.ContainsInt(authorIDs, v.Author.ID))
+	}, options...)
 }
 
 // assigneeIDs slice must be sorted in ascending order
-func ListMergeRequestsByAssigneeID(h *client.Host, project *gitlab.Project, assigneeIDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listMergeRequestsByAssigneeID(h, project, assigneeIDs, opt, wg, data, options...)
-}
+func ListMergeRequestsByAssigneeID(slot sync.Locker, assigneeIDs []int, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
 
-func listMergeRequestsByAssigneeOrAuthorID(h *client.Host, project *gitlab.Project, IDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
+	return listProjectMergeRequestsFunc(slot, opt, func(v *gitlab.MergeRequest) bool {
+		return len(assigneeIDs) == 0 || (v.Assignee != nil && util.ContainsInt(assigneeIDs, v.Assignee.ID))
+	}, options...)
+}
 
-	wg.Lock()
-	list, resp, err := h.Client.MergeRequests.ListProjectMergeRequests(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
+// IDs slice must be sorted in ascending order
+func ListMergeRequestsByAuthorOrAssigneeID(slot sync.Locker, IDs []int, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
 
-	for _, v := range list {
+	return listProjectMergeRequestsFunc(slot, opt, func(v *gitlab.MergeRequest) bool {
 		if len(IDs) == 0 {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			continue
+			return true
 		}
-
 		// if mr has assignee, then check and continue
 		if v.Assignee != nil {
-			if util.ContainsInt(IDs, v.Assignee.ID) {
-				data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			}
-			continue
+			return util.ContainsInt(IDs, v.Assignee.ID)
 		}
-
 		// otherwise check the author
-		if v.Author != nil && util.ContainsInt(IDs, v.Author.ID) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listMergeRequestsByAssigneeOrAuthorID(h, project, IDs, opt, wg, data, options...)
-	}
-
-	return nil
+		return v.Author != nil && util.ContainsInt(IDs, v.Author.ID)
+	}, options...)
 }
 
-func listPullRequestsByAssigneeOrAuthorID(h *client.Host, repository *github.Repository, IDs []int,
-	opt github.PullRequestListOptions, wg *limiter.Limiter, data chan<- interface{}) error {
-	defer wg.Done()
+// ListPullRequests returns the stage listing the pull requests of the github repository
+func ListPullRequests(slot sync.Locker, opt github.PullRequestListOptions) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*github.PullRequest]) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.PullRequests.List(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, repository.GetName(), &opt)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
+	return func(ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*github.PullRequest]) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+		defer cancel()
+		ctx = context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
 
-	for _, v := range list {
-		if len(IDs) == 0 {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			continue
-		}
+		// The options are changed for the pagination of the repository
+		opt := opt
 
-		// if pr has assignee, then check and continue
-		if v.Assignee != nil {
-			if util.ContainsInt(IDs, int(v.Assignee.GetID())) {
-				data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-			}
-			continue
-		}
-
-		// otherwise check the author
-		if v.User != nil && util.ContainsInt(IDs, int(v.User.GetID())) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
+		return pipeline.PaginateGithub(slot, func(page int) ([]*github.PullRequest, *github.Response, error) {
+			opt.Page = page
+			return e.Host.GithubClient.PullRequests.List(ctx, e.Host.Org, e.Value.GetName(), &opt)
+		}, emit)
 	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listPullRequestsByAssigneeOrAuthorID(h, repository, IDs, opt, wg, data)
-	}
-
-	return nil
 }
 
-// authorIDs slice must be sorted in ascending order
-func ListMergeRequestsByAuthorOrAssigneeID(h *client.Host, project *gitlab.Project, IDs []int, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listMergeRequestsByAssigneeOrAuthorID(h, project, IDs, opt, wg, data, options...)
-}
-
-// authorIDs slice must be sorted in ascending order
-func ListPullRequestsByAuthorOrAssigneeID(h *client.Host, repository *github.Repository, IDs []int,
-	opt github.PullRequestListOptions, wg *limiter.Limiter, data chan<- interface{}) error {
-	return listPullRequestsByAssigneeOrAuthorID(h, repository, IDs, opt, wg, data)
-}
-
-func listPullRequests(h *client.Host, repository *github.Repository, opt github.PullRequestListOptions,
-	wg *limiter.Limiter, data chan<- interface{}) error {
-	defer wg.Done()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.PullRequests.List(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, repository.GetName(), &opt)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-	}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listPullRequests(h, repository, opt, wg, data)
+// IDs slice must be sorted in ascending order
+func ListPullRequestsByAuthorOrAssigneeID(slot sync.Locker, IDs []int, opt github.PullRequestListOptions) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*github.PullRequest]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[*github.PullRequest]) error {
+		return ListPullRequests(slot, opt)(ctx, e, func(v *github.PullRequest, cached bool) {
+			switch {
+			case len(IDs) == 0:
+			// if pr has assignee, then check and continue
+			case v.Assignee != nil:
+				if !util.ContainsInt(IDs, int(v.Assignee.GetID())) {
+					return
+				}
+			// otherwise check the author
+			case v.User == nil || !util.ContainsInt(IDs, int(v.User.GetID())):
+				return
+			}
+			emit(v, cached)
+		})
 	}
-
-	return nil
 }
 
-func ListPullRequests(h *client.Host, repository *github.Repository, opt github.PullRequestListOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listPullRequests(h, repository, opt, wg, data)
-}
-
-func listMergeRequestsSearch(h *client.Host, project *gitlab.Project, key string, value *regexp.Regexp, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.MergeRequests.ListProjectMergeRequests(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return err
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		s, err := sort.ValidFieldValue([]string{key}, v)
+// ListMergeRequestsSearch returns the stage listing the merge requests of the project with the key matching value
+func ListMergeRequestsSearch(slot sync.Locker, key string, value *regexp.Regexp, opt gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*gitlab.MergeRequest]) error {
+		var (
+			mu     sync.Mutex
+			failed error
+		)
+
+		err := listProjectMergeRequests(slot, opt, options...)(ctx, e, func(v *gitlab.MergeRequest, cached bool) {
+			s, err := sort.ValidFieldValue([]string{key}, v)
+			if err != nil {
+				mu.Lock()
+				failed = err
+				mu.Unlock()
+				return
+			}
+			// This will panic if value is not a string
+			if value.MatchString(s.(string)) {
+				emit(v, cached)
+			}
+		})
 		if err != nil {
-			wg.Error(h, err)
 			return err
 		}
-		// This will panic if value is not a string
-		if value.MatchString(s.(string)) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listMergeRequestsSearch(h, project, key, value, opt, wg, data, options...)
+		return failed
 	}
-
-	return nil
-}
-
-func ListMergeRequestsSearch(h *client.Host, project *gitlab.Project, key string, value *regexp.Regexp, opt gitlab.ListProjectMergeRequestsOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
-	return listMergeRequestsSearch(h, project, key, value, opt, wg, data, options...)
 }
 
 func listProjectMergeRequestsOptionsFlags(cmd *cobra.Command, opt *gitlab.ListProjectMergeRequestsOptions) {
@@ -0,0 +1,32 @@
+package projects
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestMergeRequestsList(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	updated := time.Now()
+
+	alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/api"}).
+		AddMergeRequest(gitlab.MergeRequest{Title: "Fix the api", UpdatedAt: &updated})
+	bravo.AddProject(gitlab.Project{PathWithNamespace: "backend/web"}).
+		AddMergeRequest(gitlab.MergeRequest{Title: "Fix the web", UpdatedAt: &updated})
+
+	// All commands are registered, they share the flag variables
+	cmd := NewCmd()
+	cmd.SetArgs([]string{"mr", "list"})
+
+	out := common.RunWithInput(t, "", cmd.Execute)
+	assert.Contains(t, out, "Fix the api")
+	assert.Contains(t, out, "Fix the web")
+	assert.Contains(t, out, "Total: 2")
+}
@@ -1,6 +1,8 @@
 package projects
 
 import (
+	"github.com/flant/glaball/cmd/common"
+
 	"github.com/spf13/cobra"
 )
 
@@ -10,7 +12,8 @@ const (
 )
 
 var (
-	outputFormat []string
+	outputFormat  []string
+	resultOptions common.ResultOptions
 )
 
 func NewCmd() *cobra.Command {
@@ -20,7 +23,7 @@ func NewCmd() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringSliceVar(&outputFormat, "output", []string{"table"},
-		"Output format: [table csv]. Default: table.")
+		"Output format: [table csv]. Default: table. json is also supported with --columns, --aggregate and --matrix, ndjson with --stream.")
 
 	cmd.AddCommand(
 		NewEditCmd(),
@@ -1,16 +1,21 @@
 package projects
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 
 	"dario.cat/mergo"
 	"github.com/flant/glaball/cmd/common"
 	"github.com/flant/glaball/pkg/client"
 	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/tui"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
@@ -70,6 +75,7 @@ func NewProtectedBranchesListCmd() *cobra.Command {
 		Short: "List protected branches",
 		Long:  "Gets a list of protected branches from a project as they are defined in the UI. If a wildcard is set, it is returned instead of the exact name of the branches that match that wildcard.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			resultOptions.Actions = []tui.Action{protectAction()}
 			return ProtectedBranchesListCmd()
 		},
 	}
@@ -78,9 +84,14 @@ func NewProtectedBranchesListCmd() *cobra.Command {
 		"Return protected branches sorted in asc or desc order. Default is desc")
 
 	cmd.Flags().StringSliceVar(&protectedBranchOrderBy, "order_by", []string{"count", protectedBranchDefaultField},
-		`Return protected branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.`)
+		`Return protected branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	graphQLFlag(cmd)
+
+	common.RegisterFieldCompletions(cmd, ProjectProtectedBranch{})
 
 	return cmd
 }
@@ -169,63 +180,53 @@ func (pb *ProjectProtectedBranch) Search(name string) (*gitlab.ProtectedBranch,
 }
 
 func ProtectedBranchesListCmd() error {
+	if err := resultOptions.Validate(ProjectProtectedBranch{}); err != nil {
+		return err
+	}
+
 	if !sort.ValidOrderBy(protectedBranchOrderBy, ProjectProtectedBranch{}) {
 		protectedBranchOrderBy = append(protectedBranchOrderBy, protectedBranchDefaultField)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 	defer func() {
-		for _, err := range wg.Errors() {
+		for _, err := range pipe.Errors() {
 			hclog.L().Error(err.Err.Error())
 		}
 	}()
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting protected branches from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
-	}
-
-	if len(toList) == 0 {
-		return fmt.Errorf("no projects found")
-	}
-
-	protectedBranches := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listProtectedBranches(v.Host, v.Struct.(*gitlab.Project), listProtectedBranchesOptions, wg, protectedBranches, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(protectedBranches)
-	}()
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	batches, projects := graphQLProjects(projects)
+	protectedBranches := pipeline.Merge(
+		pipeline.Then(pipe, batches, graphQLListProtectedBranches(pipe.Slot(), listProtectedBranchesOptions, common.Client.WithCache())),
+		pipeline.Then(pipe, projects, listProjectProtectedBranches(pipe.Slot(), listProtectedBranchesOptions, common.Client.WithCache())),
+	)
 
-	results, err := sort.FromChannel(protectedBranches, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(protectedBranches), &sort.Options{
 		OrderBy:    protectedBranchOrderBy,
 		SortBy:     sortBy,
 		GroupBy:    protectedBranchDefaultField,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: ProjectProtectedBranch{},
 	})
 	if err != nil {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
 	if len(results) == 0 {
 		return fmt.Errorf("no protected branches found")
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, ProjectProtectedBranch{}, results, nil)
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	if _, err := fmt.Fprintln(w, strings.Join(protectedBranchFormat.Keys(), "\t")); err != nil {
 		return err
@@ -251,7 +252,7 @@ func ProtectedBranchesListCmd() error {
 
 	}
 
-	if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+	if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 		return err
 	}
 
@@ -267,72 +268,41 @@ func ProtectRepositoryBranchesCmd() error {
 		protectedBranchOrderBy = append(protectedBranchOrderBy, protectedBranchDefaultField)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 	defer func() {
-		for _, err := range wg.Errors() {
+		for _, err := range pipe.Errors() {
 			hclog.L().Error(err.Err.Error())
 		}
 	}()
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting protected branches from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
-	}
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	protectedBranches := pipeline.Collect(pipeline.Then(pipe, projects,
+		listProjectProtectedBranches(pipe.Slot(), listProtectedBranchesOptions, common.Client.WithCache())))
 
-	if len(toList) == 0 {
+	if found == 0 {
 		return fmt.Errorf("no projects found")
 	}
 
-	protectedBranches := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listProtectedBranches(v.Host, v.Struct.(*gitlab.Project), listProtectedBranchesOptions, wg, protectedBranches, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(protectedBranches)
-	}()
-
-	toProtect := make(sort.Elements, 0)
-	for e := range protectedBranches {
-		if v := e.(sort.Element).Struct.(*ProjectProtectedBranch); forceProtect || len(v.ProtectedBranches) == 0 {
+	toProtect := make([]pipeline.Element[*ProjectProtectedBranch], 0)
+	for _, e := range protectedBranches {
+		if forceProtect || len(e.Value.ProtectedBranches) == 0 {
 			toProtect = append(toProtect, e)
 		}
 	}
 
 	if len(toProtect) == 0 {
 		return fmt.Errorf("branch %q is already protected in %d repositories in %v",
-			*protectRepositoryBranchesOptions.Name, len(toList), common.Client.Hosts.Projects(common.Config.ShowAll))
+			*protectRepositoryBranchesOptions.Name, found, common.Client.Hosts.Projects(common.Config.ShowAll))
 	}
 
 	util.AskUser(fmt.Sprintf("Do you really want to protect branch %q in %d repositories in %v ?",
 		*protectRepositoryBranchesOptions.Name, len(toProtect), common.Client.Hosts.Projects(common.Config.ShowAll)))
 
-	protectedCh := make(chan interface{})
-	for _, v := range toProtect.Typed() {
-		wg.Add(1)
-		go protectRepositoryBranches(v.Host, v.Struct.(*ProjectProtectedBranch), forceProtect, protectRepositoryBranchesOptions, wg, protectedCh, common.Client.WithNoCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(protectedCh)
-	}()
+	protected := pipeline.Then(pipe, pipeline.From(toProtect),
+		protectRepositoryBranches(forceProtect, protectRepositoryBranchesOptions, common.Client.WithNoCache()))
 
-	results, err := sort.FromChannel(protectedCh, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(protected), &sort.Options{
 		OrderBy:    protectedBranchOrderBy,
 		SortBy:     sortBy,
 		GroupBy:    protectedBranchDefaultField,
@@ -371,7 +341,7 @@ func ProtectRepositoryBranchesCmd() error {
 
 	}
 
-	if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+	if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 		return err
 	}
 
@@ -382,39 +352,93 @@ func ProtectRepositoryBranchesCmd() error {
 	return nil
 }
 
-func listProtectedBranches(h *client.Host, project *gitlab.Project, opt gitlab.ListProtectedBranchesOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
+// Returns the stage listing all protected branches of the project
+func listProjectProtectedBranches(slot sync.Locker, opt gitlab.ListProtectedBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		var (
+			mu     sync.Mutex
+			pb     = ProjectProtectedBranch{Project: e.Value, ProtectedBranches: make([]*gitlab.ProtectedBranch, 0)}
+			cached = true
+		)
+
+		err := pipeline.PaginateHost(slot, e.Host, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.ProtectedBranch, *gitlab.Response, error) {
+			return e.Host.Client.ProtectedBranches.ListProtectedBranches(e.Value.ID, &opt, slices.Concat(options, page)...)
+		}, func(b *gitlab.ProtectedBranch, c bool) {
+			mu.Lock()
+			pb.ProtectedBranches = append(pb.ProtectedBranches, b)
+			cached = cached && c
+			mu.Unlock()
+		})
+		if err != nil {
+			return err
+		}
 
-	defer wg.Done()
+		emit(&pb, cached)
 
-	wg.Lock()
-	list, resp, err := h.Client.ProtectedBranches.ListProtectedBranches(project.ID, &opt, options...)
-	wg.Unlock()
-	if err != nil {
-		wg.Error(h, err)
-		return err
+		return nil
 	}
+}
 
-	data <- sort.Element{
-		Host: h,
-		Struct: &ProjectProtectedBranch{
-			Project:           project,
-			ProtectedBranches: list},
-		Cached: resp.Header.Get("X-From-Cache") == "1"}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProtectedBranches(h, project, opt, wg, data, options...)
+// protectAction protects the default branch of the projects selected in the interactive mode
+// with the default access levels, the projects where it is already protected are skipped
+func protectAction() tui.Action {
+	toProtect := func(elements sort.Elements) []pipeline.Element[*ProjectProtectedBranch] {
+		selected := make([]pipeline.Element[*ProjectProtectedBranch], 0, len(elements))
+		for _, v := range elements.Typed() {
+			pb := &ProjectProtectedBranch{}
+			switch s := v.Struct.(type) {
+			case *gitlab.Project:
+				pb.Project = s
+			case *ProjectProtectedBranch:
+				pb = s
+			}
+			if pb.Project == nil || pb.Project.DefaultBranch == "" {
+				continue
+			}
+			if _, ok := pb.Search(pb.Project.DefaultBranch); ok {
+				continue
+			}
+			selected = append(selected, pipeline.Element[*ProjectProtectedBranch]{Host: v.Host, Value: pb, Cached: bool(v.Cached)})
+		}
+		return selected
 	}
 
-	return nil
+	return tui.Action{
+		Key:  'p',
+		Name: "protect default branch",
+		Confirm: func(elements sort.Elements) string {
+			return fmt.Sprintf("Do you really want to protect the default branch in %d repositories in %v ?",
+				len(toProtect(elements)), elements.Hosts().Unique().Projects(common.Config.ShowAll))
+		},
+		Run: func(elements sort.Elements) (string, error) {
+			pipe := pipeline.New(limiter.NewLimiter(common.Config.Threads))
+			protected := pipeline.Collect(pipeline.Then(pipe, pipeline.From(toProtect(elements)),
+				func(ctx context.Context, e pipeline.Element[*ProjectProtectedBranch], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+					opt := gitlab.ProtectRepositoryBranchesOptions{Name: gitlab.Ptr(e.Value.Project.DefaultBranch)}
+					return protectRepositoryBranches(false, opt, common.Client.WithNoCache())(ctx, e, emit)
+				}))
+
+			return fmt.Sprintf("protected the default branch in %d repositories", len(protected)), pipe.Err()
+		},
+	}
 }
 
-func protectRepositoryBranches(h *client.Host, pb *ProjectProtectedBranch, forceProtect bool, opt gitlab.ProtectRepositoryBranchesOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) error {
+// Returns the stage protecting the branch of the project, with forceProtect the branch protected already
+// is protected again keeping its access levels which are not set in opt
+func protectRepositoryBranches(forceProtect bool, opt gitlab.ProtectRepositoryBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*ProjectProtectedBranch], emit pipeline.Emit[*ProjectProtectedBranch]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*ProjectProtectedBranch], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+		return protectRepositoryBranch(e.Host, e.Value, forceProtect, opt, emit, pipeline.WithContext(ctx, options)...)
+	}
+}
+
+func protectRepositoryBranch(h *client.Host, pb *ProjectProtectedBranch, forceProtect bool, opt gitlab.ProtectRepositoryBranchesOptions,
+	emit pipeline.Emit[*ProjectProtectedBranch], options ...gitlab.RequestOptionFunc) error {
 
 	if forceProtect {
 		if old, ok := pb.Search(*opt.Name); ok {
@@ -472,15 +496,10 @@ func protectRepositoryBranches(h *client.Host, pb *ProjectProtectedBranch, force
 			}
 
 			if err := mergo.Merge(&new, opt, mergo.WithOverwriteWithEmptyValue); err != nil {
-				wg.Error(h, err)
 				return err
 			}
 
-			wg.Lock()
-			_, err := h.Client.ProtectedBranches.UnprotectRepositoryBranches(pb.Project.ID, *new.Name, options...)
-			wg.Unlock()
-			if err != nil {
-				wg.Error(h, err)
+			if _, err := h.Client.ProtectedBranches.UnprotectRepositoryBranches(pb.Project.ID, *new.Name, options...); err != nil {
 				return err
 			}
 
@@ -488,20 +507,25 @@ func protectRepositoryBranches(h *client.Host, pb *ProjectProtectedBranch, force
 		}
 	}
 
-	wg.Lock()
 	v, resp, err := h.Client.ProtectedBranches.ProtectRepositoryBranches(pb.Project.ID, &opt, options...)
-	wg.Unlock()
 	if err != nil {
-		wg.Error(h, err)
 		return err
 	}
 
-	data <- sort.Element{
-		Host: h,
-		Struct: &ProjectProtectedBranch{
-			Project:           pb.Project,
-			ProtectedBranches: []*gitlab.ProtectedBranch{v}},
-		Cached: resp.Header.Get("X-From-Cache") == "1"}
+	emit(&ProjectProtectedBranch{Project: pb.Project, ProtectedBranches: []*gitlab.ProtectedBranch{v}},
+		resp.Header.Get("X-From-Cache") == "1")
 
 	return nil
 }
+
+// ListProjectProtectedBranches returns the stage listing all protected branches of the project
+func ListProjectProtectedBranches(slot sync.Locker, opt gitlab.ListProtectedBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+	return listProjectProtectedBranches(slot, opt, options...)
+}
+
+// ProtectRepositoryBranches returns the stage protecting the branch of the project
+func ProtectRepositoryBranches(forceProtect bool, opt gitlab.ProtectRepositoryBranchesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*ProjectProtectedBranch], emit pipeline.Emit[*ProjectProtectedBranch]) error {
+	return protectRepositoryBranches(forceProtect, opt, options...)
+}
@@ -0,0 +1,74 @@
+package projects
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/pkg/gitlabfake"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestProtectRepositoryBranches(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+
+	// The branch is already protected in the api of alfa only
+	alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/api"}).
+		Protect("main", gitlab.NoPermissions, gitlab.MaintainerPermissions)
+	alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/web"})
+	bravo.AddProject(gitlab.Project{PathWithNamespace: "backend/api"})
+
+	protectRepositoryBranchesOptions = gitlab.ProtectRepositoryBranchesOptions{
+		Name:            gitlab.String("main"),
+		PushAccessLevel: gitlab.AccessLevel(gitlab.MaintainerPermissions),
+	}
+	defer func() { protectRepositoryBranchesOptions = gitlab.ProtectRepositoryBranchesOptions{} }()
+
+	out := common.RunWithInput(t, "y\n", ProtectRepositoryBranchesCmd)
+	assert.Contains(t, out, `Do you really want to protect branch "main" in 2 repositories`)
+
+	// The existing protection is not changed
+	api := alfa.Project("backend/api")
+	assert.Len(t, api.ProtectedBranches, 1)
+	assert.Equal(t, gitlab.NoPermissions, api.ProtectedBranches[0].PushAccessLevels[0].AccessLevel)
+
+	for _, p := range []*gitlabfake.Project{alfa.Project("backend/web"), bravo.Project("backend/api")} {
+		protected := p.ProtectedBranches
+		if assert.Len(t, protected, 1, p.WebURL) {
+			assert.Equal(t, "main", protected[0].Name)
+			assert.Equal(t, gitlab.MaintainerPermissions, protected[0].PushAccessLevels[0].AccessLevel)
+		}
+	}
+}
+
+func TestProtectAction(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+
+	api := alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/api"})
+	api.Protect("main", gitlab.NoPermissions, gitlab.MaintainerPermissions)
+	web := bravo.AddProject(gitlab.Project{PathWithNamespace: "backend/web"})
+
+	hosts := common.Client.Hosts
+	elements := sort.Elements{
+		sort.Element{Host: hosts[0], Struct: &ProjectProtectedBranch{Project: api.Project, ProtectedBranches: api.ProtectedBranches}},
+		sort.Element{Host: hosts[1], Struct: web.Project},
+	}
+
+	action := protectAction()
+	assert.Contains(t, action.Confirm(elements), "protect the default branch in 1 repositories")
+
+	summary, err := action.Run(elements)
+	assert.NoError(t, err)
+	assert.Equal(t, "protected the default branch in 1 repositories", summary)
+
+	if assert.Len(t, bravo.Project("backend/web").ProtectedBranches, 1) {
+		assert.Equal(t, "main", bravo.Project("backend/web").ProtectedBranches[0].Name)
+	}
+}
@@ -1,15 +1,17 @@
 package projects
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 
 	"github.com/alecthomas/units"
 	"github.com/flant/glaball/cmd/common"
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/hashicorp/go-hclog"
@@ -83,17 +85,25 @@ func NewRegistryListCmd() *cobra.Command {
 		"Return protected branches sorted in asc or desc order. Default is desc")
 
 	cmd.Flags().StringSliceVar(&registryRepositoryhOrderBy, "order_by", []string{"count", registryRepositoryDefaultField},
-		`Return protected branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.`)
+		`Return protected branches ordered by web_url, created_at, title, updated_at or any nested field. Default is web_url.
+Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.`)
 
 	cmd.Flags().BoolVar(&registryRepositoryTotalSize, "size", false,
 		`If the parameter is included as true, the response includes "size". This is the deduplicated size of all images within the repository.`)
 
 	listProjectsOptionsFlags(cmd, &listProjectsOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+
+	common.RegisterFieldCompletions(cmd, ProjectRegistryRepository{})
 
 	return cmd
 }
 
 func RegistryListCmd() error {
+	if err := resultOptions.Validate(ProjectRegistryRepository{}); err != nil {
+		return err
+	}
+
 	if !sort.ValidOrderBy(registryRepositoryhOrderBy, ProjectRegistryRepository{}) {
 		registryRepositoryhOrderBy = append(registryRepositoryhOrderBy, registryRepositoryDefaultField)
 	}
@@ -102,82 +112,45 @@ func RegistryListCmd() error {
 		listRegistryRepositoriesOptions.Tags = &registryRepositoryTotalSize
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 	defer func() {
-		for _, err := range wg.Errors() {
+		for _, err := range pipe.Errors() {
 			hclog.L().Error(err.Err.Error())
 		}
 	}()
 
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting registry repositories from %s ...\n", h.URL)
-		wg.Add(1)
-		go listProjects(h, listProjectsOptions, wg, data, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
-	toList := make(sort.Elements, 0)
-	for e := range data {
-		toList = append(toList, e)
-	}
-
-	if len(toList) == 0 {
-		return fmt.Errorf("no projects found")
-	}
-
-	registryRepositories := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listRegistryRepositories(v.Host, v.Struct.(*gitlab.Project), listRegistryRepositoriesOptions, wg, registryRepositories, common.Client.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(registryRepositories)
-	}()
-
+	var found int
+	projects := pipeline.Count(pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsOptions, common.Client.WithCache())), &found)
+	registryRepositories := pipeline.Then(pipe, projects,
+		listRegistryRepositories(pipe.Slot(), listRegistryRepositoriesOptions, common.Client.WithCache()))
 	if registryRepositoryTotalSize {
-		registryRepositoriesList := make(sort.Elements, 0)
-		for v := range registryRepositories {
-			e := v.(sort.Element)
-			p := e.Struct.(*ProjectRegistryRepository)
-			for _, r := range p.RegistryRepositories {
-				for _, tag := range r.Tags {
-					wg.Add(1)
-					go getRegistryRepositoryTagDetail(e.Host, p.Project, r, tag, wg, common.Client.WithCache())
-				}
-			}
-			registryRepositoriesList = append(registryRepositoriesList, v)
-		}
-		wg.Wait()
-		registryRepositories = make(chan interface{})
-		go func() {
-			for _, v := range registryRepositoriesList {
-				registryRepositories <- v
-			}
-			close(registryRepositories)
-		}()
+		registryRepositories = pipeline.Then(pipe, registryRepositories, getRegistryRepositoryTagDetails(common.Client.WithCache()))
 	}
 
-	results, err := sort.FromChannel(registryRepositories, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(registryRepositories), &sort.Options{
 		OrderBy:    registryRepositoryhOrderBy,
 		SortBy:     sortBy,
 		GroupBy:    registryRepositoryDefaultField,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: ProjectRegistryRepository{},
 	})
 	if err != nil {
 		return err
 	}
 
+	if found == 0 {
+		return fmt.Errorf("no projects found")
+	}
+
 	if len(results) == 0 {
 		return fmt.Errorf("no registry repositories found")
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, ProjectRegistryRepository{}, results, nil)
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	if _, err := fmt.Fprintln(w, strings.Join(registryRepositoriesFormat.Keys(), "\t")); err != nil {
 		return err
@@ -205,7 +178,7 @@ func RegistryListCmd() error {
 
 	}
 
-	if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+	if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 		return err
 	}
 
@@ -244,45 +217,61 @@ func (pr *ProjectRegistryRepository) TotalSize() (i int) {
 	return i
 }
 
-func listRegistryRepositories(h *client.Host, project *gitlab.Project, opt gitlab.ListRegistryRepositoriesOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-
-	defer wg.Done()
+// Returns the stage listing all registry repositories of the project
+func listRegistryRepositories(slot sync.Locker, opt gitlab.ListRegistryRepositoriesOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectRegistryRepository]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProjectRegistryRepository]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		var (
+			mu     sync.Mutex
+			pr     = ProjectRegistryRepository{Project: e.Value, RegistryRepositories: make([]*gitlab.RegistryRepository, 0)}
+			cached = true
+		)
+
+		err := pipeline.PaginateHost(slot, e.Host, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.RegistryRepository, *gitlab.Response, error) {
+			return e.Host.Client.ContainerRegistry.ListProjectRegistryRepositories(e.Value.ID, &opt, slices.Concat(options, page)...)
+		}, func(v *gitlab.RegistryRepository, c bool) {
+			mu.Lock()
+			pr.RegistryRepositories = append(pr.RegistryRepositories, v)
+			cached = cached && c
+			mu.Unlock()
+		})
+		if err != nil {
+			return err
+		}
 
-	wg.Lock()
-	list, resp, err := h.Client.ContainerRegistry.ListProjectRegistryRepositories(project.ID, &opt, options...)
-	wg.Unlock()
-	if err != nil {
-		wg.Error(h, err)
-		return
-	}
+		emit(&pr, cached)
 
-	data <- sort.Element{
-		Host: h,
-		Struct: &ProjectRegistryRepository{
-			Project:              project,
-			RegistryRepositories: list},
-		Cached: resp.Header.Get("X-From-Cache") == "1"}
-
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listRegistryRepositories(h, project, opt, wg, data, options...)
+		return nil
 	}
 }
 
-func getRegistryRepositoryTagDetail(h *client.Host, project *gitlab.Project, repository *gitlab.RegistryRepository,
-	tag *gitlab.RegistryRepositoryTag, wg *limiter.Limiter, options ...gitlab.RequestOptionFunc) {
+// Returns the stage getting the details of every tag of the registry repositories, the tags are replaced in place.
+// The repositories are passed on even if some details failed, the first error is reported.
+func getRegistryRepositoryTagDetails(options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*ProjectRegistryRepository], emit pipeline.Emit[*ProjectRegistryRepository]) error {
+
+	return func(ctx context.Context, e pipeline.Element[*ProjectRegistryRepository], emit pipeline.Emit[*ProjectRegistryRepository]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		var failed error
+		for _, r := range e.Value.RegistryRepositories {
+			for _, tag := range r.Tags {
+				v, _, err := e.Host.Client.ContainerRegistry.GetRegistryRepositoryTagDetail(e.Value.Project.ID, r.ID, tag.Name, options...)
+				if err != nil {
+					if failed == nil {
+						failed = err
+					}
+					continue
+				}
+				*tag = *v
+			}
+		}
 
-	defer wg.Done()
+		emit(e.Value, e.Cached)
 
-	wg.Lock()
-	v, _, err := h.Client.ContainerRegistry.GetRegistryRepositoryTagDetail(project.ID, repository.ID, tag.Name, options...)
-	wg.Unlock()
-	if err != nil {
-		wg.Error(h, err)
-		return
+		return failed
 	}
-
-	*tag = *v
 }
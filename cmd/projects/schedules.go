@@ -2,18 +2,20 @@ package projects
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
 	go_sort "sort"
 
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 	"github.com/google/go-github/v66/github"
@@ -40,6 +42,7 @@ var (
 	cleanupOwnerToken            string
 	cleanupCreate                bool
 	cleanupCheckJobs             bool
+	schedulesWatchInterval       time.Duration
 	scheduleFormat               = util.Dict{
 		{
 			Key:   "COUNT",
@@ -104,6 +107,9 @@ func NewPipelineSchedulesCmd() *cobra.Command {
 		Short: "Pipeline schedules API",
 		Long:  "Get a list of the pipeline schedules of a project.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if schedulesWatchInterval > 0 {
+				return WatchPipelineSchedulesCmd()
+			}
 			return ListPipelineSchedulesCmd()
 		},
 	}
@@ -117,6 +123,10 @@ func NewPipelineSchedulesCmd() *cobra.Command {
 
 	// ListProjectsOptions
 	listProjectsOptionsFlags(cmd, &listProjectsPipelinesOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	common.WatchFlag(cmd, &schedulesWatchInterval)
+
+	common.RegisterFieldCompletions(cmd, ProjectPipelineSchedule{})
 
 	return cmd
 }
@@ -148,46 +158,75 @@ func NewPipelineCleanupSchedulesCmd() *cobra.Command {
 
 	// ListProjectsOptions
 	listProjectsOptionsFlags(cmd, &listProjectsPipelinesOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+
+	common.RegisterFieldCompletions(cmd, ProjectPipelineSchedule{})
 
 	return cmd
 }
 
-func ListPipelineSchedulesCmd() error {
+// WatchPipelineSchedulesCmd prints the changes of the pipeline schedules every --watch interval
+func WatchPipelineSchedulesCmd() error {
+	if err := resultOptions.Validate(ProjectPipelineSchedule{}); err != nil {
+		return err
+	}
+
+	if err := resultOptions.ValidateWatch(); err != nil {
+		return err
+	}
+
+	return common.Watch(schedulesWatchInterval, ProjectPipelineSchedule{}, "schedule.id", collectPipelineSchedules)
+}
+
+// collectPipelineSchedules returns the pipeline schedules of the projects of all hosts
+func collectPipelineSchedules(options ...gitlab.RequestOptionFunc) ([]sort.Result, error) {
 	desc := make([]*regexp.Regexp, 0, len(schedulesDescriptions))
 	for _, p := range schedulesDescriptions {
 		r, err := regexp.Compile(p)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		desc = append(desc, r)
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Fetching projects pipeline schedules from %s ...\n", h.URL)
-		// TODO: context with cancel
-		wg.Add(1)
-		go listProjectsPipelines(h, listProjectsPipelinesOptions, desc, wg, data, common.Client.WithCache())
-	}
+	pipe := pipeline.New(common.Limiter)
+	options = append([]gitlab.RequestOptionFunc{common.Client.WithCache()}, options...)
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	projects := pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsPipelinesOptions, options...))
+	schedules := pipeline.Then(pipe, projects, listPipelineSchedules(pipe.Slot(), gitlab.ListPipelineSchedulesOptions{PerPage: 100}, desc, false, options...))
 
 	var results []sort.Result
-	query, err := sort.FromChannelQuery(data, &sort.Options{
+	query, err := sort.FromChannelQuery(pipeline.Sort(schedules), &sort.Options{
 		OrderBy:    []string{"project.web_url"},
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: ProjectPipelineSchedule{},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	query.ToSlice(&results)
 
+	return results, nil
+}
+
+func ListPipelineSchedulesCmd() error {
+	if err := resultOptions.Validate(ProjectPipelineSchedule{}); err != nil {
+		return err
+	}
+
+	results, err := collectPipelineSchedules()
+	if err != nil {
+		return err
+	}
+
+	wg := common.Limiter
+
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, ProjectPipelineSchedule{}, results, wg.Errors())
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	if _, err := fmt.Fprintln(w, strings.Join(scheduleFormat.Keys(), "\t")); err != nil {
 		return err
@@ -251,6 +290,10 @@ func ListPipelineSchedulesCmd() error {
 }
 
 func ListPipelineCleanupSchedulesCmd() error {
+	if err := resultOptions.Validate(ProjectPipelineSchedule{}); err != nil {
+		return err
+	}
+
 	var ownerUser *gitlab.User
 	cacheFunc := common.Client.WithCache()
 	if cleanupCreate && cleanupOwnerToken == "" {
@@ -295,93 +338,50 @@ func ListPipelineCleanupSchedulesCmd() error {
 	// only active projects
 	listProjectsPipelinesOptions.Archived = gitlab.Bool(false)
 
-	wg := common.Limiter
-	projectsCh := make(chan interface{})
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Searching for cleanups in %s ...\n", h.URL)
-		wg.Add(1)
-
-		// files.go
-		go listProjects(h, listProjectsPipelinesOptions, wg, projectsCh, cacheFunc)
-	}
-
-	go func() {
-		wg.Wait()
-		close(projectsCh)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	gitlabCIFilesList := make(sort.Elements, 0)
-	for e := range projectsCh {
-		gitlabCIFilesList = append(gitlabCIFilesList, e)
-
-	}
-
-	gitlabCIFilesCh := make(chan interface{})
-	for _, v := range gitlabCIFilesList.Typed() {
-		wg.Add(1)
-		go getGitlabCIFile(v.Host, cleanupCheckJobs, v.Struct.(*gitlab.Project), desc, wg, gitlabCIFilesCh, cacheFunc)
-	}
-
-	go func() {
-		wg.Wait()
-		close(gitlabCIFilesCh)
-	}()
-
-	projectList := make(sort.Elements, 0)
-	for e := range gitlabCIFilesCh {
-		projectList = append(projectList, e)
-	}
+	projects := pipeline.FromHosts(pipe, common.Client.Hosts, gitlabProjects(pipe.Slot(), listProjectsPipelinesOptions, cacheFunc))
+	projectList := pipeline.Collect(pipeline.Then(pipe, projects, getGitlabCIFile(cleanupCheckJobs, desc, cacheFunc)))
 
 	if len(projectList) == 0 {
 		return fmt.Errorf(".gitlab-ci.yml was not found in any project")
 	}
 
 	// search for `cleanupFilepaths` files with contents matching `cleanupPatterns`
-	cleanupFilepathsCh := make(chan interface{})
-	for _, v := range projectList.Typed() {
-		for _, fp := range cleanupFilepaths {
-			wg.Add(1)
-			go getRawFile(v.Host, v.Struct.(*ProjectLintResult).Project, fp, gitRef, re, wg, cleanupFilepathsCh, cacheFunc)
-		}
-	}
-
-	go func() {
-		wg.Wait()
-		close(cleanupFilepathsCh)
-	}()
-
-	toList := make(sort.Elements, 0)
-	for e := range cleanupFilepathsCh {
-		toList = append(toList, e)
-	}
+	toList := pipeline.Collect(pipeline.Then(pipe, pipeline.From(projectList),
+		func(ctx context.Context, e pipeline.Element[*ProjectLintResult], emit pipeline.Emit[*ProjectFile]) error {
+			project := pipeline.Element[*gitlab.Project]{Host: e.Host, Value: e.Value.Project, Cached: e.Cached}
+			for _, fp := range cleanupFilepaths {
+				if err := getRawFile(fp, gitRef, re, cacheFunc)(ctx, project, emit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
 
 	if len(toList) == 0 {
 		return fmt.Errorf("%s files or patterns %s were not found in any project", cleanupFilepaths, cleanupPatterns)
 	}
 
-	schedules := make(chan interface{})
-	for _, v := range toList.Typed() {
-		wg.Add(1)
-		go listPipelineSchedules(v.Host, v.Struct.(*ProjectFile).Project, gitlab.ListPipelineSchedulesOptions{PerPage: 100},
-			desc, false, wg, schedules, cacheFunc)
-	}
-
-	go func() {
-		wg.Wait()
-		close(schedules)
-	}()
+	schedules := pipeline.Then(pipe, pipeline.From(toList),
+		func(ctx context.Context, e pipeline.Element[*ProjectFile], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+			project := pipeline.Element[*gitlab.Project]{Host: e.Host, Value: e.Value.Project, Cached: e.Cached}
+			return listPipelineSchedules(pipe.Slot(), gitlab.ListPipelineSchedulesOptions{PerPage: 100}, desc, false, cacheFunc)(ctx, project, emit)
+		})
 
 	var results []sort.Result
-	query, err := sort.FromChannelQuery(schedules, &sort.Options{
+	query, err := sort.FromChannelQuery(pipeline.Sort(schedules), &sort.Options{
 		OrderBy:    []string{"project.web_url"},
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: ProjectPipelineSchedule{},
 	})
 	if err != nil {
 		return err
 	}
 
-	toChangeOwner := make(sort.Elements, 0)
-	toCreate := make(sort.Elements, 0)
+	toChangeOwner := make([]pipeline.Element[ProjectPipelineSchedule], 0)
+	toCreate := make([]pipeline.Element[ProjectPipelineSchedule], 0)
 	if cleanupOwnerToken != "" && ownerUser != nil {
 		if !cleanupCreate {
 			query = query.Where(func(i interface{}) bool {
@@ -390,7 +390,8 @@ func ListPipelineCleanupSchedulesCmd() error {
 						if s.Owner.ID == ownerUser.ID {
 							return true
 						}
-						toChangeOwner = append(toChangeOwner, v)
+						toChangeOwner = append(toChangeOwner, pipeline.Element[ProjectPipelineSchedule]{
+							Host: v.Host, Value: v.Struct.(ProjectPipelineSchedule), Cached: bool(v.Cached)})
 					}
 				}
 				return false
@@ -399,7 +400,8 @@ func ListPipelineCleanupSchedulesCmd() error {
 			query = query.Where(func(i interface{}) bool {
 				for _, v := range i.(sort.Result).Elements.Typed() {
 					if s := v.Struct.(ProjectPipelineSchedule).Schedule; s == nil {
-						toCreate = append(toCreate, v)
+						toCreate = append(toCreate, pipeline.Element[ProjectPipelineSchedule]{
+							Host: v.Host, Value: v.Struct.(ProjectPipelineSchedule), Cached: bool(v.Cached)})
 						return true
 					}
 				}
@@ -411,7 +413,7 @@ func ListPipelineCleanupSchedulesCmd() error {
 	query.ToSlice(&results)
 
 	if cleanupOwnerToken != "" && ownerUser != nil {
-		data := make(chan interface{})
+		var changed <-chan pipeline.Element[ProjectPipelineSchedule]
 		host := common.Client.Hosts[0]
 		if !cleanupCreate {
 			if len(toChangeOwner) == 0 {
@@ -426,11 +428,8 @@ func ListPipelineCleanupSchedulesCmd() error {
 			util.AskUser(fmt.Sprintf("Do you really want to change %d cleanup schedules owner to %q user in gitlab %q ?",
 				len(toChangeOwner), ownerUser.Username, host.ProjectName()))
 
-			fmt.Printf("Setting cleanup schedules owner to %q in %s ...\n", ownerUser.Username, host.URL)
-			for _, v := range toChangeOwner.Typed() {
-				wg.Add(1)
-				go takeOwnership(v.Host, v.Struct.(ProjectPipelineSchedule), wg, data, cacheFunc)
-			}
+			hclog.L().Info("Setting cleanup schedules owner", "owner", ownerUser.Username, "host", host.URL)
+			changed = pipeline.Then(pipe, pipeline.From(toChangeOwner), takeOwnership(cacheFunc))
 
 		} else {
 			if len(toCreate) == 0 {
@@ -445,29 +444,27 @@ func ListPipelineCleanupSchedulesCmd() error {
 			util.AskUser(fmt.Sprintf("Do you really want to create %d cleanup schedules with owner %q user in gitlab %q ?",
 				len(toCreate), ownerUser.Username, host.ProjectName()))
 
-			fmt.Printf("Creating cleanup schedules with owner %q in %s ...\n", ownerUser.Username, host.URL)
-			for i, v := range toCreate.Typed() {
-				wg.Add(1)
-
-				s := v.Struct.(ProjectPipelineSchedule)
+			hclog.L().Info("Creating cleanup schedules", "owner", ownerUser.Username, "host", host.URL)
+			createOptions := make(map[int]gitlab.CreatePipelineScheduleOptions, len(toCreate))
+			for i, v := range toCreate {
 				targetRef := gitRef
 				if gitRef == "" {
-					targetRef = s.Project.DefaultBranch
+					targetRef = v.Value.Project.DefaultBranch
 				}
-				go createPipelineSchedule(v.Host, s, gitlab.CreatePipelineScheduleOptions{
+				createOptions[v.Value.Project.ID] = gitlab.CreatePipelineScheduleOptions{
 					Description: gitlab.String("Cleanup"),
 					Ref:         &targetRef,
 					Cron:        gitlab.String(fmt.Sprintf("%d 1 * * *", i)),
-				}, wg, data, cacheFunc)
+				}
 			}
-		}
 
-		go func() {
-			wg.Wait()
-			close(data)
-		}()
+			changed = pipeline.Then(pipe, pipeline.From(toCreate),
+				func(ctx context.Context, e pipeline.Element[ProjectPipelineSchedule], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+					return createPipelineSchedule(createOptions[e.Value.Project.ID], cacheFunc)(ctx, e, emit)
+				})
+		}
 
-		results, err = sort.FromChannel(data, &sort.Options{
+		results, err = sort.FromChannel(pipeline.Sort(changed), &sort.Options{
 			OrderBy:    []string{"project.web_url"},
 			StructType: ProjectPipelineSchedule{},
 		})
@@ -476,6 +473,10 @@ func ListPipelineCleanupSchedulesCmd() error {
 		}
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, ProjectPipelineSchedule{}, results, pipe.Errors())
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	if _, err := fmt.Fprintln(w, strings.Join(scheduleFormat.Keys(), "\t")); err != nil {
 		return err
@@ -524,7 +525,7 @@ func ListPipelineCleanupSchedulesCmd() error {
 		}
 	}
 
-	if err := totalFormat.Print(w, "\n", unique, total, len(wg.Errors())); err != nil {
+	if err := totalFormat.Print(w, "\n", unique, total, len(pipe.Errors())); err != nil {
 		return err
 	}
 
@@ -532,86 +533,70 @@ func ListPipelineCleanupSchedulesCmd() error {
 		return err
 	}
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func listProjectsPipelines(h *client.Host, opt gitlab.ListProjectsOptions, desc []*regexp.Regexp,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage listing the pipeline schedules of the project with the descriptions matching desc,
+// the project is passed with nil schedule if there are none and no state or status filter is set
+func listPipelineSchedules(slot sync.Locker, opt gitlab.ListPipelineSchedulesOptions, desc []*regexp.Regexp, withLastPipelines bool,
+	options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[ProjectPipelineSchedule]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	list, resp, err := h.Client.Projects.ListProjects(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		h, project := e.Host, e.Value
 
-	for _, v := range list {
-		wg.Add(1)
-		go listPipelineSchedules(h, v, gitlab.ListPipelineSchedulesOptions{PerPage: 100}, desc, false, wg, data, options...)
-	}
+		var (
+			mu     sync.Mutex
+			list   []*gitlab.PipelineSchedule
+			cached = true
+		)
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listProjectsPipelines(h, opt, desc, wg, data, options...)
-	}
-}
-
-func listPipelineSchedules(h *client.Host, project *gitlab.Project, opt gitlab.ListPipelineSchedulesOptions,
-	desc []*regexp.Regexp, withLastPipelines bool, wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.PipelineSchedules.ListPipelineSchedules(project.ID, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	// filter schedules by matching descriptions if any
-	filteredList := make([]*gitlab.PipelineSchedule, 0, len(desc))
-filter:
-	for _, v := range list {
-		for _, p := range desc {
-			if p.MatchString(v.Description) {
-				filteredList = append(filteredList, v)
-				continue filter
+		err := pipeline.PaginateHost(slot, h, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.PipelineSchedule, *gitlab.Response, error) {
+			list, resp, err := h.Client.PipelineSchedules.ListPipelineSchedules(project.ID, &opt, slices.Concat(options, page)...)
+			if err == nil {
+				mu.Lock()
+				cached = cached && resp.Header.Get("X-From-Cache") == "1"
+				mu.Unlock()
+			}
+			return list, resp, err
+		}, func(v *gitlab.PipelineSchedule, _ bool) {
+			// filter schedules by matching descriptions if any
+			for _, p := range desc {
+				if p.MatchString(v.Description) {
+					mu.Lock()
+					list = append(list, v)
+					mu.Unlock()
+					return
+				}
 			}
+		})
+		if err != nil {
+			return err
 		}
-	}
 
-	if len(filteredList) == 0 {
-		// if no schedules were found and no --active flag value was provided
-		// return project with nil schedule
-		if active == nil && status == nil {
-			data <- sort.Element{
-				Host:   h,
-				Struct: ProjectPipelineSchedule{project, nil, nil},
-				Cached: resp.Header.Get("X-From-Cache") == "1"}
+		if len(list) == 0 {
+			// if no schedules were found and no --active flag value was provided
+			// return project with nil schedule
+			if active == nil && status == nil {
+				emit(ProjectPipelineSchedule{project, nil, nil}, cached)
+			}
+			return nil
 		}
-	} else {
-		for _, v := range filteredList {
+
+		var errs []error
+		for _, v := range list {
 			// get entire pipeline schedule to make lastpipeline struct accessible
 			// note: init new variables with the same names
-			wg.Lock()
 			v, resp, err := h.Client.PipelineSchedules.GetPipelineSchedule(project.ID, v.ID, options...)
 			if err != nil {
-				wg.Error(h, err)
-				wg.Unlock()
+				errs = append(errs, err)
 				continue
 			}
-			wg.Unlock()
 			// check pipeline schedule state
 			if active != nil && v.Active != *active {
 				continue
@@ -628,14 +613,11 @@ filter:
 			if withLastPipelines {
 				perPage := 100
 
-				wg.Lock()
 				pipelines, resp, err = h.Client.PipelineSchedules.ListPipelinesTriggeredBySchedule(project.ID, v.ID, &gitlab.ListPipelinesTriggeredByScheduleOptions{PerPage: perPage}, options...)
 				if err != nil {
-					wg.Error(h, err)
-					wg.Unlock()
+					errs = append(errs, err)
 					continue
 				}
-				wg.Unlock()
 
 				if resp.TotalPages > 1 {
 					// count last page
@@ -648,17 +630,14 @@ filter:
 					}
 					lastPage := math.Ceil(float64(resp.TotalItems) / float64(perPage))
 
-					wg.Lock()
 					pipelines, resp, err = h.Client.PipelineSchedules.ListPipelinesTriggeredBySchedule(project.ID, v.ID, &gitlab.ListPipelinesTriggeredByScheduleOptions{
 						Page:    int(lastPage),
 						PerPage: perPage,
 					}, options...)
 					if err != nil {
-						wg.Error(h, err)
-						wg.Unlock()
+						errs = append(errs, err)
 						continue
 					}
-					wg.Unlock()
 				}
 
 				if len(pipelines) > pipelinesCount {
@@ -672,64 +651,71 @@ filter:
 				})
 			}
 
-			// push result to channel
-			data <- sort.Element{
-				Host:   h,
-				Struct: ProjectPipelineSchedule{project, v, pipelines},
-				Cached: resp.Header.Get("X-From-Cache") == "1"}
+			emit(ProjectPipelineSchedule{project, v, pipelines}, resp.Header.Get("X-From-Cache") == "1")
 		}
-	}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listPipelineSchedules(h, project, opt, desc, false, wg, data, options...)
+		return errors.Join(errs...)
 	}
 }
 
-func listWorkflowRuns(h *client.Host, repository *github.Repository, opt github.ListOptions,
-	desc []*regexp.Regexp, withLastWorkflowRuns int, withFileContent bool, wg *limiter.Limiter, data chan<- interface{}) {
+// Returns the stage listing the workflows of the github repository with the names matching desc,
+// the repository is passed with nil workflow if there are none and no state or status filter is set
+func listWorkflowRuns(slot sync.Locker, opt github.ListOptions, desc []*regexp.Regexp, withLastWorkflowRuns int, withFileContent bool) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[RepositoryWorkflow]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[RepositoryWorkflow]) error {
+		h, repository := e.Host, e.Value
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	wg.Lock()
-	list, resp, err := h.GithubClient.Actions.ListWorkflows(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true), h.Org, repository.GetName(), &opt)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	// filter schedules by matching descriptions if any
-	filteredList := make([]*github.Workflow, 0, len(desc))
-filter:
-	for _, v := range list.Workflows {
-		for _, p := range desc {
-			if p.MatchString(v.GetName()) {
-				filteredList = append(filteredList, v)
-				continue filter
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+		defer cancel()
+		ctx = context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
+
+		var (
+			mu     sync.Mutex
+			list   []*github.Workflow
+			cached = true
+		)
+
+		err := pipeline.PaginateGithub(slot, func(page int) ([]*github.Workflow, *github.Response, error) {
+			opt := opt
+			opt.Page = page
+			workflows, resp, err := h.GithubClient.Actions.ListWorkflows(ctx, h.Org, repository.GetName(), &opt)
+			if err != nil {
+				return nil, resp, err
 			}
+			return workflows.Workflows, resp, nil
+		}, func(v *github.Workflow, c bool) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			cached = cached && c
+			// filter workflows by matching names if any
+			for _, p := range desc {
+				if p.MatchString(v.GetName()) {
+					list = append(list, v)
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
 		}
-	}
-	if len(filteredList) == 0 {
-		// if no workflows were found and no --active flag value was provided
-		// return repository with nil workflow
-		if active == nil && status == nil {
-			data <- sort.Element{
-				Host:   h,
-				Struct: RepositoryWorkflow{repository, nil, nil, nil},
-				Cached: resp.Header.Get("X-From-Cache") == "1"}
+
+		if len(list) == 0 {
+			// if no workflows were found and no --active flag value was provided
+			// return repository with nil workflow
+			if active == nil && status == nil {
+				emit(RepositoryWorkflow{repository, nil, nil, nil}, cached)
+			}
+			return nil
 		}
-	} else {
-		for _, v := range filteredList {
+
+		var errs []error
+		for _, v := range list {
 			runs := new(github.WorkflowRuns)
 			if withLastWorkflowRuns > 0 {
 				// get last workflow runs
-				wg.Lock()
-				runs, _, err = h.GithubClient.Actions.ListWorkflowRunsByID(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true),
+				runs, _, err = h.GithubClient.Actions.ListWorkflowRunsByID(ctx,
 					h.Org,
 					repository.GetName(),
 					v.GetID(),
@@ -741,11 +727,9 @@ filter:
 						},
 					})
 				if err != nil {
-					wg.Error(h, err)
-					wg.Unlock()
+					errs = append(errs, err)
 					continue
 				}
-				wg.Unlock()
 			}
 
 			// check workflow state
@@ -765,31 +749,21 @@ filter:
 
 			var fileContent *github.RepositoryContent
 			if withFileContent {
-				wg.Lock()
-				fileContent, _, _, err = h.GithubClient.Repositories.GetContents(context.WithValue(ctx, github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true),
+				fileContent, _, _, err = h.GithubClient.Repositories.GetContents(ctx,
 					repository.Owner.GetLogin(),
 					repository.GetName(),
 					v.GetPath(),
 					&github.RepositoryContentGetOptions{Ref: repository.GetDefaultBranch()})
-				wg.Unlock()
 				if err != nil {
-					wg.Error(h, err)
+					errs = append(errs, err)
 					continue
 				}
 			}
 
-			// push result to channel
-			data <- sort.Element{
-				Host:   h,
-				Struct: RepositoryWorkflow{repository, v, runs, fileContent},
-				Cached: resp.Header.Get("X-From-Cache") == "1"}
+			emit(RepositoryWorkflow{repository, v, runs, fileContent}, cached)
 		}
-	}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listWorkflowRuns(h, repository, opt, desc, withLastWorkflowRuns, withFileContent, wg, data)
+		return errors.Join(errs...)
 	}
 }
 
@@ -829,59 +803,64 @@ func (a Schedules) Descriptions() string {
 	return strings.Join(s, ", ")
 }
 
-func ListPipelineSchedules(h *client.Host, project *gitlab.Project, opt gitlab.ListPipelineSchedulesOptions,
-	desc []*regexp.Regexp, withLastPipelines bool, wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	listPipelineSchedules(h, project, opt, desc, withLastPipelines, wg, data, options...)
+// ListPipelineSchedules returns the stage listing the pipeline schedules of the project with the descriptions matching desc
+func ListPipelineSchedules(slot sync.Locker, opt gitlab.ListPipelineSchedulesOptions, desc []*regexp.Regexp, withLastPipelines bool,
+	options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+	return listPipelineSchedules(slot, opt, desc, withLastPipelines, options...)
 }
-func ListWorkflowRuns(h *client.Host, repository *github.Repository, opt github.ListOptions,
-	desc []*regexp.Regexp, withLastPipelines int, withFileContent bool, wg *limiter.Limiter, data chan<- interface{}) {
-	listWorkflowRuns(h, repository, opt, desc, withLastPipelines, withFileContent, wg, data)
+
+// ListWorkflowRuns returns the stage listing the workflows of the github repository with the names matching desc
+func ListWorkflowRuns(slot sync.Locker, opt github.ListOptions, desc []*regexp.Regexp, withLastWorkflowRuns int, withFileContent bool) func(
+	ctx context.Context, e pipeline.Element[*github.Repository], emit pipeline.Emit[RepositoryWorkflow]) error {
+	return listWorkflowRuns(slot, opt, desc, withLastWorkflowRuns, withFileContent)
 }
 
-func takeOwnership(h *client.Host, schedule ProjectPipelineSchedule,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage changing the owner of the pipeline schedule to the user of --setowner token
+func takeOwnership(options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[ProjectPipelineSchedule], emit pipeline.Emit[ProjectPipelineSchedule]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[ProjectPipelineSchedule], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	v, _, err := h.Client.PipelineSchedules.TakeOwnershipOfPipelineSchedule(
-		schedule.Project.ID, schedule.Schedule.ID, gitlab.WithToken(gitlab.PrivateToken, cleanupOwnerToken))
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	// revalidate cache
-	if schedule.Schedule, _, err = h.Client.PipelineSchedules.GetPipelineSchedule(schedule.Project.ID, v.ID, options...); err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		h, schedule := e.Host, e.Value
+
+		v, _, err := h.Client.PipelineSchedules.TakeOwnershipOfPipelineSchedule(
+			schedule.Project.ID, schedule.Schedule.ID, gitlab.WithToken(gitlab.PrivateToken, cleanupOwnerToken), gitlab.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		// revalidate cache
+		if schedule.Schedule, _, err = h.Client.PipelineSchedules.GetPipelineSchedule(schedule.Project.ID, v.ID, options...); err != nil {
+			return err
+		}
 
-	data <- sort.Element{Host: h, Struct: schedule, Cached: false}
+		emit(schedule, false)
+
+		return nil
+	}
 }
 
-func createPipelineSchedule(h *client.Host, schedule ProjectPipelineSchedule, opt gitlab.CreatePipelineScheduleOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the stage creating the pipeline schedule owned by the user of --setowner token
+func createPipelineSchedule(opt gitlab.CreatePipelineScheduleOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[ProjectPipelineSchedule], emit pipeline.Emit[ProjectPipelineSchedule]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[ProjectPipelineSchedule], emit pipeline.Emit[ProjectPipelineSchedule]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	v, _, err := h.Client.PipelineSchedules.CreatePipelineSchedule(
-		schedule.Project.ID, &opt, gitlab.WithToken(gitlab.PrivateToken, cleanupOwnerToken))
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	// revalidate cache
-	if schedule.Schedule, _, err = h.Client.PipelineSchedules.GetPipelineSchedule(schedule.Project.ID, v.ID, options...); err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		h, schedule := e.Host, e.Value
 
-	data <- sort.Element{Host: h, Struct: schedule, Cached: false}
+		v, _, err := h.Client.PipelineSchedules.CreatePipelineSchedule(
+			schedule.Project.ID, &opt, gitlab.WithToken(gitlab.PrivateToken, cleanupOwnerToken), gitlab.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		// revalidate cache
+		if schedule.Schedule, _, err = h.Client.PipelineSchedules.GetPipelineSchedule(schedule.Project.ID, v.ID, options...); err != nil {
+			return err
+		}
+
+		emit(schedule, false)
+
+		return nil
+	}
 }
@@ -0,0 +1,83 @@
+package projects
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestPipelineCleanupSchedules(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	deployer := alfa.AddUser(gitlab.User{Username: "deployer"})
+
+	api := alfa.AddProject(gitlab.Project{PathWithNamespace: "backend/api"})
+	api.AddFile(".gitlab-ci.yml", "cleanup:\n  stage: cleanup\n")
+	api.AddFile("werf.yaml", "image: api\n")
+	api.AddSchedule(gitlab.PipelineSchedule{Description: "Cleanup", Active: true, Owner: deployer},
+		gitlab.Pipeline{Status: "success"})
+	// The nightly schedule is not a cleanup one
+	api.AddSchedule(gitlab.PipelineSchedule{Description: "Nightly", Active: true, Owner: deployer})
+
+	// The projects of bravo have no schedules, the web is not built by werf
+	worker := bravo.AddProject(gitlab.Project{PathWithNamespace: "backend/worker"})
+	worker.AddFile(".gitlab-ci.yml", "cleanup:\n  stage: cleanup\n")
+	worker.AddFile("werf.yml", "image: worker\n")
+	web := bravo.AddProject(gitlab.Project{PathWithNamespace: "frontend/web"})
+	web.AddFile(".gitlab-ci.yml", "build:\n  stage: build\n")
+
+	// The flags are reset by every new command
+	cleanups := func(args ...string) func() error {
+		cmd := NewCmd()
+		cmd.SetArgs(append([]string{"pipelines", "cleanups"}, args...))
+		return cmd.Execute
+	}
+
+	out := common.RunWithInput(t, "", cleanups())
+	assert.Contains(t, out, "Cleanup (active)")
+	assert.Contains(t, out, "deployer")
+	assert.Contains(t, out, "backend/worker")
+	assert.NotContains(t, out, "Nightly")
+	assert.NotContains(t, out, "frontend/web")
+	assert.Contains(t, out, "Unique: 2\nTotal: 2\nErrors: 0\n")
+
+	// The owner is changed in the selected host only
+	selectHost(t, `^main\.alfa\.`)
+	out = common.RunWithInput(t, "y\n", cleanups("--setowner", alfa.Token))
+	assert.Contains(t, out, `Do you really want to change 1 cleanup schedules owner to "root" user in gitlab "alfa.local" ?`)
+	for _, s := range api.Schedules {
+		if s.Description == "Cleanup" {
+			assert.Equal(t, "root", s.Owner.Username)
+		} else {
+			assert.Equal(t, "deployer", s.Owner.Username)
+		}
+	}
+
+	selectHost(t, `^main\.bravo\.`)
+	out = common.RunWithInput(t, "y\n", cleanups("--setowner", bravo.Token, "--create"))
+	assert.Contains(t, out, `Do you really want to create 1 cleanup schedules with owner "root" user in gitlab "bravo.local" ?`)
+	if assert.Len(t, worker.Schedules, 1) {
+		s := worker.Schedules[0]
+		assert.Equal(t, "Cleanup", s.Description)
+		assert.Equal(t, "main", s.Ref)
+		assert.Equal(t, "root", s.Owner.Username)
+	}
+	assert.Empty(t, web.Schedules)
+	assert.Len(t, api.Schedules, 2)
+}
+
+// Selects the hosts of the fake matching the filter
+func selectHost(t *testing.T, filter string) {
+	common.Config.Filter = filter
+
+	var err error
+	if common.Client, err = client.NewClient(common.Config); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,202 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	go_sort "sort"
+
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	auditFile   string
+	applyFormat = util.Dict{
+		{
+			Key:   "ACTION",
+			Value: "%s",
+		},
+		{
+			Key:   "USER",
+			Value: "%s",
+		},
+		{
+			Key:   "HOST",
+			Value: "[%s]",
+		},
+		{
+			Key:   "STATUS",
+			Value: "%s",
+		},
+	}
+)
+
+// AuditRecord is written for every applied change as a json line
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	*Change
+	Error string `json:"error,omitempty"`
+}
+
+func NewApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Apply the users manifest",
+		Long: `Creates, modifies, blocks or unblocks users of every host to match the users manifest.
+Created users receive a password reset link.
+
+` + manifestExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Apply()
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "file", "", "Path to the users manifest")
+	cmd.MarkFlagRequired("file")
+
+	cmd.Flags().StringVar(&auditFile, "audit", "", "Append applied changes to the file as json lines")
+
+	return cmd
+}
+
+func Apply() error {
+	changes, err := planUsers(manifestFile)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("Users in %v are up to date\n", common.Client.Hosts.Projects(common.Config.ShowAll))
+		return nil
+	}
+
+	if err := printPlan(changes); err != nil {
+		return err
+	}
+
+	util.AskUser(fmt.Sprintf("Do you really want to apply %d changes in %v ?",
+		len(changes), common.Client.Hosts.Projects(common.Config.ShowAll)))
+
+	toApply := make([]pipeline.Element[*Change], 0, len(changes))
+	for _, c := range changes {
+		toApply = append(toApply, pipeline.Element[*Change]{Host: c.Host, Value: c})
+	}
+
+	pipe := pipeline.New(common.Limiter)
+
+	records := make([]*AuditRecord, 0, len(changes))
+	for e := range pipeline.Then(pipe, pipeline.From(toApply), applyChange(common.Client.WithNoCache())) {
+		records = append(records, e.Value)
+	}
+
+	go_sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Host != records[j].Host {
+			return records[i].Host < records[j].Host
+		}
+		return records[i].Username < records[j].Username
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	if _, err := fmt.Fprintln(w, strings.Join(applyFormat.Keys(), "\t")); err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, r := range records {
+		status := "ok"
+		if r.Error != "" {
+			status = "failed"
+		} else {
+			applied++
+		}
+		if err := applyFormat.Print(w, "\t", r.Action, r.Username, r.Host, status); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Applied: %d\nErrors: %d\n", applied, len(pipe.Errors()))
+
+	w.Flush()
+
+	for _, err := range pipe.Errors() {
+		hclog.L().Error(err.Err.Error())
+	}
+
+	if auditFile != "" {
+		return writeAudit(auditFile, records)
+	}
+
+	return nil
+}
+
+// Returns the stage applying the change, the audit record is passed even if it fails
+func applyChange(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*Change], emit pipeline.Emit[*AuditRecord]) error {
+	return func(ctx context.Context, e pipeline.Element[*Change], emit pipeline.Emit[*AuditRecord]) error {
+		c := e.Value
+		user := pipeline.Element[*gitlab.User]{Host: c.Host, Value: c.user}
+
+		// Only errors of the user stages are needed here
+		discard := func(*gitlab.User, bool) {}
+
+		var err error
+		switch c.Action {
+		case actionCreate:
+			err = createUser(c.createOpt, options...)(ctx, c.Host, discard)
+		case actionModify:
+			err = modifyUser(c.modifyOpt, options...)(ctx, user, discard)
+		case actionBlock:
+			err = blockUser(options...)(ctx, user, discard)
+		case actionUnblock, actionActivate:
+			if c.Action == actionUnblock {
+				err = unblockUser(options...)(ctx, user, discard)
+			} else {
+				err = activateUser(options...)(ctx, user, discard)
+			}
+			if err == nil && !reflect.ValueOf(c.modifyOpt).IsZero() {
+				err = modifyUser(c.modifyOpt, options...)(ctx, user, discard)
+			}
+		default:
+			err = fmt.Errorf("unknown action %q", c.Action)
+		}
+
+		r := AuditRecord{Time: time.Now(), Host: c.Host.ProjectName(), Change: c}
+		if err != nil {
+			r.Error = err.Error()
+		}
+
+		emit(&r, false)
+
+		return err
+	}
+}
+
+func writeAudit(path string, records []*AuditRecord) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
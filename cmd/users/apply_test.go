@@ -0,0 +1,80 @@
+package users
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestApply(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	alfa.AddUser(gitlab.User{Username: "testuser1", Name: "Test User 1", Email: "testuser1@example.com"})
+	alfa.AddUser(gitlab.User{Username: "leaver", Name: "Leaver"})
+	bravo.AddUser(gitlab.User{Username: "testuser1", Name: "Old Name", Email: "testuser1@example.com", State: "blocked"})
+
+	manifestFile = filepath.Join(t.TempDir(), "users.yaml")
+	assert.NoError(t, os.WriteFile(manifestFile, []byte(`
+users:
+  - username: testuser1
+    email: testuser1@example.com
+    name: Test User 1
+  - username: newuser
+    email: newuser@example.com
+    name: New User
+    hosts: "^main\\.bravo\\."
+  - username: leaver
+    state: blocked
+`), 0o600))
+	auditFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	defer func() { manifestFile, auditFile = "", "" }()
+
+	out := common.RunWithInput(t, "y\n", Apply)
+	assert.Contains(t, out, "Do you really want to apply 3 changes in [alfa.local bravo.local] ?")
+	assert.Contains(t, out, "Applied: 3\nErrors: 0\n")
+
+	assert.Equal(t, "blocked", alfa.User("leaver").State)
+	assert.Nil(t, alfa.User("newuser"))
+	if u := bravo.User("testuser1"); assert.NotNil(t, u) {
+		assert.Equal(t, "active", u.State)
+		assert.Equal(t, "Test User 1", u.Name)
+	}
+	if u := bravo.User("newuser"); assert.NotNil(t, u) {
+		assert.Equal(t, "newuser@example.com", u.Email)
+	}
+
+	// The second run finds nothing to change
+	out = common.RunWithInput(t, "", Apply)
+	assert.Contains(t, out, "Users in [alfa.local bravo.local] are up to date")
+
+	f, err := os.Open(auditFile)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	actions := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r struct {
+			Host     string `json:"host"`
+			Action   string `json:"action"`
+			Username string `json:"username"`
+			Error    string `json:"error"`
+		}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		assert.Empty(t, r.Error)
+		actions[r.Host+" "+r.Username] = r.Action
+	}
+	assert.Equal(t, map[string]string{
+		"alfa.local leaver":     actionBlock,
+		"bravo.local testuser1": actionUnblock,
+		"bravo.local newuser":   actionCreate,
+	}, actions)
+}
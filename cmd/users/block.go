@@ -1,14 +1,16 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"text/tabwriter"
 
-	"github.com/flant/glaball/pkg/client"
 	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/tui"
 	"github.com/flant/glaball/pkg/util"
 
 	"github.com/flant/glaball/cmd/common"
@@ -26,10 +28,11 @@ var (
 
 func NewBlockCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "block --by=[email|username|name] [regexp]",
-		Short: "Blocks an existing user",
-		Long:  "Blocks an existing user. Only administrators can change attributes of a user.",
-		Args:  cobra.ExactArgs(1),
+		Use:               "block --by=[email|username|name] [regexp]",
+		Short:             "Blocks an existing user",
+		Long:              "Blocks an existing user. Only administrators can change attributes of a user.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeBy(&blockBy),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			re, err := regexp.Compile(args[0])
 			if err != nil {
@@ -49,55 +52,34 @@ func NewBlockCmd() *cobra.Command {
 }
 
 func Block() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	fmt.Printf("Searching for user %q...\n", blockFieldRegexp)
-	for _, h := range common.Client.Hosts {
-		wg.Add(1)
-		go listUsersSearch(h, blockBy, blockFieldRegexp, gitlab.ListUsersOptions{
-			ListOptions: gitlab.ListOptions{
-				PerPage: 100,
-			},
-		}, wg, data, common.Client.WithNoCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	toBlock := make(sort.Elements, 0)
-	for e := range data {
-		toBlock = append(toBlock, e)
-	}
+	hclog.L().Debug("Searching for user", blockBy, blockFieldRegexp)
+	toBlock := pipeline.Collect(pipeline.FromHosts(pipe, common.Client.Hosts, listUsersSearch(pipe.Slot(), blockBy, blockFieldRegexp, gitlab.ListUsersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	}, common.Client.WithNoCache())))
 
 	if len(toBlock) == 0 {
 		return fmt.Errorf("user not found: %s", blockFieldRegexp)
 	}
 
+	hosts := pipeline.Hosts(toBlock)
+
 	if blockHosts {
-		for _, h := range toBlock.Hosts() {
+		for _, h := range hosts {
 			fmt.Println(h.Project)
 		}
 		return nil
 	}
 
 	util.AskUser(fmt.Sprintf("Do you really want to block %d user(s) %q in %d gitlab(s) %v ?",
-		len(toBlock), blockFieldRegexp, len(toBlock.Hosts()), toBlock.Hosts().Projects(common.Config.ShowAll)))
-
-	blocked := make(chan interface{})
-	for _, v := range toBlock.Typed() {
-		wg.Add(1)
-		go blockUser(v.Host, v.Struct.(*gitlab.User), wg, blocked)
-	}
+		len(toBlock), blockFieldRegexp, len(hosts), hosts.Projects(common.Config.ShowAll)))
 
-	go func() {
-		wg.Wait()
-		close(blocked)
-	}()
+	blocked := pipeline.Then(pipe, pipeline.From(toBlock), blockUser())
 
-	results, err := sort.FromChannel(blocked, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(blocked), &sort.Options{
 		OrderBy:    []string{blockBy},
 		StructType: gitlab.User{},
 	})
@@ -114,11 +96,11 @@ func Block() error {
 		total++
 	}
 
-	fmt.Fprintf(w, "Blocked: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Blocked: %d\nErrors: %d\n", total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -126,19 +108,66 @@ func Block() error {
 
 }
 
-func blockUser(h *client.Host, user *gitlab.User, wg *limiter.Limiter, data chan<- interface{},
-	options ...gitlab.RequestOptionFunc) {
+// Returns the stage blocking the user
+func blockUser(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		if err := e.Host.Client.Users.BlockUser(e.Value.ID, options...); err != nil {
+			return err
+		}
 
-	defer wg.Done()
+		emit(e.Value, false)
 
-	wg.Lock()
-	err := h.Client.Users.BlockUser(user.ID, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
+		return nil
+	}
+}
+
+// blockAction blocks the users selected in the interactive mode
+func blockAction() tui.Action {
+	return tui.Action{
+		Key:  'b',
+		Name: "block",
+		Confirm: func(elements sort.Elements) string {
+			hosts := elements.Hosts().Unique()
+			return fmt.Sprintf("Do you really want to block %d user(s) in %d gitlab(s) %v ?",
+				len(elements), len(hosts), hosts.Projects(common.Config.ShowAll))
+		},
+		Run: func(elements sort.Elements) (string, error) {
+			pipe := pipeline.New(limiter.NewLimiter(common.Config.Threads))
+			blocked := pipeline.Collect(pipeline.Then(pipe, pipeline.From(pipeline.Elements[*gitlab.User](elements)), blockUser()))
+
+			return fmt.Sprintf("blocked %d user(s)", len(blocked)), pipe.Err()
+		},
+	}
+}
+
+// Returns the stage unblocking the user
+func unblockUser(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		if err := e.Host.Client.Users.UnblockUser(e.Value.ID, options...); err != nil {
+			return err
+		}
+
+		emit(e.Value, false)
+
+		return nil
 	}
-	wg.Unlock()
+}
+
+// Returns the stage activating the deactivated user
+func activateUser(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		if err := e.Host.Client.Users.ActivateUser(e.Value.ID, options...); err != nil {
+			return err
+		}
+
+		emit(e.Value, false)
 
-	data <- sort.Element{Host: h, Struct: user, Cached: false}
+		return nil
+	}
 }
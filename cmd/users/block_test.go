@@ -0,0 +1,60 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestBlockAction(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	bob := alfa.AddUser(gitlab.User{Username: "bob"})
+	alice := bravo.AddUser(gitlab.User{Username: "alice"})
+	bravo.AddUser(gitlab.User{Username: "bob"})
+
+	hosts := common.Client.Hosts
+	elements := sort.Elements{
+		sort.Element{Host: hosts[0], Struct: bob},
+		sort.Element{Host: hosts[1], Struct: alice},
+		// Unknown users fail on their host only
+		sort.Element{Host: hosts[1], Struct: &gitlab.User{ID: 1000, Username: "ghost"}},
+	}
+
+	action := blockAction()
+	assert.Equal(t, "Do you really want to block 3 user(s) in 2 gitlab(s) [alfa.local bravo.local] ?", action.Confirm(elements))
+
+	summary, err := action.Run(elements)
+	assert.Equal(t, "blocked 2 user(s)", summary)
+	assert.ErrorContains(t, err, "main.bravo.local: ")
+
+	assert.Equal(t, "blocked", alfa.User("bob").State)
+	assert.Equal(t, "blocked", bravo.User("alice").State)
+	assert.Equal(t, "active", bravo.User("bob").State)
+}
+
+func TestBlock(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	alfa.AddUser(gitlab.User{Username: "bob"})
+	bravo.AddUser(gitlab.User{Username: "bob"})
+	bravo.AddUser(gitlab.User{Username: "carol"})
+
+	blockBy, blockFieldRegexp = "username", regexp.MustCompile("^bob$")
+
+	out := common.RunWithInput(t, "y\n", Block)
+	assert.Contains(t, out, `Do you really want to block 2 user(s) "^bob$" in 2 gitlab(s) [alfa.local bravo.local] ?`)
+	assert.Contains(t, out, "Blocked: 2\nErrors: 0\n")
+
+	assert.Equal(t, "blocked", alfa.User("bob").State)
+	assert.Equal(t, "blocked", bravo.User("bob").State)
+	assert.Equal(t, "active", bravo.User("carol").State)
+}
@@ -1,12 +1,13 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"text/tabwriter"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 
@@ -84,20 +85,10 @@ func Create() error {
 	util.AskUser(fmt.Sprintf("Do you really want to create user %q in %v ?",
 		*createOpt.Username, common.Client.Hosts.Projects(common.Config.ShowAll)))
 
-	wg := common.Limiter
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
+	created := pipeline.FromHosts(pipe, common.Client.Hosts, createUser(createOpt))
 
-	for _, h := range common.Client.Hosts {
-		wg.Add(1)
-		go createUser(h, createOpt, wg, data)
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
-	results, err := sort.FromChannel(data, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(created), &sort.Options{
 		OrderBy:    []string{"username"},
 		StructType: gitlab.User{},
 	})
@@ -114,11 +105,11 @@ func Create() error {
 		total++
 	}
 
-	fmt.Fprintf(w, "Created: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Created: %d\nErrors: %d\n", total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -126,19 +117,18 @@ func Create() error {
 
 }
 
-func createUser(h *client.Host, opt gitlab.CreateUserOptions, wg *limiter.Limiter, data chan<- interface{},
-	options ...gitlab.RequestOptionFunc) {
+// Returns the first stage of the pipelines creating the user on every host
+func createUser(opt gitlab.CreateUserOptions, options ...gitlab.RequestOptionFunc) func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	defer wg.Done()
+		user, resp, err := h.Client.Users.CreateUser(&opt, options...)
+		if err != nil {
+			return err
+		}
 
-	wg.Lock()
-	user, resp, err := h.Client.Users.CreateUser(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		emit(user, resp.Header.Get("X-From-Cache") == "1")
 
-	data <- sort.Element{Host: h, Struct: user, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		return nil
+	}
 }
@@ -0,0 +1,36 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestCreate(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	// The username is already taken in alfa only
+	alfa.AddUser(gitlab.User{Username: "bob", Name: "Bob Old", Email: "bob@old.example.com"})
+
+	createOpt = gitlab.CreateUserOptions{
+		Username:      gitlab.String("bob"),
+		Name:          gitlab.String("Bob"),
+		Email:         gitlab.String("bob@example.com"),
+		ResetPassword: gitlab.Bool(true),
+	}
+	defer func() { createOpt = gitlab.CreateUserOptions{} }()
+
+	out := common.RunWithInput(t, "y\n", Create)
+	assert.Contains(t, out, `Do you really want to create user "bob" in [alfa.local bravo.local] ?`)
+	assert.Contains(t, out, "Created: 1\nErrors: 1\n")
+
+	assert.Equal(t, "Bob Old", alfa.User("bob").Name)
+	if bob := bravo.User("bob"); assert.NotNil(t, bob) {
+		assert.Equal(t, "Bob", bob.Name)
+		assert.Equal(t, "bob@example.com", bob.Email)
+	}
+}
@@ -1,13 +1,13 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"text/tabwriter"
 
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 
@@ -31,7 +31,8 @@ func NewDeleteCmd() *cobra.Command {
 		Long: `Deletes a user. Available only for administrators.
 This returns a 204 No Content status code if the operation was successfully,
 404 if the resource was not found or 409 if the user cannot be soft deleted.`,
-		Args: cobra.ExactArgs(1),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeBy(&deleteBy),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			re, err := regexp.Compile(args[0])
 			if err != nil {
@@ -51,35 +52,23 @@ This returns a 204 No Content status code if the operation was successfully,
 }
 
 func Delete() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	fmt.Printf("Searching for user %q...\n", deleteFieldRegexp)
-	for _, h := range common.Client.Hosts {
-		wg.Add(1)
-		go listUsersSearch(h, deleteBy, deleteFieldRegexp, gitlab.ListUsersOptions{
-			ListOptions: gitlab.ListOptions{
-				PerPage: 100,
-			},
-		}, wg, data)
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	toDelete := make(sort.Elements, 0)
-	for e := range data {
-		toDelete = append(toDelete, e)
-	}
+	hclog.L().Debug("Searching for user", deleteBy, deleteFieldRegexp)
+	toDelete := pipeline.Collect(pipeline.FromHosts(pipe, common.Client.Hosts, listUsersSearch(pipe.Slot(), deleteBy, deleteFieldRegexp, gitlab.ListUsersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	})))
 
 	if len(toDelete) == 0 {
 		return fmt.Errorf("user not found: %s", deleteFieldRegexp)
 	}
 
+	hosts := pipeline.Hosts(toDelete)
+
 	if deleteHosts {
-		for _, h := range toDelete.Hosts() {
+		for _, h := range hosts {
 			fmt.Println(h.Project)
 		}
 		return nil
@@ -91,20 +80,11 @@ func Delete() error {
 	}
 
 	util.AskUser(fmt.Sprintf("Do you really want to delete user %q in %d gitlab(s) %v ?",
-		deleteFieldRegexp, len(toDelete.Hosts()), toDelete.Hosts().Projects(common.Config.ShowAll)))
-
-	deleted := make(chan interface{})
-	for _, v := range toDelete.Typed() {
-		wg.Add(1)
-		go deleteUser(v.Host, v.Struct.(*gitlab.User), wg, deleted)
-	}
+		deleteFieldRegexp, len(hosts), hosts.Projects(common.Config.ShowAll)))
 
-	go func() {
-		wg.Wait()
-		close(deleted)
-	}()
+	deleted := pipeline.Then(pipe, pipeline.From(toDelete), deleteUser())
 
-	results, err := sort.FromChannel(deleted, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(deleted), &sort.Options{
 		OrderBy:    []string{deleteBy},
 		StructType: gitlab.User{},
 	})
@@ -121,11 +101,11 @@ func Delete() error {
 		total++
 	}
 
-	fmt.Fprintf(w, "Deleted: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Deleted: %d\nErrors: %d\n", total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -133,19 +113,18 @@ func Delete() error {
 
 }
 
-func deleteUser(h *client.Host, user *gitlab.User, wg *limiter.Limiter, data chan<- interface{},
-	options ...gitlab.RequestOptionFunc) {
+// Returns the stage deleting the user
+func deleteUser(options ...gitlab.RequestOptionFunc) func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	defer wg.Done()
+		resp, err := e.Host.Client.Users.DeleteUser(e.Value.ID, options...)
+		if err != nil {
+			return err
+		}
 
-	wg.Lock()
-	resp, err := h.Client.Users.DeleteUser(user.ID, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		emit(e.Value, resp.Header.Get("X-From-Cache") == "1")
 
-	data <- sort.Element{Host: h, Struct: user, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		return nil
+	}
 }
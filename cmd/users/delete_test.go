@@ -0,0 +1,37 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestDelete(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	alfa.AddUser(gitlab.User{Username: "bob"})
+	alfa.AddUser(gitlab.User{Username: "alice"})
+	bravo.AddUser(gitlab.User{Username: "alice"})
+
+	deleteBy = "username"
+
+	// The users found in several gitlabs are not deleted
+	deleteFieldRegexp = regexp.MustCompile("^alice$")
+	assert.ErrorContains(t, Delete(), "bulk")
+	assert.NotNil(t, alfa.User("alice"))
+	assert.NotNil(t, bravo.User("alice"))
+
+	deleteFieldRegexp = regexp.MustCompile("^bob$")
+	out := common.RunWithInput(t, "y\n", Delete)
+	assert.Contains(t, out, `Do you really want to delete user "^bob$" in 1 gitlab(s) [alfa.local] ?`)
+	assert.Contains(t, out, "Deleted: 1\nErrors: 0\n")
+
+	assert.Nil(t, alfa.User("bob"))
+	assert.NotNil(t, alfa.User("alice"))
+	assert.Len(t, bravo.Users(), 1)
+}
@@ -1,14 +1,20 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
+	"sync"
 	"text/tabwriter"
+	"time"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/tui"
 	"github.com/flant/glaball/pkg/util"
 
 	"github.com/flant/glaball/cmd/common"
@@ -19,11 +25,14 @@ import (
 )
 
 var (
-	listCount       int
-	groupBy, sortBy string
-	orderBy         []string
+	listCount         int
+	listWatchInterval time.Duration
+	groupBy, sortBy   string
+	orderBy           []string
 
 	listUsersOptions = gitlab.ListUsersOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	resultOptions    common.ResultOptions
+	outputFormat     []string
 )
 
 func NewListCmd() *cobra.Command {
@@ -31,23 +40,39 @@ func NewListCmd() *cobra.Command {
 		Use:   "list",
 		Short: "List users.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// Streamed users are written in the order they arrive by default
+			if resultOptions.Stream && !cmd.Flags().Changed("order_by") {
+				orderBy = nil
+			}
+			if listWatchInterval > 0 {
+				return WatchList()
+			}
+			resultOptions.Actions = []tui.Action{blockAction()}
 			return List()
 		},
 	}
 
-	cmd.Flags().Var(util.NewEnumValue(&groupBy, "name", "username", "email"), "group_by",
-		"Return users grouped by id, name, username, fields.")
+	cmd.Flags().StringVar(&groupBy, "group_by", "",
+		"Return users grouped by name, username, email, host or any nested field. Multiple comma separated fields produce nested groups, e.g. host,state.")
 
 	cmd.Flags().Var(util.NewEnumValue(&sortBy, "asc", "desc"), "sort",
 		"Return users sorted in asc or desc order. Default is desc")
 
 	//"id", "name", "username", "email", "count"
 	cmd.Flags().StringSliceVar(&orderBy, "order_by", []string{"count", userDefaultField},
-		"Return users ordered by id, name, username, created_at, or updated_at fields.")
+		"Return users ordered by id, name, username, created_at, or updated_at fields. Append :asc or :desc to a field to set its direction, e.g. created_at:asc,id:desc.")
 
 	cmd.Flags().IntVar(&listCount, "count", 1, "Order by count")
 
 	listUsersOptionsFlags(cmd, &listUsersOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+	common.StreamFlags(cmd, &resultOptions)
+	common.WatchFlag(cmd, &listWatchInterval)
+
+	cmd.Flags().StringSliceVar(&outputFormat, "output", []string{output.FormatTable},
+		"Output format of --columns, --aggregate, --matrix and --stream: [table csv json ndjson]. Default: table.")
+
+	common.RegisterFieldCompletions(cmd, gitlab.User{})
 
 	return cmd
 }
@@ -101,35 +126,90 @@ The list of billable users is the total number of users minus the blocked users.
 		"You can include the users’ custom attributes in the response. Default is false")
 }
 
-func List() error {
-	if !sort.ValidOrderBy(orderBy, gitlab.User{}) {
-		orderBy = append(orderBy, userDefaultField)
+func prepareList() error {
+	if err := resultOptions.Validate(gitlab.User{}); err != nil {
+		return err
 	}
 
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Fetching users from %s ...\n", h.URL)
-		wg.Add(1)
-		go listUsers(h, listUsersOptions, wg, data, common.Client.WithCache())
+	if len(orderBy) > 0 && !sort.ValidOrderBy(orderBy, gitlab.User{}) {
+		orderBy = append(orderBy, userDefaultField)
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	return nil
+}
+
+// Returns the users of all hosts as they are fetched
+// The options follow the cache option of the requests, see common.Watch
+func listAllUsers(pipe *pipeline.Pipeline, options ...gitlab.RequestOptionFunc) chan interface{} {
+	options = append([]gitlab.RequestOptionFunc{common.Client.WithCache()}, options...)
+	return pipeline.Sort(pipeline.FromHosts(pipe, common.Client.Hosts, listUsers(pipe.Slot(), listUsersOptions, options...)))
+}
 
-	results, err := sort.FromChannel(data, &sort.Options{
+func listSortOptions() *sort.Options {
+	return &sort.Options{
 		OrderBy:    orderBy,
 		SortBy:     sortBy,
 		GroupBy:    groupBy,
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: gitlab.User{},
+	}
+}
+
+// WatchList prints the changes of the users every --watch interval
+func WatchList() error {
+	if err := prepareList(); err != nil {
+		return err
+	}
+
+	if err := resultOptions.ValidateWatch(); err != nil {
+		return err
+	}
+
+	return common.Watch(listWatchInterval, gitlab.User{}, "id", func(options ...gitlab.RequestOptionFunc) ([]sort.Result, error) {
+		results, err := sort.FromChannel(listAllUsers(pipeline.New(common.Limiter), options...), listSortOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		filtered := make([]sort.Result, 0, len(results))
+		for _, v := range results {
+			if v.Count >= listCount {
+				filtered = append(filtered, v)
+			}
+		}
+		return filtered, nil
 	})
+}
+
+func List() error {
+	if err := prepareList(); err != nil {
+		return err
+	}
+
+	pipe := pipeline.New(common.Limiter)
+	data := listAllUsers(pipe)
+	opt := listSortOptions()
+
+	if resultOptions.Stream {
+		return resultOptions.PrintStream(outputFormat, data, opt, common.Limiter)
+	}
+
+	results, err := sort.FromChannel(data, opt)
 	if err != nil {
 		return err
 	}
 
+	if resultOptions.Custom() || sort.Nested(results) {
+		filtered := make([]sort.Result, 0, len(results))
+		for _, v := range results {
+			if v.Count >= listCount {
+				filtered = append(filtered, v)
+			}
+		}
+		return resultOptions.Print(outputFormat, gitlab.User{}, filtered, pipe.Errors())
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "COUNT\tUSER\tHOSTS\tCACHED\n")
 	unique := 0
@@ -146,11 +226,11 @@ func List() error {
 		fmt.Fprintf(w, "[%d]\t%s\t%s\t[%s]\n", v.Count, v.Key, v.Elements.Hosts().Projects(common.Config.ShowAll), v.Cached)
 	}
 
-	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(wg.Errors()))
+	fmt.Fprintf(w, "Unique: %d\nTotal: %d\nErrors: %d\n", unique, total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -158,60 +238,49 @@ func List() error {
 
 }
 
-func listUsers(h *client.Host, opt gitlab.ListUsersOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
+// Returns the first stage of the pipelines over the users of every host
+func listUsers(slot sync.Locker, opt gitlab.ListUsersOptions, options ...gitlab.RequestOptionFunc) func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	defer wg.Done()
+		hclog.L().Debug("Fetching users", "host", h.URL)
 
-	wg.Lock()
-	list, resp, err := h.Client.Users.ListUsers(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-	}
+		// The options are changed for the pagination of the host
+		opt := opt
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listUsers(h, opt, wg, data, options...)
+		return pipeline.PaginateHost(slot, h, pipeline.UsersKeyset(&opt), func(page ...gitlab.RequestOptionFunc) ([]*gitlab.User, *gitlab.Response, error) {
+			return h.Client.Users.ListUsers(&opt, slices.Concat(options, page)...)
+		}, emit)
 	}
 }
 
-func listUsersSearch(h *client.Host, key string, value *regexp.Regexp, opt gitlab.ListUsersOptions,
-	wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-
-	defer wg.Done()
-
-	wg.Lock()
-	list, resp, err := h.Client.Users.ListUsers(&opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
-
-	for _, v := range list {
-		s, err := sort.ValidFieldValue([]string{key}, v)
+// Returns the first stage of the pipelines over the users of every host with the key matching value
+func listUsersSearch(slot sync.Locker, key string, value *regexp.Regexp, opt gitlab.ListUsersOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+		var (
+			mu     sync.Mutex
+			failed error
+		)
+
+		err := listUsers(slot, opt, options...)(ctx, h, func(v *gitlab.User, cached bool) {
+			s, err := sort.ValidFieldValue([]string{key}, v)
+			if err != nil {
+				mu.Lock()
+				failed = err
+				mu.Unlock()
+				return
+			}
+			// This will panic if value is not a string
+			if value.MatchString(s.(string)) {
+				emit(v, cached)
+			}
+		})
 		if err != nil {
-			wg.Error(h, err)
-			return
+			return err
 		}
-		// This will panic if value is not a string
-		if value.MatchString(s.(string)) {
-			data <- sort.Element{Host: h, Struct: v, Cached: resp.Header.Get("X-From-Cache") == "1"}
-		}
-	}
 
-	if resp.NextPage > 0 {
-		wg.Add(1)
-		opt.Page = resp.NextPage
-		go listUsersSearch(h, key, value, opt, wg, data, options...)
+		return failed
 	}
 }
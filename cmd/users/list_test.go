@@ -0,0 +1,60 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/watch"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestWatchListChanges(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	bob := alfa.AddUser(gitlab.User{Username: "bob"})
+	bravo.AddUser(gitlab.User{Username: "bob"})
+
+	orderBy, groupBy, sortBy = []string{"count", userDefaultField}, "", "desc"
+
+	columns, err := sort.ParseColumns([]string{"id"}, gitlab.User{})
+	require.NoError(t, err)
+
+	collect := func() watch.Snapshot {
+		results, err := sort.FromChannel(listAllUsers(pipeline.New(common.Limiter)), listSortOptions())
+		require.NoError(t, err)
+		s, err := watch.NewSnapshot(results, &columns[0])
+		require.NoError(t, err)
+		return s
+	}
+
+	prev := collect()
+	_, err = blockAction().Run(sort.Elements{sort.Element{Host: common.Client.Hosts[0], Struct: bob}})
+	require.NoError(t, err)
+	bravo.AddUser(gitlab.User{Username: "alice"})
+
+	changes := watch.Diff(prev, collect(), nil)
+	require.Len(t, changes, 2)
+
+	assert.Equal(t, watch.Added, changes[0].Kind)
+	assert.Equal(t, "alice", changes[0].Entry.Key)
+
+	assert.Equal(t, watch.Changed, changes[1].Kind)
+	assert.Equal(t, "bob", changes[1].Entry.Key)
+	assert.Equal(t, "alfa.local", changes[1].Entry.Host.ProjectName())
+	assert.Contains(t, changes[1].Fields, watch.FieldChange{Field: "state", Old: "active", New: "blocked"})
+}
+
+func TestWatchListOptions(t *testing.T) {
+	resultOptions = common.ResultOptions{Stream: true}
+	defer func() { resultOptions = common.ResultOptions{} }()
+
+	assert.EqualError(t, resultOptions.ValidateWatch(),
+		"--watch can't be used with --stream, --interactive, --matrix, --format or --template_file")
+}
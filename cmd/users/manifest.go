@@ -0,0 +1,224 @@
+package users
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	go_sort "sort"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/pipeline"
+
+	"github.com/xanzy/go-gitlab"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	stateActive      = "active"
+	stateBlocked     = "blocked"
+	stateDeactivated = "deactivated"
+
+	actionCreate   = "create"
+	actionModify   = "modify"
+	actionBlock    = "block"
+	actionUnblock  = "unblock"
+	actionActivate = "activate"
+)
+
+type Manifest struct {
+	Users []*ManifestUser `yaml:"users"`
+}
+
+type ManifestUser struct {
+	Username string `yaml:"username"`
+	Email    string `yaml:"email"`
+	Name     string `yaml:"name"`
+	Admin    *bool  `yaml:"admin"`
+	External *bool  `yaml:"external"`
+	// Regexp of the host full name "<team>.<project>.<name>" where the user should exist
+	Hosts string `yaml:"hosts"`
+	// Desired user state: active (default) or blocked
+	State string `yaml:"state"`
+
+	hosts *regexp.Regexp
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %q error: %v", path, err)
+	}
+
+	if len(m.Users) == 0 {
+		return nil, fmt.Errorf("no users found in %q", path)
+	}
+
+	usernames := make(map[string]bool, len(m.Users))
+	for _, u := range m.Users {
+		if u.Username == "" {
+			return nil, fmt.Errorf("username is missing in %q", path)
+		}
+
+		key := strings.ToLower(u.Username)
+		if usernames[key] {
+			return nil, fmt.Errorf("duplicate user %q in %q", u.Username, path)
+		}
+		usernames[key] = true
+
+		switch u.State {
+		case "":
+			u.State = stateActive
+		case stateActive, stateBlocked:
+		default:
+			return nil, fmt.Errorf("invalid state %q of user %q, expected %s or %s", u.State, u.Username, stateActive, stateBlocked)
+		}
+
+		if u.State == stateActive && (u.Email == "" || u.Name == "") {
+			return nil, fmt.Errorf("email and name are required for active user %q", u.Username)
+		}
+
+		if u.Hosts != "" {
+			if u.hosts, err = regexp.Compile(u.Hosts); err != nil {
+				return nil, fmt.Errorf("invalid hosts of user %q: %v", u.Username, err)
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+// Selected checks if the user should exist on the host
+func (u *ManifestUser) Selected(h *client.Host) bool {
+	return u.hosts == nil || u.hosts.MatchString(h.FullName())
+}
+
+// Change is a single action required to bring the host to the manifest state
+type Change struct {
+	Host     *client.Host `json:"-"`
+	Action   string       `json:"action"`
+	Username string       `json:"username"`
+	Changes  []string     `json:"changes,omitempty"`
+
+	user      *gitlab.User
+	createOpt gitlab.CreateUserOptions
+	modifyOpt gitlab.ModifyUserOptions
+}
+
+// Plan computes changes for every host against existing users.
+// Users selected on the host are created or modified and get the desired state,
+// users existing on hosts they are not selected on are blocked.
+// Users missing from the manifest are left untouched.
+func (m *Manifest) Plan(hosts client.Hosts, users []pipeline.Element[*gitlab.User]) []*Change {
+	existing := make(map[*client.Host]map[string]*gitlab.User, len(hosts))
+	for _, h := range hosts {
+		existing[h] = make(map[string]*gitlab.User)
+	}
+	for _, v := range users {
+		if _, ok := existing[v.Host]; ok {
+			existing[v.Host][strings.ToLower(v.Value.Username)] = v.Value
+		}
+	}
+
+	changes := make([]*Change, 0)
+	for _, h := range hosts {
+		for _, mu := range m.Users {
+			if c := mu.change(h, existing[h][strings.ToLower(mu.Username)]); c != nil {
+				changes = append(changes, c)
+			}
+		}
+	}
+
+	go_sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Host.FullName() != changes[j].Host.FullName() {
+			return changes[i].Host.FullName() < changes[j].Host.FullName()
+		}
+		return changes[i].Username < changes[j].Username
+	})
+
+	return changes
+}
+
+func (mu *ManifestUser) change(h *client.Host, user *gitlab.User) *Change {
+	c := Change{Host: h, Username: mu.Username, user: user}
+
+	if user == nil {
+		if !mu.Selected(h) || mu.State != stateActive {
+			return nil
+		}
+		c.Action = actionCreate
+		c.createOpt = gitlab.CreateUserOptions{
+			Username:         gitlab.String(mu.Username),
+			Email:            gitlab.String(mu.Email),
+			Name:             gitlab.String(mu.Name),
+			Admin:            mu.Admin,
+			External:         mu.External,
+			ResetPassword:    gitlab.Bool(true),
+			SkipConfirmation: gitlab.Bool(true),
+		}
+		c.Changes = []string{fmt.Sprintf("email: %s", mu.Email), fmt.Sprintf("name: %s", mu.Name)}
+		if mu.Admin != nil {
+			c.Changes = append(c.Changes, fmt.Sprintf("admin: %t", *mu.Admin))
+		}
+		if mu.External != nil {
+			c.Changes = append(c.Changes, fmt.Sprintf("external: %t", *mu.External))
+		}
+		return &c
+	}
+
+	if !mu.Selected(h) || mu.State == stateBlocked {
+		if user.State != stateActive {
+			return nil
+		}
+		c.Action = actionBlock
+		c.Changes = []string{fmt.Sprintf("state: %s -> %s", user.State, stateBlocked)}
+		return &c
+	}
+
+	// Email is returned only to administrators
+	if user.Email != "" && mu.Email != "" && !strings.EqualFold(user.Email, mu.Email) {
+		c.modifyOpt.Email = gitlab.String(mu.Email)
+		c.modifyOpt.SkipReconfirmation = gitlab.Bool(true)
+		c.Changes = append(c.Changes, fmt.Sprintf("email: %s -> %s", user.Email, mu.Email))
+	}
+	if mu.Name != "" && user.Name != mu.Name {
+		c.modifyOpt.Name = gitlab.String(mu.Name)
+		c.Changes = append(c.Changes, fmt.Sprintf("name: %s -> %s", user.Name, mu.Name))
+	}
+	if mu.Admin != nil && user.IsAdmin != *mu.Admin {
+		c.modifyOpt.Admin = mu.Admin
+		c.Changes = append(c.Changes, fmt.Sprintf("admin: %t -> %t", user.IsAdmin, *mu.Admin))
+	}
+	if mu.External != nil && user.External != *mu.External {
+		c.modifyOpt.External = mu.External
+		c.Changes = append(c.Changes, fmt.Sprintf("external: %t -> %t", user.External, *mu.External))
+	}
+
+	// Attributes of the blocked and the deactivated users are modified after changing their state
+	switch user.State {
+	case stateBlocked:
+		c.Action = actionUnblock
+		c.Changes = append([]string{fmt.Sprintf("state: %s -> %s", user.State, stateActive)}, c.Changes...)
+		return &c
+	case stateDeactivated:
+		c.Action = actionActivate
+		c.Changes = append([]string{fmt.Sprintf("state: %s -> %s", user.State, stateActive)}, c.Changes...)
+		return &c
+	}
+
+	if len(c.Changes) == 0 {
+		return nil
+	}
+
+	c.Action = actionModify
+	return &c
+}
@@ -0,0 +1,70 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/pipeline"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestManifestPlan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+users:
+  - username: testuser1
+    email: testuser1@example.com
+    name: Test User 1
+    admin: true
+  - username: testuser2
+    email: testuser2@example.com
+    name: Test User 2
+    hosts: "^alfa\\."
+  - username: newuser
+    email: newuser@example.com
+    name: New User
+    hosts: "^beta\\."
+  - username: leaver
+    state: blocked
+`), 0o600))
+
+	manifest, err := LoadManifest(path)
+	assert.NoError(t, err)
+
+	alfa := &client.Host{Team: "alfa", Project: "test", Name: "local"}
+	beta := &client.Host{Team: "beta", Project: "test", Name: "local"}
+
+	users := []pipeline.Element[*gitlab.User]{
+		{Host: alfa, Value: &gitlab.User{ID: 1, Username: "testuser1", Email: "testuser1@example.com", Name: "Test User 1", State: "active"}},
+		{Host: alfa, Value: &gitlab.User{ID: 2, Username: "testuser2", Email: "testuser2@example.com", Name: "Test User 2", State: "blocked"}},
+		{Host: alfa, Value: &gitlab.User{ID: 3, Username: "leaver", Name: "Leaver", State: "active"}},
+		{Host: beta, Value: &gitlab.User{ID: 1, Username: "testuser1", Name: "Test User 1", State: "deactivated", IsAdmin: true}},
+		{Host: beta, Value: &gitlab.User{ID: 2, Username: "testuser2", Name: "Test User 2", State: "active"}},
+	}
+
+	type change struct {
+		host, action, username string
+	}
+
+	got := make([]change, 0)
+	for _, c := range manifest.Plan(client.Hosts{alfa, beta}, users) {
+		got = append(got, change{c.Host.Team, c.Action, c.Username})
+	}
+
+	assert.Equal(t, []change{
+		{"alfa", actionBlock, "leaver"},
+		{"alfa", actionModify, "testuser1"},
+		{"alfa", actionUnblock, "testuser2"},
+		{"beta", actionCreate, "newuser"},
+		{"beta", actionActivate, "testuser1"},
+		{"beta", actionBlock, "testuser2"},
+	}, got)
+
+	assert.NoError(t, os.WriteFile(path, []byte("users:\n  - username: invalid\n    state: deleted\n"), 0o600))
+	_, err = LoadManifest(path)
+	assert.Error(t, err)
+}
@@ -1,13 +1,13 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"text/tabwriter"
 
-	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 	"github.com/flant/glaball/pkg/util"
 
@@ -28,10 +28,11 @@ var (
 
 func NewModifyCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "modify --by=[email|username|name] [regexp]",
-		Short: "Modifies an existing user",
-		Long:  "Modifies an existing user. Only administrators can change attributes of a user.",
-		Args:  cobra.ExactArgs(1),
+		Use:               "modify --by=[email|username|name] [regexp]",
+		Short:             "Modifies an existing user",
+		Long:              "Modifies an existing user. Only administrators can change attributes of a user.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeBy(&modifyBy),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			re, err := regexp.Compile(args[0])
 			if err != nil {
@@ -72,55 +73,34 @@ func NewModifyCmd() *cobra.Command {
 }
 
 func Modify() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	fmt.Printf("Searching for user %q...\n", modifyFieldRegexp)
-	for _, h := range common.Client.Hosts {
-		wg.Add(1)
-		go listUsersSearch(h, modifyBy, modifyFieldRegexp, gitlab.ListUsersOptions{
-			ListOptions: gitlab.ListOptions{
-				PerPage: 100,
-			},
-		}, wg, data, common.Client.WithNoCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	toModify := make(sort.Elements, 0)
-	for e := range data {
-		toModify = append(toModify, e)
-	}
+	hclog.L().Debug("Searching for user", modifyBy, modifyFieldRegexp)
+	toModify := pipeline.Collect(pipeline.FromHosts(pipe, common.Client.Hosts, listUsersSearch(pipe.Slot(), modifyBy, modifyFieldRegexp, gitlab.ListUsersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	}, common.Client.WithNoCache())))
 
 	if len(toModify) == 0 {
 		return fmt.Errorf("user not found: %s", modifyFieldRegexp)
 	}
 
+	hosts := pipeline.Hosts(toModify)
+
 	if listHosts {
-		for _, h := range toModify.Hosts() {
+		for _, h := range hosts {
 			fmt.Println(h.Project)
 		}
 		return nil
 	}
 
 	util.AskUser(fmt.Sprintf("Do you really want to modify %d users %q in %d gitlab(s) %v ?",
-		len(toModify), modifyFieldRegexp, len(toModify.Hosts()), toModify.Hosts().Projects(common.Config.ShowAll)))
-
-	modified := make(chan interface{})
-	for _, v := range toModify.Typed() {
-		wg.Add(1)
-		go modifyUser(v.Host, v.Struct.(*gitlab.User).ID, modifyOpt, wg, modified)
-	}
+		len(toModify), modifyFieldRegexp, len(hosts), hosts.Projects(common.Config.ShowAll)))
 
-	go func() {
-		wg.Wait()
-		close(modified)
-	}()
+	modified := pipeline.Then(pipe, pipeline.From(toModify), modifyUser(modifyOpt))
 
-	results, err := sort.FromChannel(modified, &sort.Options{
+	results, err := sort.FromChannel(pipeline.Sort(modified), &sort.Options{
 		OrderBy:    []string{modifyBy},
 		StructType: gitlab.User{},
 	})
@@ -137,11 +117,11 @@ func Modify() error {
 		total++
 	}
 
-	fmt.Fprintf(w, "Modified: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Modified: %d\nErrors: %d\n", total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
@@ -149,19 +129,20 @@ func Modify() error {
 
 }
 
-func modifyUser(h *client.Host, id int, opt gitlab.ModifyUserOptions, wg *limiter.Limiter, data chan<- interface{},
-	options ...gitlab.RequestOptionFunc) {
+// Returns the stage modifying the user
+func modifyUser(opt gitlab.ModifyUserOptions, options ...gitlab.RequestOptionFunc) func(
+	ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
 
-	defer wg.Done()
+	return func(ctx context.Context, e pipeline.Element[*gitlab.User], emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
 
-	wg.Lock()
-	user, resp, err := h.Client.Users.ModifyUser(id, &opt, options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+		user, resp, err := e.Host.Client.Users.ModifyUser(e.Value.ID, &opt, options...)
+		if err != nil {
+			return err
+		}
 
-	data <- sort.Element{Host: h, Struct: user, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		emit(user, resp.Header.Get("X-From-Cache") == "1")
+
+		return nil
+	}
 }
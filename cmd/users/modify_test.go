@@ -0,0 +1,35 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestModify(t *testing.T) {
+	fake := common.SetupFake(t, "main.alfa.local", "main.bravo.local")
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	alfa.AddUser(gitlab.User{Username: "bob", Name: "Bob"})
+	alfa.AddUser(gitlab.User{Username: "alice", Name: "Alice"})
+	bravo.AddUser(gitlab.User{Username: "bob", Name: "Bobby"})
+
+	modifyBy, modifyFieldRegexp = "username", regexp.MustCompile("^bob$")
+	modifyOpt = gitlab.ModifyUserOptions{Name: gitlab.String("Robert"), Admin: gitlab.Bool(true)}
+	defer func() { modifyOpt = gitlab.ModifyUserOptions{} }()
+
+	out := common.RunWithInput(t, "y\n", Modify)
+	assert.Contains(t, out, `Do you really want to modify 2 users "^bob$" in 2 gitlab(s) [alfa.local bravo.local] ?`)
+	assert.Contains(t, out, "Modified: 2\nErrors: 0\n")
+
+	for _, bob := range []*gitlab.User{alfa.User("bob"), bravo.User("bob")} {
+		assert.Equal(t, "Robert", bob.Name)
+		assert.True(t, bob.IsAdmin)
+	}
+	assert.Equal(t, "Alice", alfa.User("alice").Name)
+	assert.False(t, alfa.User("alice").IsAdmin)
+}
@@ -0,0 +1,123 @@
+package users
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flant/glaball/pkg/pipeline"
+	"github.com/flant/glaball/pkg/util"
+
+	"github.com/flant/glaball/cmd/common"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/xanzy/go-gitlab"
+)
+
+const manifestExample = `Example manifest:
+
+users:
+  - username: jdoe
+    email: jdoe@example.com
+    name: John Doe
+    admin: false
+    external: false
+    # regexp of the host full name "<team>.<project>.<name>", all hosts by default
+    hosts: "^main\\."
+  - username: leaver
+    # active (default) or blocked
+    state: blocked`
+
+var (
+	manifestFile string
+	planFormat   = util.Dict{
+		{
+			Key:   "ACTION",
+			Value: "%s",
+		},
+		{
+			Key:   "USER",
+			Value: "%s",
+		},
+		{
+			Key:   "HOST",
+			Value: "[%s]",
+		},
+		{
+			Key:   "CHANGES",
+			Value: "%s",
+		},
+	}
+)
+
+func NewPlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show changes required by the users manifest",
+		Long: `Compares the users manifest with the users of every host and shows which users will be created, modified, blocked or unblocked.
+Users existing on the hosts they are not selected on are blocked. Users missing from the manifest are left untouched.
+
+` + manifestExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Plan()
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "file", "", "Path to the users manifest")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func Plan() error {
+	changes, err := planUsers(manifestFile)
+	if err != nil {
+		return err
+	}
+
+	return printPlan(changes)
+}
+
+// planUsers computes the manifest diff against the users of every host
+func planUsers(path string) ([]*Change, error) {
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := pipeline.New(common.Limiter)
+	users := pipeline.Collect(pipeline.FromHosts(pipe, common.Client.Hosts, listUsers(pipe.Slot(), gitlab.ListUsersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	}, common.Client.WithNoCache())))
+
+	// Planning against partial data may block or create users by mistake
+	if errs := pipe.Errors(); len(errs) > 0 {
+		for _, err := range errs {
+			hclog.L().Error(err.Err.Error())
+		}
+		return nil, fmt.Errorf("failed to get users from %d host(s)", len(errs))
+	}
+
+	return manifest.Plan(common.Client.Hosts, users), nil
+}
+
+func printPlan(changes []*Change) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	if _, err := fmt.Fprintln(w, strings.Join(planFormat.Keys(), "\t")); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		if err := planFormat.Print(w, "\t", c.Action, c.Username, c.Host.ProjectName(), strings.Join(c.Changes, ", ")); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Changes: %d\n", len(changes))
+
+	return w.Flush()
+}
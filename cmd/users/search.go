@@ -6,7 +6,10 @@ import (
 	"regexp"
 	"text/tabwriter"
 
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
+	"github.com/flant/glaball/pkg/tui"
 	"github.com/flant/glaball/pkg/util"
 
 	"github.com/flant/glaball/cmd/common"
@@ -23,15 +26,17 @@ var (
 
 func NewSearchCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "search --by=[email|username|name] [regexp]",
-		Short: "Search for user",
-		Args:  cobra.ExactArgs(1),
+		Use:               "search --by=[email|username|name] [regexp]",
+		Short:             "Search for user",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeBy(&searchBy),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			re, err := regexp.Compile(args[0])
 			if err != nil {
 				return err
 			}
 			searchFieldRegexp = re
+			resultOptions.Actions = []tui.Action{blockAction()}
 			return Search()
 		},
 	}
@@ -40,33 +45,40 @@ func NewSearchCmd() *cobra.Command {
 	cmd.MarkFlagRequired("by")
 
 	listUsersOptionsFlags(cmd, &listUsersOptions)
+	common.ResultOptionsFlags(cmd, &resultOptions)
+
+	cmd.Flags().StringSliceVar(&outputFormat, "output", []string{output.FormatTable},
+		"Output format of --columns, --aggregate and --matrix: [table csv json]. Default: table.")
+
+	common.RegisterFieldCompletions(cmd, gitlab.User{})
 
 	return cmd
 }
 
 func Search() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-
-	fmt.Printf("Searching for user %s %q...\n", searchBy, searchFieldRegexp)
-	for _, h := range common.Client.Hosts {
-		wg.Add(1)
-		go listUsersSearch(h, searchBy, searchFieldRegexp, listUsersOptions, wg, data, common.Client.WithCache())
+	if err := resultOptions.Validate(gitlab.User{}); err != nil {
+		return err
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
+	pipe := pipeline.New(common.Limiter)
 
-	results, err := sort.FromChannel(data, &sort.Options{
+	hclog.L().Debug("Searching for user", searchBy, searchFieldRegexp)
+	found := pipeline.FromHosts(pipe, common.Client.Hosts, listUsersSearch(pipe.Slot(), searchBy, searchFieldRegexp, listUsersOptions, common.Client.WithCache()))
+
+	results, err := sort.FromChannel(pipeline.Sort(found), &sort.Options{
 		OrderBy:    []string{searchBy},
+		Where:      resultOptions.Where,
+		Aggregate:  resultOptions.Aggregate,
 		StructType: gitlab.User{},
 	})
 	if err != nil {
 		return err
 	}
 
+	if resultOptions.Custom() {
+		return resultOptions.Print(outputFormat, gitlab.User{}, results, pipe.Errors())
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "COUNT\tUSER\tHOSTS\tCACHED\n")
 
@@ -76,11 +88,11 @@ func Search() error {
 		total++
 	}
 
-	fmt.Fprintf(w, "Found: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Found: %d\nErrors: %d\n", total, len(pipe.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range pipe.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
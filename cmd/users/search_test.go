@@ -7,7 +7,7 @@ import (
 	"regexp"
 	"testing"
 
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 
 	"github.com/flant/glaball/cmd/common"
@@ -27,28 +27,19 @@ func TestSearch(t *testing.T) {
 		fmt.Fprint(w, TestData)
 	})
 
-	wg := limiter.NewLimiter(limiter.DefaultLimit)
-	data := make(chan interface{})
+	pipe := pipeline.New(common.Limiter)
 
 	searchBy := "username"
 	searchFieldValue := regexp.MustCompile("testuser2")
 
 	fmt.Printf("Searching for user %q...\n", searchFieldValue)
-	for _, h := range cli.Hosts {
-		wg.Add(1)
-		go listUsersSearch(h, searchBy, searchFieldValue, gitlab.ListUsersOptions{
-			ListOptions: gitlab.ListOptions{
-				PerPage: 100,
-			},
-		}, wg, data, cli.WithCache())
-	}
-
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
-	results, err := sort.FromChannel(data, &sort.Options{
+	found := pipeline.FromHosts(pipe, cli.Hosts, listUsersSearch(pipe.Slot(), searchBy, searchFieldValue, gitlab.ListUsersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	}, cli.WithCache()))
+
+	results, err := sort.FromChannel(pipeline.Sort(found), &sort.Options{
 		OrderBy:    []string{searchBy},
 		StructType: gitlab.User{},
 	})
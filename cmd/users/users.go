@@ -1,6 +1,8 @@
 package users
 
 import (
+	"github.com/flant/glaball/cmd/common"
+
 	"github.com/spf13/cobra"
 )
 
@@ -15,14 +17,26 @@ func NewCmd() *cobra.Command {
 	}
 
 	cmd.AddCommand(
+		NewApplyCmd(),
 		NewBlockCmd(),
 		NewCreateCmd(),
 		NewDeleteCmd(),
 		NewListCmd(),
 		NewModifyCmd(),
+		NewPlanCmd(),
 		NewSearchCmd(),
 		NewWhoamiCmd(),
 	)
 
 	return cmd
 }
+
+// completeBy completes the regexp argument with the cached usernames when the users are searched by username
+func completeBy(by *string) common.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 || *by != userDefaultField {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return common.CompleteUsernames(cmd, args, toComplete)
+	}
+}
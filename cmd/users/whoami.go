@@ -1,12 +1,14 @@
 package users
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"text/tabwriter"
+	"time"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 
 	"github.com/flant/glaball/cmd/common"
@@ -16,75 +18,81 @@ import (
 	"github.com/xanzy/go-gitlab"
 )
 
+var whoamiWatchInterval time.Duration
+
 func NewWhoamiCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "whoami",
 		Short: "Current API user",
 		Long:  "Get info about the user whose token is used for API calls.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if whoamiWatchInterval > 0 {
+				return common.Watch(whoamiWatchInterval, gitlab.User{}, "id", collectCurrentUsers)
+			}
 			return Whoami()
 		},
 	}
 
+	common.WatchFlag(cmd, &whoamiWatchInterval)
+
 	return cmd
 }
 
 func Whoami() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting current user info from %s ...\n", h.URL)
-		wg.Add(1)
-		go currentUser(h, wg, data, common.Client.WithNoCache())
+	results, err := collectCurrentUsers()
+	if err != nil {
+		return err
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "COUNT\tUSER\tHOSTS\tCACHED\n")
 	total := 0
 
-	results, err := sort.FromChannel(data, &sort.Options{
-		OrderBy:    []string{"username"},
-		SortBy:     "desc",
-		GroupBy:    "",
-		StructType: gitlab.User{},
-	})
-	if err != nil {
-		return err
-	}
-
 	for _, v := range results {
 		total += v.Count //todo
 
 		fmt.Fprintf(w, "[%d]\t%s\t%s\t[%s]\n", v.Count, v.Key, v.Elements.Hosts().Projects(common.Config.ShowAll), v.Cached)
 	}
 
-	fmt.Fprintf(w, "Total: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Total: %d\nErrors: %d\n", total, len(common.Limiter.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range common.Limiter.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func currentUser(h *client.Host, wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	defer wg.Done()
+// collectCurrentUsers returns the users of the tokens of all hosts grouped by the username
+func collectCurrentUsers(options ...gitlab.RequestOptionFunc) ([]sort.Result, error) {
+	pipe := pipeline.New(common.Limiter)
+	options = append([]gitlab.RequestOptionFunc{common.Client.WithNoCache()}, options...)
+	users := pipeline.FromHosts(pipe, common.Client.Hosts, currentUser(options...))
 
-	wg.Lock()
-	user, resp, err := h.Client.Users.CurrentUser(options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+	return sort.FromChannel(pipeline.Sort(users), &sort.Options{
+		OrderBy:    []string{"username"},
+		SortBy:     "desc",
+		GroupBy:    "",
+		StructType: gitlab.User{},
+	})
+}
+
+// Returns the first stage of the pipelines over the users of the tokens of every host
+func currentUser(options ...gitlab.RequestOptionFunc) func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		hclog.L().Debug("Getting current user info", "host", h.URL)
 
-	data <- sort.Element{Host: h, Struct: user, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		user, resp, err := h.Client.Users.CurrentUser(options...)
+		if err != nil {
+			return err
+		}
+
+		emit(user, resp.Header.Get("X-From-Cache") == "1")
+
+		return nil
+	}
 }
@@ -1,6 +1,7 @@
 package versions
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
@@ -8,9 +9,10 @@ import (
 	"os"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/flant/glaball/pkg/client"
-	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
 	"github.com/flant/glaball/pkg/sort/v2"
 
 	"github.com/flant/glaball/cmd/common"
@@ -23,6 +25,8 @@ import (
 
 var (
 	httpClient = cleanhttp.DefaultPooledClient()
+
+	watchInterval time.Duration
 )
 
 type VersionCheck struct {
@@ -41,77 +45,79 @@ func NewCmd() *cobra.Command {
 		Short: "Retrieve version information for GitLab instances",
 		Long:  "",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if watchInterval > 0 {
+				return common.Watch(watchInterval, VersionCheck{}, "", collectVersions)
+			}
 			return Versions()
 		},
 	}
 
+	common.WatchFlag(cmd, &watchInterval)
+
 	return cmd
 }
 
 func Versions() error {
-	wg := common.Limiter
-	data := make(chan interface{})
-	for _, h := range common.Client.Hosts {
-		fmt.Printf("Getting current version info from %s ...\n", h.URL)
-		wg.Add(1)
-		go currentVersion(h, wg, data, common.Client.WithNoCache())
+	results, err := collectVersions()
+	if err != nil {
+		return err
 	}
 
-	go func() {
-		wg.Wait()
-		close(data)
-	}()
-
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
 	fmt.Fprintf(w, "HOST\tURL\tVERSION\tSTATUS\n")
 	total := 0
 
-	results, err := sort.FromChannel(data, &sort.Options{
-		OrderBy:    []string{"host", "version"},
-		SortBy:     "asc",
-		GroupBy:    "",
-		StructType: VersionCheck{},
-	})
-	if err != nil {
-		return err
-	}
-
 	for _, v := range results {
 		total++
 		elem := v.Elements.Typed()[0]
 		fmt.Fprintf(w, "[%s]\t%s\t%s\t[%s]\n", elem.Host.Project, elem.Host.URL, elem.Struct.(VersionCheck).Version, elem.Struct.(VersionCheck).CheckResult)
 	}
 
-	fmt.Fprintf(w, "Total: %d\nErrors: %d\n", total, len(wg.Errors()))
+	fmt.Fprintf(w, "Total: %d\nErrors: %d\n", total, len(common.Limiter.Errors()))
 
 	w.Flush()
 
-	for _, err := range wg.Errors() {
+	for _, err := range common.Limiter.Errors() {
 		hclog.L().Error(err.Err.Error())
 	}
 
 	return nil
 }
 
-func currentVersion(h *client.Host, wg *limiter.Limiter, data chan<- interface{}, options ...gitlab.RequestOptionFunc) {
-	defer wg.Done()
+// collectVersions returns the versions of all hosts ordered by the host
+func collectVersions(options ...gitlab.RequestOptionFunc) ([]sort.Result, error) {
+	pipe := pipeline.New(common.Limiter)
+	options = append([]gitlab.RequestOptionFunc{common.Client.WithNoCache()}, options...)
+	versions := pipeline.FromHosts(pipe, common.Client.Hosts, currentVersion(options...))
 
-	wg.Lock()
-	version, resp, err := h.Client.Version.GetVersion(options...)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	check, err := checkVersion(h, version)
-	if err != nil {
-		wg.Error(h, err)
-		wg.Unlock()
-		return
-	}
-	wg.Unlock()
+	return sort.FromChannel(pipeline.Sort(versions), &sort.Options{
+		OrderBy:    []string{"host", "version"},
+		SortBy:     "asc",
+		GroupBy:    "",
+		StructType: VersionCheck{},
+	})
+}
+
+// Returns the first stage of the pipelines over the versions of every host
+func currentVersion(options ...gitlab.RequestOptionFunc) func(ctx context.Context, h *client.Host, emit pipeline.Emit[VersionCheck]) error {
+	return func(ctx context.Context, h *client.Host, emit pipeline.Emit[VersionCheck]) error {
+		options := pipeline.WithContext(ctx, options)
+
+		hclog.L().Debug("Getting current version info", "host", h.URL)
 
-	data <- sort.Element{Host: h, Struct: VersionCheck{version.Version, check}, Cached: resp.Header.Get("X-From-Cache") == "1"}
+		version, resp, err := h.Client.Version.GetVersion(options...)
+		if err != nil {
+			return err
+		}
+		check, err := checkVersion(h, version)
+		if err != nil {
+			return err
+		}
+
+		emit(VersionCheck{version.Version, check}, resp.Header.Get("X-From-Cache") == "1")
+
+		return nil
+	}
 }
 
 func checkVersion(h *client.Host, version *gitlab.Version) (string, error) {
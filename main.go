@@ -1,16 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"time"
 
 	gconfig "github.com/flant/glaball/pkg/config"
 	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/progress"
 
+	"github.com/flant/glaball/cmd/api"
 	"github.com/flant/glaball/cmd/cache"
 	"github.com/flant/glaball/cmd/common"
 	"github.com/flant/glaball/cmd/config"
 	"github.com/flant/glaball/cmd/info"
+	"github.com/flant/glaball/cmd/plugins"
+	"github.com/flant/glaball/cmd/policy"
 	"github.com/flant/glaball/cmd/projects"
 	"github.com/flant/glaball/cmd/users"
 	"github.com/flant/glaball/cmd/versions"
@@ -27,6 +32,12 @@ var (
 	update   bool
 	verbose  bool
 
+	progressMode string // "auto", "bar", "log", "off"
+	printStats   bool
+	traceTo      string // "otlp" or a file path
+	recordTo     string
+	replayFrom   string
+
 	rootCmd = &cobra.Command{
 		Use:           gconfig.ApplicationName,
 		Short:         "Gitlab bulk administration tool",
@@ -34,6 +45,11 @@ var (
 		SilenceErrors: false,
 		SilenceUsage:  true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The completion reads the config itself and must not print anything but the values
+			if common.IsCompletion(cmd) {
+				return nil
+			}
+
 			if verbose {
 				logLevel = "debug"
 			}
@@ -46,10 +62,40 @@ var (
 				viper.Set("cache.ttl", time.Duration(0))
 			}
 
+			if recordTo != "" && replayFrom != "" {
+				return fmt.Errorf("--record and --replay can't be used together")
+			}
+
 			if err := common.Init(); err != nil {
 				return err
 			}
 
+			if recordTo != "" {
+				if err := common.StartRecording(recordTo); err != nil {
+					return err
+				}
+			}
+
+			if replayFrom != "" {
+				if err := common.StartReplay(replayFrom); err != nil {
+					return err
+				}
+			}
+
+			if err := common.StartProgress(progressMode); err != nil {
+				return err
+			}
+
+			if printStats {
+				common.StartStats()
+			}
+
+			if traceTo != "" {
+				if err := common.StartTracing(cmd.CommandPath(), traceTo); err != nil {
+					return err
+				}
+			}
+
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -67,6 +113,13 @@ func Execute() {
 
 func main() {
 	rootCmd.Execute()
+	common.StopProgress()
+	if err := common.PrintStats(); err != nil {
+		hclog.L().Error(err.Error())
+	}
+	if err := common.StopTracing(); err != nil {
+		hclog.L().Error(err.Error())
+	}
 }
 
 func init() {
@@ -92,15 +145,41 @@ func init() {
 
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
 
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", progress.ModeAuto,
+		"Progress of the requests on stderr: [auto bar log off]. auto shows a live bar on a terminal and periodic log lines otherwise.")
+
+	rootCmd.PersistentFlags().BoolVar(&printStats, "stats", false,
+		"Print the request count, bytes, p50/p95 latency, retries, 429 responses and cache hits per host and per endpoint after the results. Included in json and ndjson output.")
+
+	rootCmd.PersistentFlags().StringVar(&traceTo, "trace", "",
+		`Trace the run with a span per command, task of a host and api request: "otlp" exports them with OTLP over http configured with OTEL_EXPORTER_OTLP_* environment variables, any other value is a path to a json file.`)
+
+	rootCmd.PersistentFlags().StringVar(&recordTo, "record", "",
+		"Save every api request and response to the fixtures in the directory with the tokens redacted.")
+
+	rootCmd.PersistentFlags().StringVar(&replayFrom, "replay", "",
+		"Serve the api requests from the fixtures in the directory saved with --record instead of sending them to the hosts.")
+
 	rootCmd.AddCommand(
+		api.NewCmd(),
 		cache.NewCmd(),
 		config.NewCmd(),
 		info.NewCmd(),
+		policy.NewCmd(),
 		projects.NewCmd(),
 		users.NewCmd(),
 		users.NewWhoamiCmd(),
 		versions.NewCmd(),
 	)
+
+	rootCmd.AddCommand(plugins.NewCmds(rootCmd, initConfig)...)
+
+	rootCmd.RegisterFlagCompletionFunc("filter", common.CompleteHosts)
+	rootCmd.RegisterFlagCompletionFunc("log_level", cobra.FixedCompletions(
+		[]string{"debug", "info", "warn", "error", "off"}, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.RegisterFlagCompletionFunc("progress", cobra.FixedCompletions(
+		[]string{progress.ModeAuto, progress.ModeBar, progress.ModeLog, progress.ModeOff}, cobra.ShellCompDirectiveNoFileComp))
+	common.RegisterEnumCompletions(rootCmd)
 }
 
 func initConfig() {
@@ -6,6 +6,8 @@ import (
 	"net"
 	"net/http"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,10 +29,22 @@ const (
 	Github string = "github"
 )
 
+// Pagination of the list requests to the host
+const (
+	// One offset page after another
+	PaginationOffset string = "offset"
+	// One page after another by the link to the next one, not limited by the offset of the page
+	PaginationKeyset string = "keyset"
+	// The first offset page, then all other pages in parallel using the total number of pages
+	PaginationParallel string = "parallel"
+)
+
 type Client struct {
-	Hosts Hosts
+	Hosts   Hosts
+	GraphQL *GraphQLCollector
 
-	config *config.Config
+	config    *config.Config
+	transport *observedTransport
 }
 
 type Hosts []*Host
@@ -52,6 +66,19 @@ func (a Hosts) Projects(all bool) []string {
 	return s
 }
 
+// Unique returns the hosts without duplicates in the original order
+func (a Hosts) Unique() Hosts {
+	seen := make(map[*Host]bool, len(a))
+	s := make(Hosts, 0, len(a))
+	for _, h := range a {
+		if !seen[h] {
+			seen[h] = true
+			s = append(s, h)
+		}
+	}
+	return s
+}
+
 func (h Hosts) Len() int      { return len(h) }
 func (h Hosts) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
 func (h Hosts) Less(i, j int) bool {
@@ -61,7 +88,13 @@ func (h Hosts) Less(i, j int) bool {
 	case h[i].Team > h[j].Team:
 		return false
 	}
-	return h[i].Project < h[j].Project
+	switch {
+	case h[i].Project < h[j].Project:
+		return true
+	case h[i].Project > h[j].Project:
+		return false
+	}
+	return h[i].Name < h[j].Name
 }
 
 type Host struct {
@@ -69,12 +102,24 @@ type Host struct {
 	Client                   *gitlab.Client
 	GithubClient             *github.Client
 	Org                      string // TODO:
+	Pagination               string
+	Tags                     []string
 }
 
 func (h Host) FullName() string {
 	return fmt.Sprintf("%s.%s.%s", h.Team, h.Project, h.Name)
 }
 
+// HasTags checks if the host has all of the tags from the config
+func (h Host) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(h.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
 func (h Host) ProjectName() string {
 	return fmt.Sprintf("%s.%s", h.Project, h.Name)
 }
@@ -146,6 +191,9 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		return nil, err
 	}
 
+	transport := &observedTransport{next: httpClient.Transport, hosts: make(map[string]*Host)}
+	httpClient.Transport = transport
+
 	// TODO:
 	ghttpClient, err := github_ratelimit.NewRateLimitWaiterClient(httpClient.Transport)
 	if err != nil {
@@ -154,13 +202,14 @@ func NewClient(cfg *config.Config) (*Client, error) {
 
 	options := []gitlab.ClientOptionFunc{
 		gitlab.WithHTTPClient(httpClient),
+		gitlab.WithRequestLogHook(transport.requestLogHook),
 	}
 
 	if hclog.L().IsDebug() {
 		options = append(options, gitlab.WithCustomLeveledLogger(hclog.Default().Named("go-gitlab")))
 	}
 
-	client := Client{config: cfg}
+	client := Client{config: cfg, transport: transport, GraphQL: NewGraphQLCollector()}
 	for team, projects := range cfg.Hosts {
 		for project, hosts := range projects {
 			for name, host := range hosts {
@@ -185,6 +234,7 @@ func NewClient(cfg *config.Config) (*Client, error) {
 						URL:          fmt.Sprintf("https://github.com/%s", host.Org), // TODO:
 						Org:          host.Org,
 						GithubClient: github.NewClient(ghttpClient).WithAuthToken(host.Token),
+						Tags:         host.Tags,
 					})
 				default:
 					if host.URL == "" {
@@ -201,18 +251,41 @@ func NewClient(cfg *config.Config) (*Client, error) {
 					if host.IP != "" {
 						customAddresses[gl.BaseURL().Hostname()] = host.IP
 					}
+					switch host.Pagination {
+					case "":
+						host.Pagination = PaginationOffset
+					case PaginationOffset, PaginationKeyset, PaginationParallel:
+					default:
+						return nil, fmt.Errorf("invalid pagination %q for host %q, expected one of %s, %s, %s",
+							host.Pagination, fullName, PaginationOffset, PaginationKeyset, PaginationParallel)
+					}
 					client.Hosts = append(client.Hosts, &Host{
-						Team:    team,
-						Project: project,
-						Name:    name,
-						URL:     host.URL,
-						Client:  gl,
+						Team:       team,
+						Project:    project,
+						Name:       name,
+						URL:        host.URL,
+						Client:     gl,
+						Pagination: host.Pagination,
+						Tags:       host.Tags,
 					})
 				}
 			}
 		}
 	}
 
+	// Hosts are listed in the same order regardless of the config map iteration
+	sort.Sort(client.Hosts)
+
+	// Requests are matched to the first host with the same address
+	for _, h := range client.Hosts {
+		if h.Client == nil {
+			continue
+		}
+		if _, ok := transport.hosts[h.Client.BaseURL().Host]; !ok {
+			transport.hosts[h.Client.BaseURL().Host] = h
+		}
+	}
+
 	return &client, nil
 
 }
@@ -0,0 +1,311 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	// GraphQLBatchSize is the number of projects requested with a single graphql query
+	GraphQLBatchSize = 50
+	// GraphQLBranchesLimit is the number of branch names requested per project,
+	// the projects with more branches are not returned by the collector
+	GraphQLBranchesLimit = 1000
+)
+
+// GraphQLCollector fetches the nested fields of many projects of the host with a single graphql query
+// instead of a rest request per project and resource.
+type GraphQLCollector struct {
+	mu     sync.Mutex
+	probes map[*Host]*graphQLProbe
+}
+
+type graphQLProbe struct {
+	once      sync.Once
+	supported bool
+}
+
+func NewGraphQLCollector() *GraphQLCollector {
+	return &GraphQLCollector{probes: make(map[*Host]*graphQLProbe)}
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// GraphQL posts the query to the graphql api of the host and decodes the data of the response into v
+func (h *Host) GraphQL(query string, variables map[string]interface{}, v interface{},
+	options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+
+	if h.Client == nil {
+		return nil, fmt.Errorf("graphql is not supported by host %q", h.FullName())
+	}
+
+	// The graphql api is next to the rest api: /api/graphql
+	path := func(req *retryablehttp.Request) error {
+		req.URL.Path = strings.TrimSuffix(req.URL.Path, "v4/graphql") + "graphql"
+		req.URL.RawPath = ""
+		return nil
+	}
+
+	req, err := h.Client.NewRequest(http.MethodPost, "graphql", graphQLRequest{Query: query, Variables: variables},
+		append(options, path))
+	if err != nil {
+		return nil, err
+	}
+
+	var out graphQLResponse
+	resp, err := h.Client.Do(req, &out)
+	if err != nil {
+		return resp, err
+	}
+
+	if len(out.Errors) > 0 {
+		messages := make([]string, 0, len(out.Errors))
+		for _, e := range out.Errors {
+			messages = append(messages, e.Message)
+		}
+		return resp, fmt.Errorf("graphql query to %q failed: %s", h.FullName(), strings.Join(messages, "; "))
+	}
+
+	return resp, json.Unmarshal(out.Data, v)
+}
+
+// Supported checks once if the graphql api of the host is available
+func (c *GraphQLCollector) Supported(h *Host) bool {
+	c.mu.Lock()
+	p, ok := c.probes[h]
+	if !ok {
+		p = &graphQLProbe{}
+		c.probes[h] = p
+	}
+	c.mu.Unlock()
+
+	p.once.Do(func() {
+		if h.Client == nil {
+			return
+		}
+		var v struct {
+			Typename string `json:"__typename"`
+		}
+		_, err := h.GraphQL(`query { __typename }`, nil, &v)
+		p.supported = err == nil && v.Typename == "Query"
+	})
+
+	return p.supported
+}
+
+func projectGID(id int) string {
+	return fmt.Sprintf("gid://gitlab/Project/%d", id)
+}
+
+func projectID(gid string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(gid, "gid://gitlab/Project/"))
+}
+
+// Requests the fields of the projects and passes every returned project node to fn
+func (c *GraphQLCollector) projects(h *Host, projects []*gitlab.Project, fields string, variables map[string]interface{},
+	fn func(id int, node json.RawMessage) error, options ...gitlab.RequestOptionFunc) error {
+
+	ids := make([]string, 0, len(projects))
+	for _, p := range projects {
+		ids = append(ids, projectGID(p.ID))
+	}
+
+	vars := map[string]interface{}{"ids": ids, "first": len(ids)}
+	params := []string{"$ids: [ID!]", "$first: Int"}
+	for k, v := range variables {
+		vars[k] = v
+		params = append(params, fmt.Sprintf("$%s: Int", k))
+	}
+
+	query := fmt.Sprintf(`query(%s) { projects(ids: $ids, first: $first) { nodes { id %s } } }`,
+		strings.Join(params, ", "), fields)
+
+	var data struct {
+		Projects struct {
+			Nodes []json.RawMessage `json:"nodes"`
+		} `json:"projects"`
+	}
+	if _, err := h.GraphQL(query, vars, &data, options...); err != nil {
+		return err
+	}
+
+	for _, node := range data.Projects.Nodes {
+		var v struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(node, &v); err != nil {
+			return err
+		}
+		id, err := projectID(v.ID)
+		if err != nil {
+			return err
+		}
+		if err := fn(id, node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Languages returns the languages of the projects by project id
+func (c *GraphQLCollector) Languages(h *Host, projects []*gitlab.Project,
+	options ...gitlab.RequestOptionFunc) (map[int]*gitlab.ProjectLanguages, error) {
+
+	languages := make(map[int]*gitlab.ProjectLanguages, len(projects))
+	err := c.projects(h, projects, `languages { name share }`, nil, func(id int, node json.RawMessage) error {
+		var v struct {
+			Languages []struct {
+				Name  string  `json:"name"`
+				Share float32 `json:"share"`
+			} `json:"languages"`
+		}
+		if err := json.Unmarshal(node, &v); err != nil {
+			return err
+		}
+		l := make(gitlab.ProjectLanguages, len(v.Languages))
+		for _, lang := range v.Languages {
+			l[lang.Name] = lang.Share
+		}
+		languages[id] = &l
+		return nil
+	}, options...)
+
+	return languages, err
+}
+
+// Branches returns the branches of the projects by project id.
+// Only the names are available, the projects with more than GraphQLBranchesLimit branches are omitted.
+func (c *GraphQLCollector) Branches(h *Host, projects []*gitlab.Project,
+	options ...gitlab.RequestOptionFunc) (map[int][]*gitlab.Branch, error) {
+
+	byID := make(map[int]*gitlab.Project, len(projects))
+	for _, p := range projects {
+		byID[p.ID] = p
+	}
+
+	branches := make(map[int][]*gitlab.Branch, len(projects))
+	err := c.projects(h, projects,
+		`repository { rootRef branchNames(searchPattern: "*", offset: 0, limit: $limit) }`,
+		map[string]interface{}{"limit": GraphQLBranchesLimit},
+		func(id int, node json.RawMessage) error {
+			var v struct {
+				Repository *struct {
+					RootRef     string   `json:"rootRef"`
+					BranchNames []string `json:"branchNames"`
+				} `json:"repository"`
+			}
+			if err := json.Unmarshal(node, &v); err != nil {
+				return err
+			}
+			if v.Repository == nil {
+				branches[id] = []*gitlab.Branch{}
+				return nil
+			}
+			if len(v.Repository.BranchNames) >= GraphQLBranchesLimit {
+				return nil
+			}
+			list := make([]*gitlab.Branch, 0, len(v.Repository.BranchNames))
+			for _, name := range v.Repository.BranchNames {
+				b := gitlab.Branch{Name: name, Default: name == v.Repository.RootRef}
+				if p, ok := byID[id]; ok {
+					b.WebURL = p.WebURL + "/-/tree/" + name
+				}
+				list = append(list, &b)
+			}
+			branches[id] = list
+			return nil
+		}, options...)
+
+	return branches, err
+}
+
+// ProtectedBranches returns the protected branches of the projects by project id.
+// The branch rules are not paginated, the projects with more rules than a page of the server are omitted.
+func (c *GraphQLCollector) ProtectedBranches(h *Host, projects []*gitlab.Project,
+	options ...gitlab.RequestOptionFunc) (map[int][]*gitlab.ProtectedBranch, error) {
+
+	type accessLevels struct {
+		Nodes []struct {
+			AccessLevel            int    `json:"accessLevel"`
+			AccessLevelDescription string `json:"accessLevelDescription"`
+		} `json:"nodes"`
+	}
+
+	descriptions := func(levels accessLevels) []*gitlab.BranchAccessDescription {
+		list := make([]*gitlab.BranchAccessDescription, 0, len(levels.Nodes))
+		for _, l := range levels.Nodes {
+			list = append(list, &gitlab.BranchAccessDescription{
+				AccessLevel:            gitlab.AccessLevelValue(l.AccessLevel),
+				AccessLevelDescription: l.AccessLevelDescription,
+			})
+		}
+		return list
+	}
+
+	protected := make(map[int][]*gitlab.ProtectedBranch, len(projects))
+	err := c.projects(h, projects,
+		`branchRules { pageInfo { hasNextPage } nodes { name branchProtection { allowForcePush codeOwnerApprovalRequired
+			mergeAccessLevels { nodes { accessLevel accessLevelDescription } }
+			pushAccessLevels { nodes { accessLevel accessLevelDescription } } } } }`,
+		nil,
+		func(id int, node json.RawMessage) error {
+			var v struct {
+				BranchRules struct {
+					PageInfo struct {
+						HasNextPage bool `json:"hasNextPage"`
+					} `json:"pageInfo"`
+					Nodes []struct {
+						Name             string `json:"name"`
+						BranchProtection *struct {
+							AllowForcePush            bool         `json:"allowForcePush"`
+							CodeOwnerApprovalRequired bool         `json:"codeOwnerApprovalRequired"`
+							MergeAccessLevels         accessLevels `json:"mergeAccessLevels"`
+							PushAccessLevels          accessLevels `json:"pushAccessLevels"`
+						} `json:"branchProtection"`
+					} `json:"nodes"`
+				} `json:"branchRules"`
+			}
+			if err := json.Unmarshal(node, &v); err != nil {
+				return err
+			}
+			if v.BranchRules.PageInfo.HasNextPage {
+				return nil
+			}
+			list := make([]*gitlab.ProtectedBranch, 0, len(v.BranchRules.Nodes))
+			for _, rule := range v.BranchRules.Nodes {
+				// The rules of all branches are not protected branches
+				if rule.BranchProtection == nil {
+					continue
+				}
+				list = append(list, &gitlab.ProtectedBranch{
+					Name:                      rule.Name,
+					AllowForcePush:            rule.BranchProtection.AllowForcePush,
+					CodeOwnerApprovalRequired: rule.BranchProtection.CodeOwnerApprovalRequired,
+					MergeAccessLevels:         descriptions(rule.BranchProtection.MergeAccessLevels),
+					PushAccessLevels:          descriptions(rule.BranchProtection.PushAccessLevels),
+				})
+			}
+			protected[id] = list
+			return nil
+		}, options...)
+
+	return protected, err
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flant/glaball/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestGraphQLCollector(t *testing.T) {
+	defer func(limit int) { GraphQLBranchesLimit = limit }(GraphQLBranchesLimit)
+	GraphQLBranchesLimit = 3
+
+	queries := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.Contains(req.Query, "__typename") {
+			fmt.Fprint(w, `{"data": {"__typename": "Query"}}`)
+			return
+		}
+
+		queries++
+		nodes := make([]string, 0)
+		for _, id := range req.Variables["ids"].([]interface{}) {
+			switch {
+			case strings.Contains(req.Query, "languages"):
+				nodes = append(nodes, fmt.Sprintf(`{"id": %q, "languages": [{"name": "Go", "share": 90.5}, {"name": "Shell", "share": 9.5}]}`, id))
+			case strings.Contains(req.Query, "branchNames"):
+				names := `["main", "dev"]`
+				if id == "gid://gitlab/Project/2" {
+					names = `["main", "a", "b"]`
+				}
+				nodes = append(nodes, fmt.Sprintf(`{"id": %q, "repository": {"rootRef": "main", "branchNames": %s}}`, id, names))
+			case strings.Contains(req.Query, "branchRules"):
+				nodes = append(nodes, fmt.Sprintf(`{"id": %q, "branchRules": {"pageInfo": {"hasNextPage": %t}, "nodes": [
+					{"name": "All branches", "branchProtection": null},
+					{"name": "main", "branchProtection": {"allowForcePush": true, "codeOwnerApprovalRequired": false,
+						"mergeAccessLevels": {"nodes": [{"accessLevel": 40, "accessLevelDescription": "Maintainers"}]},
+						"pushAccessLevels": {"nodes": [{"accessLevel": 0, "accessLevelDescription": "No one"}]}}}
+				]}}`, id, id == "gid://gitlab/Project/1"))
+			}
+		}
+		fmt.Fprintf(w, `{"data": {"projects": {"nodes": [%s]}}}`, strings.Join(nodes, ","))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token"}}},
+		},
+	})
+	assert.NoError(t, err)
+
+	h := cli.Hosts[0]
+	assert.True(t, cli.GraphQL.Supported(h))
+
+	projects := []*gitlab.Project{{ID: 1, WebURL: "https://gitlab.example.com/a"}, {ID: 2, WebURL: "https://gitlab.example.com/b"}}
+
+	languages, err := cli.GraphQL.Languages(h, projects)
+	assert.NoError(t, err)
+	assert.Equal(t, &gitlab.ProjectLanguages{"Go": 90.5, "Shell": 9.5}, languages[1])
+	assert.Len(t, languages, 2)
+
+	// The project with too many branches is omitted
+	branches, err := cli.GraphQL.Branches(h, projects)
+	assert.NoError(t, err)
+	assert.Len(t, branches, 1)
+	assert.Equal(t, []*gitlab.Branch{
+		{Name: "main", Default: true, WebURL: "https://gitlab.example.com/a/-/tree/main"},
+		{Name: "dev", WebURL: "https://gitlab.example.com/a/-/tree/dev"},
+	}, branches[1])
+
+	// The project with more branch rules than a page is omitted
+	protected, err := cli.GraphQL.ProtectedBranches(h, projects)
+	assert.NoError(t, err)
+	assert.Len(t, protected, 1)
+	assert.Len(t, protected[2], 1)
+	assert.Equal(t, "main", protected[2][0].Name)
+	assert.True(t, protected[2][0].AllowForcePush)
+	assert.Equal(t, gitlab.MaintainerPermissions, protected[2][0].MergeAccessLevels[0].AccessLevel)
+	assert.Equal(t, gitlab.NoPermissions, protected[2][0].PushAccessLevels[0].AccessLevel)
+
+	// One query per batch of projects
+	assert.Equal(t, 3, queries)
+
+	// The instance without graphql
+	unsupported := httptest.NewServer(http.NotFoundHandler())
+	defer unsupported.Close()
+
+	cli, err = NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: unsupported.URL, Token: "token"}}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, cli.GraphQL.Supported(cli.Hosts[0]))
+}
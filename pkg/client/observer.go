@@ -0,0 +1,107 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// Observer is notified about every http request to the hosts, including retries of the same request.
+// The host is nil if the request can't be matched to any of the hosts.
+type Observer interface {
+	RequestStarted(h *Host, req *http.Request)
+	RequestFinished(h *Host, req *http.Request, resp *http.Response, err error, elapsed time.Duration)
+}
+
+// RetryObserver is an optional interface of the observer notified before the request is sent again
+// by the retrying client of the gitlab hosts, the attempt number of the first retry is 1.
+type RetryObserver interface {
+	RequestRetried(h *Host, req *http.Request, attempt int)
+}
+
+// Observe adds the observer of the requests to all hosts
+func (c *Client) Observe(o Observer) {
+	c.transport.mu.Lock()
+	c.transport.observers = append(c.transport.observers, o)
+	c.transport.mu.Unlock()
+}
+
+// Used to notify the observers, wraps the cache transport to see the cached responses too
+type observedTransport struct {
+	next  http.RoundTripper
+	hosts map[string]*Host
+
+	mu        sync.RWMutex
+	observers []Observer
+}
+
+func (t *observedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	observers := t.observers
+	t.mu.RUnlock()
+
+	if len(observers) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	h := t.hosts[req.URL.Host]
+	for _, o := range observers {
+		o.RequestStarted(h, req)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	for _, o := range observers {
+		o.RequestFinished(h, req, resp, err, elapsed)
+	}
+
+	return resp, err
+}
+
+// Used as the request log hook of the retrying client, it is called before every attempt of the request
+func (t *observedTransport) requestLogHook(_ retryablehttp.Logger, req *http.Request, attempt int) {
+	if attempt == 0 {
+		return
+	}
+
+	t.mu.RLock()
+	observers := t.observers
+	t.mu.RUnlock()
+
+	h := t.hosts[req.URL.Host]
+	for _, o := range observers {
+		if r, ok := o.(RetryObserver); ok {
+			r.RequestRetried(h, req, attempt)
+		}
+	}
+}
+
+// Endpoint returns the method and the path of the request without the api prefix,
+// numeric and url encoded path segments are replaced with ":id", e.g. "GET /projects/:id/merge_requests"
+func Endpoint(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	for _, prefix := range []string{"/api/v4/", "/api/v3/"} {
+		if i := strings.Index(path, prefix); i != -1 {
+			path = path[i+len(prefix)-1:]
+			break
+		}
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if s == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(s); err == nil || strings.Contains(s, "%") {
+			segments[i] = ":id"
+		}
+	}
+
+	return req.Method + " " + strings.Join(segments, "/")
+}
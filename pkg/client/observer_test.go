@@ -0,0 +1,20 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoint(t *testing.T) {
+	for url, expected := range map[string]string{
+		"https://gitlab.example.com/api/v4/projects/42/merge_requests?page=2": "GET /projects/:id/merge_requests",
+		"https://example.com/gitlab/api/v4/projects/group%2Fproject/members":  "GET /projects/:id/members",
+		"https://api.github.com/repos/flant/glaball/commits":                  "GET /repos/flant/glaball/commits",
+	} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, Endpoint(req))
+	}
+}
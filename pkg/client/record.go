@@ -0,0 +1,300 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Redacted replaces the tokens and the secrets in the recorded requests and responses
+const Redacted = "REDACTED"
+
+var (
+	// The headers with the credentials of the requests
+	redactedHeaders = []string{"Private-Token", "Authorization", "Job-Token", "Cookie", "Set-Cookie"}
+	// The parts of the query parameters and the json fields with the secrets
+	redactedFields = []string{"token", "password", "secret"}
+)
+
+// Record saves every request and response of the hosts as a fixture in the directory, the credentials are redacted.
+// Must be called before any requests.
+func (c *Client) Record(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	c.transport.next = &recordTransport{next: c.transport.next, dir: dir, seq: make(map[string]int)}
+	return nil
+}
+
+// Replay serves the requests of the hosts from the fixtures in the directory saved by Record
+// instead of sending them, the cache is not used. Must be called before any requests.
+func (c *Client) Replay(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("replay fixtures %q is not a directory", dir)
+	}
+	c.transport.next = &replayTransport{dir: dir, seq: make(map[string]int)}
+	return nil
+}
+
+// Fixture is the recorded request and response
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+type FixtureRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type FixtureResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	// BodyBase64 is set instead of Body for binary bodies
+	BodyBase64 string `json:"body_base64,omitempty"`
+}
+
+// Reads the body of the request and restores it
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func redactedField(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range redactedFields {
+		if strings.Contains(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range redactedHeaders {
+		if _, ok := h[k]; ok {
+			h.Set(k, Redacted)
+		}
+	}
+	return h
+}
+
+func redactURL(u *url.URL) string {
+	r := *u
+	r.User = nil
+	q := r.Query()
+	for k := range q {
+		if redactedField(k) {
+			q.Set(k, Redacted)
+		}
+	}
+	r.RawQuery = q.Encode()
+	return r.String()
+}
+
+// Redacts the secret fields of the json body, other bodies are returned as is
+func redactBody(body []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+
+	var redact func(v interface{}) interface{}
+	redact = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, f := range t {
+				if _, ok := f.(string); ok && redactedField(k) {
+					t[k] = Redacted
+				} else {
+					t[k] = redact(f)
+				}
+			}
+		case []interface{}:
+			for i := range t {
+				t[i] = redact(t[i])
+			}
+		}
+		return v
+	}
+
+	b, err := json.Marshal(redact(v))
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// Returns the redacted request of the fixture and the key of the request: the method, the url and the hash of the body
+func fixtureRequest(req *http.Request) (FixtureRequest, string, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return FixtureRequest{}, "", err
+	}
+
+	r := FixtureRequest{
+		Method: req.Method,
+		URL:    redactURL(req.URL),
+		Header: redactHeader(req.Header),
+		Body:   string(redactBody(body)),
+	}
+
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL + "\n" + r.Body))
+	return r, hex.EncodeToString(sum[:8]), nil
+}
+
+// The fixtures of the same request are numbered in the order of the requests
+func fixturePath(dir string, req *http.Request, key string, n int) string {
+	host := strings.ReplaceAll(req.URL.Host, ":", "_")
+	return filepath.Join(dir, host, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+type recordTransport struct {
+	next http.RoundTripper
+	dir  string
+
+	mu  sync.Mutex
+	seq map[string]int
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fr, key, err := fixtureRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	f := Fixture{
+		Request: fr,
+		Response: FixtureResponse{
+			StatusCode: resp.StatusCode,
+			Header:     redactHeader(resp.Header),
+		},
+	}
+	if body = redactBody(body); utf8.Valid(body) {
+		f.Response.Body = string(body)
+	} else {
+		f.Response.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	t.mu.Lock()
+	n := t.seq[key]
+	t.seq[key]++
+	t.mu.Unlock()
+
+	path := fixturePath(t.dir, req, key, n)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+type replayTransport struct {
+	dir string
+
+	mu  sync.Mutex
+	seq map[string]int
+}
+
+// The same requests are served in the recorded order, the last response is repeated after that
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fr, key, err := fixtureRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	n := t.seq[key]
+	t.seq[key]++
+	t.mu.Unlock()
+
+	var data []byte
+	for ; n >= 0; n-- {
+		if data, err = os.ReadFile(fixturePath(t.dir, req, key, n)); err == nil || !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recorded response for %s %s", fr.Method, fr.URL)
+		}
+		return nil, err
+	}
+
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture of %s %s: %v", fr.Method, fr.URL, err)
+	}
+
+	body := []byte(f.Response.Body)
+	if f.Response.BodyBase64 != "" {
+		if body, err = base64.StdEncoding.DecodeString(f.Response.BodyBase64); err != nil {
+			return nil, err
+		}
+	}
+
+	header := f.Response.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	// The redacted body may differ in length
+	header.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Response.StatusCode, http.StatusText(f.Response.StatusCode)),
+		StatusCode:    f.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
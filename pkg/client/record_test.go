@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flant/glaball/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestRecordReplay(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "secret-token", r.Header.Get("PRIVATE-TOKEN"))
+		w.Header().Set("X-Total", "1")
+		fmt.Fprintf(w, `[{"id": %d, "username": "alice"}]`, requests)
+	})
+	mux.HandleFunc("POST /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id": 2, "username": "bob", "feed_token": "feed-secret"}`)
+	})
+	server := httptest.NewServer(mux)
+
+	cfg := &config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "secret-token"}}},
+		},
+	}
+	dir := t.TempDir()
+
+	cli, err := NewClient(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Record(dir))
+
+	gl := cli.Hosts[0].Client
+	for id := 1; id <= 2; id++ {
+		users, _, err := gl.Users.ListUsers(&gitlab.ListUsersOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, id, users[0].ID)
+	}
+	_, _, err = gl.Users.CreateUser(&gitlab.CreateUserOptions{Username: gitlab.String("bob"), Password: gitlab.String("hunter2")})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requests)
+
+	// The credentials are not saved
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		assert.NoError(t, err)
+		for _, secret := range []string{"secret-token", "hunter2", "feed-secret"} {
+			assert.NotContains(t, string(b), secret, f)
+		}
+		assert.True(t, strings.Contains(string(b), Redacted), f)
+	}
+
+	server.Close()
+
+	cli, err = NewClient(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Replay(dir))
+
+	gl = cli.Hosts[0].Client
+	// The responses are served in the recorded order, the last one is repeated
+	for _, id := range []int{1, 2, 2} {
+		users, resp, err := gl.Users.ListUsers(&gitlab.ListUsersOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, id, users[0].ID)
+		assert.Equal(t, 1, resp.TotalItems)
+	}
+
+	user, resp, err := gl.Users.CreateUser(&gitlab.CreateUserOptions{Username: gitlab.String("bob"), Password: gitlab.String("other")})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "bob", user.Username)
+
+	_, _, err = gl.Users.CurrentUser()
+	assert.ErrorContains(t, err, "no recorded response for GET "+server.URL+"/api/v4/user")
+
+	assert.Error(t, cli.Replay(filepath.Join(dir, "missing")))
+}
@@ -1,11 +1,22 @@
 package config
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/units"
+	"github.com/gregjones/httpcache"
 	"github.com/gregjones/httpcache/diskcache"
 	"github.com/peterbourgon/diskv"
 )
@@ -69,10 +80,117 @@ func (c *CacheOptions) Diskv() (*diskv.Diskv, error) {
 	return diskv.New(diskvOpts), nil
 }
 
-func (c *CacheOptions) DiskCache() (*diskcache.Cache, error) {
+// DiskCache returns the cache of the responses, the values of the indexed fields of the cached lists are kept
+// aside for CachedValues
+func (c *CacheOptions) DiskCache() (httpcache.Cache, error) {
 	diskv, err := c.Diskv()
 	if err != nil {
 		return nil, err
 	}
-	return diskcache.NewWithDiskv(diskv), nil
+	return &indexedCache{Cache: diskcache.NewWithDiskv(diskv), d: diskv}, nil
+}
+
+// CachedValues returns the sorted unique values of the indexed field found in the cached lists,
+// nothing is requested from the hosts
+func (c *CacheOptions) CachedValues(field string) ([]string, error) {
+	d, err := c.Diskv()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for key := range d.KeysPrefix(field+"-", nil) {
+		v, err := d.Read(key)
+		if err != nil {
+			continue
+		}
+		for _, s := range strings.Split(string(v), "\n") {
+			if s != "" {
+				seen[s] = struct{}{}
+			}
+		}
+	}
+
+	values := make([]string, 0, len(seen))
+	for v := range seen {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	return values, nil
+}
+
+// Indexed fields of the cached lists by the last segment of their path
+var cacheIndex = map[string]string{
+	"users":    "username",
+	"projects": "path",
+}
+
+// The values of the indexed field of every cached list are kept under their own key,
+// so the completions don't read and decode all cached responses
+type indexedCache struct {
+	*diskcache.Cache
+	d *diskv.Diskv
+}
+
+func (c *indexedCache) Set(key string, resp []byte) {
+	c.Cache.Set(key, resp)
+
+	field, ok := indexedField(key)
+	if !ok {
+		return
+	}
+
+	values, err := listValues(resp, field)
+	if err != nil {
+		return
+	}
+	c.d.Write(indexKey(field, key), []byte(strings.Join(values, "\n")))
+}
+
+func (c *indexedCache) Delete(key string) {
+	c.Cache.Delete(key)
+
+	if field, ok := indexedField(key); ok {
+		c.d.Erase(indexKey(field, key))
+	}
+}
+
+// The keys of the cached GET requests are their urls
+func indexedField(key string) (string, bool) {
+	u, err := url.Parse(key)
+	if err != nil {
+		return "", false
+	}
+	field, ok := cacheIndex[path.Base(u.Path)]
+	return field, ok
+}
+
+func indexKey(field, key string) string {
+	sum := md5.Sum([]byte(key))
+	return field + "-" + hex.EncodeToString(sum[:])
+}
+
+// Returns the string values of the top level field of the objects of the cached list
+func listValues(resp []byte, field string) ([]string, error) {
+	// The cached responses are the dumps of the http responses
+	r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var objects []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&objects); err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(objects))
+	for _, o := range objects {
+		if v, ok := o[field].(string); ok && v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values, nil
 }
@@ -29,6 +29,8 @@ type Host struct {
 	Type        string             `yaml:"type" mapstructure:"type"`
 	Org         string             `yaml:"org" mapstructure:"org"`
 	RateLimiter RateLimiterOptions `yaml:"rate_limiter" mapstructure:"rate_limiter"`
+	Pagination  string             `yaml:"pagination" mapstructure:"pagination"`
+	Tags        []string           `yaml:"tags" mapstructure:"tags"`
 }
 
 // TODO:
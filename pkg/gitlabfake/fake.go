@@ -0,0 +1,411 @@
+// Package gitlabfake is an in-memory gitlab api for the tests of the commands against several instances.
+package gitlabfake
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Fake is a set of gitlab instances with the full names of the config hosts: team.project.name
+type Fake struct {
+	instances map[string]*Instance
+}
+
+// New starts an instance for every full name, e.g. New("main.alfa.local", "main.bravo.local")
+func New(names ...string) *Fake {
+	f := Fake{instances: make(map[string]*Instance, len(names))}
+	for _, name := range names {
+		f.instances[name] = NewInstance(name)
+	}
+	return &f
+}
+
+// Instance returns the instance with the full name, nil if there is none
+func (f *Fake) Instance(name string) *Instance {
+	return f.instances[name]
+}
+
+// Instances returns the instances sorted by name
+func (f *Fake) Instances() []*Instance {
+	list := make([]*Instance, 0, len(f.instances))
+	for _, i := range f.instances {
+		list = append(list, i)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
+}
+
+// Config returns the config with a host for every instance, the cache is disabled
+func (f *Fake) Config() *config.Config {
+	hosts := make(config.Hosts)
+	for name, i := range f.instances {
+		parts := strings.SplitN(name, ".", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "local")
+		}
+		team, project, host := parts[0], parts[1], parts[2]
+		if hosts[team] == nil {
+			hosts[team] = make(map[string]map[string]config.Host)
+		}
+		if hosts[team][project] == nil {
+			hosts[team][project] = make(map[string]config.Host)
+		}
+		hosts[team][project][host] = config.Host{URL: i.URL, Token: i.Token, Pagination: i.Pagination}
+	}
+
+	return &config.Config{
+		Hosts:   hosts,
+		Threads: limiter.DefaultLimit,
+	}
+}
+
+// Close stops all instances
+func (f *Fake) Close() {
+	for _, i := range f.instances {
+		i.Close()
+	}
+}
+
+// Instance is an in-memory gitlab instance served over http.
+// The fields and the data are set up before the requests, the api changes the data under the lock of the instance.
+type Instance struct {
+	Name  string
+	URL   string
+	Token string
+
+	// Version is returned by /version
+	Version gitlab.Version
+	// CurrentUser is returned by /user and owns the created pipeline schedules
+	CurrentUser *gitlab.User
+	// Pagination is set in the config of the host
+	Pagination string
+	// OmitTotals omits the X-Total and X-Total-Pages headers like gitlab does for the large lists
+	OmitTotals bool
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	users    []*gitlab.User
+	projects []*Project
+	requests []string
+}
+
+// NewInstance starts the instance, the token of the instance is required in every request
+func NewInstance(name string) *Instance {
+	i := Instance{
+		Name:        name,
+		Token:       "token-" + name,
+		Version:     gitlab.Version{Version: "17.0.0-ee", Revision: "fake"},
+		CurrentUser: &gitlab.User{Username: "root", Name: "Administrator", State: "active", IsAdmin: true},
+		nextID:      1,
+	}
+	i.server = httptest.NewServer(i.handler())
+	i.URL = i.server.URL
+
+	return &i
+}
+
+// Close stops the instance
+func (i *Instance) Close() {
+	i.server.Close()
+}
+
+// Requests returns the method and the path with the query of every request to the instance
+func (i *Instance) Requests() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]string{}, i.requests...)
+}
+
+func (i *Instance) id() int {
+	id := i.nextID
+	i.nextID++
+	return id
+}
+
+// AddUser adds the user, the id is assigned if it is not set
+func (i *Instance) AddUser(u gitlab.User) *gitlab.User {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if u.ID == 0 {
+		u.ID = i.id()
+	} else if u.ID >= i.nextID {
+		i.nextID = u.ID + 1
+	}
+	if u.State == "" {
+		u.State = "active"
+	}
+	if u.WebURL == "" {
+		u.WebURL = i.URL + "/" + u.Username
+	}
+	i.users = append(i.users, &u)
+
+	return &u
+}
+
+// User returns the user with the username, nil if there is none
+func (i *Instance) User(username string) *gitlab.User {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, u := range i.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+// Users returns the users of the instance
+func (i *Instance) Users() []*gitlab.User {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*gitlab.User{}, i.users...)
+}
+
+// Project is the project with its nested resources
+type Project struct {
+	*gitlab.Project
+
+	Languages         gitlab.ProjectLanguages
+	Branches          []*gitlab.Branch
+	ProtectedBranches []*gitlab.ProtectedBranch
+	MergeRequests     []*gitlab.MergeRequest
+	Schedules         []*Schedule
+	Repositories      []*Repository
+	// Files are the raw files of the repository by path, the same on every ref.
+	// The merged yaml of the ci lint is the .gitlab-ci.yml file.
+	Files map[string]string
+
+	instance *Instance
+}
+
+// Schedule is the pipeline schedule with its pipelines
+type Schedule struct {
+	*gitlab.PipelineSchedule
+	Pipelines []*gitlab.Pipeline
+}
+
+// Repository is the container registry repository with its tags
+type Repository struct {
+	*gitlab.RegistryRepository
+	Tags []*gitlab.RegistryRepositoryTag
+}
+
+// AddProject adds the project, the id is assigned if it is not set.
+// The path, the web url and the default branch "main" are set from the path with namespace.
+func (i *Instance) AddProject(p gitlab.Project) *Project {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if p.ID == 0 {
+		p.ID = i.id()
+	} else if p.ID >= i.nextID {
+		i.nextID = p.ID + 1
+	}
+	if p.PathWithNamespace == "" {
+		p.PathWithNamespace = fmt.Sprintf("group/project-%d", p.ID)
+	}
+	namespace, path := "", p.PathWithNamespace
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		namespace, path = path[:idx], path[idx+1:]
+	}
+	if p.Path == "" {
+		p.Path = path
+	}
+	if p.Name == "" {
+		p.Name = path
+	}
+	if p.Namespace == nil {
+		p.Namespace = &gitlab.ProjectNamespace{FullPath: namespace, Path: namespace, Name: namespace, Kind: "group"}
+	}
+	if p.WebURL == "" {
+		p.WebURL = i.URL + "/" + p.PathWithNamespace
+	}
+	if p.DefaultBranch == "" {
+		p.DefaultBranch = "main"
+	}
+
+	project := Project{Project: &p, Languages: gitlab.ProjectLanguages{}, Files: make(map[string]string), instance: i}
+	i.projects = append(i.projects, &project)
+
+	return &project
+}
+
+// Project returns the project with the path with namespace, nil if there is none
+func (i *Instance) Project(path string) *Project {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.findProject(path)
+}
+
+// Projects returns the projects of the instance
+func (i *Instance) Projects() []*Project {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*Project{}, i.projects...)
+}
+
+// AddBranch adds the branch with a commit of the time
+func (p *Project) AddBranch(name string, committed time.Time) *gitlab.Branch {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	b := gitlab.Branch{
+		Name:    name,
+		Default: name == p.DefaultBranch,
+		WebURL:  p.WebURL + "/-/tree/" + name,
+		Commit:  &gitlab.Commit{ID: fmt.Sprintf("%040d", len(p.Branches)+1), CommittedDate: &committed},
+	}
+	p.Branches = append(p.Branches, &b)
+
+	return &b
+}
+
+// Protect protects the branch or the wildcard with the access levels of push and merge
+func (p *Project) Protect(name string, push, merge gitlab.AccessLevelValue) *gitlab.ProtectedBranch {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	b := gitlab.ProtectedBranch{
+		ID:                    p.instance.id(),
+		Name:                  name,
+		PushAccessLevels:      []*gitlab.BranchAccessDescription{accessLevel(push)},
+		MergeAccessLevels:     []*gitlab.BranchAccessDescription{accessLevel(merge)},
+		UnprotectAccessLevels: []*gitlab.BranchAccessDescription{accessLevel(gitlab.MaintainerPermissions)},
+	}
+	p.ProtectedBranches = append(p.ProtectedBranches, &b)
+
+	return &b
+}
+
+// AddMergeRequest adds the merge request, the ids are assigned if they are not set
+func (p *Project) AddMergeRequest(mr gitlab.MergeRequest) *gitlab.MergeRequest {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	if mr.ID == 0 {
+		mr.ID = p.instance.id()
+	}
+	if mr.IID == 0 {
+		mr.IID = len(p.MergeRequests) + 1
+	}
+	if mr.State == "" {
+		mr.State = "opened"
+	}
+	mr.ProjectID = p.ID
+	if mr.WebURL == "" {
+		mr.WebURL = fmt.Sprintf("%s/-/merge_requests/%d", p.WebURL, mr.IID)
+	}
+	p.MergeRequests = append(p.MergeRequests, &mr)
+
+	return &mr
+}
+
+// AddSchedule adds the pipeline schedule with the pipelines, the ids are assigned if they are not set
+func (p *Project) AddSchedule(s gitlab.PipelineSchedule, pipelines ...gitlab.Pipeline) *Schedule {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	if s.ID == 0 {
+		s.ID = p.instance.id()
+	}
+	if s.Ref == "" {
+		s.Ref = p.DefaultBranch
+	}
+	schedule := Schedule{PipelineSchedule: &s}
+	for _, pl := range pipelines {
+		if pl.ID == 0 {
+			pl.ID = p.instance.id()
+		}
+		pl.ProjectID = p.ID
+		schedule.Pipelines = append(schedule.Pipelines, &pl)
+	}
+	if n := len(schedule.Pipelines); n > 0 {
+		last := schedule.Pipelines[n-1]
+		s.LastPipeline = &gitlab.LastPipeline{ID: last.ID, SHA: last.SHA, Ref: last.Ref, Status: last.Status}
+	}
+	p.Schedules = append(p.Schedules, &schedule)
+
+	return &schedule
+}
+
+// AddFile adds the file with the content to the repository
+func (p *Project) AddFile(path, content string) {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	p.Files[path] = content
+}
+
+// AddRepository adds the container registry repository with the tags of the sizes
+func (p *Project) AddRepository(name string, tags map[string]int) *Repository {
+	p.instance.mu.Lock()
+	defer p.instance.mu.Unlock()
+
+	r := Repository{RegistryRepository: &gitlab.RegistryRepository{
+		ID:        p.instance.id(),
+		Name:      name,
+		Path:      p.PathWithNamespace + "/" + name,
+		ProjectID: p.ID,
+		Location:  "registry.example.com/" + p.PathWithNamespace + "/" + name,
+	}}
+
+	names := make([]string, 0, len(tags))
+	for t := range tags {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		r.Tags = append(r.Tags, &gitlab.RegistryRepositoryTag{
+			Name:      t,
+			Path:      r.Path + ":" + t,
+			Location:  r.Location + ":" + t,
+			TotalSize: tags[t],
+		})
+	}
+	p.Repositories = append(p.Repositories, &r)
+
+	return &r
+}
+
+// Returns the project by the id or the path with namespace, the lock is held by the caller
+func (i *Instance) findProject(id string) *Project {
+	for _, p := range i.projects {
+		if fmt.Sprint(p.ID) == id || p.PathWithNamespace == id {
+			return p
+		}
+	}
+	return nil
+}
+
+var accessLevelDescriptions = map[gitlab.AccessLevelValue]string{
+	gitlab.NoPermissions:         "No one",
+	gitlab.DeveloperPermissions:  "Developers + Maintainers",
+	gitlab.MaintainerPermissions: "Maintainers",
+	gitlab.AdminPermissions:      "Admins",
+}
+
+func accessLevel(l gitlab.AccessLevelValue) *gitlab.BranchAccessDescription {
+	return &gitlab.BranchAccessDescription{AccessLevel: l, AccessLevelDescription: accessLevelDescriptions[l]}
+}
+
+// Checks the token of the request
+func (i *Instance) authorized(r *http.Request) bool {
+	if r.Header.Get("PRIVATE-TOKEN") == i.Token {
+		return true
+	}
+	return r.Header.Get("Authorization") == "Bearer "+i.Token
+}
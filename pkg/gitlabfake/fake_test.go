@@ -0,0 +1,204 @@
+package gitlabfake
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func newClient(t *testing.T, i *Instance, token string) *gitlab.Client {
+	cli, err := gitlab.NewClient(token, gitlab.WithBaseURL(i.URL), gitlab.WithoutRetries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func TestFake(t *testing.T) {
+	fake := New("main.alfa.local", "main.bravo.local")
+	defer fake.Close()
+
+	alfa, bravo := fake.Instance("main.alfa.local"), fake.Instance("main.bravo.local")
+	assert.Equal(t, []*Instance{alfa, bravo}, fake.Instances())
+
+	cfg := fake.Config()
+	assert.Equal(t, alfa.URL, cfg.Hosts["main"]["alfa"]["local"].URL)
+	assert.Equal(t, bravo.Token, cfg.Hosts["main"]["bravo"]["local"].Token)
+
+	// The instances have their own data and tokens
+	alfa.AddUser(gitlab.User{Username: "alice"})
+	_, _, err := newClient(t, bravo, alfa.Token).Users.CurrentUser()
+	assert.Error(t, err)
+
+	users, _, err := newClient(t, bravo, bravo.Token).Users.ListUsers(&gitlab.ListUsersOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestPagination(t *testing.T) {
+	i := NewInstance("main.alfa.local")
+	defer i.Close()
+
+	for n := 0; n < 5; n++ {
+		i.AddProject(gitlab.Project{})
+	}
+	cli := newClient(t, i, i.Token)
+
+	list, resp, err := cli.Projects.ListProjects(&gitlab.ListProjectsOptions{ListOptions: gitlab.ListOptions{Page: 2, PerPage: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, ids(list))
+	assert.Equal(t, 3, resp.NextPage)
+	assert.Equal(t, 1, resp.PreviousPage)
+	assert.Equal(t, 5, resp.TotalItems)
+	assert.Equal(t, 3, resp.TotalPages)
+
+	i.OmitTotals = true
+	list, resp, err = cli.Projects.ListProjects(&gitlab.ListProjectsOptions{ListOptions: gitlab.ListOptions{Page: 3, PerPage: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5}, ids(list))
+	assert.Equal(t, 0, resp.NextPage)
+	assert.Equal(t, 0, resp.TotalPages)
+
+	opt := gitlab.ListProjectsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 3, Pagination: "keyset", OrderBy: "id", Sort: "asc"},
+	}
+	list, resp, err = cli.Projects.ListProjects(&opt)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids(list))
+	assert.NotEmpty(t, resp.NextLink)
+
+	list, resp, err = cli.Projects.ListProjects(&opt, gitlab.WithKeysetPaginationParameters(resp.NextLink))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, ids(list))
+	assert.Empty(t, resp.NextLink)
+
+	assert.Contains(t, i.Requests(), "GET /api/v4/projects?page=2&per_page=2")
+}
+
+func ids(list []*gitlab.Project) []int {
+	s := make([]int, 0, len(list))
+	for _, p := range list {
+		s = append(s, p.ID)
+	}
+	return s
+}
+
+func TestUsers(t *testing.T) {
+	i := NewInstance("main.alfa.local")
+	defer i.Close()
+
+	i.AddUser(gitlab.User{Username: "alice", Email: "alice@example.com"})
+	cli := newClient(t, i, i.Token)
+
+	_, _, err := cli.Users.CreateUser(&gitlab.CreateUserOptions{Username: gitlab.String("alice"), Email: gitlab.String("a@example.com")})
+	assert.Error(t, err)
+
+	bob, _, err := cli.Users.CreateUser(&gitlab.CreateUserOptions{
+		Username: gitlab.String("bob"), Name: gitlab.String("Bob"), Email: gitlab.String("bob@example.com"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "active", bob.State)
+
+	_, _, err = cli.Users.ModifyUser(bob.ID, &gitlab.ModifyUserOptions{Name: gitlab.String("Robert"), Admin: gitlab.Bool(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, "Robert", i.User("bob").Name)
+	assert.True(t, i.User("bob").IsAdmin)
+
+	assert.NoError(t, cli.Users.BlockUser(bob.ID))
+	blocked, _, err := cli.Users.ListUsers(&gitlab.ListUsersOptions{Blocked: gitlab.Bool(true)})
+	assert.NoError(t, err)
+	assert.Len(t, blocked, 1)
+
+	_, err = cli.Users.DeleteUser(bob.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, i.User("bob"))
+
+	current, _, err := cli.Users.CurrentUser()
+	assert.NoError(t, err)
+	assert.Equal(t, "root", current.Username)
+}
+
+func TestProjectResources(t *testing.T) {
+	i := NewInstance("main.alfa.local")
+	defer i.Close()
+
+	p := i.AddProject(gitlab.Project{PathWithNamespace: "backend/api"})
+	p.AddBranch("main", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	p.AddBranch("dev", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	p.Protect("main", gitlab.MaintainerPermissions, gitlab.DeveloperPermissions)
+	p.AddMergeRequest(gitlab.MergeRequest{Title: "Fix", State: "merged"})
+	p.AddMergeRequest(gitlab.MergeRequest{Title: "Add"})
+	p.AddSchedule(gitlab.PipelineSchedule{Description: "nightly", Active: true}, gitlab.Pipeline{Status: "success"})
+	repo := p.AddRepository("app", map[string]int{"latest": 1024, "v1": 2048})
+	p.AddFile(".gitlab-ci.yml", "stages: [build]\n")
+
+	cli := newClient(t, i, i.Token)
+
+	project, _, err := cli.Projects.GetProject("backend/api", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "api", project.Path)
+	assert.Equal(t, "backend", project.Namespace.FullPath)
+
+	_, _, err = cli.Projects.EditProject(p.ID, &gitlab.EditProjectOptions{Description: gitlab.String("The api")})
+	assert.NoError(t, err)
+	assert.Equal(t, "The api", p.Description)
+
+	branches, _, err := cli.Branches.ListBranches(p.ID, &gitlab.ListBranchesOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, branches, 2)
+	assert.True(t, branches[0].Default)
+
+	_, _, err = cli.ProtectedBranches.ProtectRepositoryBranches(p.ID, &gitlab.ProtectRepositoryBranchesOptions{Name: gitlab.String("main")})
+	assert.Error(t, err)
+
+	protected, _, err := cli.ProtectedBranches.ProtectRepositoryBranches(p.ID, &gitlab.ProtectRepositoryBranchesOptions{
+		Name:            gitlab.String("release/*"),
+		PushAccessLevel: gitlab.AccessLevel(gitlab.NoPermissions),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, gitlab.NoPermissions, protected.PushAccessLevels[0].AccessLevel)
+	assert.Equal(t, gitlab.MaintainerPermissions, protected.MergeAccessLevels[0].AccessLevel)
+
+	_, err = cli.ProtectedBranches.UnprotectRepositoryBranches(p.ID, "release/*")
+	assert.NoError(t, err)
+	_, _, err = cli.ProtectedBranches.GetProtectedBranch(p.ID, "release/*")
+	assert.True(t, errors.Is(err, gitlab.ErrNotFound))
+
+	mrs, _, err := cli.MergeRequests.ListProjectMergeRequests(p.ID, &gitlab.ListProjectMergeRequestsOptions{State: gitlab.String("opened")})
+	assert.NoError(t, err)
+	assert.Len(t, mrs, 1)
+	assert.Equal(t, "Add", mrs[0].Title)
+
+	raw, _, err := cli.RepositoryFiles.GetRawFile(p.ID, ".gitlab-ci.yml", &gitlab.GetRawFileOptions{Ref: gitlab.String("main")})
+	assert.NoError(t, err)
+	assert.Equal(t, "stages: [build]\n", string(raw))
+	_, _, err = cli.RepositoryFiles.GetRawFile(p.ID, "werf.yaml", &gitlab.GetRawFileOptions{})
+	assert.True(t, errors.Is(err, gitlab.ErrNotFound))
+
+	lint, _, err := cli.Validate.ProjectLint(p.ID, &gitlab.ProjectLintOptions{})
+	assert.NoError(t, err)
+	assert.True(t, lint.Valid)
+	assert.Equal(t, "stages: [build]\n", lint.MergedYaml)
+
+	schedules, _, err := cli.PipelineSchedules.ListPipelineSchedules(p.ID, &gitlab.ListPipelineSchedulesOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, schedules, 1)
+	assert.Nil(t, schedules[0].LastPipeline)
+
+	schedule, _, err := cli.PipelineSchedules.TakeOwnershipOfPipelineSchedule(p.ID, schedules[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", schedule.Owner.Username)
+	assert.Equal(t, "success", schedule.LastPipeline.Status)
+
+	repos, _, err := cli.ContainerRegistry.ListProjectRegistryRepositories(p.ID, &gitlab.ListRegistryRepositoriesOptions{Tags: gitlab.Bool(true)})
+	assert.NoError(t, err)
+	assert.Len(t, repos[0].Tags, 2)
+	assert.Zero(t, repos[0].Tags[0].TotalSize)
+
+	tag, _, err := cli.ContainerRegistry.GetRegistryRepositoryTagDetail(p.ID, repo.ID, "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2048, tag.TotalSize)
+}
@@ -0,0 +1,497 @@
+package gitlabfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Serves the rest api of the instance, every request holds the lock of the instance
+func (i *Instance) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, i.Version)
+	})
+
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, i.CurrentUser)
+	})
+
+	mux.HandleFunc("GET /api/v4/users", i.listUsers)
+	mux.HandleFunc("POST /api/v4/users", i.createUser)
+	mux.HandleFunc("GET /api/v4/users/{id}", i.withUser(func(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+		writeJSON(w, http.StatusOK, u)
+	}))
+	mux.HandleFunc("PUT /api/v4/users/{id}", i.withUser(i.modifyUser))
+	mux.HandleFunc("POST /api/v4/users/{id}/block", i.withUser(func(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+		u.State = "blocked"
+		writeJSON(w, http.StatusCreated, true)
+	}))
+	mux.HandleFunc("POST /api/v4/users/{id}/unblock", i.withUser(func(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+		u.State = "active"
+		writeJSON(w, http.StatusCreated, true)
+	}))
+	mux.HandleFunc("POST /api/v4/users/{id}/activate", i.withUser(func(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+		u.State = "active"
+		writeJSON(w, http.StatusCreated, true)
+	}))
+	mux.HandleFunc("DELETE /api/v4/users/{id}", i.withUser(func(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+		i.users = slices.DeleteFunc(i.users, func(v *gitlab.User) bool { return v == u })
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects", i.listProjects)
+	mux.HandleFunc("GET /api/v4/projects/{id}", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		writeJSON(w, http.StatusOK, p.Project)
+	}))
+	mux.HandleFunc("PUT /api/v4/projects/{id}", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		if err := json.NewDecoder(r.Body).Decode(p.Project); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, p.Project)
+	}))
+	mux.HandleFunc("GET /api/v4/projects/{id}/languages", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		writeJSON(w, http.StatusOK, p.Languages)
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/repository/branches", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		search := r.URL.Query().Get("search")
+		list := filter(p.Branches, func(b *gitlab.Branch) bool { return strings.Contains(b.Name, search) })
+		paginate(i, w, r, list, nil)
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/protected_branches", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		search := r.URL.Query().Get("search")
+		list := filter(p.ProtectedBranches, func(b *gitlab.ProtectedBranch) bool { return strings.Contains(b.Name, search) })
+		paginate(i, w, r, list, nil)
+	}))
+	mux.HandleFunc("GET /api/v4/projects/{id}/protected_branches/{name}", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		if b := protectedBranch(p, r.PathValue("name")); b != nil {
+			writeJSON(w, http.StatusOK, b)
+			return
+		}
+		writeError(w, http.StatusNotFound, "404 Not found")
+	}))
+	mux.HandleFunc("POST /api/v4/projects/{id}/protected_branches", i.withProject(i.protectBranch))
+	mux.HandleFunc("DELETE /api/v4/projects/{id}/protected_branches/{name}", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		b := protectedBranch(p, r.PathValue("name"))
+		if b == nil {
+			writeError(w, http.StatusNotFound, "404 Not found")
+			return
+		}
+		p.ProtectedBranches = slices.DeleteFunc(p.ProtectedBranches, func(v *gitlab.ProtectedBranch) bool { return v == b })
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/repository/files/{path}/raw", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		content, ok := p.Files[r.PathValue("path")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "404 File Not Found")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, content)
+	}))
+	mux.HandleFunc("GET /api/v4/projects/{id}/ci/lint", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		content, ok := p.Files[".gitlab-ci.yml"]
+		if !ok {
+			writeJSON(w, http.StatusOK, gitlab.ProjectLintResult{Errors: []string{"Please provide content of .gitlab-ci.yml"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, gitlab.ProjectLintResult{Valid: true, MergedYaml: content})
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/merge_requests", i.withProject(i.listMergeRequests))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/pipeline_schedules", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		scope := r.URL.Query().Get("scope")
+		list := make([]*gitlab.PipelineSchedule, 0, len(p.Schedules))
+		for _, s := range p.Schedules {
+			if (scope == "active" && !s.Active) || (scope == "inactive" && s.Active) {
+				continue
+			}
+			// The last pipeline is returned for the single schedule only
+			v := *s.PipelineSchedule
+			v.LastPipeline = nil
+			list = append(list, &v)
+		}
+		paginate(i, w, r, list, nil)
+	}))
+	mux.HandleFunc("POST /api/v4/projects/{id}/pipeline_schedules", i.withProject(i.createSchedule))
+	mux.HandleFunc("GET /api/v4/projects/{id}/pipeline_schedules/{schedule}", i.withSchedule(func(w http.ResponseWriter, r *http.Request, p *Project, s *Schedule) {
+		writeJSON(w, http.StatusOK, s.PipelineSchedule)
+	}))
+	mux.HandleFunc("GET /api/v4/projects/{id}/pipeline_schedules/{schedule}/pipelines", i.withSchedule(func(w http.ResponseWriter, r *http.Request, p *Project, s *Schedule) {
+		paginate(i, w, r, s.Pipelines, nil)
+	}))
+	mux.HandleFunc("POST /api/v4/projects/{id}/pipeline_schedules/{schedule}/take_ownership", i.withSchedule(func(w http.ResponseWriter, r *http.Request, p *Project, s *Schedule) {
+		s.Owner = i.CurrentUser
+		writeJSON(w, http.StatusCreated, s.PipelineSchedule)
+	}))
+
+	mux.HandleFunc("GET /api/v4/projects/{id}/registry/repositories", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		tags := r.URL.Query().Get("tags") == "true"
+		list := make([]*gitlab.RegistryRepository, 0, len(p.Repositories))
+		for _, repo := range p.Repositories {
+			v := *repo.RegistryRepository
+			v.TagsCount = len(repo.Tags)
+			if tags {
+				// The details of the tags are returned by the tag api only
+				for _, t := range repo.Tags {
+					v.Tags = append(v.Tags, &gitlab.RegistryRepositoryTag{Name: t.Name, Path: t.Path, Location: t.Location})
+				}
+			}
+			list = append(list, &v)
+		}
+		paginate(i, w, r, list, nil)
+	}))
+	mux.HandleFunc("GET /api/v4/projects/{id}/registry/repositories/{repository}/tags/{tag}", i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		for _, repo := range p.Repositories {
+			if strconv.Itoa(repo.ID) != r.PathValue("repository") {
+				continue
+			}
+			for _, t := range repo.Tags {
+				if t.Name == r.PathValue("tag") {
+					writeJSON(w, http.StatusOK, t)
+					return
+				}
+			}
+		}
+		writeError(w, http.StatusNotFound, "404 Tag Not Found")
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		i.requests = append(i.requests, r.Method+" "+r.URL.RequestURI())
+
+		if !i.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "401 Unauthorized")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func filter[T any](list []T, fn func(v T) bool) []T {
+	out := make([]T, 0, len(list))
+	for _, v := range list {
+		if fn(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func queryInt(r *http.Request, key string, def int) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// Writes the page of the list with the pagination headers of gitlab.
+// The keyset pagination is used if it is requested and the elements have ids.
+func paginate[T any](i *Instance, w http.ResponseWriter, r *http.Request, list []T, id func(v T) int) {
+	perPage := min(max(queryInt(r, "per_page", defaultPerPage), 1), maxPerPage)
+
+	if q := r.URL.Query(); q.Get("pagination") == "keyset" && id != nil {
+		after := queryInt(r, "id_after", 0)
+		start := slices.IndexFunc(list, func(v T) bool { return id(v) > after })
+		if start < 0 {
+			start = len(list)
+		}
+		end := min(start+perPage, len(list))
+		page := list[start:end]
+
+		if end < len(list) {
+			q.Set("id_after", strconv.Itoa(id(page[len(page)-1])))
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, i.URL, r.URL.Path, q.Encode()))
+		}
+		writeJSON(w, http.StatusOK, page)
+		return
+	}
+
+	page := max(queryInt(r, "page", 1), 1)
+	pages := (len(list) + perPage - 1) / perPage
+
+	w.Header().Set("X-Page", strconv.Itoa(page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	if page > 1 {
+		w.Header().Set("X-Prev-Page", strconv.Itoa(page-1))
+	}
+	if page < pages {
+		w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+	if !i.OmitTotals {
+		w.Header().Set("X-Total", strconv.Itoa(len(list)))
+		w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+	}
+
+	start := min((page-1)*perPage, len(list))
+	end := min(start+perPage, len(list))
+	writeJSON(w, http.StatusOK, list[start:end])
+}
+
+func (i *Instance) withUser(fn func(w http.ResponseWriter, r *http.Request, u *gitlab.User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, u := range i.users {
+			if strconv.Itoa(u.ID) == r.PathValue("id") {
+				fn(w, r, u)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "404 User Not Found")
+	}
+}
+
+func (i *Instance) withProject(fn func(w http.ResponseWriter, r *http.Request, p *Project)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := i.findProject(r.PathValue("id")); p != nil {
+			fn(w, r, p)
+			return
+		}
+		writeError(w, http.StatusNotFound, "404 Project Not Found")
+	}
+}
+
+func (i *Instance) withSchedule(fn func(w http.ResponseWriter, r *http.Request, p *Project, s *Schedule)) http.HandlerFunc {
+	return i.withProject(func(w http.ResponseWriter, r *http.Request, p *Project) {
+		for _, s := range p.Schedules {
+			if strconv.Itoa(s.ID) == r.PathValue("schedule") {
+				fn(w, r, p, s)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "404 Pipeline Schedule Not Found")
+	})
+}
+
+func (i *Instance) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	username, search := q.Get("username"), strings.ToLower(q.Get("search"))
+
+	list := filter(i.users, func(u *gitlab.User) bool {
+		switch {
+		case username != "" && !strings.EqualFold(u.Username, username),
+			search != "" && !strings.Contains(strings.ToLower(u.Username+" "+u.Name+" "+u.Email), search),
+			q.Get("active") == "true" && u.State != "active",
+			q.Get("blocked") == "true" && u.State != "blocked":
+			return false
+		}
+		return true
+	})
+
+	paginate(i, w, r, list, func(u *gitlab.User) int { return u.ID })
+}
+
+func (i *Instance) createUser(w http.ResponseWriter, r *http.Request) {
+	var opt struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Admin    bool   `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, u := range i.users {
+		switch {
+		case strings.EqualFold(u.Username, opt.Username):
+			writeError(w, http.StatusConflict, "Username has already been taken")
+			return
+		case opt.Email != "" && strings.EqualFold(u.Email, opt.Email):
+			writeError(w, http.StatusConflict, "Email has already been taken")
+			return
+		}
+	}
+
+	u := gitlab.User{
+		ID:       i.id(),
+		Username: opt.Username,
+		Name:     opt.Name,
+		Email:    opt.Email,
+		IsAdmin:  opt.Admin,
+		State:    "active",
+		WebURL:   i.URL + "/" + opt.Username,
+	}
+	i.users = append(i.users, &u)
+
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func (i *Instance) modifyUser(w http.ResponseWriter, r *http.Request, u *gitlab.User) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The options are named like the fields of the user except admin
+	if v, ok := body["admin"]; ok {
+		body["is_admin"] = v
+	}
+	b, _ := json.Marshal(body)
+	if err := json.Unmarshal(b, u); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (i *Instance) listProjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	search := strings.ToLower(q.Get("search"))
+
+	list := make([]*gitlab.Project, 0, len(i.projects))
+	for _, p := range i.projects {
+		switch {
+		case search != "" && !strings.Contains(strings.ToLower(p.Name+" "+p.Path), search),
+			q.Get("archived") != "" && strconv.FormatBool(p.Archived) != q.Get("archived"),
+			q.Get("visibility") != "" && string(p.Visibility) != q.Get("visibility"):
+			continue
+		}
+		list = append(list, p.Project)
+	}
+
+	paginate(i, w, r, list, func(p *gitlab.Project) int { return p.ID })
+}
+
+func protectedBranch(p *Project, name string) *gitlab.ProtectedBranch {
+	for _, b := range p.ProtectedBranches {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (i *Instance) protectBranch(w http.ResponseWriter, r *http.Request, p *Project) {
+	var opt gitlab.ProtectRepositoryBranchesOptions
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opt.Name == nil || *opt.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is missing")
+		return
+	}
+	if protectedBranch(p, *opt.Name) != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Protected branch '%s' already exists", *opt.Name))
+		return
+	}
+
+	levels := func(level *gitlab.AccessLevelValue, allowed *[]*gitlab.BranchPermissionOptions) []*gitlab.BranchAccessDescription {
+		list := make([]*gitlab.BranchAccessDescription, 0)
+		if allowed != nil {
+			for _, a := range *allowed {
+				d := gitlab.BranchAccessDescription{}
+				if a.AccessLevel != nil {
+					d = *accessLevel(*a.AccessLevel)
+				}
+				if a.UserID != nil {
+					d.UserID = *a.UserID
+				}
+				if a.GroupID != nil {
+					d.GroupID = *a.GroupID
+				}
+				list = append(list, &d)
+			}
+		}
+		if level != nil || len(list) == 0 {
+			l := gitlab.MaintainerPermissions
+			if level != nil {
+				l = *level
+			}
+			list = append(list, accessLevel(l))
+		}
+		return list
+	}
+
+	b := gitlab.ProtectedBranch{
+		ID:                    i.id(),
+		Name:                  *opt.Name,
+		PushAccessLevels:      levels(opt.PushAccessLevel, opt.AllowedToPush),
+		MergeAccessLevels:     levels(opt.MergeAccessLevel, opt.AllowedToMerge),
+		UnprotectAccessLevels: levels(opt.UnprotectAccessLevel, opt.AllowedToUnprotect),
+	}
+	if opt.AllowForcePush != nil {
+		b.AllowForcePush = *opt.AllowForcePush
+	}
+	if opt.CodeOwnerApprovalRequired != nil {
+		b.CodeOwnerApprovalRequired = *opt.CodeOwnerApprovalRequired
+	}
+	p.ProtectedBranches = append(p.ProtectedBranches, &b)
+
+	writeJSON(w, http.StatusCreated, b)
+}
+
+func (i *Instance) listMergeRequests(w http.ResponseWriter, r *http.Request, p *Project) {
+	q := r.URL.Query()
+	state := q.Get("state")
+
+	list := filter(p.MergeRequests, func(mr *gitlab.MergeRequest) bool {
+		switch {
+		case state != "" && state != "all" && mr.State != state,
+			q.Get("author_id") != "" && (mr.Author == nil || strconv.Itoa(mr.Author.ID) != q.Get("author_id")),
+			q.Get("assignee_id") != "" && (mr.Assignee == nil || strconv.Itoa(mr.Assignee.ID) != q.Get("assignee_id")):
+			return false
+		}
+		return true
+	})
+
+	paginate(i, w, r, list, nil)
+}
+
+func (i *Instance) createSchedule(w http.ResponseWriter, r *http.Request, p *Project) {
+	var opt gitlab.CreatePipelineScheduleOptions
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opt.Description == nil || opt.Ref == nil || opt.Cron == nil {
+		writeError(w, http.StatusBadRequest, "description, ref and cron are required")
+		return
+	}
+
+	s := gitlab.PipelineSchedule{
+		ID:          i.id(),
+		Description: *opt.Description,
+		Ref:         *opt.Ref,
+		Cron:        *opt.Cron,
+		Active:      opt.Active == nil || *opt.Active,
+		Owner:       i.CurrentUser,
+	}
+	if opt.CronTimezone != nil {
+		s.CronTimezone = *opt.CronTimezone
+	}
+	p.Schedules = append(p.Schedules, &Schedule{PipelineSchedule: &s})
+
+	writeJSON(w, http.StatusCreated, s)
+}
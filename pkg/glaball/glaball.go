@@ -0,0 +1,204 @@
+// Package glaball runs the requests of glaball against all hosts of the config
+// and returns the results with the errors of the hosts, without any global state or output.
+package glaball
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/pipeline"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Glaball sends the requests to the hosts of the config selected by its filter
+type Glaball struct {
+	client  *client.Client
+	threads int
+}
+
+// Result is the value fetched from the host
+type Result[T any] struct {
+	Host   *client.Host
+	Value  T
+	Cached bool
+}
+
+// Error is the error of the request to the host
+type Error struct {
+	Host *client.Host
+	Err  error
+}
+
+func (e Error) Error() string {
+	if e.Host == nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Host.FullName(), e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates the clients of the hosts of the config, the config is not changed afterwards
+func New(cfg *config.Config) (*Glaball, error) {
+	if cfg == nil {
+		return nil, errors.New("config is not set")
+	}
+
+	// The client disables the cache of its config when there are github hosts
+	c := *cfg
+	cli, err := client.NewClient(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	threads := c.Threads
+	if threads <= 0 {
+		threads = limiter.DefaultLimit
+	}
+
+	return &Glaball{client: cli, threads: threads}, nil
+}
+
+// Client returns the client of the hosts, e.g. to observe the requests with client.Observer
+func (g *Glaball) Client() *client.Client {
+	return g.client
+}
+
+// Hosts returns the hosts selected by the filter of the config
+func (g *Glaball) Hosts() client.Hosts {
+	return g.client.Hosts
+}
+
+// Runs the stages of the pipeline with the own limiter and the context and collects the results of the last stage
+func run[T any](ctx context.Context, g *Glaball, fn func(p *pipeline.Pipeline) <-chan pipeline.Element[T]) ([]Result[T], []Error) {
+	p := pipeline.NewContext(ctx, limiter.NewLimiter(g.threads))
+
+	elements := pipeline.Collect(fn(p))
+	results := make([]Result[T], 0, len(elements))
+	for _, e := range elements {
+		results = append(results, Result[T]{Host: e.Host, Value: e.Value, Cached: e.Cached})
+	}
+
+	errs := make([]Error, 0)
+	for _, err := range p.Errors() {
+		errs = append(errs, Error{Host: err.Host, Err: err.Err})
+	}
+
+	return results, errs
+}
+
+// Returns the first stage of the pipeline over the gitlab hosts, github hosts are skipped
+func gitlabHosts[T any](g *Glaball, p *pipeline.Pipeline, fn func(ctx context.Context, h *client.Host, emit pipeline.Emit[T]) error) <-chan pipeline.Element[T] {
+	return pipeline.FromHosts(p, g.client.Hosts, func(ctx context.Context, h *client.Host, emit pipeline.Emit[T]) error {
+		if h.Client == nil {
+			return nil
+		}
+		return fn(ctx, h, emit)
+	})
+}
+
+// Users lists the users of all hosts with the pagination of every host
+func (g *Glaball) Users(ctx context.Context, opt gitlab.ListUsersOptions) ([]Result[*gitlab.User], []Error) {
+	options := []gitlab.RequestOptionFunc{g.client.WithCache()}
+
+	return run(ctx, g, func(p *pipeline.Pipeline) <-chan pipeline.Element[*gitlab.User] {
+		return gitlabHosts(g, p, func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+			options := pipeline.WithContext(ctx, options)
+			opt := opt
+			return pipeline.PaginateHost(p.Slot(), h, pipeline.UsersKeyset(&opt),
+				func(page ...gitlab.RequestOptionFunc) ([]*gitlab.User, *gitlab.Response, error) {
+					return h.Client.Users.ListUsers(&opt, slices.Concat(options, page)...)
+				}, emit)
+		})
+	})
+}
+
+// CurrentUser returns the user of the token of every host
+func (g *Glaball) CurrentUser(ctx context.Context) ([]Result[*gitlab.User], []Error) {
+	options := []gitlab.RequestOptionFunc{g.client.WithNoCache()}
+
+	return run(ctx, g, func(p *pipeline.Pipeline) <-chan pipeline.Element[*gitlab.User] {
+		return gitlabHosts(g, p, func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.User]) error {
+			user, _, err := h.Client.Users.CurrentUser(pipeline.WithContext(ctx, options)...)
+			if err != nil {
+				return err
+			}
+			emit(user, false)
+			return nil
+		})
+	})
+}
+
+// Projects lists the projects of all hosts with the pagination of every host
+func (g *Glaball) Projects(ctx context.Context, opt gitlab.ListProjectsOptions) ([]Result[*gitlab.Project], []Error) {
+	options := []gitlab.RequestOptionFunc{g.client.WithCache()}
+
+	return run(ctx, g, func(p *pipeline.Pipeline) <-chan pipeline.Element[*gitlab.Project] {
+		return gitlabHosts(g, p, func(ctx context.Context, h *client.Host, emit pipeline.Emit[*gitlab.Project]) error {
+			options := pipeline.WithContext(ctx, options)
+			opt := opt
+			return pipeline.PaginateHost(p.Slot(), h, pipeline.ProjectsKeyset(&opt),
+				func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Project, *gitlab.Response, error) {
+					return h.Client.Projects.ListProjects(&opt, slices.Concat(options, page)...)
+				}, emit)
+		})
+	})
+}
+
+// ProtectedBranch is the branch protected in the project
+type ProtectedBranch struct {
+	Project *gitlab.Project
+	Branch  *gitlab.ProtectedBranch
+}
+
+// ProtectBranch protects the branch or the wildcard of opt.Name in the projects, e.g. returned by Projects.
+// The projects with the branch already protected are skipped, their settings are not changed.
+func (g *Glaball) ProtectBranch(ctx context.Context, projects []Result[*gitlab.Project],
+	opt gitlab.ProtectRepositoryBranchesOptions) ([]Result[*ProtectedBranch], []Error) {
+
+	if opt.Name == nil || *opt.Name == "" {
+		return nil, []Error{{Err: errors.New("branch name is not set")}}
+	}
+
+	options := []gitlab.RequestOptionFunc{g.client.WithNoCache()}
+
+	return run(ctx, g, func(p *pipeline.Pipeline) <-chan pipeline.Element[*ProtectedBranch] {
+		in := make(chan pipeline.Element[*gitlab.Project])
+		go func() {
+			defer close(in)
+			for _, r := range projects {
+				in <- pipeline.Element[*gitlab.Project]{Host: r.Host, Value: r.Value, Cached: r.Cached}
+			}
+		}()
+
+		return pipeline.Then(p, in, func(ctx context.Context, e pipeline.Element[*gitlab.Project], emit pipeline.Emit[*ProtectedBranch]) error {
+			if e.Host.Client == nil {
+				return nil
+			}
+			options := pipeline.WithContext(ctx, options)
+
+			_, _, err := e.Host.Client.ProtectedBranches.GetProtectedBranch(e.Value.ID, *opt.Name, options...)
+			switch {
+			case err == nil:
+				return nil
+			case !errors.Is(err, gitlab.ErrNotFound):
+				return err
+			}
+
+			branch, _, err := e.Host.Client.ProtectedBranches.ProtectRepositoryBranches(e.Value.ID, &opt, options...)
+			if err != nil {
+				return fmt.Errorf("failed to protect branch %q in %q: %v", *opt.Name, e.Value.PathWithNamespace, err)
+			}
+			emit(&ProtectedBranch{Project: e.Value, Branch: branch}, false)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package glaball
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/flant/glaball/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestGlaball(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `[{"id": 2, "username": "bob"}]`)
+			return
+		}
+		w.Header().Set("X-Next-Page", "2")
+		fmt.Fprint(w, `[{"id": 1, "username": "alice"}]`)
+	})
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1, "username": "admin"}`)
+	})
+	mux.HandleFunc("GET /api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("archived"))
+		fmt.Fprint(w, `[{"id": 1, "path_with_namespace": "group/api"}, {"id": 2, "path_with_namespace": "group/web"}]`)
+	})
+	mux.HandleFunc("GET /api/v4/projects/1/protected_branches/main", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "main"}`)
+	})
+	mux.HandleFunc("GET /api/v4/projects/2/protected_branches/main", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("POST /api/v4/projects/2/protected_branches", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, map[string]interface{}{"name": "main", "push_access_level": float64(0)}, body)
+		fmt.Fprint(w, `{"name": "main", "push_access_levels": [{"access_level": 0}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "403 Forbidden"}`)
+	}))
+	defer broken.Close()
+
+	g, err := New(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {
+				"alfa":  {"local": config.Host{URL: server.URL, Token: "token"}},
+				"bravo": {"local": config.Host{URL: broken.URL, Token: "token"}},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, g.Hosts(), 2)
+
+	ctx := context.Background()
+
+	users, errs := g.Users(ctx, gitlab.ListUsersOptions{})
+	usernames := make([]string, 0, len(users))
+	for _, u := range users {
+		assert.Equal(t, "main.alfa.local", u.Host.FullName())
+		usernames = append(usernames, u.Value.Username)
+	}
+	sort.Strings(usernames)
+	assert.Equal(t, []string{"alice", "bob"}, usernames)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "main.bravo.local", errs[0].Host.FullName())
+	assert.Contains(t, errs[0].Error(), "main.bravo.local: GET "+broken.URL+"/api/v4/users: 403")
+
+	current, errs := g.CurrentUser(ctx)
+	assert.Len(t, current, 1)
+	assert.Equal(t, "admin", current[0].Value.Username)
+	assert.Len(t, errs, 1)
+
+	projects, errs := g.Projects(ctx, gitlab.ListProjectsOptions{Archived: gitlab.Bool(true)})
+	assert.Len(t, projects, 2)
+	assert.Len(t, errs, 1)
+
+	protected, errs := g.ProtectBranch(ctx, projects, gitlab.ProtectRepositoryBranchesOptions{
+		Name:            gitlab.String("main"),
+		PushAccessLevel: gitlab.AccessLevel(gitlab.NoPermissions),
+	})
+	assert.Empty(t, errs)
+	assert.Len(t, protected, 1)
+	assert.Equal(t, "group/web", protected[0].Value.Project.PathWithNamespace)
+	assert.Equal(t, "main", protected[0].Value.Branch.Name)
+
+	_, errs = g.ProtectBranch(ctx, projects, gitlab.ProtectRepositoryBranchesOptions{})
+	assert.Len(t, errs, 1)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, errs = g.CurrentUser(cancelled)
+	assert.Len(t, errs, 2)
+}
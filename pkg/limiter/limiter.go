@@ -1,6 +1,9 @@
 package limiter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/flant/glaball/pkg/client"
@@ -16,12 +19,27 @@ type Error struct {
 	Err  error
 }
 
+// Progress is notified about the tasks of the limiter
+type Progress interface {
+	TaskAdded(delta int)
+	TaskDone()
+	TaskError(host *client.Host, err error)
+}
+
+// TaskObserver is an optional interface of the progress notified when the task of the host gets a worker.
+// The returned context is passed to the requests of the task and the returned func is called with the result
+// of the task when it releases the worker.
+type TaskObserver interface {
+	TaskStarted(ctx context.Context, host *client.Host) (context.Context, func(err error))
+}
+
 type Limiter struct {
 	wg   sync.WaitGroup
 	mu   sync.Mutex
 	errs []Error
 
-	sem chan struct{}
+	sem      chan struct{}
+	progress []Progress
 }
 
 func NewLimiter(limit int) *Limiter {
@@ -29,24 +47,77 @@ func NewLimiter(limit int) *Limiter {
 	return &w
 }
 
+// Notify adds the receiver of the task events, must be called before adding any tasks
+func (l *Limiter) Notify(p Progress) {
+	l.progress = append(l.progress, p)
+}
+
 func (l *Limiter) Error(host *client.Host, err error) {
 	l.mu.Lock()
 	l.errs = append(l.errs, Error{host, err})
 	l.mu.Unlock()
+	for _, p := range l.progress {
+		p.TaskError(host, err)
+	}
+}
+
+// Reset drops the errors of the tasks, e.g. before the command is run again with the same receivers of the task events
+func (l *Limiter) Reset() {
+	l.mu.Lock()
+	l.errs = nil
+	l.mu.Unlock()
 }
 
 func (l *Limiter) Errors() []Error {
 	return l.errs
 }
 
+// Err joins the errors of the tasks prefixed with their hosts, nil if there are none
+func (l *Limiter) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	errs := make([]error, 0, len(l.errs))
+	for _, e := range l.errs {
+		errs = append(errs, fmt.Errorf("%s: %w", e.Host.FullName(), e.Err))
+	}
+
+	return errors.Join(errs...)
+}
+
 func (l *Limiter) Add(delta int) {
 	l.wg.Add(delta)
+	for _, p := range l.progress {
+		p.TaskAdded(delta)
+	}
 }
 
 func (l *Limiter) Done() {
+	for _, p := range l.progress {
+		p.TaskDone()
+	}
 	l.wg.Done()
 }
 
+// Start notifies the receivers implementing TaskObserver that the task of the host got a worker,
+// the task runs with the returned context and the returned func must be called with its result
+func (l *Limiter) Start(ctx context.Context, host *client.Host) (context.Context, func(err error)) {
+	done := make([]func(err error), 0, len(l.progress))
+	for _, p := range l.progress {
+		if o, ok := p.(TaskObserver); ok {
+			var fn func(err error)
+			ctx, fn = o.TaskStarted(ctx, host)
+			done = append(done, fn)
+		}
+	}
+
+	return ctx, func(err error) {
+		for _, fn := range done {
+			fn(err)
+		}
+	}
+}
+
 func (l *Limiter) Lock() {
 	l.sem <- struct{}{}
 }
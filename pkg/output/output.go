@@ -0,0 +1,519 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+)
+
+const (
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+)
+
+// Columns prints one row per result element with the values of the columns
+func Columns(w io.Writer, format string, columns []sort.Column, results []sort.Result) error {
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = strings.ToUpper(c.Name)
+	}
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, r := range results {
+			for _, e := range r.Elements.Typed() {
+				if err := cw.Write(Row(columns, r, e)); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		if _, err := fmt.Fprintln(tw, strings.Join(header, "\t")); err != nil {
+			return err
+		}
+		for _, r := range results {
+			for _, e := range r.Elements.Typed() {
+				if _, err := fmt.Fprintln(tw, strings.Join(Row(columns, r, e), "\t")); err != nil {
+					return err
+				}
+			}
+		}
+		return tw.Flush()
+
+	case FormatJSON:
+		rows := make([]map[string]interface{}, 0, len(results))
+		for _, r := range results {
+			for _, e := range r.Elements.Typed() {
+				row := make(map[string]interface{}, len(columns))
+				for _, c := range columns {
+					row[c.Name] = c.Value(r, e)
+				}
+				rows = append(rows, row)
+			}
+		}
+		return encodeJSON(w, rows)
+	}
+
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+// Row returns formatted values of the columns
+func Row(columns []sort.Column, r sort.Result, e sort.Element) []string {
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		row[i] = FormatValue(c.Value(r, e))
+	}
+	return row
+}
+
+// Aggregates prints one row per result with its key, count and values of the aggregations.
+// The table also ends with the totals computed over the elements of all results.
+func Aggregates(w io.Writer, format string, aggregations []sort.Aggregation, results []sort.Result) error {
+	header := []string{"KEY", "COUNT"}
+	for _, a := range aggregations {
+		header = append(header, strings.ToUpper(a.Name()))
+	}
+
+	row := func(key string, count int, values []interface{}) []string {
+		s := []string{key, fmt.Sprint(count)}
+		for _, v := range values {
+			s = append(s, FormatValue(v))
+		}
+		return s
+	}
+
+	all := make(sort.Elements, 0, len(results))
+	for _, r := range results {
+		all = append(all, r.Elements...)
+	}
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, r := range results {
+			if err := cw.Write(row(r.Key, r.Count, r.Aggregates)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, r := range results {
+			fmt.Fprintln(tw, strings.Join(row(r.Key, r.Count, r.Aggregates), "\t"))
+		}
+		fmt.Fprintln(tw, strings.Join(row("TOTAL", len(all), sort.Aggregate(aggregations, all)), "\t"))
+		return tw.Flush()
+
+	case FormatJSON:
+		type group struct {
+			Key        string                 `json:"key,omitempty"`
+			Count      int                    `json:"count"`
+			Aggregates map[string]interface{} `json:"aggregates"`
+		}
+		newGroup := func(key string, count int, values []interface{}) group {
+			g := group{Key: key, Count: count, Aggregates: make(map[string]interface{}, len(values))}
+			for i, a := range aggregations {
+				g.Aggregates[a.Name()] = values[i]
+			}
+			return g
+		}
+
+		groups := make([]group, 0, len(results))
+		for _, r := range results {
+			groups = append(groups, newGroup(r.Key, r.Count, r.Aggregates))
+		}
+		return encodeJSON(w, struct {
+			Groups []group `json:"groups"`
+			Total  group   `json:"total"`
+		}{groups, newGroup("", len(all), sort.Aggregate(aggregations, all))})
+	}
+
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// FormatValue converts the field value to string.
+// Times are formatted as RFC3339, floats are rounded to hundredths, lists of scalars are joined with commas, objects are encoded as json.
+func FormatValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(math.Round(t*100)/100, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(math.Round(float64(t)*100)/100, 'f', -1, 64)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	case fmt.Stringer:
+		if _, ok := v.(json.Marshaler); !ok {
+			return t.String()
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Struct:
+		// Aliases of time.Time, e.g. gitlab.ISOTime
+		if rv.Type().ConvertibleTo(timeType) {
+			if b, err := json.Marshal(v); err == nil {
+				return strings.Trim(string(b), `"`)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if s, ok := scalars(rv); ok {
+			return strings.Join(s, ",")
+		}
+	case reflect.Map:
+	default:
+		return fmt.Sprint(v)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Format list of scalar values, returns false if any of the elements is an object
+func scalars(rv reflect.Value) ([]string, bool) {
+	s := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		for e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface {
+			if e.IsNil() {
+				break
+			}
+			e = e.Elem()
+		}
+		switch e.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			return nil, false
+		case reflect.Ptr, reflect.Interface:
+			s = append(s, "")
+		default:
+			s = append(s, fmt.Sprint(e.Interface()))
+		}
+	}
+	return s, true
+}
+
+// Tree prints the results of multi-level grouping.
+// The table is indented by the group levels, csv has one row per leaf group (or per element if there are columns)
+// with the keys of every level, json is a tree of groups.
+func Tree(w io.Writer, format string, columns []sort.Column, aggregations []sort.Aggregation, results []sort.Result) error {
+	header := make([]string, 0, 2+len(aggregations)+len(columns))
+	for _, a := range aggregations {
+		header = append(header, strings.ToUpper(a.Name()))
+	}
+	for _, c := range columns {
+		header = append(header, strings.ToUpper(c.Name))
+	}
+
+	values := func(r sort.Result) []string {
+		s := make([]string, 0, len(aggregations)+len(columns))
+		for _, v := range r.Aggregates {
+			s = append(s, FormatValue(v))
+		}
+		for range columns {
+			s = append(s, "")
+		}
+		return s
+	}
+
+	element := func(r sort.Result, e sort.Element) []string {
+		s := make([]string, len(aggregations), len(aggregations)+len(columns))
+		return append(s, Row(columns, r, e)...)
+	}
+
+	switch format {
+	case FormatCSV:
+		var fields []string
+		for r := results; len(r) > 0; r = r[0].Groups {
+			fields = append(fields, strings.ToUpper(r[0].Field))
+		}
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append(append(fields, "COUNT"), header...)); err != nil {
+			return err
+		}
+
+		var walk func(keys []string, results []sort.Result) error
+		walk = func(keys []string, results []sort.Result) error {
+			for _, r := range results {
+				path := append(keys[:len(keys):len(keys)], r.Key)
+				if len(r.Groups) > 0 {
+					if err := walk(path, r.Groups); err != nil {
+						return err
+					}
+					continue
+				}
+
+				// Keys of the missing levels are empty
+				row := append(path, make([]string, len(fields)-len(path))...)
+				row = append(row, fmt.Sprint(r.Count))
+				if len(columns) == 0 {
+					if err := cw.Write(append(row, values(r)...)); err != nil {
+						return err
+					}
+					continue
+				}
+				for _, e := range r.Elements.Typed() {
+					s := element(r, e)
+					copy(s[:len(aggregations)], values(r))
+					if err := cw.Write(append(row[:len(row):len(row)], s...)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		if err := walk(nil, results); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(tw, strings.Join(append([]string{"KEY", "COUNT"}, header...), "\t"))
+
+		var walk func(depth int, results []sort.Result)
+		walk = func(depth int, results []sort.Result) {
+			indent := strings.Repeat("  ", depth)
+			for _, r := range results {
+				row := append([]string{indent + r.Key, fmt.Sprint(r.Count)}, values(r)...)
+				fmt.Fprintln(tw, strings.Join(row, "\t"))
+				if len(r.Groups) > 0 {
+					walk(depth+1, r.Groups)
+					continue
+				}
+				if len(columns) > 0 {
+					for _, e := range r.Elements.Typed() {
+						row := append([]string{indent + "  -", ""}, element(r, e)...)
+						fmt.Fprintln(tw, strings.Join(row, "\t"))
+					}
+				}
+			}
+		}
+		walk(0, results)
+
+		if len(aggregations) > 0 {
+			all := make(sort.Elements, 0, len(results))
+			for _, r := range results {
+				all = append(all, r.Elements...)
+			}
+			row := []string{"TOTAL", fmt.Sprint(len(all))}
+			for _, v := range sort.Aggregate(aggregations, all) {
+				row = append(row, FormatValue(v))
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+
+	case FormatJSON:
+		return encodeJSON(w, tree(columns, aggregations, results))
+	}
+
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+type node struct {
+	Field      string                   `json:"field"`
+	Key        string                   `json:"key"`
+	Count      int                      `json:"count"`
+	Aggregates map[string]interface{}   `json:"aggregates,omitempty"`
+	Groups     []node                   `json:"groups,omitempty"`
+	Elements   []map[string]interface{} `json:"elements,omitempty"`
+}
+
+func tree(columns []sort.Column, aggregations []sort.Aggregation, results []sort.Result) []node {
+	nodes := make([]node, 0, len(results))
+	for _, r := range results {
+		n := node{Field: r.Field, Key: r.Key, Count: r.Count}
+		if len(aggregations) > 0 {
+			n.Aggregates = make(map[string]interface{}, len(aggregations))
+			for i, a := range aggregations {
+				n.Aggregates[a.Name()] = r.Aggregates[i]
+			}
+		}
+		if len(r.Groups) > 0 {
+			n.Groups = tree(columns, aggregations, r.Groups)
+		} else if len(columns) > 0 {
+			for _, e := range r.Elements.Typed() {
+				row := make(map[string]interface{}, len(columns))
+				for _, c := range columns {
+					row[c.Name] = c.Value(r, e)
+				}
+				n.Elements = append(n.Elements, row)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+const (
+	matrixPresent = "✓"
+	matrixMissing = "✗"
+	matrixFailed  = "?"
+)
+
+// Matrix prints one row per object and one column per host.
+// Objects are identified by the value of the key column, or by the result key if it is not set.
+// Cells show if there are elements of the object on the host, or their values of the value column if it is set.
+// Hosts that failed to return the results are marked with "?" instead of missing.
+func Matrix(w io.Writer, format string, hosts client.Hosts, key, value *sort.Column, results []sort.Result, failed client.Hosts) error {
+	type row struct {
+		key      string
+		elements map[*client.Host][]interface{}
+	}
+
+	// Elements with the same key are merged, e.g. ungrouped users of different hosts
+	rows := make([]*row, 0, len(results))
+	index := make(map[string]*row, len(results))
+	for _, r := range results {
+		for _, e := range r.Elements.Typed() {
+			k := r.Key
+			if key != nil {
+				k = FormatValue(key.Value(r, e))
+			}
+
+			v, ok := index[k]
+			if !ok {
+				v = &row{key: k, elements: make(map[*client.Host][]interface{})}
+				index[k] = v
+				rows = append(rows, v)
+			}
+
+			var cell interface{} = true
+			if value != nil {
+				cell = value.Value(r, e)
+			}
+			v.elements[e.Host] = append(v.elements[e.Host], cell)
+		}
+	}
+
+	isFailed := make(map[*client.Host]bool, len(failed))
+	for _, h := range failed {
+		isFailed[h] = true
+	}
+
+	cell := func(r *row, h *client.Host) string {
+		values, ok := r.elements[h]
+		switch {
+		case !ok && isFailed[h]:
+			return matrixFailed
+		case !ok:
+			return matrixMissing
+		case value == nil:
+			return matrixPresent
+		}
+		s := make([]string, len(values))
+		for i, v := range values {
+			s[i] = FormatValue(v)
+		}
+		return strings.Join(s, ",")
+	}
+
+	header := []string{"KEY"}
+	for _, h := range hosts {
+		header = append(header, h.FullName())
+	}
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			s := []string{r.key}
+			for _, h := range hosts {
+				s = append(s, cell(r, h))
+			}
+			if err := cw.Write(s); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, r := range rows {
+			s := []string{r.key}
+			for _, h := range hosts {
+				s = append(s, cell(r, h))
+			}
+			fmt.Fprintln(tw, strings.Join(s, "\t"))
+		}
+		return tw.Flush()
+
+	case FormatJSON:
+		type jsonRow struct {
+			Key   string                 `json:"key"`
+			Hosts map[string]interface{} `json:"hosts"`
+		}
+		s := make([]jsonRow, 0, len(rows))
+		for _, r := range rows {
+			v := jsonRow{Key: r.key, Hosts: make(map[string]interface{}, len(hosts))}
+			for _, h := range hosts {
+				values, ok := r.elements[h]
+				switch {
+				case !ok && isFailed[h]:
+					v.Hosts[h.FullName()] = nil
+				case value == nil:
+					v.Hosts[h.FullName()] = ok
+				case !ok:
+					v.Hosts[h.FullName()] = nil
+				case len(values) == 1:
+					v.Hosts[h.FullName()] = values[0]
+				default:
+					v.Hosts[h.FullName()] = values
+				}
+			}
+			s = append(s, v)
+		}
+		return encodeJSON(w, s)
+	}
+
+	return fmt.Errorf("unsupported output format: %s", format)
+}
@@ -0,0 +1,161 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestColumns(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := []sort.Result{
+		{
+			Count: 1,
+			Elements: sort.Elements{
+				sort.Element{
+					Host: &client.Host{Project: "main", Name: "local"},
+					Struct: &gitlab.Project{
+						Name:      "api",
+						CreatedAt: &created,
+						Topics:    []string{"go", "backend"},
+						Namespace: &gitlab.ProjectNamespace{FullPath: "backend"},
+					},
+				},
+				sort.Element{
+					Host:   &client.Host{Project: "main", Name: "local"},
+					Struct: &gitlab.Project{Name: "web"},
+				},
+			},
+		},
+	}
+
+	columns, err := sort.ParseColumns([]string{"name", "namespace.full_path", "created_at", "topics", "host"}, gitlab.Project{})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Columns(&buf, FormatCSV, columns, results))
+	assert.Equal(t, `NAME,NAMESPACE.FULL_PATH,CREATED_AT,TOPICS,HOST
+api,backend,2024-01-02T03:04:05Z,"go,backend",main.local
+web,,,,main.local
+`, buf.String())
+
+	_, err = sort.ParseColumns([]string{"namespace.unknown"}, gitlab.Project{})
+	assert.Error(t, err)
+}
+
+func TestAggregates(t *testing.T) {
+	host := &client.Host{Project: "main", Name: "local"}
+	results := []sort.Result{
+		{
+			Count: 2,
+			Key:   "backend",
+			Elements: sort.Elements{
+				sort.Element{Host: host, Struct: &gitlab.Project{StarCount: 1}},
+				sort.Element{Host: host, Struct: &gitlab.Project{StarCount: 2}},
+			},
+			Aggregates: []interface{}{int64(3), 1.5},
+		},
+		{
+			Count:      1,
+			Key:        "frontend",
+			Elements:   sort.Elements{sort.Element{Host: host, Struct: &gitlab.Project{StarCount: 7}}},
+			Aggregates: []interface{}{int64(7), 7.0},
+		},
+	}
+
+	aggregations, err := sort.ParseAggregations([]string{"sum:star_count", "avg:star_count"}, gitlab.Project{})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Aggregates(&buf, FormatTable, aggregations, results))
+	assert.Equal(t, `KEY      COUNT SUM:STAR_COUNT AVG:STAR_COUNT
+backend  2     3              1.5
+frontend 1     7              7
+TOTAL    3     10             3.33
+`, buf.String())
+}
+
+func TestTree(t *testing.T) {
+	host := &client.Host{Project: "main", Name: "local"}
+	api := sort.Element{Host: host, Struct: &gitlab.Project{Name: "api"}}
+	web := sort.Element{Host: host, Struct: &gitlab.Project{Name: "web"}}
+	results := []sort.Result{
+		{
+			Field:    "host",
+			Key:      "main.local",
+			Count:    2,
+			Elements: sort.Elements{api, web},
+			Groups: []sort.Result{
+				{Field: "namespace.full_path", Key: "backend", Count: 1, Elements: sort.Elements{api}},
+				{Field: "namespace.full_path", Key: "frontend", Count: 1, Elements: sort.Elements{web}},
+			},
+		},
+	}
+
+	columns, err := sort.ParseColumns([]string{"name"}, gitlab.Project{})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Tree(&buf, FormatTable, columns, nil, results))
+	assert.Equal(t, `KEY        COUNT NAME
+main.local 2     
+  backend  1     
+    -            api
+  frontend 1     
+    -            web
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Tree(&buf, FormatCSV, columns, nil, results))
+	assert.Equal(t, `HOST,NAMESPACE.FULL_PATH,COUNT,NAME
+main.local,backend,1,api
+main.local,frontend,1,web
+`, buf.String())
+}
+
+func TestMatrix(t *testing.T) {
+	alfa := &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	beta := &client.Host{Team: "main", Project: "beta", Name: "local"}
+	// The same project name in another team
+	gamma := &client.Host{Team: "ops", Project: "alfa", Name: "local"}
+	hosts := client.Hosts{alfa, beta, gamma}
+
+	user := func(h *client.Host, username, state string) sort.Result {
+		return sort.Result{Count: 1, Key: username, Elements: sort.Elements{
+			sort.Element{Host: h, Struct: &gitlab.User{Username: username, State: state}},
+		}}
+	}
+	results := []sort.Result{
+		user(alfa, "alice", "active"),
+		user(beta, "alice", "blocked"),
+		user(alfa, "bob", "active"),
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Matrix(&buf, FormatCSV, hosts, nil, nil, results, client.Hosts{gamma}))
+	assert.Equal(t, `KEY,main.alfa.local,main.beta.local,ops.alfa.local
+alice,✓,✓,?
+bob,✓,✗,?
+`, buf.String())
+
+	columns, err := sort.ParseColumns([]string{"state"}, gitlab.User{})
+	assert.NoError(t, err)
+
+	buf.Reset()
+	assert.NoError(t, Matrix(&buf, FormatCSV, hosts, nil, &columns[0], results, nil))
+	assert.Equal(t, `KEY,main.alfa.local,main.beta.local,ops.alfa.local
+alice,active,blocked,✗
+bob,active,✗,✗
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Matrix(&buf, FormatJSON, hosts, nil, &columns[0], results, nil))
+	assert.Contains(t, buf.String(), `"main.alfa.local": "active"`)
+	assert.Contains(t, buf.String(), `"ops.alfa.local": null`)
+}
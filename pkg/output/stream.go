@@ -0,0 +1,152 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flant/glaball/pkg/sort/v2"
+)
+
+const FormatNDJSON = "ndjson"
+
+// Number of table rows aligned together, the table is flushed after every block
+const streamTableRows = 100
+
+// StreamWriter writes the results as they arrive.
+// With columns every element is written as a row, otherwise every result with its key, count, hosts and aggregations.
+type StreamWriter struct {
+	format       string
+	columns      []sort.Column
+	aggregations []sort.Aggregation
+
+	w    io.Writer
+	tw   *tabwriter.Writer
+	cw   *csv.Writer
+	enc  *json.Encoder
+	rows int
+}
+
+func NewStreamWriter(w io.Writer, format string, columns []sort.Column, aggregations []sort.Aggregation) (*StreamWriter, error) {
+	s := StreamWriter{format: format, columns: columns, aggregations: aggregations, w: w}
+
+	header := make([]string, 0, 3+len(aggregations)+len(columns))
+	if len(columns) > 0 {
+		for _, c := range columns {
+			header = append(header, strings.ToUpper(c.Name))
+		}
+	} else {
+		header = append(header, "KEY", "COUNT", "HOSTS")
+		for _, a := range aggregations {
+			header = append(header, strings.ToUpper(a.Name()))
+		}
+	}
+
+	switch format {
+	case FormatTable:
+		s.tw = tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		if _, err := fmt.Fprintln(s.tw, strings.Join(header, "\t")); err != nil {
+			return nil, err
+		}
+	case FormatCSV:
+		s.cw = csv.NewWriter(w)
+		if err := s.cw.Write(header); err != nil {
+			return nil, err
+		}
+	case FormatNDJSON, FormatJSON:
+		s.enc = json.NewEncoder(w)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	return &s, nil
+}
+
+// Write writes the result and flushes the output
+func (s *StreamWriter) Write(r sort.Result) error {
+	if len(s.columns) > 0 {
+		for _, e := range r.Elements.Typed() {
+			if err := s.write(Row(s.columns, r, e), func() interface{} {
+				row := make(map[string]interface{}, len(s.columns))
+				for _, c := range s.columns {
+					row[c.Name] = c.Value(r, e)
+				}
+				return row
+			}); err != nil {
+				return err
+			}
+		}
+		return s.flush()
+	}
+
+	hosts := make([]string, 0, r.Count)
+	for _, h := range r.Elements.Hosts() {
+		hosts = append(hosts, h.ProjectName())
+	}
+
+	row := []string{r.Key, fmt.Sprint(r.Count), strings.Join(hosts, ",")}
+	for _, v := range r.Aggregates {
+		row = append(row, FormatValue(v))
+	}
+
+	if err := s.write(row, func() interface{} {
+		v := struct {
+			Key        string                 `json:"key,omitempty"`
+			Count      int                    `json:"count"`
+			Hosts      []string               `json:"hosts"`
+			Cached     bool                   `json:"cached"`
+			Aggregates map[string]interface{} `json:"aggregates,omitempty"`
+			Element    interface{}            `json:"element,omitempty"`
+		}{Key: r.Key, Count: r.Count, Hosts: hosts, Cached: bool(r.Cached)}
+		if len(s.aggregations) > 0 {
+			v.Aggregates = make(map[string]interface{}, len(s.aggregations))
+			for i, a := range s.aggregations {
+				v.Aggregates[a.Name()] = r.Aggregates[i]
+			}
+		}
+		// Single elements are written as is
+		if r.Field == "" && len(r.Elements) == 1 {
+			v.Element = r.Elements.Typed()[0].Struct
+		}
+		return v
+	}); err != nil {
+		return err
+	}
+
+	return s.flush()
+}
+
+func (s *StreamWriter) write(row []string, object func() interface{}) error {
+	switch {
+	case s.tw != nil:
+		s.rows++
+		_, err := fmt.Fprintln(s.tw, strings.Join(row, "\t"))
+		return err
+	case s.cw != nil:
+		return s.cw.Write(row)
+	}
+	return s.enc.Encode(object())
+}
+
+func (s *StreamWriter) flush() error {
+	switch {
+	case s.tw != nil && s.rows >= streamTableRows:
+		s.rows = 0
+		return s.tw.Flush()
+	case s.cw != nil:
+		s.cw.Flush()
+		return s.cw.Error()
+	}
+	return nil
+}
+
+// Flush writes the rest of the table
+func (s *StreamWriter) Flush() error {
+	if s.tw != nil {
+		return s.tw.Flush()
+	}
+	return s.flush()
+}
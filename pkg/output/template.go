@@ -0,0 +1,150 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/alecthomas/units"
+)
+
+// TemplateFuncs are the helper functions of the output templates
+var TemplateFuncs = template.FuncMap{
+	// date formats the time with the go layout: {{date "2006-01-02" .Struct.CreatedAt}}, empty for nil
+	"date": func(layout string, v interface{}) (string, error) {
+		t, ok := toTime(v)
+		if !ok {
+			if isNil(v) {
+				return "", nil
+			}
+			return "", fmt.Errorf("date: %T is not a time", v)
+		}
+		return t.Format(layout), nil
+	},
+	// bytes formats the size in bytes like 1.5GiB: {{bytes .Struct.Statistics.RepositorySize}}
+	"bytes": func(v interface{}) (string, error) {
+		n, err := toInt(v)
+		if err != nil {
+			return "", fmt.Errorf("bytes: %v", err)
+		}
+		return units.Base2Bytes(n).Floor().String(), nil
+	},
+	// join joins the values of the list formatted like the columns: {{join ", " .Struct.Topics}}
+	"join": func(sep string, v interface{}) string {
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return FormatValue(deref(v))
+		}
+		s := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			s = append(s, FormatValue(deref(rv.Index(i).Interface())))
+		}
+		return strings.Join(s, sep)
+	},
+	// value formats the field like the columns: {{value .Struct.LastActivityAt}}
+	"value": func(v interface{}) string {
+		return FormatValue(deref(v))
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// NewTemplate parses the output template, a newline is added after every result unless the template ends with one
+func NewTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("output").Funcs(TemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// Template renders the template for every sort.Element of the results,
+// or for every sort.Result if they are grouped
+func Template(w io.Writer, tmpl *template.Template, results []sort.Result) error {
+	for _, r := range results {
+		if err := TemplateResult(w, tmpl, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TemplateResult renders the template for the elements of the result, or for the result if it is a group
+func TemplateResult(w io.Writer, tmpl *template.Template, r sort.Result) error {
+	if r.Field != "" {
+		return tmpl.Execute(w, r)
+	}
+	for _, e := range r.Elements.Typed() {
+		if err := tmpl.Execute(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func deref(v interface{}) interface{} {
+	if isNil(v) {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		return rv.Elem().Interface()
+	}
+	return v
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	v = deref(v)
+	if v == nil {
+		return time.Time{}, false
+	}
+	if t, ok := v.(time.Time); ok {
+		return t, true
+	}
+	// Aliases of time.Time, e.g. gitlab.ISOTime
+	if rv := reflect.ValueOf(v); rv.Type().ConvertibleTo(timeType) {
+		return rv.Convert(timeType).Interface().(time.Time), true
+	}
+	if s, ok := v.(string); ok {
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func toInt(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(deref(v))
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), nil
+	case reflect.String:
+		return strconv.ParseInt(rv.String(), 10, 64)
+	case reflect.Invalid:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%T is not a number", v)
+}
@@ -0,0 +1,64 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestTemplate(t *testing.T) {
+	host := &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	activity := gitlab.ISOTime(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC))
+
+	results := []sort.Result{
+		{
+			Count: 2,
+			Elements: sort.Elements{
+				sort.Element{Host: host, Struct: &gitlab.User{Username: "alice", CreatedAt: &created, LastActivityOn: &activity}},
+				sort.Element{Host: host, Struct: &gitlab.User{Username: "bob"}, Cached: true},
+			},
+		},
+	}
+
+	tmpl, err := NewTemplate(`{{.Host.FullName}} {{.Struct.Username}} {{date "2006-01-02" .Struct.LastActivityOn}} {{date "15:04" .Struct.CreatedAt}} {{.Cached}}`)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Template(&buf, tmpl, results))
+	assert.Equal(t, "main.alfa.local alice 2024-05-06 03:04 no\nmain.alfa.local bob   yes\n", buf.String())
+
+	project := &gitlab.Project{
+		Name:       "api",
+		Topics:     []string{"go", "backend"},
+		Statistics: &gitlab.Statistics{RepositorySize: 3 * 1024 * 1024},
+	}
+	grouped := []sort.Result{
+		{
+			Count:    1,
+			Key:      "backend",
+			Field:    "namespace.full_path",
+			Elements: sort.Elements{sort.Element{Host: host, Struct: project}},
+		},
+	}
+
+	tmpl, err = NewTemplate("{{.Key}} {{.Count}}:{{range .Elements}} {{.Struct.Name}} [{{join \", \" .Struct.Topics}}] {{bytes .Struct.Statistics.RepositorySize}} {{upper .Struct.Name}}{{end}}\n")
+	assert.NoError(t, err)
+
+	buf.Reset()
+	assert.NoError(t, Template(&buf, tmpl, grouped))
+	assert.Equal(t, "backend 1: api [go, backend] 3MiB API\n", buf.String())
+
+	tmpl, err = NewTemplate(`{{date "2006" .Struct.Username}}`)
+	assert.NoError(t, err)
+	assert.Error(t, Template(&buf, tmpl, results))
+
+	_, err = NewTemplate(`{{.Struct.Username`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/flant/glaball/pkg/client"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/xanzy/go-gitlab"
+)
+
+// ParallelPages is the number of pages fetched at once from a host with the parallel pagination
+var ParallelPages = 8
+
+// Fetch requests a page of the list, the options select the page
+type Fetch[T any] func(options ...gitlab.RequestOptionFunc) ([]T, *gitlab.Response, error)
+
+// PaginateHost fetches all pages of the list with the pagination of the host.
+// keyset prepares the list options for the keyset pagination and returns false if they can't be used with it,
+// then the offset pagination is used, nil keyset means the list doesn't support it.
+// The caller holds the worker slot, it is released while the values of every page are emitted,
+// so a long list doesn't block the slot, with the parallel pagination every page takes a slot of its own.
+// emit is called concurrently with the parallel pagination.
+func PaginateHost[T any](slot sync.Locker, h *client.Host, keyset func() bool, fetch Fetch[T], emit Emit[T]) error {
+	switch h.Pagination {
+	case client.PaginationKeyset:
+		if keyset != nil && keyset() {
+			return paginateKeyset(slot, fetch, emit)
+		}
+	case client.PaginationParallel:
+		return paginateParallel(slot, fetch, emit)
+	}
+
+	return paginateOffset(slot, offsetPages(fetch), emit, 0)
+}
+
+// PaginateGithub fetches all pages of the github list one by one, fetch requests the page, 0 is the first one.
+// The slot is held the same way as in PaginateHost.
+func PaginateGithub[T any](slot sync.Locker, fetch func(page int) ([]T, *github.Response, error), emit Emit[T]) error {
+	return paginateOffset(slot, func(page int) ([]T, *gitlab.Response, error) {
+		list, resp, err := fetch(page)
+		if err != nil {
+			return nil, nil, err
+		}
+		return list, &gitlab.Response{Response: resp.Response, NextPage: resp.NextPage}, nil
+	}, emit, 0)
+}
+
+// Sets the page of the offset pagination
+func withPage(page int) gitlab.RequestOptionFunc {
+	return func(req *retryablehttp.Request) error {
+		q := req.URL.Query()
+		q.Set("page", strconv.Itoa(page))
+		req.URL.RawQuery = q.Encode()
+		return nil
+	}
+}
+
+func emitPage[T any](list []T, resp *gitlab.Response, emit Emit[T]) {
+	cached := resp.Header.Get("X-From-Cache") == "1"
+	for _, v := range list {
+		emit(v, cached)
+	}
+}
+
+// Requests the page of the offset pagination, the first page is requested without the page parameter
+func offsetPages[T any](fetch Fetch[T]) func(page int) ([]T, *gitlab.Response, error) {
+	return func(page int) ([]T, *gitlab.Response, error) {
+		if page == 0 {
+			return fetch()
+		}
+		return fetch(withPage(page))
+	}
+}
+
+// Fetches the page holding the slot and emits its values with the slot released
+func fetchPage[T any](slot sync.Locker, emit Emit[T], fetch func() ([]T, *gitlab.Response, error)) (*gitlab.Response, error) {
+	list, resp, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	slot.Unlock()
+	defer slot.Lock()
+
+	emitPage(list, resp, emit)
+
+	return resp, nil
+}
+
+func paginateOffset[T any](slot sync.Locker, fetch func(page int) ([]T, *gitlab.Response, error), emit Emit[T], page int) error {
+	for {
+		resp, err := fetchPage(slot, emit, func() ([]T, *gitlab.Response, error) { return fetch(page) })
+		if err != nil {
+			return err
+		}
+
+		if resp.NextPage == 0 {
+			return nil
+		}
+		page = resp.NextPage
+	}
+}
+
+func paginateKeyset[T any](slot sync.Locker, fetch Fetch[T], emit Emit[T]) error {
+	var options []gitlab.RequestOptionFunc
+	for {
+		resp, err := fetchPage(slot, emit, func() ([]T, *gitlab.Response, error) { return fetch(options...) })
+		if err != nil {
+			return err
+		}
+
+		if resp.NextLink == "" {
+			return nil
+		}
+		options = []gitlab.RequestOptionFunc{gitlab.WithKeysetPaginationParameters(resp.NextLink)}
+	}
+}
+
+func paginateParallel[T any](slot sync.Locker, fetch Fetch[T], emit Emit[T]) error {
+	resp, err := fetchPage(slot, emit, func() ([]T, *gitlab.Response, error) { return fetch() })
+	if err != nil {
+		return err
+	}
+
+	if resp.NextPage == 0 {
+		return nil
+	}
+
+	// The total is not returned for the large lists, the rest is fetched one page after another
+	if resp.TotalPages == 0 {
+		return paginateOffset(slot, offsetPages(fetch), emit, resp.NextPage)
+	}
+
+	// Every page takes a slot of its own, the slot of the caller is taken back when all of them are done
+	slot.Unlock()
+	defer slot.Lock()
+
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		done   = make(chan struct{})
+		failed error
+	)
+
+	// No more pages are fetched after the first error
+	fail := func(err error) {
+		once.Do(func() {
+			failed = err
+			close(done)
+		})
+	}
+
+	pages := make(chan int)
+	go func() {
+		defer close(pages)
+		for page := resp.NextPage; page <= resp.TotalPages; page++ {
+			select {
+			case pages <- page:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < ParallelPages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				slot.Lock()
+				list, resp, err := fetch(withPage(page))
+				slot.Unlock()
+				if err != nil {
+					fail(err)
+					return
+				}
+				emitPage(list, resp, emit)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// ProjectsKeyset prepares the options of the projects list for the keyset pagination,
+// it is supported with the order by id only
+func ProjectsKeyset(opt *gitlab.ListProjectsOptions) func() bool {
+	return func() bool {
+		if opt.OrderBy != nil && *opt.OrderBy != "id" {
+			return false
+		}
+		opt.Pagination = "keyset"
+		opt.OrderBy = gitlab.String("id")
+		if opt.Sort == nil {
+			opt.Sort = gitlab.String("asc")
+		}
+		return true
+	}
+}
+
+// UsersKeyset prepares the options of the users list for the keyset pagination,
+// it is supported with the order by id only
+func UsersKeyset(opt *gitlab.ListUsersOptions) func() bool {
+	return func() bool {
+		if opt.OrderBy != nil && *opt.OrderBy != "id" {
+			return false
+		}
+		opt.Pagination = "keyset"
+		opt.OrderBy = gitlab.String("id")
+		if opt.Sort == nil {
+			opt.Sort = gitlab.String("asc")
+		}
+		return true
+	}
+}
@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestPaginateHost(t *testing.T) {
+	const pages = 5
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		mu.Unlock()
+
+		q := r.URL.Query()
+		page := 1
+		if q.Get("pagination") == "keyset" {
+			if after, err := strconv.Atoi(q.Get("id_after")); err == nil {
+				page = after/2 + 1
+			}
+			if page < pages {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/api/v4/projects?id_after=%d&order_by=id&pagination=keyset&sort=asc>; rel="next"`,
+					"http://"+r.Host, page*2))
+			}
+		} else {
+			if p, err := strconv.Atoi(q.Get("page")); err == nil {
+				page = p
+			}
+			if page < pages {
+				w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+			}
+			w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"id": %d}, {"id": %d}]`, page*2-1, page*2)
+	}))
+	defer server.Close()
+
+	for _, pagination := range []string{"", client.PaginationOffset, client.PaginationKeyset, client.PaginationParallel} {
+		requests = nil
+
+		cli, err := client.NewClient(&config.Config{
+			Hosts: map[string]map[string]map[string]config.Host{
+				"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token", Pagination: pagination}}},
+			},
+			Threads: limiter.DefaultLimit,
+		})
+		assert.NoError(t, err)
+
+		h := cli.Hosts[0]
+		opt := gitlab.ListProjectsOptions{}
+		keyset := func() bool {
+			opt.Pagination = "keyset"
+			opt.OrderBy = gitlab.String("id")
+			return true
+		}
+
+		slot := limiter.NewLimiter(1)
+		slot.Lock()
+
+		var ids []int
+		err = PaginateHost(slot, h, keyset, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Project, *gitlab.Response, error) {
+			return h.Client.Projects.ListProjects(&opt, page...)
+		}, func(v *gitlab.Project, _ bool) {
+			mu.Lock()
+			ids = append(ids, v.ID)
+			mu.Unlock()
+		})
+		assert.NoError(t, err)
+		slot.Unlock()
+
+		slices.Sort(ids)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ids, pagination)
+		assert.Len(t, requests, pages, pagination)
+
+		for _, q := range requests {
+			assert.Equal(t, pagination == client.PaginationKeyset, strings.Contains(q, "pagination=keyset"), q)
+		}
+	}
+
+	_, err := client.NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token", Pagination: "cursor"}}},
+		},
+	})
+	assert.EqualError(t, err, `invalid pagination "cursor" for host "main.alfa.local", expected one of offset, keyset, parallel`)
+}
+
+func TestPaginateParallel(t *testing.T) {
+	const pages = 20
+
+	var (
+		mu                 sync.Mutex
+		requests, inflight int
+		maxInflight        int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		inflight++
+		maxInflight = max(maxInflight, inflight)
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+		}()
+
+		page := 1
+		if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil {
+			page = p
+		}
+		if r.Header.Get("Private-Token") == "broken" && page == 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if page < pages {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"id": %d}]`, page)
+	}))
+	defer server.Close()
+
+	paginate := func(token string) ([]int, error) {
+		cli, err := client.NewClient(&config.Config{
+			Hosts: map[string]map[string]map[string]config.Host{
+				"main": {"alfa": {"local": config.Host{URL: server.URL, Token: token, Pagination: client.PaginationParallel}}},
+			},
+			Threads: limiter.DefaultLimit,
+		})
+		assert.NoError(t, err)
+
+		h := cli.Hosts[0]
+
+		// The pages share the slots with the other tasks
+		slot := limiter.NewLimiter(2)
+		slot.Lock()
+		defer slot.Unlock()
+
+		var ids []int
+		err = PaginateHost(slot, h, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Project, *gitlab.Response, error) {
+			return h.Client.Projects.ListProjects(nil, page...)
+		}, func(v *gitlab.Project, _ bool) {
+			mu.Lock()
+			ids = append(ids, v.ID)
+			mu.Unlock()
+		})
+
+		return ids, err
+	}
+
+	defer func(n int) { ParallelPages = n }(ParallelPages)
+	ParallelPages = 1
+
+	ids, err := paginate("token")
+	assert.NoError(t, err)
+	assert.Len(t, ids, pages)
+	assert.Equal(t, pages, requests)
+
+	// The rest of the pages is not fetched after the error
+	requests = 0
+	_, err = paginate("broken")
+	assert.Error(t, err)
+	assert.Equal(t, 3, requests)
+
+	ParallelPages = 8
+	requests, maxInflight = 0, 0
+	ids, err = paginate("token")
+	assert.NoError(t, err)
+	assert.Len(t, ids, pages)
+	assert.LessOrEqual(t, maxInflight, 2)
+}
@@ -0,0 +1,290 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Element is a value fetched from the host
+type Element[T any] struct {
+	Host   *client.Host
+	Value  T
+	Cached bool
+}
+
+// Emit sends the value fetched from the host of the task to the next stage
+type Emit[T any] func(v T, cached bool)
+
+// Pipeline runs the tasks of the stages with a bounded number of workers.
+// Every stage closes its output when its own tasks are done, the limiter only bounds the workers
+// and collects the errors of all stages.
+type Pipeline struct {
+	ctx     context.Context
+	limiter *limiter.Limiter
+}
+
+func New(l *limiter.Limiter) *Pipeline {
+	return NewContext(context.Background(), l)
+}
+
+// NewContext returns the pipeline running the tasks with the context, e.g. to cancel their requests
+func NewContext(ctx context.Context, l *limiter.Limiter) *Pipeline {
+	return &Pipeline{ctx: ctx, limiter: l}
+}
+
+// Errors returns the errors of the tasks of all stages
+func (p *Pipeline) Errors() []limiter.Error {
+	return p.limiter.Errors()
+}
+
+// Slot is the worker slot held by the tasks, see PaginateHost
+func (p *Pipeline) Slot() sync.Locker {
+	return p.limiter
+}
+
+// Err joins the errors of the tasks of all stages prefixed with their hosts, nil if there are none
+func (p *Pipeline) Err() error {
+	return p.limiter.Err()
+}
+
+// Runs the task holding a worker, the error is reported for the host.
+// The requests of the task must be made with its context, see WithContext.
+func (p *Pipeline) run(stage *sync.WaitGroup, h *client.Host, fn func(ctx context.Context) error) {
+	stage.Add(1)
+	p.limiter.Add(1)
+
+	go func() {
+		defer stage.Done()
+		defer p.limiter.Done()
+
+		p.limiter.Lock()
+		ctx, done := p.limiter.Start(p.ctx, h)
+		err := fn(ctx)
+		done(err)
+		p.limiter.Unlock()
+
+		if err != nil {
+			p.limiter.Error(h, err)
+		}
+	}()
+}
+
+// WithContext returns the request options of the task with its context, e.g. to trace the requests in the task
+func WithContext(ctx context.Context, options []gitlab.RequestOptionFunc) []gitlab.RequestOptionFunc {
+	return append(slices.Clip(options), gitlab.WithContext(ctx))
+}
+
+func emitTo[T any](out chan<- Element[T], h *client.Host) Emit[T] {
+	return func(v T, cached bool) {
+		out <- Element[T]{Host: h, Value: v, Cached: cached}
+	}
+}
+
+// FromHosts is the first stage, fn is called for every host and the channel is closed when all of them are done
+func FromHosts[T any](p *Pipeline, hosts client.Hosts, fn func(ctx context.Context, h *client.Host, emit Emit[T]) error) <-chan Element[T] {
+	out := make(chan Element[T])
+
+	var stage sync.WaitGroup
+	for _, h := range hosts {
+		p.run(&stage, h, func(ctx context.Context) error { return fn(ctx, h, emitTo(out, h)) })
+	}
+
+	go func() {
+		stage.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// From passes the elements collected from the previous stages, e.g. the elements selected by the user
+func From[T any](elements []Element[T]) <-chan Element[T] {
+	out := make(chan Element[T])
+
+	go func() {
+		defer close(out)
+		for _, e := range elements {
+			out <- e
+		}
+	}()
+
+	return out
+}
+
+// Elements converts the elements of the sort results, e.g. the ones selected in the interactive mode,
+// their values must be of type T
+func Elements[T any](elements sort.Elements) []Element[T] {
+	list := make([]Element[T], 0, len(elements))
+	for _, v := range elements.Typed() {
+		list = append(list, Element[T]{Host: v.Host, Value: v.Struct.(T), Cached: bool(v.Cached)})
+	}
+	return list
+}
+
+// Hosts returns the host of every element
+func Hosts[T any](elements []Element[T]) client.Hosts {
+	hosts := make(client.Hosts, len(elements))
+	for i, e := range elements {
+		hosts[i] = e.Host
+	}
+	return hosts
+}
+
+// Then is the next stage, fn is called for every element of the previous stage as it arrives
+// and the channel is closed when the previous stage and all tasks of this one are done
+func Then[In, Out any](p *Pipeline, in <-chan Element[In], fn func(ctx context.Context, e Element[In], emit Emit[Out]) error) <-chan Element[Out] {
+	out := make(chan Element[Out])
+
+	go func() {
+		var stage sync.WaitGroup
+		for e := range in {
+			p.run(&stage, e.Host, func(ctx context.Context) error { return fn(ctx, e, emitTo(out, e.Host)) })
+		}
+		stage.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Filter passes only the elements matching fn
+func Filter[T any](in <-chan Element[T], fn func(e Element[T]) bool) <-chan Element[T] {
+	out := make(chan Element[T])
+
+	go func() {
+		defer close(out)
+		for e := range in {
+			if fn(e) {
+				out <- e
+			}
+		}
+	}()
+
+	return out
+}
+
+// Count passes the elements on and counts them, n is complete when the output is closed
+func Count[T any](in <-chan Element[T], n *int) <-chan Element[T] {
+	out := make(chan Element[T])
+
+	go func() {
+		defer close(out)
+		for e := range in {
+			*n++
+			out <- e
+		}
+	}()
+
+	return out
+}
+
+// Collect waits for all elements of the stage
+func Collect[T any](in <-chan Element[T]) []Element[T] {
+	list := make([]Element[T], 0)
+	for e := range in {
+		list = append(list, e)
+	}
+	return list
+}
+
+// Sort passes the elements to the channel accepted by sort.FromChannel and sort.Stream
+func Sort[T any](in <-chan Element[T]) chan interface{} {
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+		for e := range in {
+			out <- sort.Element{Host: e.Host, Struct: e.Value, Cached: sort.Cached(e.Cached)}
+		}
+	}()
+
+	return out
+}
+
+// Split passes the elements matching fn to the first channel and the others to the second one
+func Split[T any](in <-chan Element[T], fn func(e Element[T]) bool) (<-chan Element[T], <-chan Element[T]) {
+	matched, other := make(chan Element[T]), make(chan Element[T])
+
+	go func() {
+		defer close(matched)
+		defer close(other)
+		for e := range in {
+			if fn(e) {
+				matched <- e
+			} else {
+				other <- e
+			}
+		}
+	}()
+
+	return matched, other
+}
+
+// Merge passes the elements of all channels to one
+func Merge[T any](in ...<-chan Element[T]) <-chan Element[T] {
+	out := make(chan Element[T])
+
+	var wg sync.WaitGroup
+	for _, ch := range in {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range ch {
+				out <- e
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Batch groups the elements of every host by size, the rest is passed when the input is closed.
+// A batch is cached if all its elements are.
+func Batch[T any](in <-chan Element[T], size int) <-chan Element[[]T] {
+	out := make(chan Element[[]T])
+
+	go func() {
+		defer close(out)
+
+		batches := make(map[*client.Host]*Element[[]T])
+		hosts := make(client.Hosts, 0)
+
+		for e := range in {
+			b, ok := batches[e.Host]
+			if !ok {
+				b = &Element[[]T]{Host: e.Host, Value: make([]T, 0, size), Cached: true}
+				batches[e.Host] = b
+				hosts = append(hosts, e.Host)
+			}
+
+			b.Value = append(b.Value, e.Value)
+			b.Cached = b.Cached && e.Cached
+
+			if len(b.Value) >= size {
+				out <- *b
+				delete(batches, e.Host)
+			}
+		}
+
+		for _, h := range hosts {
+			if b, ok := batches[h]; ok {
+				out <- *b
+				delete(batches, h)
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestPipeline(t *testing.T) {
+	mux := http.NewServeMux()
+	// 3 pages of 2 projects
+	mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if page < 3 {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"id": %d}, {"id": %d}]`, page*2-1, page*2)
+	})
+	mux.HandleFunc("/api/v4/projects/{id}/languages", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "3" {
+			http.Error(w, `{"message": "404 Project Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"Go": 100}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := client.NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {
+				"alfa": {"local": config.Host{URL: server.URL, Token: "token"}},
+				"beta": {"local": config.Host{URL: server.URL, Token: "token"}},
+			},
+		},
+		Threads: 1,
+	})
+	assert.NoError(t, err)
+
+	// A single worker is shared by the stages
+	p := New(limiter.NewLimiter(1))
+
+	projects := FromHosts(p, cli.Hosts, func(ctx context.Context, h *client.Host, emit Emit[*gitlab.Project]) error {
+		return PaginateHost(p.Slot(), h, nil, func(page ...gitlab.RequestOptionFunc) ([]*gitlab.Project, *gitlab.Response, error) {
+			return h.Client.Projects.ListProjects(nil, page...)
+		}, emit)
+	})
+
+	var found int
+	filtered := Filter(Count(projects, &found), func(e Element[*gitlab.Project]) bool { return e.Value.ID != 6 })
+
+	languages := Collect(Then(p, filtered, func(ctx context.Context, e Element[*gitlab.Project], emit Emit[*gitlab.ProjectLanguages]) error {
+		v, resp, err := e.Host.Client.Projects.GetProjectLanguages(e.Value.ID)
+		if err != nil {
+			return err
+		}
+		emit(v, resp.Header.Get("X-From-Cache") == "1")
+		return nil
+	}))
+
+	// 6 projects of every host without the filtered and the failed one
+	assert.Len(t, languages, 8)
+	assert.Equal(t, 12, found)
+	for _, e := range languages {
+		assert.Equal(t, float32(100), (*e.Value)["Go"])
+	}
+
+	assert.Len(t, p.Errors(), 2)
+	hosts := make(map[*client.Host]bool)
+	for _, err := range p.Errors() {
+		hosts[err.Host] = true
+	}
+	assert.Len(t, hosts, 2)
+}
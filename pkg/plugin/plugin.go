@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flant/glaball/pkg/config"
+)
+
+const (
+	// Prefix is the prefix of the plugin executables: glaball-<name>
+	Prefix = config.ApplicationName + "-"
+
+	// EnvHostsFD is the file descriptor of the pipe the plugin reads the selected hosts from
+	EnvHostsFD = "GLABALL_HOSTS_FD"
+	// EnvCachePath is the path of the cache directory
+	EnvCachePath = "GLABALL_CACHE_PATH"
+	// EnvOutput is the output format requested by the user
+	EnvOutput = "GLABALL_OUTPUT"
+	// EnvThreads is the number of concurrent requests
+	EnvThreads = "GLABALL_THREADS"
+)
+
+// Plugin is the glaball-<name> executable found in PATH
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// Host is the selected host passed to the plugin, the token is only sent through the pipe
+type Host struct {
+	Name       string `json:"name"`
+	Team       string `json:"team"`
+	Project    string `json:"project"`
+	Host       string `json:"host"`
+	Type       string `json:"type"`
+	URL        string `json:"url"`
+	IP         string `json:"ip,omitempty"`
+	Org        string `json:"org,omitempty"`
+	Token      string `json:"token"`
+	Pagination string `json:"pagination,omitempty"`
+}
+
+// Options are passed to the plugin in the environment
+type Options struct {
+	CachePath string
+	Output    string
+	Threads   int
+}
+
+// Find returns the plugins in the directories of the path list sorted by name,
+// the first executable with the name wins like in the shell
+func Find(path string) []Plugin {
+	seen := make(map[string]bool)
+	plugins := make([]Plugin, 0)
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), Prefix)
+			if !ok || name == "" || e.IsDir() {
+				continue
+			}
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if seen[name] {
+				continue
+			}
+			file := filepath.Join(dir, e.Name())
+			if !executable(file) {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, Plugin{Name: name, Path: file})
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+
+	return plugins
+}
+
+func executable(file string) bool {
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if _, err := exec.LookPath(file); err != nil {
+		return false
+	}
+	return true
+}
+
+// Run runs the plugin with the arguments, the hosts are written to the pipe passed as the file descriptor 3
+// and the options are set in the environment
+func (p Plugin) Run(args []string, hosts []Host, opt Options) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(p.Path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.Env = append(os.Environ(),
+		EnvHostsFD+"=3",
+		EnvCachePath+"="+opt.CachePath,
+		EnvOutput+"="+opt.Output,
+		EnvThreads+"="+strconv.Itoa(opt.Threads),
+	)
+
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to run plugin %q: %v", p.Name, err)
+	}
+
+	// The plugin may not read the hosts at all, the pipe is closed on exit then
+	go func() {
+		defer w.Close()
+		json.NewEncoder(w).Encode(hosts)
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("plugin %q failed: %v", p.Name, err)
+	}
+
+	return nil
+}
+
+// Hosts reads the hosts passed by glaball, it is called by the plugins written in go
+func Hosts() ([]Host, error) {
+	fd, err := strconv.Atoi(os.Getenv(EnvHostsFD))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not set, the plugin must be run by %s", EnvHostsFD, config.ApplicationName)
+	}
+
+	f := os.NewFile(uintptr(fd), "hosts")
+	if f == nil {
+		return nil, fmt.Errorf("invalid %s: %d", EnvHostsFD, fd)
+	}
+	defer f.Close()
+
+	var hosts []Host
+	if err := json.NewDecoder(f).Decode(&hosts); err != nil {
+		return nil, fmt.Errorf("failed to read hosts: %v", err)
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeScript(t *testing.T, dir, name, script string, mode os.FileMode) string {
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"+script), mode); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestFind(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+
+	hello := writeScript(t, first, "glaball-hello", "", 0755)
+	writeScript(t, second, "glaball-hello", "", 0755)
+	report := writeScript(t, second, "glaball-report", "", 0755)
+	writeScript(t, second, "glaball-noexec", "", 0644)
+	writeScript(t, second, "other", "", 0755)
+	assert.NoError(t, os.Mkdir(filepath.Join(second, "glaball-dir"), 0755))
+
+	path := strings.Join([]string{first, filepath.Join(first, "missing"), second}, string(os.PathListSeparator))
+	assert.Equal(t, []Plugin{
+		{Name: "hello", Path: hello},
+		{Name: "report", Path: report},
+	}, Find(path))
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+
+	p := Plugin{Name: "dump", Path: writeScript(t, dir, "glaball-dump",
+		`echo "$@" > `+out+`.args
+echo "$GLABALL_CACHE_PATH $GLABALL_OUTPUT $GLABALL_THREADS" > `+out+`.env
+cat <&$GLABALL_HOSTS_FD > `+out+`
+`, 0755)}
+
+	hosts := []Host{{Name: "main.alfa.local", Team: "main", Project: "alfa", Host: "local", Type: "gitlab",
+		URL: "https://gitlab.example.com", Token: "secret"}}
+
+	assert.NoError(t, p.Run([]string{"list", "--all"}, hosts, Options{CachePath: "/tmp/cache", Output: "json", Threads: 4}))
+
+	args, err := os.ReadFile(out + ".args")
+	assert.NoError(t, err)
+	assert.Equal(t, "list --all\n", string(args))
+
+	env, err := os.ReadFile(out + ".env")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/cache json 4\n", string(env))
+
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	var got []Host
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, hosts, got)
+
+	failed := Plugin{Name: "fail", Path: writeScript(t, dir, "glaball-fail", "exit 3\n", 0755)}
+	assert.EqualError(t, failed.Run(nil, hosts, Options{}), `plugin "fail" failed: exit status 3`)
+}
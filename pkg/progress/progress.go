@@ -0,0 +1,272 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	ModeAuto = "auto"
+	ModeBar  = "bar"
+	ModeLog  = "log"
+	ModeOff  = "off"
+)
+
+var (
+	// BarInterval is the refresh interval of the live bar
+	BarInterval = 200 * time.Millisecond
+	// LogInterval is the interval of the progress log lines
+	LogInterval = 5 * time.Second
+)
+
+const barWidth = 20
+
+type hostStats struct {
+	inFlight, pages, cached, errors int
+	last                            time.Time
+}
+
+// Tracker counts the tasks of the limiter and the requests to every host.
+// It implements limiter.Progress and client.Observer.
+type Tracker struct {
+	mu    sync.Mutex
+	start time.Time
+	hosts client.Hosts
+	stats map[*client.Host]*hostStats
+
+	tasks, done                     int
+	inFlight, pages, cached, errors int
+
+	// The bar is drawn on w while there are tasks in progress
+	bar   bool
+	drawn bool
+	w     io.Writer
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func New(hosts client.Hosts) *Tracker {
+	t := Tracker{
+		start: time.Now(),
+		hosts: hosts,
+		stats: make(map[*client.Host]*hostStats, len(hosts)),
+	}
+	for _, h := range hosts {
+		t.stats[h] = &hostStats{}
+	}
+	return &t
+}
+
+// Returns stats of the host, nil hosts are counted in the totals only
+func (t *Tracker) host(h *client.Host) *hostStats {
+	if s, ok := t.stats[h]; ok {
+		return s
+	}
+	return &hostStats{}
+}
+
+func (t *Tracker) TaskAdded(delta int) {
+	t.mu.Lock()
+	t.tasks += delta
+	t.mu.Unlock()
+}
+
+func (t *Tracker) TaskDone() {
+	t.mu.Lock()
+	t.done++
+	// Clear the bar before the results are printed
+	if t.done == t.tasks {
+		t.clear()
+	}
+	t.mu.Unlock()
+}
+
+func (t *Tracker) TaskError(h *client.Host, _ error) {
+	t.mu.Lock()
+	t.errors++
+	t.host(h).errors++
+	t.mu.Unlock()
+}
+
+func (t *Tracker) RequestStarted(h *client.Host, _ *http.Request) {
+	t.mu.Lock()
+	t.inFlight++
+	t.host(h).inFlight++
+	t.mu.Unlock()
+}
+
+func (t *Tracker) RequestFinished(h *client.Host, _ *http.Request, resp *http.Response, err error, _ time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.host(h)
+	t.inFlight--
+	s.inFlight--
+	s.last = time.Now()
+
+	if err != nil {
+		return
+	}
+
+	t.pages++
+	s.pages++
+	if resp.Header.Get("X-From-Cache") == "1" {
+		t.cached++
+		s.cached++
+	}
+}
+
+// String returns the current progress line
+func (t *Tracker) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.line()
+}
+
+func (t *Tracker) line() string {
+	completed := 0
+	for _, s := range t.stats {
+		if s.inFlight == 0 && (s.pages > 0 || s.errors > 0) {
+			completed++
+		}
+	}
+
+	percent := 0
+	if t.tasks > 0 {
+		percent = t.done * 100 / t.tasks
+	}
+
+	eta := "?"
+	if t.done > 0 {
+		elapsed := time.Since(t.start)
+		eta = (elapsed * time.Duration(t.tasks-t.done) / time.Duration(t.done)).Round(time.Second).String()
+	}
+
+	filled := percent * barWidth / 100
+	return fmt.Sprintf("[%s%s] %3d%% tasks %d/%d, in flight %d, pages %d, cached %d, errors %d, hosts %d/%d, eta %s",
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), percent,
+		t.done, t.tasks, t.inFlight, t.pages, t.cached, t.errors, completed, len(t.hosts), eta)
+}
+
+func (t *Tracker) active() bool {
+	return t.tasks > 0 || t.pages > 0
+}
+
+func (t *Tracker) draw() {
+	if t.done < t.tasks {
+		fmt.Fprint(t.w, "\r\033[K"+t.line())
+		t.drawn = true
+	}
+}
+
+func (t *Tracker) clear() {
+	if t.drawn {
+		fmt.Fprint(t.w, "\r\033[K")
+		t.drawn = false
+	}
+}
+
+// Start renders the progress until Stop is called: a live bar on the terminal or periodic log lines.
+// Auto mode selects the bar if w is a terminal.
+func (t *Tracker) Start(mode string, w *os.File) error {
+	switch mode {
+	case ModeOff:
+		return nil
+	case ModeAuto:
+		mode = ModeLog
+		if isatty.IsTerminal(w.Fd()) || isatty.IsCygwinTerminal(w.Fd()) {
+			mode = ModeBar
+		}
+	case ModeBar, ModeLog:
+	default:
+		return fmt.Errorf("invalid progress mode %q, expected one of %s, %s, %s, %s", mode, ModeAuto, ModeBar, ModeLog, ModeOff)
+	}
+
+	t.mu.Lock()
+	t.bar, t.w = mode == ModeBar, w
+	t.mu.Unlock()
+
+	t.stop = make(chan struct{})
+	t.stopped = make(chan struct{})
+
+	go t.render()
+
+	return nil
+}
+
+func (t *Tracker) render() {
+	defer close(t.stopped)
+
+	interval := LogInterval
+	if t.bar {
+		interval = BarInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			t.mu.Lock()
+			t.clear()
+			t.mu.Unlock()
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			switch {
+			case !t.active() || t.done == t.tasks:
+			case t.bar:
+				t.draw()
+			default:
+				hclog.L().Named("progress").Info(t.line())
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Stop stops rendering and logs the completion of every host
+func (t *Tracker) Stop() {
+	if t.stop == nil {
+		return
+	}
+
+	select {
+	case <-t.stop:
+		return
+	default:
+		close(t.stop)
+	}
+	<-t.stopped
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.active() {
+		return
+	}
+
+	logger := hclog.L().Named("progress")
+	for _, h := range t.hosts {
+		s := t.stats[h]
+		if s.pages == 0 && s.errors == 0 {
+			continue
+		}
+		logger.Debug("host completed", "host", h.FullName(), "pages", s.pages, "cached", s.cached,
+			"errors", s.errors, "duration", s.last.Sub(t.start).Round(time.Millisecond))
+	}
+	logger.Debug("completed", "tasks", t.done, "pages", t.pages, "cached", t.cached,
+		"errors", t.errors, "duration", time.Since(t.start).Round(time.Millisecond))
+}
@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	cli, err := client.NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token"}}},
+		},
+		Threads: limiter.DefaultLimit,
+	})
+	assert.NoError(t, err)
+
+	tracker := New(cli.Hosts)
+	wg := limiter.NewLimiter(limiter.DefaultLimit)
+	wg.Notify(tracker)
+	cli.Observe(tracker)
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := cli.Hosts[0].Client.Users.ListUsers(nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Add(1)
+	wg.Error(cli.Hosts[0], assert.AnError)
+	wg.Done()
+	wg.Wait()
+
+	assert.Equal(t, "[====================] 100% tasks 4/4, in flight 0, pages 3, cached 0, errors 1, hosts 1/1, eta 0s", tracker.String())
+
+	stats := tracker.stats[cli.Hosts[0]]
+	assert.Equal(t, 3, stats.pages)
+	assert.Equal(t, 1, stats.errors)
+}
@@ -0,0 +1,160 @@
+package sort
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	aggregateSum = "sum"
+	aggregateAvg = "avg"
+	aggregateMin = "min"
+	aggregateMax = "max"
+)
+
+// AggregateFuncs are the functions of the aggregations
+var AggregateFuncs = []string{aggregateSum, aggregateAvg, aggregateMin, aggregateMax}
+
+// Aggregation is a function computed over the field values of grouped elements, e.g. "sum:statistics.repository_size"
+type Aggregation struct {
+	Func, Field string
+	index       []int
+	integer     bool
+}
+
+// ParseAggregations validates "func:field" specs against the struct type.
+// sum and avg are only allowed for numeric fields, min and max for any scalar field.
+func ParseAggregations(specs []string, structType interface{}) ([]Aggregation, error) {
+	m := mapper.TypeMap(reflect.TypeOf(structType))
+	aggregations := make([]Aggregation, 0, len(specs))
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		fn, field, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid aggregation %q, expected func:field", spec)
+		}
+
+		fn = strings.ToLower(fn)
+		switch fn {
+		case aggregateSum, aggregateAvg, aggregateMin, aggregateMax:
+		default:
+			return nil, fmt.Errorf("invalid aggregation function %q, expected one of %s, %s, %s, %s",
+				fn, aggregateSum, aggregateAvg, aggregateMin, aggregateMax)
+		}
+
+		fi := m.GetByPath(field)
+		if fi == nil || len(fi.Index) == 0 {
+			return nil, fmt.Errorf("invalid struct field: %s", field)
+		}
+
+		t := fi.Field.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		a := Aggregation{Func: fn, Field: field, index: fi.Index}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			a.integer = true
+		case reflect.Float32, reflect.Float64:
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.Interface:
+			return nil, fmt.Errorf("unable to aggregate non-scalar field: %s", field)
+		default:
+			if fn == aggregateSum || fn == aggregateAvg {
+				return nil, fmt.Errorf("unable to %s non-numeric field: %s", fn, field)
+			}
+		}
+
+		aggregations = append(aggregations, a)
+	}
+
+	return aggregations, nil
+}
+
+// Name returns the aggregation spec
+func (a Aggregation) Name() string {
+	return a.Func + ":" + a.Field
+}
+
+// Apply computes the aggregation over the elements, nil values are skipped.
+// Returns nil if there are no values.
+func (a Aggregation) Apply(elements Elements) interface{} {
+	var (
+		sum, n float64
+		isum   int64
+		best   reflect.Value
+		key    whereValue
+	)
+
+	for _, v := range elements {
+		fv := FieldByIndexes(reflect.ValueOf(v.(Element).Struct), a.index)
+		for fv.IsValid() && (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) {
+			if fv.IsNil() {
+				fv = reflect.Value{}
+				break
+			}
+			fv = fv.Elem()
+		}
+		if !fv.IsValid() {
+			continue
+		}
+
+		k := whereValueOf(fv)
+		n++
+
+		switch a.Func {
+		case aggregateSum, aggregateAvg:
+			sum += k.n
+			switch fv.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				isum += fv.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				isum += int64(fv.Uint())
+			}
+		case aggregateMin, aggregateMax:
+			c := 0
+			if best.IsValid() {
+				c = compareKeys(k, key)
+			}
+			if !best.IsValid() || (a.Func == aggregateMin && c < 0) || (a.Func == aggregateMax && c > 0) {
+				best, key = fv, k
+			}
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	switch a.Func {
+	case aggregateSum:
+		if a.integer {
+			return isum
+		}
+		return sum
+	case aggregateAvg:
+		return sum / n
+	}
+
+	return best.Interface()
+}
+
+// Aggregate computes the aggregations over the elements
+func Aggregate(aggregations []Aggregation, elements Elements) []interface{} {
+	if len(aggregations) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(aggregations))
+	for i, a := range aggregations {
+		values[i] = a.Apply(elements)
+	}
+	return values
+}
@@ -0,0 +1,50 @@
+package sort
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestAggregate(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	alfa := &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	beta := &client.Host{Team: "main", Project: "beta", Name: "local"}
+
+	ch := make(chan interface{}, 4)
+	for _, e := range []Element{
+		{Host: alfa, Struct: &gitlab.Project{Name: "a", StarCount: 1, LastActivityAt: day(3), Statistics: &gitlab.Statistics{RepositorySize: 100}}},
+		{Host: alfa, Struct: &gitlab.Project{Name: "b", StarCount: 2, LastActivityAt: day(1)}},
+		{Host: beta, Struct: &gitlab.Project{Name: "c", StarCount: 4, LastActivityAt: day(2), Statistics: &gitlab.Statistics{RepositorySize: 50}}},
+		{Host: beta, Struct: &gitlab.Project{Name: "d", StarCount: 8, Statistics: &gitlab.Statistics{RepositorySize: 25}}},
+	} {
+		ch <- e
+	}
+	close(ch)
+
+	results, err := FromChannel(ch, &Options{
+		GroupBy:    "host",
+		OrderBy:    []string{"host:asc"},
+		Aggregate:  []string{"sum:statistics.repository_size", "avg:star_count", "min:last_activity_at", "max:name"},
+		StructType: gitlab.Project{},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	assert.Equal(t, "alfa.local", results[0].Key)
+	assert.Equal(t, []interface{}{int64(100), 1.5, *day(1), "b"}, results[0].Aggregates)
+	assert.Equal(t, "beta.local", results[1].Key)
+	assert.Equal(t, []interface{}{int64(75), 6.0, *day(2), "d"}, results[1].Aggregates)
+
+	for _, spec := range []string{"sum:name", "median:star_count", "max:topics", "star_count", "sum:unknown"} {
+		_, err := ParseAggregations([]string{spec}, gitlab.Project{})
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,72 @@
+package sort
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	columnCached = "cached"
+)
+
+// Column is a json field path of the result elements or one of the pseudo fields: host, count, cached
+type Column struct {
+	Name  string
+	index []int
+}
+
+// ParseColumns validates column names against the struct type
+func ParseColumns(names []string, structType interface{}) ([]Column, error) {
+	m := mapper.TypeMap(reflect.TypeOf(structType))
+	columns := make([]Column, 0, len(names))
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case byHostFI.Name, byLenFI.Name, columnCached:
+			columns = append(columns, Column{Name: name})
+			continue
+		}
+
+		fi := m.GetByPath(name)
+		if fi == nil || len(fi.Index) == 0 {
+			return nil, fmt.Errorf("invalid struct field: %s", name)
+		}
+		columns = append(columns, Column{Name: name, index: fi.Index})
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns specified")
+	}
+
+	return columns, nil
+}
+
+// Value returns the column value of the result element or nil if any of the parent structs is nil
+func (c Column) Value(r Result, e Element) interface{} {
+	switch c.Name {
+	case byHostFI.Name:
+		return e.Host.ProjectName()
+	case byLenFI.Name:
+		return r.Count
+	case columnCached:
+		return e.Cached
+	}
+
+	v := FieldByIndexes(reflect.ValueOf(e.Struct), c.index)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return nil
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,141 @@
+package sort
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/ahmetb/go-linq"
+)
+
+const (
+	directionAsc  = "asc"
+	directionDesc = "desc"
+)
+
+// Key is a typed value of the field used to order results.
+// Numbers, times and bools are compared by their values, strings are compared
+// in natural case-insensitive order ("item2" < "item10"), nil values go first.
+type Key struct {
+	v whereValue
+}
+
+// KeyOf returns the typed key of the field value
+func KeyOf(v reflect.Value) Key {
+	return Key{whereValueOf(v)}
+}
+
+func (k Key) CompareTo(c linq.Comparable) int {
+	return compareKeys(k.v, c.(Key).v)
+}
+
+func compareKeys(a, b whereValue) int {
+	if a.kind != b.kind {
+		// Values of different types, including nil, are ordered by their kind
+		if a.kind < b.kind {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.kind {
+	case kindString:
+		return naturalCompare(strings.ToLower(a.s), strings.ToLower(b.s))
+	case kindList:
+		for i := 0; i < len(a.list) && i < len(b.list); i++ {
+			if c := compareKeys(a.list[i], b.list[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(a.list), len(b.list))
+	}
+
+	c, _ := compareValues(a, b)
+	return c
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// naturalCompare compares strings treating digit sequences as numbers
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitsPrefix(a), digitsPrefix(b)
+			// Compare numbers without leading zeros by length and then lexically
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if c := compareInts(len(ta), len(tb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return strings.Compare(a[:1], b[:1])
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return compareInts(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c < unicode.MaxASCII && unicode.IsDigit(rune(c))
+}
+
+func digitsPrefix(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// ParseOrderBy splits "field:direction" into the field path and direction.
+// Direction is empty if not specified.
+func ParseOrderBy(key string) (string, string, error) {
+	field, direction, ok := strings.Cut(key, ":")
+	if !ok {
+		return key, "", nil
+	}
+
+	switch direction = strings.ToLower(direction); direction {
+	case directionAsc, directionDesc:
+		return field, direction, nil
+	}
+
+	return "", "", fmt.Errorf("invalid order direction %q of %q, expected %s or %s", direction, field, directionAsc, directionDesc)
+}
+
+// Returns if the order key is descending. The direction of the key is absolute,
+// --sort asc reverses the default direction of the keys without it.
+func descending(direction string, byDefault bool, sortBy string) bool {
+	switch direction {
+	case directionAsc:
+		return false
+	case directionDesc:
+		return true
+	}
+	return byDefault != (sortBy == directionAsc)
+}
+
+// Fields returns field paths of the order keys without directions
+func Fields(keys []string) []string {
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i], _, _ = strings.Cut(k, ":")
+	}
+	return fields
+}
@@ -0,0 +1,77 @@
+package sort
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestCompareKeys(t *testing.T) {
+	key := func(v interface{}) Key { return KeyOf(reflect.ValueOf(v)) }
+
+	for _, tc := range []struct {
+		a, b interface{}
+		want int
+	}{
+		{9, 100, -1},
+		{"item2", "item10", -1},
+		{"Beta", "alpha", 1},
+		{"v1.9.0", "v1.10.0", -1},
+		{(*time.Time)(nil), time.Now(), -1},
+		{time.Unix(2, 0), time.Unix(1, 0), 1},
+		{[]string{"a", "b"}, []string{"a"}, 1},
+		{"x01", "x1", 0},
+	} {
+		assert.Equal(t, tc.want, key(tc.a).CompareTo(key(tc.b)), "%v <=> %v", tc.a, tc.b)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	order := func(sortBy string, orderBy ...string) []string {
+		ch := make(chan interface{}, 4)
+		for _, p := range []gitlab.Project{
+			{ID: 9, Name: "b", CreatedAt: day(2)},
+			{ID: 100, Name: "a", CreatedAt: day(1)},
+			{ID: 10, Name: "c", CreatedAt: day(2)},
+			{ID: 1, Name: "d"},
+		} {
+			p := p
+			ch <- Element{Host: &client.Host{Team: "main", Project: "gitlab", Name: "local"}, Struct: &p}
+		}
+		close(ch)
+
+		results, err := FromChannel(ch, &Options{
+			OrderBy:    orderBy,
+			SortBy:     sortBy,
+			StructType: gitlab.Project{},
+		})
+		assert.NoError(t, err)
+
+		names := make([]string, 0, len(results))
+		for _, r := range results {
+			names = append(names, r.Elements.Typed()[0].Struct.(*gitlab.Project).Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"d", "a", "c", "b"}, order("", "created_at:asc", "id:desc"))
+	// The direction of the key is not reversed by --sort
+	assert.Equal(t, []string{"d", "a", "c", "b"}, order("asc", "created_at:asc", "id:desc"))
+	assert.Equal(t, []string{"d", "a", "b", "c"}, order("asc", "created_at:asc", "id"))
+	assert.Equal(t, []string{"c", "b", "a", "d"}, order("desc", "created_at", "id"))
+
+	_, err := FromChannel(make(chan interface{}), &Options{
+		OrderBy:    []string{"id:up"},
+		StructType: gitlab.Project{},
+	})
+	assert.Error(t, err)
+}
@@ -5,6 +5,8 @@ import (
 	"reflect"
 	"strings"
 
+	go_sort "sort"
+
 	"github.com/ahmetb/go-linq"
 	"github.com/flant/glaball/pkg/client"
 	"github.com/jmoiron/sqlx/reflectx"
@@ -25,8 +27,15 @@ var (
 )
 
 type Options struct {
+	// GroupBy is a comma separated list of fields, every next field groups the elements of the previous level
 	SortBy, GroupBy string
 
+	// Where is a filter expression, see ParseWhere
+	Where string
+
+	// Aggregate is a list of "func:field" aggregations computed per result, see ParseAggregations
+	Aggregate []string
+
 	OrderBy    []string
 	StructType interface{}
 }
@@ -36,6 +45,14 @@ type Result struct {
 	Key      string
 	Elements Elements
 	Cached   Cached
+
+	// Field is the group field of the result, empty for single elements
+	Field string
+	// Groups are the nested results of multi-level grouping
+	Groups []Result
+
+	// Aggregates are the values of Options.Aggregate in the same order
+	Aggregates []interface{}
 }
 
 type Element struct {
@@ -93,77 +110,139 @@ func FromChannel(ch chan interface{}, opt *Options) ([]Result, error) {
 }
 
 func FromChannelQuery(ch chan interface{}, opt *Options) (linq.Query, error) {
+	return fromQuery(linq.FromChannel(ch), opt)
+}
+
+func fromQuery(query linq.Query, opt *Options) (linq.Query, error) {
 	var (
-		query        linq.Query
 		orderedQuery linq.OrderedQuery
 		groupBy      *reflectx.FieldInfo
 		first        *reflectx.FieldInfo
 	)
 
-	query = linq.FromChannel(ch)
+	if opt.Where != "" {
+		where, err := ParseWhere(opt.Where, opt.StructType)
+		if err != nil {
+			return linq.Query{}, err
+		}
+		query = query.Where(func(i interface{}) bool { return where.Match(i.(Element)) })
+	}
+
+	aggregations, err := ParseAggregations(opt.Aggregate, opt.StructType)
+	if err != nil {
+		return linq.Query{}, err
+	}
+
 	m := mapper.TypeMap(reflect.TypeOf(opt.StructType))
 	m.Paths[byHostFI.Name] = &byHostFI
 	m.Names[byHostFI.Name] = &byHostFI
 	m.Paths[byLenFI.Path] = &byLenFI
 	m.Names[byLenFI.Name] = &byLenFI
 
-	if f := opt.GroupBy; f != "" {
+	f, nested, _ := strings.Cut(opt.GroupBy, ",")
+	if f = strings.TrimSpace(f); f != "" {
 		groupBy = m.GetByPath(f)
 		if groupBy == nil {
 			return linq.Query{}, fmt.Errorf("invalid struct field: %s", f)
 		}
 		query = query.GroupBy(GroupBy(groupBy))
-
 	}
 
-	if f := opt.OrderBy; f != nil {
-		first = m.GetByPath(f[0])
-		if first == nil {
-			return linq.Query{}, fmt.Errorf("invalid struct field: %s", f[0])
+	// Every nested level is grouped by the same options
+	var nestedOpt *Options
+	if nested != "" {
+		nestedOpt = &Options{
+			SortBy:     opt.SortBy,
+			GroupBy:    nested,
+			OrderBy:    opt.OrderBy,
+			Aggregate:  opt.Aggregate,
+			StructType: opt.StructType,
+		}
+		if _, err := fromQuery(linq.From([]interface{}{}), nestedOpt); err != nil {
+			return linq.Query{}, err
 		}
 	}
 
-	orderedQuery = query.OrderByDescending(OrderBy(groupBy, first))
-
-	if groupBy != nil && first != nil && first.Name == byLenFI.Name {
-		orderedQuery = query.OrderBy(ByLen())
-		orderedQuery = orderedQuery.ThenByDescending(ByKey())
+	if len(opt.OrderBy) == 0 {
+		return linq.Query{}, fmt.Errorf("no order fields specified")
 	}
 
-	for _, key := range opt.OrderBy[1:] {
-		v := m.GetByPath(key)
+	for i, key := range opt.OrderBy {
+		field, direction, err := ParseOrderBy(key)
+		if err != nil {
+			return linq.Query{}, err
+		}
+
+		v := m.GetByPath(field)
 		if v == nil {
-			return linq.Query{}, fmt.Errorf("invalid struct field: %s", key)
+			return linq.Query{}, fmt.Errorf("invalid struct field: %s", field)
+		}
+
+		if i > 0 {
+			orderedQuery = thenBy(orderedQuery, OrderBy(groupBy, v), descending(direction, true, opt.SortBy))
+			continue
+		}
+
+		first = v
+		// Groups are ordered by count in ascending order and then by key by default
+		if groupBy != nil && first.Name == byLenFI.Name {
+			if descending(direction, false, opt.SortBy) {
+				orderedQuery = query.OrderByDescending(ByLen())
+			} else {
+				orderedQuery = query.OrderBy(ByLen())
+			}
+			orderedQuery = thenBy(orderedQuery, ByKey(), descending("", true, opt.SortBy))
+			continue
+		}
+
+		if descending(direction, true, opt.SortBy) {
+			orderedQuery = query.OrderByDescending(OrderBy(groupBy, first))
+		} else {
+			orderedQuery = query.OrderBy(OrderBy(groupBy, first))
 		}
-		orderedQuery = orderedQuery.ThenByDescending(OrderBy(groupBy, v))
 	}
 
-	query = orderedQuery.Query
-	if opt.SortBy == "asc" {
-		query = orderedQuery.Reverse()
+	// Make the order of equal results stable across runs
+	orderedQuery = thenBy(orderedQuery, ByHostName(), descending("", true, opt.SortBy))
+	if groupBy != nil {
+		orderedQuery = thenBy(orderedQuery, ByKey(), descending("", false, opt.SortBy))
 	}
 
+	query = orderedQuery.Query
+
 	query = query.Select(func(i interface{}) interface{} {
 		// Check if we have a group or single element
 		switch v := i.(type) {
 		case Element:
-			key, err := ValidFieldValue(opt.OrderBy, v.Struct)
+			key, err := ValidFieldValue(Fields(opt.OrderBy), v.Struct)
 			if err != nil {
 				return nil
 			}
 			return Result{
-				Count:    1, // Count of single element is always 1
-				Key:      fmt.Sprint(key),
-				Elements: Elements{v},
-				Cached:   v.Cached,
+				Count:      1, // Count of single element is always 1
+				Key:        fmt.Sprint(key),
+				Elements:   Elements{v},
+				Cached:     v.Cached,
+				Aggregates: Aggregate(aggregations, Elements{v}),
 			}
 		case linq.Group:
-			return Result{
-				Count:    len(v.Group),
-				Key:      fmt.Sprint(v.Key),
-				Elements: Elements(v.Group),
-				Cached:   Elements(v.Group).Cached(),
+			go_sort.SliceStable(v.Group, func(i, j int) bool {
+				return v.Group[i].(Element).Host.FullName() < v.Group[j].(Element).Host.FullName()
+			})
+			r := Result{
+				Count:      len(v.Group),
+				Key:        fmt.Sprint(v.Key),
+				Elements:   Elements(v.Group),
+				Cached:     Elements(v.Group).Cached(),
+				Field:      groupBy.Path,
+				Aggregates: Aggregate(aggregations, Elements(v.Group)),
 			}
+			if nestedOpt != nil {
+				// Options are already validated
+				q, _ := fromQuery(linq.From(v.Group), nestedOpt)
+				q.ToSlice(&r.Groups)
+			}
+			return r
 		default:
 			return v
 		}
@@ -172,13 +251,48 @@ func FromChannelQuery(ch chan interface{}, opt *Options) (linq.Query, error) {
 	return query, nil
 }
 
+// Nested checks if the results have multi-level groups
+func Nested(results []Result) bool {
+	for _, r := range results {
+		if r.Groups != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func GroupBy(groupBy *reflectx.FieldInfo) (func(i interface{}) interface{}, func(i interface{}) interface{}) {
 	return func(i interface{}) interface{} {
-			return reflectx.FieldByIndexesReadOnly(reflect.ValueOf(i.(Element).Struct), groupBy.Index).Interface()
+			if groupBy.Name == byHostFI.Name {
+				return i.(Element).Host.ProjectName()
+			}
+
+			v := FieldByIndexes(reflect.ValueOf(i.(Element).Struct), groupBy.Index)
+			for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+				if v.IsNil() {
+					return nil
+				}
+				v = v.Elem()
+			}
+			switch v.Kind() {
+			case reflect.Invalid:
+				return nil
+			case reflect.Slice, reflect.Map:
+				// Group keys must be hashable
+				return fmt.Sprint(v.Interface())
+			}
+			return v.Interface()
 		},
 		func(i interface{}) interface{} { return i }
 }
 
+func thenBy(q linq.OrderedQuery, selector func(interface{}) interface{}, desc bool) linq.OrderedQuery {
+	if desc {
+		return q.ThenByDescending(selector)
+	}
+	return q.ThenBy(selector)
+}
+
 func OrderBy(groupBy, orderBy *reflectx.FieldInfo) func(i interface{}) interface{} {
 	if orderBy.Name == byHostFI.Name {
 		// Return Host.Project name
@@ -210,7 +324,7 @@ func ByLen() func(i interface{}) interface{} {
 
 // Order by GroupBy key
 func ByKey() func(i interface{}) interface{} {
-	return func(i interface{}) interface{} { return strings.ToLower(fmt.Sprint(i.(linq.Group).Key)) }
+	return func(i interface{}) interface{} { return KeyOf(reflect.ValueOf(i.(linq.Group).Key)) }
 }
 
 // Order by Host.Project key
@@ -224,13 +338,29 @@ func ByHost() func(i interface{}) interface{} {
 	}
 }
 
+// Order by the full host name, used to order equal results
+func ByHostName() func(i interface{}) interface{} {
+	return func(i interface{}) interface{} {
+		if v, ok := i.(Element); ok {
+			return v.Host.FullName()
+		}
+
+		names := make([]string, 0, len(i.(linq.Group).Group))
+		for _, h := range Elements(i.(linq.Group).Group).Hosts() {
+			names = append(names, h.FullName())
+		}
+		go_sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+}
+
 // Order by the field
 func ByFieldIndex(fi *reflectx.FieldInfo) func(i interface{}) interface{} {
 	return func(i interface{}) interface{} {
 		if v, ok := i.(Element); ok {
-			return strings.ToLower(fmt.Sprint(reflectx.FieldByIndexesReadOnly(reflect.ValueOf(v.Struct), fi.Index).Interface()))
+			return KeyOf(FieldByIndexes(reflect.ValueOf(v.Struct), fi.Index))
 		}
 
-		return strings.ToLower(fmt.Sprint(reflectx.FieldByIndexesReadOnly(reflect.ValueOf(i), fi.Index).Interface()))
+		return KeyOf(FieldByIndexes(reflect.ValueOf(i), fi.Index))
 	}
 }
@@ -0,0 +1,75 @@
+package sort
+
+import (
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestNestedGroupBy(t *testing.T) {
+	alfa := &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	beta := &client.Host{Team: "main", Project: "beta", Name: "local"}
+
+	project := func(h *client.Host, namespace string, stars int) Element {
+		return Element{Host: h, Struct: &gitlab.Project{StarCount: stars, Namespace: &gitlab.ProjectNamespace{FullPath: namespace}}}
+	}
+
+	ch := make(chan interface{}, 5)
+	for _, e := range []Element{
+		project(alfa, "backend", 1),
+		project(alfa, "backend", 2),
+		project(alfa, "frontend", 4),
+		project(beta, "backend", 8),
+		project(beta, "infra", 16),
+	} {
+		ch <- e
+	}
+	close(ch)
+
+	results, err := FromChannel(ch, &Options{
+		GroupBy:    "host, namespace.full_path",
+		OrderBy:    []string{"host:asc"},
+		Aggregate:  []string{"sum:star_count"},
+		StructType: gitlab.Project{},
+	})
+	assert.NoError(t, err)
+	assert.True(t, Nested(results))
+
+	type group struct {
+		field, key string
+		count      int
+		sum        interface{}
+	}
+	flatten := func(results []Result) []group {
+		s := make([]group, 0, len(results))
+		for _, r := range results {
+			s = append(s, group{r.Field, r.Key, r.Count, r.Aggregates[0]})
+		}
+		return s
+	}
+
+	assert.Equal(t, []group{{"host", "alfa.local", 3, int64(7)}, {"host", "beta.local", 2, int64(24)}}, flatten(results))
+	assert.Equal(t, []group{{"namespace.full_path", "backend", 2, int64(3)}, {"namespace.full_path", "frontend", 1, int64(4)}}, flatten(results[0].Groups))
+	assert.Equal(t, []group{{"namespace.full_path", "backend", 1, int64(8)}, {"namespace.full_path", "infra", 1, int64(16)}}, flatten(results[1].Groups))
+
+	_, err = FromChannel(make(chan interface{}), &Options{
+		GroupBy:    "host,unknown",
+		OrderBy:    []string{"count"},
+		StructType: gitlab.Project{},
+	})
+	assert.Error(t, err)
+}
+
+func TestFieldNames(t *testing.T) {
+	names := FieldNames(gitlab.User{})
+	assert.Contains(t, names, "username")
+	assert.Contains(t, names, "identities")
+	assert.Contains(t, names, "custom_attributes")
+
+	names = FieldNames(gitlab.Project{})
+	assert.Contains(t, names, "namespace.full_path")
+	assert.Contains(t, names, "statistics.repository_size")
+	assert.IsIncreasing(t, names)
+}
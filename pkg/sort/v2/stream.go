@@ -0,0 +1,388 @@
+package sort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	go_sort "sort"
+	"strings"
+
+	"github.com/ahmetb/go-linq"
+	"github.com/flant/glaball/pkg/client"
+)
+
+// StreamChunkSize is the number of elements sorted in memory before they are written to a temporary file
+var StreamChunkSize = 10000
+
+// Stream calls fn for every result as soon as it is ready.
+// Without GroupBy and OrderBy the elements are passed in the order they arrive.
+// Otherwise they are sorted with bounded memory: sorted chunks of StreamChunkSize elements are written
+// to temporary files and merged. Groups are ordered by key, the elements of every group are kept in memory.
+// The channel is always drained, so the senders are never blocked.
+func Stream(ch chan interface{}, opt *Options, fn func(Result) error) (err error) {
+	defer func() {
+		for range ch {
+		}
+	}()
+
+	var where *Where
+	if opt.Where != "" {
+		if where, err = ParseWhere(opt.Where, opt.StructType); err != nil {
+			return err
+		}
+	}
+
+	aggregations, err := ParseAggregations(opt.Aggregate, opt.StructType)
+	if err != nil {
+		return err
+	}
+
+	match := func(v interface{}) bool { return where == nil || where.Match(v.(Element)) }
+
+	// Results are built by the in-memory query from the already filtered elements
+	queryOpt := *opt
+	queryOpt.Where = ""
+	if len(queryOpt.OrderBy) == 0 {
+		queryOpt.OrderBy = []string{byLenFI.Name}
+	}
+
+	if opt.GroupBy == "" && len(opt.OrderBy) == 0 {
+		for v := range ch {
+			if !match(v) {
+				continue
+			}
+			e := v.(Element)
+			if err := fn(Result{
+				Count:      1,
+				Elements:   Elements{e},
+				Cached:     e.Cached,
+				Aggregates: Aggregate(aggregations, Elements{e}),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	s, err := newExternalSorter(&queryOpt)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	for v := range ch {
+		if !match(v) {
+			continue
+		}
+		if err := s.add(v.(Element)); err != nil {
+			return err
+		}
+	}
+
+	next, err := s.merge()
+	if err != nil {
+		return err
+	}
+
+	emit := func(elements Elements) error {
+		// Options are validated in newExternalSorter
+		q, _ := fromQuery(linq.From(elements), &queryOpt)
+		// Elements without any of the order fields are skipped as in FromChannel
+		if r, ok := q.First().(Result); ok {
+			return fn(r)
+		}
+		return nil
+	}
+
+	var group Elements
+	for {
+		e, ok, err := next()
+		if err != nil {
+			return err
+		}
+
+		// Elements of the group are adjacent, emit the group when the key changes
+		if len(group) > 0 && (!ok || s.compareGroups(group[0].(Element), e) != 0) {
+			if err := emit(group); err != nil {
+				return err
+			}
+			group = group[:0]
+		}
+
+		if !ok {
+			return nil
+		}
+
+		if s.groupBy == nil {
+			if err := emit(Elements{e}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		group = append(group, e)
+	}
+}
+
+type orderKey struct {
+	fn   func(i interface{}) interface{}
+	desc bool
+}
+
+type externalSorter struct {
+	opt     *Options
+	groupBy func(i interface{}) interface{}
+	keys    []orderKey
+
+	chunk Elements
+	files []*os.File
+	hosts []*client.Host
+	index map[*client.Host]int
+
+	// Elements of the github hosts have other types than the struct type of the options
+	types     []reflect.Type
+	typeIndex map[reflect.Type]int
+}
+
+func newExternalSorter(opt *Options) (*externalSorter, error) {
+	// Check the options the same way as the in-memory query does
+	if _, err := fromQuery(linq.From([]interface{}{}), opt); err != nil {
+		return nil, err
+	}
+
+	m := mapper.TypeMap(reflect.TypeOf(opt.StructType))
+	s := externalSorter{
+		opt:       opt,
+		index:     make(map[*client.Host]int),
+		typeIndex: make(map[reflect.Type]int),
+	}
+
+	if f, _, _ := strings.Cut(opt.GroupBy, ","); strings.TrimSpace(f) != "" {
+		s.groupBy, _ = GroupBy(m.GetByPath(strings.TrimSpace(f)))
+	}
+
+	for _, key := range opt.OrderBy {
+		field, direction, _ := ParseOrderBy(key)
+		fi := m.GetByPath(field)
+		// Count of every element is 1, groups are ordered by key
+		if fi.Name == byLenFI.Name || s.groupBy != nil {
+			continue
+		}
+		fn := ByFieldIndex(fi)
+		if fi.Name == byHostFI.Name {
+			fn = func(i interface{}) interface{} { return i.(Element).Host }
+		}
+		s.keys = append(s.keys, orderKey{fn: fn, desc: descending(direction, true, opt.SortBy)})
+	}
+
+	return &s, nil
+}
+
+func (s *externalSorter) compareGroups(a, b Element) int {
+	if s.groupBy == nil {
+		return 0
+	}
+	return KeyOf(reflect.ValueOf(s.groupBy(a))).CompareTo(KeyOf(reflect.ValueOf(s.groupBy(b))))
+}
+
+func (s *externalSorter) compare(a, b Element) int {
+	if c := s.compareGroups(a, b); c != 0 {
+		return c
+	}
+
+	c := 0
+	for _, k := range s.keys {
+		if c = k.fn(a).(linq.Comparable).CompareTo(k.fn(b).(linq.Comparable)); c != 0 {
+			if k.desc {
+				c = -c
+			}
+			break
+		}
+	}
+	if c != 0 {
+		return c
+	}
+
+	// Groups are always ordered by key
+	c = strings.Compare(a.Host.FullName(), b.Host.FullName())
+	if s.groupBy != nil || descending("", true, s.opt.SortBy) {
+		c = -c
+	}
+
+	return c
+}
+
+func (s *externalSorter) add(e Element) error {
+	if _, ok := s.index[e.Host]; !ok {
+		s.index[e.Host] = len(s.hosts)
+		s.hosts = append(s.hosts, e.Host)
+	}
+
+	s.chunk = append(s.chunk, e)
+	if len(s.chunk) < StreamChunkSize {
+		return nil
+	}
+
+	return s.spill()
+}
+
+type streamRecord struct {
+	Host   int             `json:"host"`
+	Type   int             `json:"type"`
+	Cached Cached          `json:"cached"`
+	Struct json.RawMessage `json:"struct"`
+}
+
+func (s *externalSorter) sortChunk() {
+	go_sort.SliceStable(s.chunk, func(i, j int) bool {
+		return s.compare(s.chunk[i].(Element), s.chunk[j].(Element)) < 0
+	})
+}
+
+// Write the sorted chunk to a temporary file
+func (s *externalSorter) spill() error {
+	s.sortChunk()
+
+	f, err := os.CreateTemp("", "glaball-*.ndjson")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, f)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, v := range s.chunk {
+		e := v.(Element)
+		b, err := json.Marshal(e.Struct)
+		if err != nil {
+			return err
+		}
+		t := reflect.TypeOf(e.Struct)
+		if _, ok := s.typeIndex[t]; !ok {
+			s.typeIndex[t] = len(s.types)
+			s.types = append(s.types, t)
+		}
+		if err := enc.Encode(streamRecord{Host: s.index[e.Host], Type: s.typeIndex[t], Cached: e.Cached, Struct: b}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	s.chunk = s.chunk[:0]
+
+	return nil
+}
+
+func (s *externalSorter) decode(dec *json.Decoder) (Element, bool, error) {
+	var r streamRecord
+	if err := dec.Decode(&r); err == io.EOF {
+		return Element{}, false, nil
+	} else if err != nil {
+		return Element{}, false, err
+	}
+
+	// The element is decoded into the same type it had before the spill
+	t := s.types[r.Type]
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(r.Struct, v.Interface()); err != nil {
+		return Element{}, false, err
+	}
+	if s.types[r.Type].Kind() != reflect.Ptr {
+		v = v.Elem()
+	}
+
+	return Element{Host: s.hosts[r.Host], Struct: v.Interface(), Cached: r.Cached}, true, nil
+}
+
+// Returns the iterator over the sorted elements of all chunks
+func (s *externalSorter) merge() (func() (Element, bool, error), error) {
+	if len(s.files) == 0 {
+		s.sortChunk()
+		i := 0
+		return func() (Element, bool, error) {
+			if i == len(s.chunk) {
+				return Element{}, false, nil
+			}
+			i++
+			return s.chunk[i-1].(Element), true, nil
+		}, nil
+	}
+
+	if len(s.chunk) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &mergeHeap{compare: s.compare}
+	for _, f := range s.files {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		e, ok, err := s.decode(dec)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h.items = append(h.items, mergeItem{e, dec})
+		}
+	}
+	heap.Init(h)
+
+	return func() (Element, bool, error) {
+		if h.Len() == 0 {
+			return Element{}, false, nil
+		}
+
+		item := h.items[0]
+		e, ok, err := s.decode(item.dec)
+		if err != nil {
+			return Element{}, false, err
+		}
+		if ok {
+			h.items[0].e = e
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+
+		return item.e, true, nil
+	}, nil
+}
+
+func (s *externalSorter) close() {
+	for _, f := range s.files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+type mergeItem struct {
+	e   Element
+	dec *json.Decoder
+}
+
+type mergeHeap struct {
+	items   []mergeItem
+	compare func(a, b Element) int
+}
+
+func (h mergeHeap) Len() int            { return len(h.items) }
+func (h mergeHeap) Less(i, j int) bool  { return h.compare(h.items[i].e, h.items[j].e) < 0 }
+func (h mergeHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
+}
@@ -0,0 +1,125 @@
+package sort
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestStream(t *testing.T) {
+	hosts := []*client.Host{
+		{Team: "main", Project: "alfa", Name: "local"},
+		{Team: "main", Project: "beta", Name: "local"},
+	}
+
+	elements := func() chan interface{} {
+		ch := make(chan interface{}, 50)
+		for i := 0; i < 50; i++ {
+			ch <- Element{Host: hosts[i%2], Struct: &gitlab.Project{
+				ID:        i,
+				Name:      fmt.Sprintf("project%d", (i*7)%25),
+				StarCount: i % 3,
+				Namespace: &gitlab.ProjectNamespace{FullPath: fmt.Sprintf("group%d", i%4)},
+			}}
+		}
+		close(ch)
+		return ch
+	}
+
+	collect := func(opt *Options) []Result {
+		results := make([]Result, 0)
+		assert.NoError(t, Stream(elements(), opt, func(r Result) error {
+			results = append(results, r)
+			return nil
+		}))
+		return results
+	}
+
+	defer func(size int) { StreamChunkSize = size }(StreamChunkSize)
+	StreamChunkSize = 7
+
+	// Elements are passed as is without ordering
+	results := collect(&Options{Where: "star_count == 0", StructType: gitlab.Project{}})
+	assert.Len(t, results, 17)
+	for i, r := range results {
+		assert.Equal(t, i*3, r.Elements.Typed()[0].Struct.(*gitlab.Project).ID)
+	}
+
+	// Sorted with temporary files in the same order as in memory
+	opt := &Options{OrderBy: []string{"star_count:asc", "name"}, StructType: gitlab.Project{}}
+	expected, err := FromChannel(elements(), opt)
+	assert.NoError(t, err)
+
+	ids := func(results []Result) []int {
+		s := make([]int, 0, len(results))
+		for _, r := range results {
+			for _, e := range r.Elements.Typed() {
+				s = append(s, e.Struct.(*gitlab.Project).ID)
+			}
+		}
+		return s
+	}
+	assert.Equal(t, ids(expected), ids(collect(opt)))
+
+	// --sort reverses only the keys without the direction
+	opt = &Options{OrderBy: []string{"star_count:desc", "name"}, SortBy: "asc", StructType: gitlab.Project{}}
+	expected, err = FromChannel(elements(), opt)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, expected[0].Elements.Typed()[0].Struct.(*gitlab.Project).StarCount)
+	assert.Equal(t, ids(expected), ids(collect(opt)))
+
+	// Groups are ordered by key
+	results = collect(&Options{
+		GroupBy:    "namespace.full_path,host",
+		Aggregate:  []string{"sum:star_count"},
+		StructType: gitlab.Project{},
+	})
+	assert.Len(t, results, 4)
+	for i, r := range results {
+		assert.Equal(t, fmt.Sprintf("group%d", i), r.Key)
+		assert.Equal(t, 13-i/2, r.Count)
+		assert.Len(t, r.Groups, 1)
+	}
+}
+
+func TestStreamMixedTypes(t *testing.T) {
+	defer func(size int) { StreamChunkSize = size }(StreamChunkSize)
+	StreamChunkSize = 3
+
+	gl := &client.Host{Team: "main", Project: "gitlab", Name: "local"}
+	gh := &client.Host{Team: "main", Project: "github", Name: "com"}
+
+	ch := make(chan interface{}, 10)
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			ch <- Element{Host: gl, Struct: &gitlab.Project{ID: i, Name: fmt.Sprintf("p%d", i)}}
+		} else {
+			ch <- Element{Host: gh, Struct: &github.Repository{ID: github.Int64(int64(i)), Name: github.String(fmt.Sprintf("r%d", i))}}
+		}
+	}
+	close(ch)
+
+	// The spilled elements of the github hosts are decoded as repositories
+	count := 0
+	assert.NoError(t, Stream(ch, &Options{GroupBy: "host", StructType: gitlab.Project{}}, func(r Result) error {
+		for _, e := range r.Elements.Typed() {
+			count++
+			switch v := e.Struct.(type) {
+			case *gitlab.Project:
+				assert.Equal(t, gl, e.Host)
+				assert.Equal(t, fmt.Sprintf("p%d", v.ID), v.Name)
+			case *github.Repository:
+				assert.Equal(t, gh, e.Host)
+				assert.Equal(t, fmt.Sprintf("r%d", v.GetID()), v.GetName())
+			default:
+				t.Fatalf("unexpected type %T", v)
+			}
+		}
+		return nil
+	}))
+	assert.Equal(t, 10, count)
+}
@@ -4,18 +4,22 @@ import (
 	"fmt"
 	"reflect"
 
-	"github.com/jmoiron/sqlx/reflectx"
+	go_sort "sort"
 )
 
 func ValidFieldValue(keys []string, v interface{}) (interface{}, error) {
 	m := mapper.TypeMap(reflect.TypeOf(v))
 	rv := reflect.ValueOf(v)
-	for _, k := range keys {
-		if fi := m.GetByPath(k); fi != nil {
-			fv := reflectx.FieldByIndexesReadOnly(rv, fi.Index)
-			if fi.Field.Type.Kind() == reflect.Ptr {
+	for _, k := range Fields(keys) {
+		// Pseudo fields like count have no index
+		if fi := m.GetByPath(k); fi != nil && len(fi.Index) > 0 {
+			fv := FieldByIndexes(rv, fi.Index)
+			if fv.IsValid() && fi.Field.Type.Kind() == reflect.Ptr {
 				fv = fv.Elem()
 			}
+			if !fv.IsValid() {
+				continue
+			}
 			if rfv := fv.Interface(); rfv != nil && rfv != v {
 				return rfv, nil
 			}
@@ -26,10 +30,25 @@ func ValidFieldValue(keys []string, v interface{}) (interface{}, error) {
 
 func ValidOrderBy(keys []string, v interface{}) bool {
 	m := mapper.TypeMap(reflect.TypeOf(v))
-	for _, k := range keys {
+	for _, k := range Fields(keys) {
 		if fi := m.GetByPath(k); fi != nil {
 			return true
 		}
 	}
 	return false
 }
+
+// FieldNames returns the json field paths of the struct type including the nested ones, e.g. namespace.full_path
+func FieldNames(v interface{}) []string {
+	m := mapper.TypeMap(reflect.TypeOf(v))
+	names := make([]string, 0, len(m.Index))
+	for _, fi := range m.Index {
+		// Embedded structs have no path of their own, their fields are promoted
+		if fi.Path == "" || fi.Embedded || len(fi.Index) == 0 {
+			continue
+		}
+		names = append(names, fi.Path)
+	}
+	go_sort.Strings(names)
+	return names
+}
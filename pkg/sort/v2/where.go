@@ -0,0 +1,627 @@
+package sort
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// Where is a compiled filter expression evaluated against the json fields of the elements, e.g.
+//
+//	last_activity_at < now-180d && !archived && star_count == 0
+//
+// Supported operators are == != < <= > >= =~ !~ && || ! and parentheses.
+// Operands are field paths, quoted strings, numbers, true, false, null
+// and now with an optional offset in s, m, h, d or w units.
+// Comparisons with list fields are true if any of the list elements match.
+type Where struct {
+	src    string
+	expr   whereNode
+	fields []string
+}
+
+// ParseWhere compiles the expression validating field paths against the struct type
+func ParseWhere(s string, structType interface{}) (*Where, error) {
+	tokens, err := whereTokenize(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid where expression %q: %v", s, err)
+	}
+
+	p := whereParser{
+		tokens: tokens,
+		fields: mapper.TypeMap(reflect.TypeOf(structType)),
+		now:    time.Now(),
+	}
+
+	expr, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid where expression %q: %v", s, err)
+	}
+
+	return &Where{src: s, expr: expr, fields: p.paths}, nil
+}
+
+func (w *Where) String() string {
+	return w.src
+}
+
+// Fields returns the field paths of the expression
+func (w *Where) Fields() []string {
+	return w.fields
+}
+
+// Match evaluates the expression for the element
+func (w *Where) Match(e Element) bool {
+	return w.expr.eval(e).truthy()
+}
+
+type whereTokenKind int
+
+const (
+	tokenIdent whereTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenNow
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type whereToken struct {
+	kind whereTokenKind
+	text string
+}
+
+var (
+	whereOps      = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+	whereNowRe    = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?`)
+	whereNumberRe = regexp.MustCompile(`^-?\d+(?:\.\d+)?`)
+)
+
+func whereTokenize(s string) ([]whereToken, error) {
+	tokens := make([]whereToken, 0)
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, whereToken{tokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, whereToken{tokenRParen, ")"})
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, whereToken{tokenString, b.String()})
+			i = j + 1
+		default:
+			if m := whereNumberRe.FindString(s[i:]); m != "" {
+				tokens = append(tokens, whereToken{tokenNumber, m})
+				i += len(m)
+				continue
+			}
+
+			if op := whereMatchOp(s[i:]); op != "" {
+				tokens = append(tokens, whereToken{tokenOp, op})
+				i += len(op)
+				continue
+			}
+
+			if !isIdentRune(rune(c)) {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+
+			j := i
+			for j < len(s) && (isIdentRune(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			ident := s[i:j]
+			if ident == "now" {
+				m := whereNowRe.FindString(s[i:])
+				tokens = append(tokens, whereToken{tokenNow, m})
+				i += len(m)
+				continue
+			}
+			tokens = append(tokens, whereToken{tokenIdent, ident})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+func whereMatchOp(s string) string {
+	for _, op := range whereOps {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+	fields *reflectx.StructMap
+	paths  []string
+	now    time.Time
+}
+
+func (p *whereParser) peek() *whereToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *whereParser) acceptOp(ops ...string) (string, bool) {
+	if t := p.peek(); t != nil && t.kind == tokenOp {
+		for _, op := range ops {
+			if t.text == op {
+				p.pos++
+				return op, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &whereLogical{op: "||", left: left, right: right}
+	}
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &whereLogical{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *whereParser) parseUnary() (whereNode, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &whereNot{n}, nil
+	}
+
+	if t := p.peek(); t != nil && t.kind == tokenLParen {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t == nil || t.kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return n, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.acceptOp("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return newWhereCompare(op, left, right)
+}
+
+func (p *whereParser) parseOperand() (whereNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch t.kind {
+	case tokenString:
+		return &whereLiteral{whereValue{kind: kindString, s: t.text}}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &whereLiteral{whereValue{kind: kindNumber, n: n}}, nil
+	case tokenNow:
+		return &whereLiteral{whereValue{kind: kindTime, t: p.nowWithOffset(t.text)}}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &whereLiteral{whereValue{kind: kindBool, b: t.text == "true"}}, nil
+		case "null", "nil":
+			return &whereLiteral{whereValue{kind: kindNull}}, nil
+		case byHostFI.Name:
+			return &whereHost{}, nil
+		}
+		// Pseudo fields like count have no index
+		fi := p.fields.GetByPath(t.text)
+		if fi == nil || len(fi.Index) == 0 {
+			return nil, fmt.Errorf("invalid struct field: %s", t.text)
+		}
+		p.paths = append(p.paths, t.text)
+		return &whereField{path: t.text, index: fi.Index}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *whereParser) nowWithOffset(s string) time.Time {
+	m := whereNowRe.FindStringSubmatch(s)
+	if m[1] == "" {
+		return p.now
+	}
+
+	n, _ := strconv.Atoi(m[2])
+	d := time.Duration(n)
+	switch m[3] {
+	case "s":
+		d *= time.Second
+	case "m":
+		d *= time.Minute
+	case "h":
+		d *= time.Hour
+	case "d":
+		d *= 24 * time.Hour
+	case "w":
+		d *= 7 * 24 * time.Hour
+	}
+
+	if m[1] == "-" {
+		d = -d
+	}
+
+	return p.now.Add(d)
+}
+
+type whereKind int
+
+const (
+	kindNull whereKind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindTime
+	kindList
+)
+
+type whereValue struct {
+	kind whereKind
+	b    bool
+	n    float64
+	s    string
+	t    time.Time
+	list []whereValue
+}
+
+func (v whereValue) truthy() bool {
+	switch v.kind {
+	case kindBool:
+		return v.b
+	case kindNumber:
+		return v.n != 0
+	case kindString:
+		return v.s != ""
+	case kindTime:
+		return !v.t.IsZero()
+	case kindList:
+		return len(v.list) > 0
+	}
+	return false
+}
+
+func (v whereValue) String() string {
+	switch v.kind {
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	case kindNumber:
+		return strconv.FormatFloat(v.n, 'f', -1, 64)
+	case kindString:
+		return v.s
+	case kindTime:
+		return v.t.Format(time.RFC3339)
+	}
+	return ""
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// whereValueOf converts the field value to the expression value
+func whereValueOf(rv reflect.Value) whereValue {
+	for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return whereValue{kind: kindNull}
+		}
+		rv = rv.Elem()
+	}
+
+	if !rv.IsValid() {
+		return whereValue{kind: kindNull}
+	}
+
+	// time.Time and its aliases, e.g. gitlab.ISOTime
+	if rv.Type().ConvertibleTo(timeType) && rv.Kind() == reflect.Struct {
+		return whereValue{kind: kindTime, t: rv.Convert(timeType).Interface().(time.Time)}
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return whereValue{kind: kindBool, b: rv.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return whereValue{kind: kindNumber, n: float64(rv.Int())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return whereValue{kind: kindNumber, n: float64(rv.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return whereValue{kind: kindNumber, n: rv.Float()}
+	case reflect.String:
+		return whereValue{kind: kindString, s: rv.String()}
+	case reflect.Slice, reflect.Array:
+		list := make([]whereValue, rv.Len())
+		for i := range list {
+			list[i] = whereValueOf(rv.Index(i))
+		}
+		return whereValue{kind: kindList, list: list}
+	}
+
+	return whereValue{kind: kindString, s: fmt.Sprint(rv.Interface())}
+}
+
+// FieldByIndexes returns the field value or invalid value if any of the parent structs is nil
+func FieldByIndexes(v reflect.Value, indexes []int) reflect.Value {
+	for _, i := range indexes {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+type whereNode interface {
+	eval(e Element) whereValue
+}
+
+type whereLiteral struct {
+	v whereValue
+}
+
+func (n *whereLiteral) eval(Element) whereValue {
+	return n.v
+}
+
+type whereField struct {
+	path  string
+	index []int
+}
+
+func (n *whereField) eval(e Element) whereValue {
+	return whereValueOf(FieldByIndexes(reflect.ValueOf(e.Struct), n.index))
+}
+
+type whereHost struct{}
+
+func (n *whereHost) eval(e Element) whereValue {
+	if e.Host == nil {
+		return whereValue{kind: kindNull}
+	}
+	return whereValue{kind: kindString, s: e.Host.FullName()}
+}
+
+type whereNot struct {
+	n whereNode
+}
+
+func (n *whereNot) eval(e Element) whereValue {
+	return whereValue{kind: kindBool, b: !n.n.eval(e).truthy()}
+}
+
+type whereLogical struct {
+	op          string
+	left, right whereNode
+}
+
+func (n *whereLogical) eval(e Element) whereValue {
+	l := n.left.eval(e).truthy()
+	if n.op == "&&" && !l || n.op == "||" && l {
+		return whereValue{kind: kindBool, b: l}
+	}
+	return whereValue{kind: kindBool, b: n.right.eval(e).truthy()}
+}
+
+type whereCompare struct {
+	op          string
+	left, right whereNode
+	re          *regexp.Regexp
+}
+
+func newWhereCompare(op string, left, right whereNode) (whereNode, error) {
+	n := whereCompare{op: op, left: left, right: right}
+
+	if op == "=~" || op == "!~" {
+		lit, ok := right.(*whereLiteral)
+		if !ok || lit.v.kind != kindString {
+			return nil, fmt.Errorf("%s requires a quoted regexp on the right side", op)
+		}
+		re, err := regexp.Compile(lit.v.s)
+		if err != nil {
+			return nil, err
+		}
+		n.re = re
+	}
+
+	return &n, nil
+}
+
+func (n *whereCompare) eval(e Element) whereValue {
+	l, r := n.left.eval(e), n.right.eval(e)
+
+	var b bool
+	switch n.op {
+	case "=~":
+		b = anyValue(l, func(v whereValue) bool { return v.kind != kindNull && n.re.MatchString(v.String()) })
+	case "!~":
+		b = !anyValue(l, func(v whereValue) bool { return v.kind != kindNull && n.re.MatchString(v.String()) })
+	case "==":
+		b = anyValue(l, func(v whereValue) bool { c, ok := compareValues(v, r); return ok && c == 0 })
+	case "!=":
+		b = !anyValue(l, func(v whereValue) bool { c, ok := compareValues(v, r); return ok && c == 0 })
+	default:
+		c, ok := compareValues(l, r)
+		if ok {
+			switch n.op {
+			case "<":
+				b = c < 0
+			case "<=":
+				b = c <= 0
+			case ">":
+				b = c > 0
+			case ">=":
+				b = c >= 0
+			}
+		}
+	}
+
+	return whereValue{kind: kindBool, b: b}
+}
+
+// Check if the value or any of the list elements match
+func anyValue(v whereValue, f func(v whereValue) bool) bool {
+	if v.kind != kindList {
+		return f(v)
+	}
+	for _, e := range v.list {
+		if f(e) {
+			return true
+		}
+	}
+	return false
+}
+
+var whereTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// compareValues returns -1, 0 or 1 and false if the values are not comparable
+func compareValues(a, b whereValue) (int, bool) {
+	if a.kind == kindNull || b.kind == kindNull {
+		if a.kind == b.kind {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if a.kind == kindTime && b.kind == kindString {
+		if t, ok := parseWhereTime(b.s); ok {
+			b = whereValue{kind: kindTime, t: t}
+		}
+	}
+	if a.kind == kindString && b.kind == kindTime {
+		if t, ok := parseWhereTime(a.s); ok {
+			a = whereValue{kind: kindTime, t: t}
+		}
+	}
+
+	if a.kind != b.kind {
+		if a.kind == kindList || b.kind == kindList {
+			return 0, false
+		}
+		// Compare values of different types by their string representation
+		return strings.Compare(a.String(), b.String()), true
+	}
+
+	switch a.kind {
+	case kindBool:
+		if a.b == b.b {
+			return 0, true
+		}
+		if !a.b {
+			return -1, true
+		}
+		return 1, true
+	case kindNumber:
+		switch {
+		case a.n < b.n:
+			return -1, true
+		case a.n > b.n:
+			return 1, true
+		}
+		return 0, true
+	case kindString:
+		return strings.Compare(a.s, b.s), true
+	case kindTime:
+		return a.t.Compare(b.t), true
+	}
+
+	return 0, false
+}
+
+func parseWhereTime(s string) (time.Time, bool) {
+	for _, layout := range whereTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
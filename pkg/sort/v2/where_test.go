@@ -0,0 +1,66 @@
+package sort
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestWhere(t *testing.T) {
+	old := time.Now().Add(-200 * 24 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+
+	project := func(p gitlab.Project) Element {
+		return Element{Host: &client.Host{Team: "main", Project: "gitlab", Name: "local"}, Struct: &p}
+	}
+
+	stale := project(gitlab.Project{
+		Name:           "stale",
+		LastActivityAt: &old,
+		StarCount:      0,
+		Topics:         []string{"go", "legacy"},
+		Namespace:      &gitlab.ProjectNamespace{FullPath: "backend"},
+	})
+	active := project(gitlab.Project{
+		Name:           "active",
+		LastActivityAt: &recent,
+		StarCount:      5,
+		Archived:       true,
+	})
+
+	for expr, want := range map[string][2]bool{
+		"last_activity_at < now-180d && !archived && star_count == 0": {true, false},
+		"last_activity_at > now-1w":                                   {false, true},
+		"last_activity_at > '2000-01-01'":                             {true, true},
+		`name == "stale" || star_count >= 5`:                          {true, true},
+		"!(archived || star_count > 0)":                               {true, false},
+		"topics == 'legacy'":                                          {true, false},
+		"topics =~ '^le'":                                             {true, false},
+		"namespace.full_path == null":                                 {false, true},
+		"namespace.full_path !~ 'front'":                              {true, true},
+		"name =~ '(?i)^ACT' && host =~ '^main\\.'":                    {false, true},
+	} {
+		w, err := ParseWhere(expr, gitlab.Project{})
+		if !assert.NoError(t, err, expr) {
+			continue
+		}
+		assert.Equal(t, want[0], w.Match(stale), expr)
+		assert.Equal(t, want[1], w.Match(active), expr)
+	}
+
+	for _, expr := range []string{
+		"unknown_field == 1",
+		"name ==",
+		"(name == 'a'",
+		"name =~ 1",
+		"name =~ '['",
+		"count > 1",
+		"name == 'a' )",
+	} {
+		_, err := ParseWhere(expr, gitlab.Project{})
+		assert.Error(t, err, expr)
+	}
+}
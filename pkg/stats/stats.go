@@ -0,0 +1,284 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/output"
+)
+
+// Recorder collects the statistics of the requests per host and per endpoint.
+// It implements client.Observer and client.RetryObserver.
+type Recorder struct {
+	mu      sync.Mutex
+	entries map[key]*entry
+}
+
+type key struct {
+	host     string
+	endpoint string
+}
+
+type entry struct {
+	requests, retries, rateLimited, cached, errors int
+	bytes                                          int64
+	latencies                                      []time.Duration
+}
+
+// Summary is the statistics of the requests to the host, to the endpoint of the host or to all hosts
+type Summary struct {
+	Host        string  `json:"host,omitempty"`
+	Endpoint    string  `json:"endpoint,omitempty"`
+	Requests    int     `json:"requests"`
+	Bytes       int64   `json:"bytes"`
+	P50         float64 `json:"p50_ms"`
+	P95         float64 `json:"p95_ms"`
+	Retries     int     `json:"retries"`
+	RateLimited int     `json:"rate_limited"`
+	Cached      int     `json:"cached"`
+	Errors      int     `json:"errors"`
+}
+
+// Report is the statistics of the run
+type Report struct {
+	Hosts     []Summary `json:"hosts"`
+	Endpoints []Summary `json:"endpoints"`
+	Total     Summary   `json:"total"`
+}
+
+func New() *Recorder {
+	return &Recorder{
+		entries: make(map[key]*entry),
+	}
+}
+
+func (r *Recorder) RequestStarted(h *client.Host, req *http.Request) {}
+
+func (r *Recorder) RequestFinished(h *client.Host, req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.entry(h, req)
+	e.requests++
+	e.latencies = append(e.latencies, elapsed)
+
+	if err != nil {
+		e.errors++
+		return
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.rateLimited++
+	case resp.StatusCode >= http.StatusInternalServerError:
+		e.errors++
+	case resp.StatusCode >= http.StatusBadRequest:
+		e.errors++
+	}
+
+	if resp.Header.Get("X-From-Cache") == "1" {
+		e.cached++
+	}
+
+	if resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, r: r, e: e}
+	}
+}
+
+// The retrying client sends the same request again after an error, 429 or 5xx response
+func (r *Recorder) RequestRetried(h *client.Host, req *http.Request, _ int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entry(h, req).retries++
+}
+
+func (r *Recorder) entry(h *client.Host, req *http.Request) *entry {
+	k := key{host: hostName(h, req), endpoint: client.Endpoint(req)}
+	e, ok := r.entries[k]
+	if !ok {
+		e = &entry{}
+		r.entries[k] = e
+	}
+	return e
+}
+
+// Counts the bytes of the response body as it is read by the client
+type countingBody struct {
+	io.ReadCloser
+	r *Recorder
+	e *entry
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.r.mu.Lock()
+	b.e.bytes += int64(n)
+	b.r.mu.Unlock()
+	return n, err
+}
+
+func hostName(h *client.Host, req *http.Request) string {
+	if h == nil {
+		return req.URL.Host
+	}
+	return h.FullName()
+}
+
+// Report returns the statistics per host and per endpoint ordered by the number of requests
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hosts := make(map[string]*entry)
+	total := &entry{}
+
+	report := Report{
+		Hosts:     make([]Summary, 0),
+		Endpoints: make([]Summary, 0, len(r.entries)),
+	}
+
+	for k, e := range r.entries {
+		report.Endpoints = append(report.Endpoints, e.summary(k.host, k.endpoint))
+
+		h, ok := hosts[k.host]
+		if !ok {
+			h = &entry{}
+			hosts[k.host] = h
+		}
+		h.add(e)
+		total.add(e)
+	}
+
+	for name, e := range hosts {
+		report.Hosts = append(report.Hosts, e.summary(name, ""))
+	}
+
+	for _, s := range [][]Summary{report.Hosts, report.Endpoints} {
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].Requests != s[j].Requests {
+				return s[i].Requests > s[j].Requests
+			}
+			if s[i].Host != s[j].Host {
+				return s[i].Host < s[j].Host
+			}
+			return s[i].Endpoint < s[j].Endpoint
+		})
+	}
+
+	report.Total = total.summary("", "")
+
+	return report
+}
+
+func (e *entry) add(o *entry) {
+	e.requests += o.requests
+	e.retries += o.retries
+	e.rateLimited += o.rateLimited
+	e.cached += o.cached
+	e.errors += o.errors
+	e.bytes += o.bytes
+	e.latencies = append(e.latencies, o.latencies...)
+}
+
+func (e *entry) summary(host, endpoint string) Summary {
+	latencies := make([]time.Duration, len(e.latencies))
+	copy(latencies, e.latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return Summary{
+		Host:        host,
+		Endpoint:    endpoint,
+		Requests:    e.requests,
+		Bytes:       e.bytes,
+		P50:         milliseconds(percentile(latencies, 50)),
+		P95:         milliseconds(percentile(latencies, 95)),
+		Retries:     e.retries,
+		RateLimited: e.rateLimited,
+		Cached:      e.cached,
+		Errors:      e.errors,
+	}
+}
+
+// Nearest-rank percentile of the sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Write writes the report in the output format: hosts, endpoints and the total
+func (r Report) Write(w io.Writer, format string) error {
+	header := []string{"HOST", "ENDPOINT", "REQUESTS", "BYTES", "P50", "P95", "RETRIES", "429", "CACHED", "ERRORS"}
+
+	rows := make([][]string, 0, len(r.Hosts)+len(r.Endpoints)+1)
+	for _, s := range r.Hosts {
+		rows = append(rows, s.row(s.Host, "*"))
+	}
+	for _, s := range r.Endpoints {
+		rows = append(rows, s.row(s.Host, s.Endpoint))
+	}
+	rows = append(rows, r.Total.row("TOTAL", "*"))
+
+	switch format {
+	case output.FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+
+	case output.FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case output.FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+func (s Summary) row(host, endpoint string) []string {
+	return []string{
+		host,
+		endpoint,
+		strconv.Itoa(s.Requests),
+		strconv.FormatInt(s.Bytes, 10),
+		time.Duration(s.P50 * float64(time.Millisecond)).Round(time.Millisecond).String(),
+		time.Duration(s.P95 * float64(time.Millisecond)).Round(time.Millisecond).String(),
+		strconv.Itoa(s.Retries),
+		strconv.Itoa(s.RateLimited),
+		strconv.Itoa(s.Cached),
+		strconv.Itoa(s.Errors),
+	}
+}
@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+	"github.com/flant/glaball/pkg/output"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request is rate limited and retried
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	cli, err := client.NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token"}}},
+		},
+		Threads: limiter.DefaultLimit,
+	})
+	assert.NoError(t, err)
+
+	recorder := New()
+	cli.Observe(recorder)
+
+	_, _, err = cli.Hosts[0].Client.Users.ListUsers(nil)
+	assert.NoError(t, err)
+	_, _, err = cli.Hosts[0].Client.ProjectMembers.ListProjectMembers("group/project", nil)
+	assert.NoError(t, err)
+
+	report := recorder.Report()
+	assert.Len(t, report.Hosts, 1)
+	assert.Equal(t, "main.alfa.local", report.Hosts[0].Host)
+
+	assert.Equal(t, 3, report.Total.Requests)
+	assert.Equal(t, int64(4), report.Total.Bytes)
+	assert.Equal(t, 1, report.Total.Retries)
+	assert.Equal(t, 1, report.Total.RateLimited)
+
+	assert.Len(t, report.Endpoints, 2)
+	assert.Equal(t, "GET /users", report.Endpoints[0].Endpoint)
+	assert.Equal(t, 2, report.Endpoints[0].Requests)
+	assert.Equal(t, "GET /projects/:id/members", report.Endpoints[1].Endpoint)
+	assert.Equal(t, 0, report.Endpoints[1].Retries)
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.Write(&buf, output.FormatCSV))
+	assert.Contains(t, buf.String(), "main.alfa.local,GET /users,2,2,")
+}
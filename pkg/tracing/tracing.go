@@ -0,0 +1,248 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/util"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExporterOTLP exports the spans with OTLP over http, configured with the OTEL_EXPORTER_OTLP_* environment variables
+const ExporterOTLP = "otlp"
+
+// Tracer traces the run of a command: the root span of the command,
+// a child span per task of a host and a span per api request made by the task.
+// It implements limiter.Progress, limiter.TaskObserver and client.Observer.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	file     io.Closer
+
+	ctx  context.Context
+	root trace.Span
+
+	mu       sync.Mutex
+	hosts    map[*client.Host]struct{}
+	tasks    map[trace.SpanID]*taskSpan
+	requests map[*http.Request]request
+
+	added, done, errors int
+}
+
+type taskSpan struct {
+	span     trace.Span
+	requests int
+}
+
+type request struct {
+	span trace.Span
+	task *taskSpan
+}
+
+// Start starts the root span of the command, the spans are exported with OTLP or written to the json file at the destination
+func Start(ctx context.Context, name, destination string) (*Tracer, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		file     *os.File
+		err      error
+	)
+
+	switch destination {
+	case "":
+		return nil, fmt.Errorf("trace destination is not set")
+	case ExporterOTLP:
+		if exporter, err = otlptracehttp.New(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		if file, err = os.Create(destination); err != nil {
+			return nil, err
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(config.ApplicationName),
+			semconv.ServiceVersion(util.Version),
+		)),
+	)
+
+	t := Tracer{
+		provider: provider,
+		tracer:   provider.Tracer("github.com/flant/glaball"),
+		hosts:    make(map[*client.Host]struct{}),
+		tasks:    make(map[trace.SpanID]*taskSpan),
+		requests: make(map[*http.Request]request),
+	}
+	if file != nil {
+		t.file = file
+	}
+
+	t.ctx, t.root = t.tracer.Start(ctx, name)
+
+	return &t, nil
+}
+
+func (t *Tracer) TaskAdded(delta int) {
+	t.mu.Lock()
+	t.added += delta
+	t.mu.Unlock()
+}
+
+func (t *Tracer) TaskDone() {
+	t.mu.Lock()
+	t.done++
+	t.mu.Unlock()
+}
+
+func (t *Tracer) TaskError(h *client.Host, err error) {
+	t.mu.Lock()
+	t.errors++
+	t.mu.Unlock()
+}
+
+// TaskStarted starts the span of the task of the host under the root span, it is ended with the result of the task.
+// The requests made with the returned context are traced in the span of the task.
+func (t *Tracer) TaskStarted(ctx context.Context, h *client.Host) (context.Context, func(err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name := h.FullName()
+	s := taskSpan{}
+	_, s.span = t.tracer.Start(t.ctx, name, trace.WithAttributes(
+		attribute.String("glaball.host", name),
+		semconv.ServerAddress(h.URL),
+	))
+	id := s.span.SpanContext().SpanID()
+	t.hosts[h] = struct{}{}
+	t.tasks[id] = &s
+
+	return trace.ContextWithSpan(ctx, s.span), func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.tasks, id)
+
+		s.span.SetAttributes(attribute.Int("glaball.requests", s.requests))
+		if err != nil {
+			s.span.RecordError(err)
+			s.span.SetStatus(codes.Error, err.Error())
+		}
+		s.span.End()
+	}
+}
+
+func (t *Tracer) RequestStarted(h *client.Host, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	endpoint := client.Endpoint(req)
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLTemplate(strings.TrimPrefix(endpoint, req.Method+" ")),
+		semconv.URLFull(req.URL.String()),
+		semconv.ServerAddress(req.URL.Hostname()),
+	}
+	if page, err := strconv.Atoi(req.URL.Query().Get("page")); err == nil {
+		attrs = append(attrs, attribute.Int("glaball.page", page))
+	}
+
+	// The requests made without the context of a task are traced in the root span
+	ctx, task := t.ctx, t.tasks[trace.SpanFromContext(req.Context()).SpanContext().SpanID()]
+	if task != nil {
+		ctx = trace.ContextWithSpan(ctx, task.span)
+	}
+
+	_, span := t.tracer.Start(ctx, endpoint,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	t.requests[req] = request{span: span, task: task}
+}
+
+func (t *Tracer) RequestFinished(h *client.Host, req *http.Request, resp *http.Response, err error, _ time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.requests[req]
+	if !ok {
+		return
+	}
+	delete(t.requests, req)
+
+	span := r.span
+	defer span.End()
+
+	if r.task != nil {
+		r.task.requests++
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(resp.StatusCode),
+		attribute.Bool("glaball.cache_hit", resp.Header.Get("X-From-Cache") == "1"),
+	)
+	if next, err := strconv.Atoi(resp.Header.Get("X-Next-Page")); err == nil {
+		span.SetAttributes(attribute.Int("glaball.next_page", next))
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+}
+
+// Stop ends the spans of the tasks still running and the root span, then flushes the exporter
+func (t *Tracer) Stop(ctx context.Context) error {
+	t.mu.Lock()
+	for _, s := range t.tasks {
+		s.span.SetAttributes(attribute.Int("glaball.requests", s.requests))
+		s.span.End()
+	}
+	t.root.SetAttributes(
+		attribute.Int("glaball.hosts", len(t.hosts)),
+		attribute.Int("glaball.tasks", t.added),
+		attribute.Int("glaball.tasks_done", t.done),
+		attribute.Int("glaball.errors", t.errors),
+	)
+	if t.errors > 0 {
+		t.root.SetStatus(codes.Error, fmt.Sprintf("%d errors", t.errors))
+	}
+	t.mu.Unlock()
+
+	t.root.End()
+
+	err := t.provider.Shutdown(ctx)
+
+	if t.file != nil {
+		if cerr := t.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/config"
+	"github.com/flant/glaball/pkg/limiter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	cli, err := client.NewClient(&config.Config{
+		Hosts: map[string]map[string]map[string]config.Host{
+			"main": {"alfa": {"local": config.Host{URL: server.URL, Token: "token"}}},
+		},
+		Threads: limiter.DefaultLimit,
+	})
+	assert.NoError(t, err)
+
+	destination := filepath.Join(t.TempDir(), "trace.json")
+	tracer, err := Start(context.Background(), "glaball users list", destination)
+	assert.NoError(t, err)
+
+	wg := limiter.NewLimiter(limiter.DefaultLimit)
+	wg.Notify(tracer)
+	cli.Observe(tracer)
+
+	// The request is traced in its own task while another task of the host is running
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer wg.Done()
+		ctx, done := wg.Start(context.Background(), cli.Hosts[0])
+		_, other := wg.Start(context.Background(), cli.Hosts[0])
+		_, _, err := cli.Hosts[0].Client.Users.ListUsers(nil, gitlab.WithContext(ctx))
+		assert.NoError(t, err)
+		other(nil)
+		done(assert.AnError)
+		wg.Error(cli.Hosts[0], assert.AnError)
+	}()
+	wg.Wait()
+
+	assert.NoError(t, tracer.Stop(context.Background()))
+
+	f, err := os.Open(destination)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	type span struct {
+		Name        string
+		SpanContext struct{ SpanID string }
+		Parent      struct{ SpanID string }
+		Status      struct{ Code string }
+		Attributes  []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+
+	spans := make(map[string]span)
+	tasks := 0
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var s span
+		assert.NoError(t, dec.Decode(&s))
+		if s.Name == "main.alfa.local" {
+			tasks++
+			// The span of the other task has no error
+			if s.Status.Code != "Error" {
+				continue
+			}
+		}
+		spans[s.Name] = s
+	}
+	assert.Len(t, spans, 3)
+	assert.Equal(t, 2, tasks)
+
+	root, host, request := spans["glaball users list"], spans["main.alfa.local"], spans["GET /users"]
+	assert.Equal(t, root.SpanContext.SpanID, host.Parent.SpanID)
+	assert.Equal(t, host.SpanContext.SpanID, request.Parent.SpanID)
+	assert.Equal(t, "Error", host.Status.Code)
+
+	attrs := make(map[string]interface{})
+	for _, a := range request.Attributes {
+		attrs[a.Key] = a.Value.Value
+	}
+	assert.Equal(t, "/users", attrs["url.template"])
+	assert.Equal(t, float64(200), attrs["http.response.status_code"])
+	assert.Equal(t, false, attrs["glaball.cache_hit"])
+}
@@ -0,0 +1,309 @@
+package tui
+
+import (
+	"reflect"
+	go_sort "sort"
+	"strings"
+
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/sort/v2"
+)
+
+// The key column is the second one after the count
+const columnKey = 1
+
+// Node is a row of the table: a result, a nested group of the result or an element of the result
+type Node struct {
+	Level    int
+	Result   *sort.Result
+	Element  *sort.Element
+	Children []*Node
+
+	Expanded bool
+	Marked   bool
+
+	values []interface{}
+}
+
+// Group reports whether the node is a result or a nested group that can be expanded
+func (n *Node) Group() bool {
+	return n.Result != nil
+}
+
+// Elements returns the elements of the node including the ones of the nested groups
+func (n *Node) Elements() sort.Elements {
+	if !n.Group() {
+		return sort.Elements{*n.Element}
+	}
+	if len(n.Result.Groups) == 0 {
+		return n.Result.Elements
+	}
+	elements := make(sort.Elements, 0, n.Result.Count)
+	for _, c := range n.Children {
+		elements = append(elements, c.Elements()...)
+	}
+	return elements
+}
+
+// Model is the state of the table: the tree of the results, the filter and the order of the rows
+type Model struct {
+	Header []string
+
+	nodes   []*Node
+	columns []sort.Column
+	aggs    []sort.Aggregation
+
+	filter     string
+	sortColumn int
+	sortDesc   bool
+}
+
+// NewModel builds the tree of the results. The custom columns are shown instead of the hosts and the cached
+// columns if any, the aggregations are shown on the group rows.
+func NewModel(columns []sort.Column, aggregations []sort.Aggregation, results []sort.Result) *Model {
+	m := &Model{columns: columns, aggs: aggregations, sortColumn: -1}
+
+	m.Header = []string{"COUNT", "KEY"}
+	if len(columns) == 0 {
+		m.Header = append(m.Header, "HOSTS", "CACHED")
+	}
+	for _, c := range columns {
+		m.Header = append(m.Header, strings.ToUpper(c.Name))
+	}
+	for _, a := range aggregations {
+		m.Header = append(m.Header, strings.ToUpper(a.Name()))
+	}
+
+	m.nodes = m.groups(0, results)
+
+	return m
+}
+
+func (m *Model) groups(level int, results []sort.Result) []*Node {
+	nodes := make([]*Node, 0, len(results))
+	for i := range results {
+		r := &results[i]
+		n := &Node{Level: level, Result: r}
+		if len(r.Groups) > 0 {
+			n.Children = m.groups(level+1, r.Groups)
+		} else {
+			for _, e := range r.Elements.Typed() {
+				e := e
+				c := &Node{Level: level + 1, Element: &e}
+				c.values = m.elementValues(*r, e)
+				n.Children = append(n.Children, c)
+			}
+		}
+		n.values = m.groupValues(n)
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func (m *Model) groupValues(n *Node) []interface{} {
+	r, elements := n.Result, n.Elements()
+
+	values := []interface{}{r.Count, r.Key}
+	if len(m.columns) == 0 {
+		values = append(values, strings.Join(hosts(elements), ", "), r.Cached)
+	}
+	for _, c := range m.columns {
+		values = append(values, commonValue(c, *r, elements))
+	}
+	for _, a := range m.aggs {
+		values = append(values, a.Apply(elements))
+	}
+
+	return values
+}
+
+func (m *Model) elementValues(r sort.Result, e sort.Element) []interface{} {
+	// The host of the element is shown in the key column
+	values := []interface{}{nil, nil}
+	if len(m.columns) == 0 {
+		values = append(values, nil, e.Cached)
+	}
+	for _, c := range m.columns {
+		values = append(values, c.Value(r, e))
+	}
+	for range m.aggs {
+		values = append(values, nil)
+	}
+
+	return values
+}
+
+// Returns the sorted hosts of the elements
+func hosts(elements sort.Elements) []string {
+	return elements.Hosts().Unique().Projects(true)
+}
+
+// Returns the value of the column if it is the same for all elements, nil otherwise
+func commonValue(c sort.Column, r sort.Result, elements sort.Elements) interface{} {
+	var v interface{}
+	for i, e := range elements.Typed() {
+		ev := c.Value(r, e)
+		if i > 0 && output.FormatValue(ev) != output.FormatValue(v) {
+			return nil
+		}
+		v = ev
+	}
+	return v
+}
+
+// Cells returns the formatted values of the node, the key is indented by the level of the node
+func (m *Model) Cells(n *Node) []string {
+	cells := make([]string, len(n.values))
+	for i, v := range n.values {
+		cells[i] = output.FormatValue(v)
+	}
+
+	mark := "  "
+	if n.Marked {
+		mark = "* "
+	}
+
+	indent := strings.Repeat("  ", n.Level)
+	switch {
+	case n.Group() && n.Expanded:
+		cells[columnKey] = mark + indent + "▾ " + cells[columnKey]
+	case n.Group():
+		cells[columnKey] = mark + indent + "▸ " + cells[columnKey]
+	default:
+		cells[columnKey] = mark + indent + "  " + n.Element.Host.ProjectName()
+	}
+
+	return cells
+}
+
+// Rows returns the visible rows: the results matching the filter and the children of the expanded ones
+func (m *Model) Rows() []*Node {
+	rows := make([]*Node, 0, len(m.nodes))
+	var walk func(nodes []*Node)
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			if !m.matches(n) {
+				continue
+			}
+			rows = append(rows, n)
+			if n.Expanded {
+				walk(n.Children)
+			}
+		}
+	}
+	walk(m.nodes)
+	return rows
+}
+
+// A node matches the filter if any of its cells or the cells of its children contain the filter text
+func (m *Model) matches(n *Node) bool {
+	if m.filter == "" {
+		return true
+	}
+	for _, v := range n.values {
+		if strings.Contains(strings.ToLower(output.FormatValue(v)), m.filter) {
+			return true
+		}
+	}
+	if !n.Group() && strings.Contains(strings.ToLower(n.Element.Host.ProjectName()), m.filter) {
+		return true
+	}
+	for _, c := range n.Children {
+		if m.matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter shows only the rows containing the text, case insensitive
+func (m *Model) Filter(text string) {
+	m.filter = strings.ToLower(strings.TrimSpace(text))
+}
+
+// FilterText returns the current filter
+func (m *Model) FilterText() string {
+	return m.filter
+}
+
+// Sort orders the rows of every level by the column, sorting by the same column again reverses the order.
+// The values are compared by their types like --order_by does.
+func (m *Model) Sort(column int) {
+	if column < 0 || column >= len(m.Header) {
+		return
+	}
+	if m.sortColumn == column {
+		m.sortDesc = !m.sortDesc
+	} else {
+		m.sortColumn, m.sortDesc = column, false
+	}
+
+	var sortNodes func(nodes []*Node)
+	sortNodes = func(nodes []*Node) {
+		go_sort.SliceStable(nodes, func(i, j int) bool {
+			c := key(nodes[i], column).CompareTo(key(nodes[j], column))
+			if m.sortDesc {
+				return c > 0
+			}
+			return c < 0
+		})
+		for _, n := range nodes {
+			sortNodes(n.Children)
+		}
+	}
+	sortNodes(m.nodes)
+}
+
+// SortedBy returns the sort column and the direction, the column is -1 if the rows are not sorted
+func (m *Model) SortedBy() (int, bool) {
+	return m.sortColumn, m.sortDesc
+}
+
+func key(n *Node, column int) sort.Key {
+	v := n.values[column]
+	// Elements are compared by the host instead of the empty key
+	if column == columnKey && !n.Group() {
+		v = n.Element.Host.ProjectName()
+	}
+	return sort.KeyOf(reflect.ValueOf(v))
+}
+
+// Toggle expands or collapses the group
+func (m *Model) Toggle(n *Node) {
+	if n.Group() {
+		n.Expanded = !n.Expanded
+	}
+}
+
+// Mark marks or unmarks the row, marking a group marks all of its rows
+func (m *Model) Mark(n *Node) {
+	var mark func(n *Node, marked bool)
+	mark = func(n *Node, marked bool) {
+		n.Marked = marked
+		for _, c := range n.Children {
+			mark(c, marked)
+		}
+	}
+	mark(n, !n.Marked)
+}
+
+// Selected returns the elements of the marked rows or of the current row if none are marked
+func (m *Model) Selected(current *Node) sort.Elements {
+	elements := make(sort.Elements, 0)
+	var walk func(nodes []*Node)
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			if !n.Group() && n.Marked {
+				elements = append(elements, *n.Element)
+			}
+			walk(n.Children)
+		}
+	}
+	walk(m.nodes)
+
+	if len(elements) == 0 && current != nil {
+		return current.Elements()
+	}
+
+	return elements
+}
@@ -0,0 +1,238 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	pageMain    = "main"
+	pageConfirm = "confirm"
+)
+
+// Action is run on the elements of the selected rows after the confirmation, e.g. blocking the users
+type Action struct {
+	Key  rune
+	Name string
+	// Confirm returns the question asked before running the action
+	Confirm func(elements sort.Elements) string
+	// Run returns the summary shown in the status line
+	Run func(elements sort.Elements) (string, error)
+}
+
+// Browser is a table of the results that can be filtered, sorted and expanded to the elements of every host
+type Browser struct {
+	app     *tview.Application
+	pages   *tview.Pages
+	table   *tview.Table
+	filter  *tview.InputField
+	status  *tview.TextView
+	model   *Model
+	actions []Action
+
+	rows    []*Node
+	message string
+	// The action keys are ignored until the running action is done
+	running bool
+}
+
+// Run shows the results in the terminal until the user quits
+func Run(model *Model, actions ...Action) error {
+	return NewBrowser(tview.NewApplication(), model, actions...).Run()
+}
+
+func NewBrowser(app *tview.Application, model *Model, actions ...Action) *Browser {
+	b := &Browser{
+		app:     app,
+		pages:   tview.NewPages(),
+		table:   tview.NewTable(),
+		filter:  tview.NewInputField(),
+		status:  tview.NewTextView(),
+		model:   model,
+		actions: actions,
+	}
+
+	b.table.SetFixed(1, 0).SetSelectable(true, false).SetInputCapture(b.handle)
+	b.table.SetSelectedFunc(func(row, column int) {
+		if n := b.current(); n != nil {
+			b.model.Toggle(n)
+			b.refresh()
+		}
+	})
+
+	b.filter.SetLabel("Filter: ").SetChangedFunc(func(text string) {
+		b.model.Filter(text)
+		b.refresh()
+	})
+	b.filter.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			b.filter.SetText("")
+		}
+		b.app.SetFocus(b.table)
+	})
+
+	b.status.SetDynamicColors(false)
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(b.table, 0, 1, true).
+		AddItem(b.filter, 1, 0, false).
+		AddItem(b.status, 2, 0, false)
+	b.pages.AddPage(pageMain, layout, true, true)
+
+	b.refresh()
+
+	return b
+}
+
+func (b *Browser) Run() error {
+	return b.app.SetRoot(b.pages, true).SetFocus(b.table).Run()
+}
+
+// Handles the keys of the table, the arrows and enter are left to the table
+func (b *Browser) handle(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		b.app.Stop()
+		return nil
+	case tcell.KeyRune:
+	default:
+		return event
+	}
+
+	r := event.Rune()
+	switch {
+	case r == 'q':
+		b.app.Stop()
+	case r == '/':
+		b.app.SetFocus(b.filter)
+	case r == ' ':
+		if n := b.current(); n != nil {
+			b.model.Mark(n)
+			b.refresh()
+		}
+	case r >= '1' && r <= '9':
+		b.model.Sort(int(r - '1'))
+		b.refresh()
+	default:
+		for _, a := range b.actions {
+			if a.Key == r {
+				if !b.running {
+					b.confirm(a)
+				}
+				return nil
+			}
+		}
+		return event
+	}
+
+	return nil
+}
+
+// Asks the user before running the action on the selected elements
+func (b *Browser) confirm(a Action) {
+	elements := b.model.Selected(b.current())
+	if len(elements) == 0 {
+		return
+	}
+
+	modal := tview.NewModal().
+		SetText(a.Confirm(elements)).
+		AddButtons([]string{"Yes", "No"}).
+		SetDoneFunc(func(index int, label string) {
+			b.pages.RemovePage(pageConfirm)
+			b.app.SetFocus(b.table)
+			if label == "Yes" {
+				b.run(a, elements)
+			}
+		})
+	b.pages.AddPage(pageConfirm, modal, false, true)
+	b.app.SetFocus(modal)
+}
+
+// Runs the action in the background and shows the result in the status line
+func (b *Browser) run(a Action, elements sort.Elements) {
+	b.running = true
+	b.message = fmt.Sprintf("%s: running on %d element(s)...", a.Name, len(elements))
+	b.refresh()
+
+	go func() {
+		summary, err := a.Run(elements)
+		b.app.QueueUpdateDraw(func() {
+			b.running = false
+			b.message = fmt.Sprintf("%s: %s", a.Name, summary)
+			if err != nil {
+				b.message += fmt.Sprintf(", error: %v", err)
+			}
+			b.refresh()
+		})
+	}()
+}
+
+// Returns the node of the selected row, nil if the table is empty
+func (b *Browser) current() *Node {
+	row, _ := b.table.GetSelection()
+	if row < 1 || row > len(b.rows) {
+		return nil
+	}
+	return b.rows[row-1]
+}
+
+// Redraws the table keeping the selected node
+func (b *Browser) refresh() {
+	selected := b.current()
+
+	b.rows = b.model.Rows()
+	b.table.Clear()
+
+	column, desc := b.model.SortedBy()
+	for i, h := range b.model.Header {
+		switch {
+		case i == column && desc:
+			h += " ▼"
+		case i == column:
+			h += " ▲"
+		}
+		b.table.SetCell(0, i, tview.NewTableCell(h).
+			SetAttributes(tcell.AttrBold).
+			SetSelectable(false))
+	}
+
+	row := 1
+	for i, n := range b.rows {
+		for j, v := range b.model.Cells(n) {
+			cell := tview.NewTableCell(tview.Escape(v)).SetMaxWidth(60)
+			if n.Marked {
+				cell.SetTextColor(tcell.ColorYellow)
+			}
+			b.table.SetCell(i+1, j, cell)
+		}
+		if n == selected {
+			row = i + 1
+		}
+	}
+	b.table.Select(row, 0)
+
+	b.status.SetText(b.help())
+}
+
+func (b *Browser) help() string {
+	keys := []string{"enter expand", "space mark", "/ filter", "1-9 sort", "q quit"}
+	for _, a := range b.actions {
+		keys = append(keys, fmt.Sprintf("%c %s", a.Key, a.Name))
+	}
+
+	info := fmt.Sprintf("%d row(s), %d marked", len(b.rows), len(b.model.Selected(nil)))
+	if f := b.model.FilterText(); f != "" {
+		info += fmt.Sprintf(", filter %q", f)
+	}
+	if b.message != "" {
+		info += " | " + b.message
+	}
+
+	return info + "\n" + strings.Join(keys, " · ")
+}
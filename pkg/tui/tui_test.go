@@ -0,0 +1,144 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	alfa = &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	beta = &client.Host{Team: "main", Project: "beta", Name: "local"}
+)
+
+func testResults() []sort.Result {
+	user := func(h *client.Host, username string, id int) sort.Element {
+		return sort.Element{Host: h, Struct: &gitlab.User{ID: id, Username: username, State: "active"}}
+	}
+	return []sort.Result{
+		{Count: 2, Key: "bob", Elements: sort.Elements{user(alfa, "bob", 1), user(beta, "bob", 7)}},
+		{Count: 1, Key: "alice", Elements: sort.Elements{user(beta, "alice", 2)}},
+	}
+}
+
+func keys(m *Model) []string {
+	rows := m.Rows()
+	s := make([]string, 0, len(rows))
+	for _, n := range rows {
+		s = append(s, strings.TrimSpace(m.Cells(n)[columnKey]))
+	}
+	return s
+}
+
+func TestModel(t *testing.T) {
+	columns, err := sort.ParseColumns([]string{"id", "state"}, gitlab.User{})
+	require.NoError(t, err)
+
+	m := NewModel(columns, nil, testResults())
+	assert.Equal(t, []string{"COUNT", "KEY", "ID", "STATE"}, m.Header)
+	assert.Equal(t, []string{"▸ bob", "▸ alice"}, keys(m))
+
+	// The group shows only the values of the columns that are the same on every host
+	bob := m.Rows()[0]
+	assert.Equal(t, []string{"2", "  ▸ bob", "", "active"}, m.Cells(bob))
+
+	m.Toggle(bob)
+	assert.Equal(t, []string{"▾ bob", "alfa.local", "beta.local", "▸ alice"}, keys(m))
+	assert.Equal(t, "7", m.Cells(m.Rows()[2])[2])
+
+	m.Sort(columnKey)
+	assert.Equal(t, []string{"▸ alice", "▾ bob", "alfa.local", "beta.local"}, keys(m))
+	m.Sort(columnKey)
+	assert.Equal(t, []string{"▾ bob", "beta.local", "alfa.local", "▸ alice"}, keys(m))
+
+	// Numbers are compared by their values
+	m.Sort(0)
+	assert.Equal(t, "▸ alice", keys(m)[0])
+
+	m.Filter("ALI")
+	assert.Equal(t, []string{"▸ alice"}, keys(m))
+	m.Filter("beta")
+	assert.Equal(t, []string{"▸ alice", "▾ bob", "beta.local"}, keys(m))
+	m.Filter("")
+
+	// The current row is selected if nothing is marked
+	assert.Len(t, m.Selected(bob), 2)
+	m.Mark(m.Rows()[2])
+	assert.Len(t, m.Selected(bob), 1)
+	m.Mark(bob)
+	assert.Len(t, m.Selected(nil), 2)
+}
+
+func TestModelNested(t *testing.T) {
+	aggregations, err := sort.ParseAggregations([]string{"sum:id"}, gitlab.User{})
+	require.NoError(t, err)
+
+	results := testResults()
+	nested := []sort.Result{{Count: 3, Key: "active", Field: "state", Groups: results}}
+
+	m := NewModel(nil, aggregations, nested)
+	assert.Equal(t, []string{"COUNT", "KEY", "HOSTS", "CACHED", "SUM:ID"}, m.Header)
+
+	group := m.Rows()[0]
+	assert.Equal(t, []string{"3", "  ▸ active", "alfa.local, beta.local", "no", "10"}, m.Cells(group))
+	assert.Len(t, group.Elements(), 3)
+
+	m.Toggle(group)
+	m.Toggle(m.Rows()[1])
+	assert.Equal(t, []string{"▾ active", "▾ bob", "alfa.local", "beta.local", "▸ alice"}, keys(m))
+}
+
+func TestBrowser(t *testing.T) {
+	blocked := make(chan sort.Elements, 1)
+	block := Action{
+		Key:     'b',
+		Name:    "block",
+		Confirm: func(elements sort.Elements) string { return "block?" },
+		Run: func(elements sort.Elements) (string, error) {
+			blocked <- elements
+			return "done", nil
+		},
+	}
+
+	b := NewBrowser(tview.NewApplication(), NewModel(nil, nil, testResults()), block)
+	key := func(r rune) { b.handle(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)) }
+
+	assert.Equal(t, "COUNT", b.table.GetCell(0, 0).Text)
+	assert.Equal(t, "  ▸ bob", b.table.GetCell(1, 1).Text)
+
+	key('2')
+	assert.Equal(t, "KEY ▲", b.table.GetCell(0, 1).Text)
+	assert.Equal(t, "  ▸ alice", b.table.GetCell(1, 1).Text)
+	// The selection follows the row
+	assert.Equal(t, "bob", b.current().Result.Key)
+
+	key(' ')
+	assert.Equal(t, "* ▸ bob", b.table.GetCell(2, 1).Text)
+	assert.Contains(t, b.status.GetText(false), "2 marked")
+	assert.Contains(t, b.status.GetText(false), "b block")
+
+	key('b')
+	assert.True(t, b.pages.HasPage(pageConfirm))
+
+	b.pages.RemovePage(pageConfirm)
+	b.run(block, b.model.Selected(b.current()))
+
+	// The action is not confirmed again until the running one is done
+	key('b')
+	assert.False(t, b.pages.HasPage(pageConfirm))
+	select {
+	case elements := <-blocked:
+		assert.Len(t, elements, 2)
+	case <-time.After(time.Second):
+		t.Fatal("the action was not run")
+	}
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Patch represents a set of struct fields addressed by their json tags
+type Patch map[string]interface{}
+
+// Get json tag name of the struct field
+func jsonTagName(f reflect.StructField) string {
+	tag, ok := f.Tag.Lookup("json")
+	if !ok {
+		return ""
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// Get actual type if it is a pointer
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Find struct field by its json tag
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); jsonTagName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// JsonFieldNames returns sorted json tag names of the struct fields
+func JsonFieldNames(v interface{}) []string {
+	t := indirectType(reflect.TypeOf(v))
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonTagName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePatch parses "key=value" pairs into a patch validated against the json tags of v.
+// Nested struct fields are addressed with dots, e.g. "container_expiration_policy_attributes.keep_n=5".
+func ParsePatch(v interface{}, pairs []string) (Patch, error) {
+	patch := make(Patch)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid field value %q, expected key=value", pair)
+		}
+
+		path := strings.Split(key, ".")
+		t := reflect.TypeOf(v)
+		m := patch
+		for i, name := range path {
+			f, ok := jsonField(t, name)
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q", strings.Join(path[:i+1], "."))
+			}
+			t = f.Type
+
+			if i == len(path)-1 {
+				fv, err := parseFieldValue(t, value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for field %q: %v", key, err)
+				}
+				m[name] = fv
+				break
+			}
+
+			if indirectType(t).Kind() != reflect.Struct {
+				return nil, fmt.Errorf("field %q is not a struct", strings.Join(path[:i+1], "."))
+			}
+			next, ok := m[name].(Patch)
+			if !ok {
+				next = make(Patch)
+				m[name] = next
+			}
+			m = next
+		}
+	}
+
+	return patch, nil
+}
+
+// Convert string value to the type of the struct field
+func parseFieldValue(t reflect.Type, s string) (interface{}, error) {
+	switch t = indirectType(t); t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	case reflect.Slice:
+		if indirectType(t.Elem()).Kind() == reflect.String {
+			if s == "" {
+				return []string{}, nil
+			}
+			return strings.Split(s, ","), nil
+		}
+	}
+
+	// Complex values (structs, maps, non-string slices) are parsed as yaml
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// PatchFromFile reads a yaml (or json) patch file and validates it against the json tags of v
+func PatchFromFile(v interface{}, path string) (Patch, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch Patch
+	if err := yaml.Unmarshal(b, &patch); err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %q error: %v", path, err)
+	}
+
+	if err := patch.Validate(v); err != nil {
+		return nil, fmt.Errorf("invalid patch: %q error: %v", path, err)
+	}
+
+	return patch, nil
+}
+
+// Validate checks that every key of the patch is a json field of v
+func (p Patch) Validate(v interface{}) error {
+	return validatePatch(reflect.TypeOf(v), p, nil)
+}
+
+func validatePatch(t reflect.Type, m map[string]interface{}, prefix []string) error {
+	for k, v := range m {
+		path := append(slices.Clip(prefix), k)
+		f, ok := jsonField(t, k)
+		if !ok {
+			return fmt.Errorf("unknown field %q", strings.Join(path, "."))
+		}
+		var nested map[string]interface{}
+		switch n := v.(type) {
+		case Patch:
+			nested = n
+		case map[string]interface{}:
+			nested = n
+		default:
+			continue
+		}
+		if indirectType(f.Type).Kind() != reflect.Struct {
+			return fmt.Errorf("field %q is not a struct", strings.Join(path, "."))
+		}
+		if err := validatePatch(f.Type, nested, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge copies all fields of other into the patch recursively
+func (p Patch) Merge(other Patch) {
+	for k, v := range other {
+		if src, ok := asMap(v); ok {
+			if dst, ok := asMap(p[k]); ok {
+				Patch(dst).Merge(src)
+				p[k] = dst
+				continue
+			}
+		}
+		p[k] = v
+	}
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case Patch:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
+// Keys returns sorted top-level keys of the patch
+func (p Patch) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Apply decodes the patch into v, which must be a pointer to struct.
+// Values are converted by their json representation, unknown fields are rejected.
+func (p Patch) Apply(v interface{}) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
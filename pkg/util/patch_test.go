@@ -0,0 +1,74 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestParsePatch(t *testing.T) {
+	var opt gitlab.EditProjectOptions
+
+	patch, err := ParsePatch(&opt, []string{
+		"merge_method=ff",
+		"only_allow_merge_if_pipeline_succeeds=true",
+		"ci_default_git_depth=20",
+		"topics=foo,bar",
+		"container_expiration_policy_attributes.keep_n=5",
+		"container_expiration_policy_attributes.cadence=7d",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, patch.Apply(&opt))
+
+	assert.Equal(t, gitlab.FastForwardMerge, *opt.MergeMethod)
+	assert.True(t, *opt.OnlyAllowMergeIfPipelineSucceeds)
+	assert.Equal(t, 20, *opt.CIDefaultGitDepth)
+	assert.Equal(t, []string{"foo", "bar"}, *opt.Topics)
+	assert.Equal(t, 5, *opt.ContainerExpirationPolicyAttributes.KeepN)
+	assert.Equal(t, "7d", *opt.ContainerExpirationPolicyAttributes.Cadence)
+	assert.Nil(t, opt.Visibility)
+
+	for _, pair := range []string{
+		"unknown_field=1",
+		"merge_method",
+		"only_allow_merge_if_pipeline_succeeds=maybe",
+		"container_expiration_policy_attributes.unknown=1",
+		"merge_method.nested=1",
+	} {
+		_, err := ParsePatch(&opt, []string{pair})
+		assert.Error(t, err, pair)
+	}
+}
+
+func TestPatchFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patch.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+visibility: private
+squash_option: default_on
+container_expiration_policy_attributes:
+  enabled: true
+  older_than: 14d
+`), 0o600))
+
+	var opt gitlab.EditProjectOptions
+	patch, err := PatchFromFile(&opt, path)
+	assert.NoError(t, err)
+
+	// --set values override the file
+	set, err := ParsePatch(&opt, []string{"container_expiration_policy_attributes.older_than=30d"})
+	assert.NoError(t, err)
+	patch.Merge(set)
+
+	assert.NoError(t, patch.Apply(&opt))
+	assert.Equal(t, gitlab.PrivateVisibility, *opt.Visibility)
+	assert.Equal(t, gitlab.SquashOptionDefaultOn, *opt.SquashOption)
+	assert.True(t, *opt.ContainerExpirationPolicyAttributes.Enabled)
+	assert.Equal(t, "30d", *opt.ContainerExpirationPolicyAttributes.OlderThan)
+
+	assert.NoError(t, os.WriteFile(path, []byte("unknown_field: 1\n"), 0o600))
+	_, err = PatchFromFile(&opt, path)
+	assert.Error(t, err)
+}
@@ -34,6 +34,11 @@ func (f *boolPtrValue) String() string {
 	return fmt.Sprintf("%v", *f.v)
 }
 
+// Options are the allowed values, used for the shell completion
+func (f *boolPtrValue) Options() []string {
+	return []string{"true", "false"}
+}
+
 func (f *boolPtrValue) Type() string {
 	return "bool"
 }
@@ -90,6 +95,11 @@ func (f *enumPtrValue) String() string {
 	return string(**f.v)
 }
 
+// Options are the allowed values, used for the shell completion
+func (f *enumPtrValue) Options() []string {
+	return f.options
+}
+
 func (f *enumPtrValue) Type() string {
 	return "string"
 }
@@ -154,11 +164,7 @@ func NewVisibilityPtrValue(p **gitlab.VisibilityValue) *visibilityPtrValue {
 }
 
 func (f *visibilityPtrValue) Set(s string) error {
-	options := []string{
-		string(gitlab.PrivateVisibility),
-		string(gitlab.InternalVisibility),
-		string(gitlab.PublicVisibility),
-	}
+	options := f.Options()
 
 	for _, opt := range options {
 		if s == opt {
@@ -178,6 +184,15 @@ func (f *visibilityPtrValue) String() string {
 	return string(**f.v)
 }
 
+// Options are the allowed values, used for the shell completion
+func (f *visibilityPtrValue) Options() []string {
+	return []string{
+		string(gitlab.PrivateVisibility),
+		string(gitlab.InternalVisibility),
+		string(gitlab.PublicVisibility),
+	}
+}
+
 func (f *visibilityPtrValue) Type() string {
 	return "string"
 }
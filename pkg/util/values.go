@@ -29,6 +29,11 @@ func (f *enumValue) String() string {
 	return *f.v
 }
 
+// Options are the allowed values, used for the shell completion
+func (f *enumValue) Options() []string {
+	return f.options
+}
+
 func (f *enumValue) Type() string {
 	return "string"
 }
@@ -0,0 +1,233 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	go_sort "sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/output"
+	"github.com/flant/glaball/pkg/sort/v2"
+)
+
+// Kinds of the changes
+const (
+	Added   = "+"
+	Removed = "-"
+	Changed = "~"
+)
+
+// Entry is an element of the results identified by its key, host and id
+type Entry struct {
+	Key, ID string
+	Host    *client.Host
+	Element sort.Element
+
+	fields map[string]string
+}
+
+func (e Entry) identity() string {
+	return strings.Join([]string{e.Key, e.Host.FullName(), e.ID}, "\x00")
+}
+
+// Snapshot is the state of the results of one iteration
+type Snapshot map[string]Entry
+
+// NewSnapshot flattens the results to the entries, the keys of the nested groups are joined with slashes.
+// The id column tells apart the elements with the same key on a host, the elements are numbered in their order without it.
+func NewSnapshot(results []sort.Result, id *sort.Column) (Snapshot, error) {
+	s := make(Snapshot)
+
+	var walk func(keys []string, results []sort.Result) error
+	walk = func(keys []string, results []sort.Result) error {
+		for _, r := range results {
+			path := append(keys[:len(keys):len(keys)], r.Key)
+			if len(r.Groups) > 0 {
+				if err := walk(path, r.Groups); err != nil {
+					return err
+				}
+				continue
+			}
+
+			for _, e := range r.Elements.Typed() {
+				entry := Entry{Key: strings.Join(path, "/"), Host: e.Host, Element: e}
+				if id != nil {
+					entry.ID = output.FormatValue(id.Value(r, e))
+				}
+
+				fields, err := flatten(e.Struct)
+				if err != nil {
+					return err
+				}
+				entry.fields = fields
+
+				base := entry.ID
+				for n := 2; ; n++ {
+					if _, ok := s[entry.identity()]; !ok {
+						break
+					}
+					entry.ID = fmt.Sprintf("%s#%d", base, n)
+				}
+				s[entry.identity()] = entry
+			}
+		}
+		return nil
+	}
+
+	if err := walk(nil, results); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Returns the json field paths of the struct with the formatted scalar values
+func flatten(v interface{}) (map[string]string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			for k, v := range m {
+				if path != "" {
+					k = path + "." + k
+				}
+				walk(k, v)
+			}
+			return
+		}
+		if s, ok := v.(string); ok {
+			fields[path] = s
+			return
+		}
+		b, _ := json.Marshal(v)
+		fields[path] = string(b)
+	}
+	walk("", data)
+
+	return fields, nil
+}
+
+// FieldChange is the old and the new value of a json field
+type FieldChange struct {
+	Field    string
+	Old, New string
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %s → %s", c.Field, quote(c.Old), quote(c.New))
+}
+
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+// Change is an added, removed or changed entry
+type Change struct {
+	Kind   string
+	Entry  Entry
+	Fields []FieldChange
+}
+
+// Diff compares the snapshots by the key, host and id of the entries, the changed fields are listed for the changed entries.
+// The entries of the failed hosts are neither added nor removed, they are copied from the previous snapshot instead.
+func Diff(prev, next Snapshot, failed client.Hosts) []Change {
+	skip := make(map[*client.Host]bool, len(failed))
+	for _, h := range failed {
+		skip[h] = true
+	}
+
+	changes := make([]Change, 0)
+	for k, e := range prev {
+		if skip[e.Host] {
+			next[k] = e
+			continue
+		}
+		n, ok := next[k]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Entry: e})
+			continue
+		}
+		if fields := diffFields(e.fields, n.fields); len(fields) > 0 {
+			changes = append(changes, Change{Kind: Changed, Entry: n, Fields: fields})
+		}
+	}
+
+	for k, e := range next {
+		if _, ok := prev[k]; !ok && !skip[e.Host] {
+			changes = append(changes, Change{Kind: Added, Entry: e})
+		}
+	}
+
+	go_sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i].Entry, changes[j].Entry
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Host.FullName() != b.Host.FullName() {
+			return a.Host.FullName() < b.Host.FullName()
+		}
+		return a.ID < b.ID
+	})
+
+	return changes
+}
+
+func diffFields(prev, next map[string]string) []FieldChange {
+	changes := make([]FieldChange, 0)
+	for k, v := range prev {
+		if n, ok := next[k]; !ok || n != v {
+			changes = append(changes, FieldChange{Field: k, Old: v, New: n})
+		}
+	}
+	for k, v := range next {
+		if _, ok := prev[k]; !ok {
+			changes = append(changes, FieldChange{Field: k, New: v})
+		}
+	}
+
+	go_sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// Print writes the changes of the iteration, one line per entry
+func Print(w io.Writer, t time.Time, changes []Change) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.TabIndent)
+	for _, c := range changes {
+		key := c.Entry.Key
+		if c.Entry.ID != "" {
+			key += " [" + c.Entry.ID + "]"
+		}
+
+		fields := make([]string, 0, len(c.Fields))
+		for _, f := range c.Fields {
+			fields = append(fields, f.String())
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Format(time.RFC3339), c.Kind, key,
+			c.Entry.Host.ProjectName(), strings.Join(fields, ", ")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
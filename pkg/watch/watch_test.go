@@ -0,0 +1,112 @@
+package watch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/flant/glaball/pkg/client"
+	"github.com/flant/glaball/pkg/sort/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	alfa = &client.Host{Team: "main", Project: "alfa", Name: "local"}
+	beta = &client.Host{Team: "main", Project: "beta", Name: "local"}
+)
+
+func user(h *client.Host, id int, username, state string) sort.Element {
+	return sort.Element{Host: h, Struct: &gitlab.User{ID: id, Username: username, State: state}}
+}
+
+func snapshot(t *testing.T, results ...sort.Result) Snapshot {
+	columns, err := sort.ParseColumns([]string{"id"}, gitlab.User{})
+	require.NoError(t, err)
+
+	s, err := NewSnapshot(results, &columns[0])
+	require.NoError(t, err)
+
+	return s
+}
+
+func kinds(changes []Change) []string {
+	s := make([]string, 0, len(changes))
+	for _, c := range changes {
+		s = append(s, c.Kind+" "+c.Entry.Key+" "+c.Entry.Host.ProjectName()+" "+c.Entry.ID)
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	prev := snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "active"), user(beta, 5, "alice", "active")}},
+		sort.Result{Key: "bob", Elements: sort.Elements{user(alfa, 2, "bob", "active")}},
+	)
+	next := snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "blocked")}},
+		sort.Result{Key: "carol", Elements: sort.Elements{user(beta, 6, "carol", "active")}},
+	)
+
+	changes := Diff(prev, next, nil)
+	assert.Equal(t, []string{
+		"~ alice alfa.local 1",
+		"- alice beta.local 5",
+		"- bob alfa.local 2",
+		"+ carol beta.local 6",
+	}, kinds(changes))
+	assert.Equal(t, []FieldChange{{Field: "state", Old: "active", New: "blocked"}}, changes[0].Fields)
+
+	// Nothing is printed without the changes
+	assert.Empty(t, Diff(next, snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "blocked")}},
+		sort.Result{Key: "carol", Elements: sort.Elements{user(beta, 6, "carol", "active")}},
+	), nil))
+}
+
+func TestDiffFailedHosts(t *testing.T) {
+	prev := snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "active"), user(beta, 5, "alice", "active")}},
+	)
+	next := snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "blocked")}},
+	)
+
+	// The users of the failed host are not removed and are compared on the next iteration
+	assert.Equal(t, []string{"~ alice alfa.local 1"}, kinds(Diff(prev, next, client.Hosts{beta})))
+	assert.Len(t, next, 2)
+
+	last := snapshot(t,
+		sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "blocked")}},
+	)
+	assert.Equal(t, []string{"- alice beta.local 5"}, kinds(Diff(next, last, nil)))
+}
+
+func TestNewSnapshot(t *testing.T) {
+	nested := []sort.Result{{Key: "active", Groups: []sort.Result{
+		{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "active")}},
+	}}}
+	s, err := NewSnapshot(nested, nil)
+	require.NoError(t, err)
+	for _, e := range s {
+		assert.Equal(t, "active/alice", e.Key)
+	}
+
+	// The elements with the same identity are numbered
+	s = snapshot(t, sort.Result{Key: "alice", Elements: sort.Elements{user(alfa, 1, "alice", "active"), user(alfa, 1, "alice", "active")}})
+	assert.Equal(t, []string{"+ alice alfa.local 1", "+ alice alfa.local 1#2"}, kinds(Diff(Snapshot{}, s, nil)))
+}
+
+func TestPrint(t *testing.T) {
+	changes := []Change{
+		{Kind: Changed, Entry: Entry{Key: "alice", ID: "1", Host: alfa}, Fields: []FieldChange{{Field: "state", Old: "active", New: "blocked"}, {Field: "note", New: "x"}}},
+		{Kind: Added, Entry: Entry{Key: "carol", Host: beta}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Print(&buf, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), changes))
+	assert.Equal(t, "2024-01-02T03:04:05Z ~ alice [1] alfa.local state: active → blocked, note: \"\" → x\n"+
+		"2024-01-02T03:04:05Z + carol     beta.local \n", buf.String())
+}